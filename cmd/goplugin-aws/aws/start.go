@@ -2,8 +2,8 @@ package aws
 
 import (
 	"github.com/lyraproj/lyra/cmd/goplugin-aws/resource"
+	"github.com/lyraproj/lyra/pkg/grpc"
 	"github.com/lyraproj/puppet-evaluator/eval"
-	"github.com/lyraproj/servicesdk/grpc"
 )
 
 const (
@@ -2,7 +2,6 @@ package resource
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/hashicorp/go-hclog"
@@ -135,16 +134,5 @@ func iamTagsToAws(tags map[string]string) []*iam.Tag {
 
 // newIamClient() creates a new iam client
 func newIamClient() *iam.IAM {
-	config := aws.NewConfig().
-		WithMaxRetries(maxRetries)
-
-	opt := session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-		Config:            *config,
-	}
-	sess, err := session.NewSessionWithOptions(opt)
-	if err != nil {
-		panic(err)
-	}
-	return iam.New(sess)
+	return iam.New(newSession())
 }
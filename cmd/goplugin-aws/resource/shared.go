@@ -1,14 +1,18 @@
 package resource
 
 import (
+	"context"
 	"errors"
 	"math/rand"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/lyra/pkg/credhelper"
+	"github.com/lyraproj/lyra/pkg/grpc"
 )
 
 const (
@@ -18,9 +22,33 @@ const (
 
 // newClient() creates a new ec2 client
 func newClient() *ec2.EC2 {
+	return ec2.New(newSession())
+}
+
+// newSession() creates a new session. Credentials passed by lyra with the current invocation take
+// precedence. Otherwise they are obtained from the credential helper configured for the aws provider
+// when there is one and from the environment.
+func newSession() *session.Session {
 	config := aws.NewConfig().
 		WithMaxRetries(maxRetries)
 
+	c, ok := grpc.InvocationCredentials()
+	var err error
+	if !ok {
+		c, err = credhelper.Get(context.Background(), "aws", "")
+	}
+	switch err {
+	case nil:
+		config = config.WithCredentials(credentials.NewStaticCredentials(
+			c.Get("AWS_ACCESS_KEY_ID"), c.Get("AWS_SECRET_ACCESS_KEY"), c.Get("AWS_SESSION_TOKEN")))
+		if region := c.Get("AWS_REGION"); region != "" {
+			config = config.WithRegion(region)
+		}
+	case credhelper.ErrNoHelper:
+	default:
+		panic(err)
+	}
+
 	opt := session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 		Config:            *config,
@@ -29,7 +57,7 @@ func newClient() *ec2.EC2 {
 	if err != nil {
 		panic(err)
 	}
-	return ec2.New(sess)
+	return sess
 }
 
 func tagResource(client ec2.EC2, tags map[string]string, resourceIds ...*string) error {
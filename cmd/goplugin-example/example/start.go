@@ -2,9 +2,9 @@ package example
 
 import (
 	"github.com/lyraproj/lyra/cmd/goplugin-example/resource"
+	"github.com/lyraproj/lyra/pkg/grpc"
 	"github.com/lyraproj/puppet-evaluator/eval"
 	"github.com/lyraproj/servicesdk/annotation"
-	"github.com/lyraproj/servicesdk/grpc"
 	"github.com/lyraproj/servicesdk/service"
 )
 
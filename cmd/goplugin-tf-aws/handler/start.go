@@ -4,8 +4,8 @@ import (
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
 	"github.com/lyraproj/lyra/cmd/goplugin-tf-aws/generated"
+	"github.com/lyraproj/lyra/pkg/grpc"
 	"github.com/lyraproj/puppet-evaluator/eval"
-	"github.com/lyraproj/servicesdk/grpc"
 	"github.com/lyraproj/servicesdk/service"
 	"github.com/terraform-providers/terraform-provider-aws/aws"
 )
@@ -3,8 +3,8 @@ package handler
 import (
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/lyraproj/lyra/cmd/goplugin-tf-kubernetes/generated"
+	"github.com/lyraproj/lyra/pkg/grpc"
 	"github.com/lyraproj/puppet-evaluator/eval"
-	"github.com/lyraproj/servicesdk/grpc"
 	"github.com/lyraproj/servicesdk/service"
 	"github.com/terraform-providers/terraform-provider-kubernetes/kubernetes"
 )
@@ -6,7 +6,6 @@ import (
 	"github.com/lyraproj/lyra/pkg/i18n"
 	"github.com/lyraproj/servicesdk/wfapi"
 	"github.com/spf13/cobra"
-	"os"
 
 	// Ensure that lookup function properly loaded
 	_ "github.com/lyraproj/hiera/functions"
@@ -14,6 +13,18 @@ import (
 
 var homeDir string
 var hieraDataFilename string
+var eventSink string
+var auditLog string
+var safeEval bool
+var skipLeakCheck bool
+var incrementalApply bool
+var stepRole string
+var providerLimits []string
+var recordCassette string
+var replayCassette string
+var noopRun bool
+var chaosFaults []string
+var chaosSeed int64
 
 // NewApplyCmd returns the apply subcommand used to evaluate and apply activities. //TODO: (JD) Does 'apply' even make sense for what this does now?
 func NewApplyCmd() *cobra.Command {
@@ -28,6 +39,18 @@ func NewApplyCmd() *cobra.Command {
 
 	cmd.Flags().StringVarP(&homeDir, "root", "r", "", i18n.T("flagHomeDir"))
 	cmd.Flags().StringVarP(&hieraDataFilename, "data", "d", "data.yaml", i18n.T("applyFlagExtData"))
+	cmd.Flags().StringVar(&eventSink, "event-sink", "", i18n.T("flagEventSink"))
+	cmd.Flags().StringVar(&auditLog, "audit-log", "", i18n.T("flagAuditLog"))
+	cmd.Flags().BoolVar(&safeEval, "safe-eval", false, i18n.T("flagSafeEval"))
+	cmd.Flags().StringVar(&stepRole, "step-role", "", i18n.T("flagStepRole"))
+	cmd.Flags().BoolVar(&skipLeakCheck, "skip-leak-check", false, i18n.T("flagSkipLeakCheck"))
+	cmd.Flags().BoolVar(&incrementalApply, "incremental", false, i18n.T("flagIncremental"))
+	cmd.Flags().StringArrayVar(&providerLimits, "provider-limit", nil, i18n.T("flagProviderLimit"))
+	cmd.Flags().StringVar(&recordCassette, "record", "", i18n.T("flagRecord"))
+	cmd.Flags().StringVar(&replayCassette, "replay", "", i18n.T("flagReplay"))
+	cmd.Flags().BoolVar(&noopRun, "noop", false, i18n.T("flagNoop"))
+	cmd.Flags().StringArrayVar(&chaosFaults, "chaos", nil, i18n.T("flagChaos"))
+	cmd.Flags().Int64Var(&chaosSeed, "chaos-seed", 0, i18n.T("flagChaosSeed"))
 
 	cmd.SetHelpTemplate(ui.HelpTemplate)
 	cmd.SetUsageTemplate(ui.UsageTemplate)
@@ -36,10 +59,10 @@ func NewApplyCmd() *cobra.Command {
 }
 
 func runApplyCmd(cmd *cobra.Command, args []string) {
-	applicator := &apply.Applicator{HomeDir: homeDir}
+	applicator := &apply.Applicator{HomeDir: homeDir, EventSink: eventSink, AuditLog: auditLog, SafeEval: safeEval, SkipLeakCheck: skipLeakCheck, StepRole: stepRole, Incremental: incrementalApply, ProviderLimits: providerLimits, Record: recordCassette, Replay: replayCassette, Noop: noopRun, Chaos: chaosFaults, ChaosSeed: chaosSeed}
 	workflowName := args[0]
 	exitCode := applicator.ApplyWorkflow(workflowName, hieraDataFilename, wfapi.Upsert)
 	if exitCode != 0 {
-		os.Exit(exitCode)
+		exit(exitCode)
 	}
 }
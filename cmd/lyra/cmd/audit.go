@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/audit"
+	"github.com/lyraproj/lyra/pkg/i18n"
+	"github.com/lyraproj/lyra/pkg/signing"
+	"github.com/spf13/cobra"
+)
+
+var auditTrustedKeys = ``
+
+// NewAuditCmd returns the audit subcommand used to work with audit logs
+func NewAuditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   i18n.T("auditCmdUse"),
+		Short: i18n.T("auditCmdShort"),
+		Long:  i18n.T("auditCmdLong"),
+	}
+
+	cmd.AddCommand(newAuditVerifyCmd())
+
+	cmd.SetHelpTemplate(ui.HelpTemplate)
+	cmd.SetUsageTemplate(ui.UsageTemplate)
+
+	return cmd
+}
+
+func newAuditVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     i18n.T("auditVerifyCmdUse"),
+		Short:   i18n.T("auditVerifyCmdShort"),
+		Long:    i18n.T("auditVerifyCmdLong"),
+		Example: i18n.T("auditVerifyCmdExample"),
+		Run:     runAuditVerifyCmd,
+		Args:    cobra.ExactArgs(1),
+	}
+
+	cmd.Flags().StringVar(&auditTrustedKeys, "trusted-keys", "", i18n.T("auditFlagTrustedKeys"))
+
+	cmd.SetHelpTemplate(ui.HelpTemplate)
+	cmd.SetUsageTemplate(ui.UsageTemplate)
+
+	return cmd
+}
+
+func runAuditVerifyCmd(cmd *cobra.Command, args []string) {
+	var verifier *signing.Verifier
+	var err error
+	if auditTrustedKeys != `` {
+		verifier, err = signing.LoadVerifier(auditTrustedKeys)
+	} else {
+		verifier, err = signing.VerifierFromEnv()
+	}
+	if err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+	defer f.Close()
+
+	res, err := audit.Verify(f, verifier)
+	if err != nil {
+		ui.Message("error", fmt.Errorf("%s: %s", args[0], err))
+		exit(1)
+	}
+	ui.Message("info", fmt.Sprintf("%s: %d entries verified, %d signed, last hash %s", args[0], res.Entries, res.Signed, res.LastHash))
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/catalog"
+	"github.com/lyraproj/lyra/pkg/i18n"
+	"github.com/spf13/cobra"
+
+	// Ensure that lookup function properly loaded
+	_ "github.com/lyraproj/hiera/functions"
+)
+
+var catalogOptions = catalog.Options{}
+var catalogOutput = ``
+
+// NewCatalogCmd returns the catalog subcommand used to generate Backstage catalog entities
+func NewCatalogCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     i18n.T("catalogCmdUse"),
+		Short:   i18n.T("catalogCmdShort"),
+		Long:    i18n.T("catalogCmdLong"),
+		Example: i18n.T("catalogCmdExample"),
+		Run:     runCatalogCmd,
+		Args:    cobra.ExactArgs(1),
+	}
+
+	cmd.Flags().StringVarP(&homeDir, "root", "r", "", i18n.T("flagHomeDir"))
+	cmd.Flags().StringVar(&catalogOptions.Owner, "owner", "guests", i18n.T("catalogFlagOwner"))
+	cmd.Flags().StringVar(&catalogOptions.Lifecycle, "lifecycle", "production", i18n.T("catalogFlagLifecycle"))
+	cmd.Flags().StringVar(&catalogOptions.System, "system", "", i18n.T("catalogFlagSystem"))
+	cmd.Flags().StringVarP(&catalogOutput, "output", "o", "", i18n.T("catalogFlagOutput"))
+
+	cmd.SetHelpTemplate(ui.HelpTemplate)
+	cmd.SetUsageTemplate(ui.UsageTemplate)
+
+	return cmd
+}
+
+func runCatalogCmd(cmd *cobra.Command, args []string) {
+	if homeDir != `` {
+		if err := os.Chdir(homeDir); err != nil {
+			ui.Message("error", fmt.Errorf("Unable to change directory to '%s'", homeDir))
+			exit(1)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if catalogOutput != `` {
+		f, err := os.Create(catalogOutput)
+		if err != nil {
+			ui.Message("error", err)
+			exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := catalog.Generate(args[0], catalogOptions, w); err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/conformance"
+	"github.com/lyraproj/lyra/pkg/i18n"
+	"github.com/lyraproj/lyra/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+var conformanceFormat string
+var conformancePluginDirs []string
+
+// newPluginConformanceCmd returns the plugin conformance subcommand used to certify the handlers of providers
+func newPluginConformanceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     i18n.T("conformanceCmdUse"),
+		Short:   i18n.T("conformanceCmdShort"),
+		Long:    i18n.T("conformanceCmdLong"),
+		Example: i18n.T("conformanceCmdExample"),
+		Run:     runConformanceCmd,
+		Args:    cobra.ExactArgs(1),
+	}
+
+	cmd.Flags().StringVarP(&homeDir, "root", "r", "", i18n.T("flagHomeDir"))
+	cmd.Flags().StringVar(&conformanceFormat, "format", "text", i18n.T("conformanceFlagFormat"))
+	cmd.Flags().StringArrayVar(&conformancePluginDirs, "plugin-dir", nil, i18n.T("conformanceFlagPluginDir"))
+
+	cmd.SetHelpTemplate(ui.HelpTemplate)
+	cmd.SetUsageTemplate(ui.UsageTemplate)
+
+	return cmd
+}
+
+func runConformanceCmd(cmd *cobra.Command, args []string) {
+	if conformanceFormat != `text` && conformanceFormat != `json` {
+		ui.Message("error", fmt.Errorf("Unknown format '%s', expected text or json", conformanceFormat))
+		exit(1)
+	}
+	cases, err := conformance.LoadCases(args[0])
+	if err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+	if homeDir != `` {
+		if err := os.Chdir(homeDir); err != nil {
+			ui.Message("error", fmt.Errorf("Unable to change directory to '%s'", homeDir))
+			exit(1)
+		}
+	}
+
+	results, err := conformance.Run(cases, conformance.WithPlugins(conformancePluginDirs...), conformance.WithLogger(logger.Get()))
+	if err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+	if conformanceFormat == `json` {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent(``, `  `)
+		enc.Encode(results)
+	} else {
+		for _, r := range results {
+			fmt.Println(r)
+		}
+	}
+	if conformance.AnyFailed(results) {
+		exit(1)
+	}
+}
@@ -2,7 +2,7 @@ package cmd
 
 import (
 	"fmt"
-	"os"
+	"time"
 
 	"github.com/go-logr/logr"
 	hclog "github.com/hashicorp/go-hclog"
@@ -13,12 +13,16 @@ import (
 	"github.com/lyraproj/lyra/cmd/lyra/ui"
 	"github.com/lyraproj/lyra/pkg/apply"
 	"github.com/lyraproj/lyra/pkg/i18n"
+	"github.com/lyraproj/lyra/pkg/loader"
 	"github.com/lyraproj/lyra/pkg/logger"
+	"github.com/lyraproj/lyra/pkg/profile"
 	"github.com/spf13/cobra"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 )
 
 var namespace string
+var pluginIdleTimeout time.Duration
+var pprofAddr string
 
 // NewControllerCmd starts the Kubernetes controller
 func NewControllerCmd() *cobra.Command {
@@ -33,6 +37,15 @@ func NewControllerCmd() *cobra.Command {
 
 	cmd.Flags().StringVarP(&namespace, "namespace", "n", "default", i18n.T("controllerNamespace"))
 	cmd.Flags().StringVarP(&homeDir, "root", "r", "", i18n.T("controllerFlagHomeDir"))
+	cmd.Flags().StringVar(&eventSink, "event-sink", "", i18n.T("flagEventSink"))
+	cmd.Flags().StringVar(&auditLog, "audit-log", "", i18n.T("flagAuditLog"))
+	cmd.Flags().BoolVar(&safeEval, "safe-eval", false, i18n.T("flagSafeEval"))
+	cmd.Flags().StringVar(&stepRole, "step-role", "", i18n.T("flagStepRole"))
+	cmd.Flags().BoolVar(&skipLeakCheck, "skip-leak-check", false, i18n.T("flagSkipLeakCheck"))
+	cmd.Flags().DurationVar(&pluginIdleTimeout, "plugin-idle-timeout", 10*time.Minute, i18n.T("controllerFlagPluginIdleTimeout"))
+	cmd.Flags().StringVar(&pprofAddr, "pprof-addr", "", i18n.T("controllerFlagPprofAddr"))
+	cmd.Flags().BoolVar(&incrementalApply, "incremental", false, i18n.T("flagIncremental"))
+	cmd.Flags().StringArrayVar(&providerLimits, "provider-limit", nil, i18n.T("flagProviderLimit"))
 
 	cmd.SetHelpTemplate(ui.HelpTemplate)
 	cmd.SetUsageTemplate(ui.UsageTemplate)
@@ -42,11 +55,25 @@ func NewControllerCmd() *cobra.Command {
 
 func runControllerCmd(cmd *cobra.Command, args []string) {
 	logf.SetLogger(&hclogLogger{hcLogger: logger.Get()})
-	applicator := &apply.Applicator{HomeDir: homeDir}
+	if pprofAddr != `` {
+		addr, err := profile.Serve(pprofAddr)
+		if err != nil {
+			logger.Get().Error("Failed to serve profiles", "err", err)
+			exit(1)
+		}
+		logger.Get().Info("Serving profiles", "url", "http://"+addr+"/debug/pprof/")
+	}
+	applicator := &apply.Applicator{HomeDir: homeDir, EventSink: eventSink, AuditLog: auditLog, SafeEval: safeEval, SkipLeakCheck: skipLeakCheck, StepRole: stepRole, Incremental: incrementalApply, ProviderLimits: providerLimits}
+	if pluginIdleTimeout > 0 {
+		applicator.Plugins = loader.NewPluginPool(pluginIdleTimeout)
+	}
 	err := controller.Start(namespace, applicator)
+	if applicator.Plugins != nil {
+		applicator.Plugins.Close()
+	}
 	if err != nil {
 		logger.Get().Error("Failed to start controller", "err", err)
-		os.Exit(1)
+		exit(1)
 	}
 }
 
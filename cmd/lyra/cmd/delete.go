@@ -6,7 +6,6 @@ import (
 	"github.com/lyraproj/lyra/pkg/i18n"
 	"github.com/lyraproj/servicesdk/wfapi"
 	"github.com/spf13/cobra"
-	"os"
 
 	// Ensure that lookup function properly loaded
 	_ "github.com/lyraproj/hiera/functions"
@@ -24,6 +23,16 @@ func NewDeleteCmd() *cobra.Command {
 	}
 
 	cmd.Flags().StringVarP(&homeDir, "root", "r", "", i18n.T("flagHomeDir"))
+	cmd.Flags().StringVar(&eventSink, "event-sink", "", i18n.T("flagEventSink"))
+	cmd.Flags().StringVar(&auditLog, "audit-log", "", i18n.T("flagAuditLog"))
+	cmd.Flags().BoolVar(&safeEval, "safe-eval", false, i18n.T("flagSafeEval"))
+	cmd.Flags().StringVar(&stepRole, "step-role", "", i18n.T("flagStepRole"))
+	cmd.Flags().StringArrayVar(&providerLimits, "provider-limit", nil, i18n.T("flagProviderLimit"))
+	cmd.Flags().StringVar(&recordCassette, "record", "", i18n.T("flagRecord"))
+	cmd.Flags().StringVar(&replayCassette, "replay", "", i18n.T("flagReplay"))
+	cmd.Flags().BoolVar(&noopRun, "noop", false, i18n.T("flagNoop"))
+	cmd.Flags().StringArrayVar(&chaosFaults, "chaos", nil, i18n.T("flagChaos"))
+	cmd.Flags().Int64Var(&chaosSeed, "chaos-seed", 0, i18n.T("flagChaosSeed"))
 
 	cmd.SetHelpTemplate(ui.HelpTemplate)
 	cmd.SetUsageTemplate(ui.UsageTemplate)
@@ -32,10 +41,10 @@ func NewDeleteCmd() *cobra.Command {
 }
 
 func runDeleteCmd(cmd *cobra.Command, args []string) {
-	applicator := &apply.Applicator{HomeDir: homeDir}
+	applicator := &apply.Applicator{HomeDir: homeDir, EventSink: eventSink, AuditLog: auditLog, SafeEval: safeEval, StepRole: stepRole, ProviderLimits: providerLimits, Record: recordCassette, Replay: replayCassette, Noop: noopRun, Chaos: chaosFaults, ChaosSeed: chaosSeed}
 	workflowName := args[0]
 	exitCode := applicator.ApplyWorkflow(workflowName, hieraDataFilename, wfapi.Delete)
 	if exitCode != 0 {
-		os.Exit(exitCode)
+		exit(exitCode)
 	}
 }
@@ -1,11 +1,14 @@
 package cmd
 
 import (
-	"os"
-
 	"github.com/lyraproj/lyra/cmd/goplugin-identity/identity"
+	"github.com/lyraproj/lyra/pkg/grpc"
+	"github.com/lyraproj/lyra/pkg/loader"
 	"github.com/lyraproj/lyra/pkg/logger"
+	"github.com/lyraproj/lyra/pkg/safe"
+	"github.com/lyraproj/puppet-evaluator/eval"
 	"github.com/lyraproj/puppet-workflow/puppet"
+	"github.com/lyraproj/servicesdk/serviceapi"
 	"github.com/spf13/cobra"
 )
 
@@ -18,20 +21,57 @@ func EmbeddedPluginCmd() *cobra.Command {
 		Args:   cobra.ExactArgs(1),
 	}
 
+	cmd.AddCommand(newPluginConformanceCmd())
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate())
+
+	return cmd
+}
+
+var pluginAllowEnv []string
+
+// PluginExecCmd starts a plugin with a scoped environment. The loader uses it to start all plugins.
+func PluginExecCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    loader.PluginExecCommand,
+		Hidden: true,
+		Run:    execPlugin,
+		Args:   cobra.MinimumNArgs(1),
+	}
+	cmd.Flags().StringSliceVar(&pluginAllowEnv, "allow-env", nil, "")
+	cmd.Flags().SetInterspersed(false)
+
 	cmd.SetHelpTemplate(cmd.HelpTemplate())
 
 	return cmd
 }
 
+func execPlugin(cmd *cobra.Command, args []string) {
+	err := loader.RunPlugin(pluginAllowEnv, args[0], args[1:])
+	logger.Get().Error("Unable to start plugin", "cmd", args[0], "err", err)
+	exit(1)
+}
+
 func startPlugin(cmd *cobra.Command, args []string) {
 	name := args[0]
 	switch name {
 	case "identity":
 		identity.Start("identity.db")
 	case "puppet":
-		puppet.Start(`Puppet`)
+		startPuppet()
 	default:
 		logger.Get().Error("Unknown embedded plugin", "name", name)
-		os.Exit(1)
+		exit(1)
 	}
 }
+
+// startPuppet starts the Puppet DSL plugin. In safe evaluation mode, the plugin uses a loader that makes
+// functions with side effects unavailable to the manifests that it loads.
+func startPuppet() {
+	puppet.WithService(`Puppet`, func(c eval.Context, s serviceapi.Service) {
+		if safe.Enabled() {
+			c.SetLoader(safe.NewLoader(c.Loader().(eval.DefiningLoader)))
+		}
+		grpc.Serve(c, s)
+	})
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/example"
+	"github.com/lyraproj/lyra/pkg/i18n"
+	"github.com/lyraproj/lyra/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+var exampleOutput string
+
+// NewExampleCmd returns the example subcommand used to generate a sample workflow for a resource type
+func NewExampleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     i18n.T("exampleCmdUse"),
+		Short:   i18n.T("exampleCmdShort"),
+		Long:    i18n.T("exampleCmdLong"),
+		Example: i18n.T("exampleCmdExample"),
+		Run:     runExampleCmd,
+		Args:    cobra.ExactArgs(1),
+	}
+
+	cmd.Flags().StringVarP(&homeDir, "root", "r", "", i18n.T("flagHomeDir"))
+	cmd.Flags().StringVarP(&exampleOutput, "output", "o", "", i18n.T("exampleFlagOutput"))
+
+	cmd.SetHelpTemplate(ui.HelpTemplate)
+	cmd.SetUsageTemplate(ui.UsageTemplate)
+
+	return cmd
+}
+
+func runExampleCmd(cmd *cobra.Command, args []string) {
+	if homeDir != `` {
+		if err := os.Chdir(homeDir); err != nil {
+			ui.Message("error", fmt.Errorf("Unable to change directory to '%s'", homeDir))
+			exit(1)
+		}
+	}
+
+	// A workflow is found by the name of its file, so the workflow is named after the file it is written to
+	name := example.Name(args[0])
+	var w io.Writer = os.Stdout
+	if exampleOutput != `` {
+		name = strings.TrimSuffix(filepath.Base(exampleOutput), filepath.Ext(exampleOutput))
+		f, err := os.Create(exampleOutput)
+		if err != nil {
+			ui.Message("error", err)
+			exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := example.Generate(args[0], name, w, logger.Get()); err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+}
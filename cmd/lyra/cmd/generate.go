@@ -6,8 +6,6 @@ import (
 	"github.com/lyraproj/lyra/pkg/i18n"
 	"github.com/spf13/cobra"
 
-	"os"
-
 	// Ensure that lookup function properly loaded
 	_ "github.com/lyraproj/hiera/functions"
 )
@@ -40,8 +38,8 @@ func runGenerateCmd(cmd *cobra.Command, args []string) {
 	err := generate.Generate(language, targetDirectory)
 	if err != nil {
 		ui.Message("error", err)
-		os.Exit(0)
+		exit(0)
 	}
 	ui.ShowMessage("Generation complete")
-	os.Exit(1)
+	exit(1)
 }
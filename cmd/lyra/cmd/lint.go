@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/i18n"
+	"github.com/lyraproj/lyra/pkg/lint"
+	"github.com/spf13/cobra"
+)
+
+var lintFormat string
+var lintOutput string
+var lintRequiredTags []string
+var lintDeprecatedTypes []string
+var lintDisabled []string
+var lintRules []string
+
+// NewLintCmd returns the lint subcommand used to find likely mistakes in workflow manifests
+func NewLintCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     i18n.T("lintCmdUse"),
+		Short:   i18n.T("lintCmdShort"),
+		Long:    i18n.T("lintCmdLong"),
+		Example: i18n.T("lintCmdExample"),
+		Run:     runLintCmd,
+		Args:    cobra.MinimumNArgs(1),
+	}
+
+	cmd.Flags().StringVar(&lintFormat, "format", "text", i18n.T("lintFlagFormat"))
+	cmd.Flags().StringVarP(&lintOutput, "output", "o", "", i18n.T("lintFlagOutput"))
+	cmd.Flags().StringArrayVar(&lintRequiredTags, "required-tag", nil, i18n.T("lintFlagRequiredTag"))
+	cmd.Flags().StringArrayVar(&lintDeprecatedTypes, "deprecated-type", nil, i18n.T("lintFlagDeprecatedType"))
+	cmd.Flags().StringArrayVar(&lintDisabled, "disable", nil, i18n.T("lintFlagDisable"))
+	cmd.Flags().StringArrayVar(&lintRules, "rule", nil, i18n.T("lintFlagRule"))
+
+	cmd.SetHelpTemplate(ui.HelpTemplate)
+	cmd.SetUsageTemplate(ui.UsageTemplate)
+
+	return cmd
+}
+
+func runLintCmd(cmd *cobra.Command, args []string) {
+	if lintFormat != `text` && lintFormat != `sarif` {
+		ui.Message("error", fmt.Errorf("Unknown format '%s', expected text or sarif", lintFormat))
+		exit(1)
+	}
+	cfg := &lint.Config{RequiredTags: lintRequiredTags, Disabled: lintDisabled, DeprecatedTypes: map[string]string{}}
+	for _, dt := range lintDeprecatedTypes {
+		kv := strings.SplitN(dt, `=`, 2)
+		if len(kv) == 2 {
+			cfg.DeprecatedTypes[kv[0]] = kv[1]
+		} else {
+			cfg.DeprecatedTypes[dt] = ``
+		}
+	}
+	for _, path := range lintRules {
+		r, err := lint.NewExecRule(path)
+		if err != nil {
+			ui.Message("error", err)
+			exit(1)
+		}
+		cfg.Rules = append(cfg.Rules, r)
+	}
+
+	var findings []lint.Finding
+	for _, manifest := range args {
+		fs, err := lint.Lint(manifest, cfg)
+		if err != nil {
+			ui.Message("error", err)
+			exit(1)
+		}
+		findings = append(findings, fs...)
+	}
+
+	var w io.Writer = os.Stdout
+	if lintOutput != `` {
+		f, err := os.Create(lintOutput)
+		if err != nil {
+			ui.Message("error", err)
+			exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	if lintFormat == `sarif` {
+		if err := lint.WriteSARIF(w, lint.Rules(cfg), findings); err != nil {
+			ui.Message("error", err)
+			exit(1)
+		}
+	} else {
+		for _, f := range findings {
+			fmt.Fprintln(w, f)
+		}
+	}
+
+	// Only errors fail the command, so that warnings can be introduced without breaking builds
+	for _, f := range findings {
+		if f.Level == lint.Error {
+			exit(1)
+		}
+	}
+}
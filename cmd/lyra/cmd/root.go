@@ -5,8 +5,10 @@ import (
 	"os"
 
 	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/fips"
 	"github.com/lyraproj/lyra/pkg/i18n"
 	"github.com/lyraproj/lyra/pkg/logger"
+	"github.com/lyraproj/lyra/pkg/profile"
 	"github.com/lyraproj/lyra/pkg/version"
 	"github.com/mgutz/ansi"
 	"github.com/spf13/cobra"
@@ -14,11 +16,17 @@ import (
 
 const (
 	defaultLogEncoding = "console"
+
+	// logBufferSize is the number of log entries that are queued while they are written in the background
+	logBufferSize = 4096
 )
 
 var (
-	debug    bool
-	loglevel string
+	debug       bool
+	loglevel    string
+	profileKind string
+	profileFile string
+	stopProfile func() error
 )
 
 // NewRootCmd returns the root command
@@ -28,16 +36,19 @@ func NewRootCmd() *cobra.Command {
 	i18n.Configure("locales", "en_US", "default")
 
 	cmd := &cobra.Command{
-		Use:              i18n.T("rootCmdUse"),
-		Short:            i18n.T("rootCmdShort"),
-		Long:             i18n.T("rootCmdLong"),
-		Run:              runHelp,
-		PersistentPreRun: initialiseTool,
-		Version:          fmt.Sprintf("%v", version.Get()),
+		Use:               i18n.T("rootCmdUse"),
+		Short:             i18n.T("rootCmdShort"),
+		Long:              i18n.T("rootCmdLong"),
+		Run:               runHelp,
+		PersistentPreRun:  initialiseTool,
+		PersistentPostRun: finaliseTool,
+		Version:           fmt.Sprintf("%v", version.Get()),
 	}
 
 	cmd.PersistentFlags().BoolVar(&debug, "debug", false, i18n.T("rootFlagDebug"))
 	cmd.PersistentFlags().StringVar(&loglevel, "loglevel", "", i18n.T("rootFlagLoglevel"))
+	cmd.PersistentFlags().StringVar(&profileKind, "profile", "", i18n.T("rootFlagProfile"))
+	cmd.PersistentFlags().StringVar(&profileFile, "profile-file", "", i18n.T("rootFlagProfileFile"))
 
 	cmd.SetHelpTemplate(ansi.Blue + version.LogoFiglet + ansi.Reset + ui.HelpTemplate)
 	cmd.SetUsageTemplate(ui.UsageTemplate)
@@ -48,7 +59,15 @@ func NewRootCmd() *cobra.Command {
 	cmd.AddCommand(NewControllerCmd())
 	cmd.AddCommand(NewValidateCmd())
 	cmd.AddCommand(NewGenerateCmd())
+	cmd.AddCommand(NewExampleCmd())
+	cmd.AddCommand(NewCatalogCmd())
+	cmd.AddCommand(NewTestCmd())
+	cmd.AddCommand(NewLintCmd())
+	cmd.AddCommand(NewServeCmd())
+	cmd.AddCommand(NewSignCmd())
+	cmd.AddCommand(NewAuditCmd())
 	cmd.AddCommand(EmbeddedPluginCmd())
+	cmd.AddCommand(PluginExecCmd())
 
 	return cmd
 }
@@ -62,9 +81,41 @@ func initialiseTool(cmd *cobra.Command, args []string) {
 		loglevel = "debug"
 	}
 	spec := logger.Spec{
-		Name:   "lyra",
-		Level:  loglevel,
-		Output: os.Stderr,
+		Name:       "lyra",
+		Level:      loglevel,
+		Output:     os.Stderr,
+		BufferSize: logBufferSize,
 	}
 	logger.Initialise(spec)
+
+	if err := fips.Check(); err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+
+	if profileKind != `` {
+		var err error
+		if stopProfile, err = profile.Start(profileKind, profileFile); err != nil {
+			ui.Message("error", err)
+			exit(1)
+		}
+	}
+}
+
+func finaliseTool(cmd *cobra.Command, args []string) {
+	logger.Flush()
+	if stopProfile == nil {
+		return
+	}
+	if err := stopProfile(); err != nil {
+		ui.Message("error", fmt.Errorf("unable to write %s profile: %v", profileKind, err))
+	}
+	stopProfile = nil
+}
+
+// exit ends the process with the given code once all log entries and the profile of the run, if any,
+// are written
+func exit(code int) {
+	finaliseTool(nil, nil)
+	os.Exit(code)
 }
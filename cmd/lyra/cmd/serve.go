@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/catalog"
+	"github.com/lyraproj/lyra/pkg/i18n"
+	"github.com/lyraproj/lyra/pkg/logger"
+	"github.com/lyraproj/lyra/pkg/server"
+	"github.com/spf13/cobra"
+
+	// Ensure that lookup function properly loaded
+	_ "github.com/lyraproj/hiera/functions"
+)
+
+var serveListen = ``
+var serveUI = false
+
+// NewServeCmd returns the serve subcommand used to serve workflows, their runs and state over HTTP
+func NewServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     i18n.T("serveCmdUse"),
+		Short:   i18n.T("serveCmdShort"),
+		Long:    i18n.T("serveCmdLong"),
+		Example: i18n.T("serveCmdExample"),
+		Run:     runServeCmd,
+		Args:    cobra.NoArgs,
+	}
+
+	cmd.Flags().StringVarP(&homeDir, "root", "r", "", i18n.T("flagHomeDir"))
+	cmd.Flags().StringVar(&serveListen, "listen", "127.0.0.1:8080", i18n.T("serveFlagListen"))
+	cmd.Flags().BoolVar(&serveUI, "ui", false, i18n.T("serveFlagUI"))
+
+	cmd.SetHelpTemplate(ui.HelpTemplate)
+	cmd.SetUsageTemplate(ui.UsageTemplate)
+
+	return cmd
+}
+
+func runServeCmd(cmd *cobra.Command, args []string) {
+	if homeDir != `` {
+		if err := os.Chdir(homeDir); err != nil {
+			ui.Message("error", fmt.Errorf("Unable to change directory to '%s'", homeDir))
+			exit(1)
+		}
+	}
+
+	wfs, err := catalog.LoadWorkflows()
+	// The workflows are only read, so the plugins are not needed once they are loaded
+	plugin.CleanupClients()
+	if err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+
+	var options []server.Option
+	if serveUI {
+		options = append(options, server.WithUI())
+	}
+	l, err := net.Listen("tcp", serveListen)
+	if err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+	logger.Get().Info("Serving workflows", "url", "http://"+l.Addr().String()+"/", "workflows", len(wfs), "ui", serveUI)
+	if err = http.Serve(l, server.New(wfs, options...).Handler()); err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/i18n"
+	"github.com/lyraproj/lyra/pkg/signing"
+	"github.com/spf13/cobra"
+)
+
+var signKey = ``
+var signGenerateKey = ``
+
+// NewSignCmd returns the sign subcommand used to sign manifests
+func NewSignCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     i18n.T("signCmdUse"),
+		Short:   i18n.T("signCmdShort"),
+		Long:    i18n.T("signCmdLong"),
+		Example: i18n.T("signCmdExample"),
+		Run:     runSignCmd,
+	}
+
+	cmd.Flags().StringVarP(&signKey, "key", "k", "", i18n.T("signFlagKey"))
+	cmd.Flags().StringVar(&signGenerateKey, "generate-key", "", i18n.T("signFlagGenerateKey"))
+
+	cmd.SetHelpTemplate(ui.HelpTemplate)
+	cmd.SetUsageTemplate(ui.UsageTemplate)
+
+	return cmd
+}
+
+func runSignCmd(cmd *cobra.Command, args []string) {
+	if signGenerateKey != `` {
+		if err := signing.GenerateKeyPair(signGenerateKey); err != nil {
+			ui.Message("error", err)
+			exit(1)
+		}
+		ui.Message("info", fmt.Sprintf("wrote %s%s and %s%s", signGenerateKey, signing.PrivateKeyExt, signGenerateKey, signing.PublicKeyExt))
+		return
+	}
+
+	if signKey == `` || len(args) == 0 {
+		cmd.Usage()
+		exit(1)
+	}
+	key, err := signing.ReadPrivateKey(signKey)
+	if err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+	for _, file := range args {
+		if err = signing.SignFile(file, key); err != nil {
+			ui.Message("error", err)
+			exit(1)
+		}
+		ui.Message("info", fmt.Sprintf("signed %s", file))
+	}
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/i18n"
+	"github.com/lyraproj/lyra/pkg/lyratest"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var updateGolden bool
+
+// NewTestCmd returns the test subcommand used to compare the plans of workflows with golden files
+func NewTestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     i18n.T("testCmdUse"),
+		Short:   i18n.T("testCmdShort"),
+		Long:    i18n.T("testCmdLong"),
+		Example: i18n.T("testCmdExample"),
+		Run:     runTestCmd,
+		Args:    cobra.MinimumNArgs(1),
+	}
+
+	cmd.Flags().StringVarP(&hieraDataFilename, "data", "d", "data.yaml", i18n.T("testFlagData"))
+	cmd.Flags().BoolVar(&updateGolden, "update", false, i18n.T("testFlagUpdate"))
+
+	cmd.SetHelpTemplate(ui.HelpTemplate)
+	cmd.SetUsageTemplate(ui.UsageTemplate)
+
+	return cmd
+}
+
+func runTestCmd(cmd *cobra.Command, args []string) {
+	data, err := readTestData(hieraDataFilename, cmd.Flags().Changed("data"))
+	if err != nil {
+		ui.Message("error", err)
+		exit(1)
+	}
+
+	failed := false
+	for _, manifest := range args {
+		if err := lyratest.CheckPlans(manifest, updateGolden, lyratest.WithData(data)); err != nil {
+			ui.Message("error", fmt.Errorf("%s: %s", manifest, err))
+			failed = true
+		} else if updateGolden {
+			ui.ShowMessage("golden files updated:", manifest)
+		} else {
+			ui.ShowMessage("plans match:", manifest)
+		}
+	}
+	if failed {
+		exit(1)
+	}
+}
+
+// readTestData reads the data that lookups of the workflows find. The default data file is optional.
+func readTestData(path string, required bool) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return data, nil
+		}
+		return nil, err
+	}
+	if err = yaml.Unmarshal(bs, &data); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err)
+	}
+	return data, nil
+}
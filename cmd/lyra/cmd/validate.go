@@ -1,8 +1,6 @@
 package cmd
 
 import (
-	"os"
-
 	"github.com/lyraproj/issue/issue"
 	"github.com/lyraproj/lyra/cmd/lyra/ui"
 	"github.com/lyraproj/lyra/pkg/i18n"
@@ -41,7 +39,7 @@ func runValidate(cmd *cobra.Command, args []string) {
 		default:
 			ui.ValidationError(err)
 		}
-		os.Exit(1)
+		exit(1)
 	}
 	ui.ValidationSuccess()
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/fips"
 	"github.com/lyraproj/lyra/pkg/i18n"
 	"github.com/lyraproj/lyra/pkg/version"
 
@@ -32,5 +33,9 @@ func runVersion(cmd *cobra.Command, args []string) {
 
 func prettyPrintVersion() string {
 	v := version.Get()
-	return fmt.Sprintf("Tag:\t\t%s\nCommit:\t\t%s\nBuildTime:\t%s", v.BuildTag, v.BuildSHA, v.BuildTime)
+	fipsMode := `disabled`
+	if fips.Enabled() {
+		fipsMode = `enabled (` + fips.Module() + `)`
+	}
+	return fmt.Sprintf("Tag:\t\t%s\nCommit:\t\t%s\nBuildTime:\t%s\nFIPS:\t\t%s", v.BuildTag, v.BuildSHA, v.BuildTime, fipsMode)
 }
@@ -5,11 +5,15 @@ import (
 	"os"
 
 	"github.com/lyraproj/lyra/cmd/lyra/cmd"
+	"github.com/lyraproj/lyra/pkg/logger"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 )
 
 func main() {
+	// Log entries are written in the background and must not be lost when a command panics
+	defer logger.Flush()
 	if err := cmd.NewRootCmd().Execute(); err != nil {
+		logger.Flush()
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(-1)
 	}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lyraproj/lyra/pkg/logger"
 	"github.com/mgutz/ansi"
 )
 
@@ -20,9 +21,13 @@ func init() {
 // Message prepends messages about what we are going to do
 // with colour and an informative label
 func Message(kind string, message interface{}) {
+	// Log entries are written in the background and must appear before the message
+	logger.Flush()
 	switch kind {
 	case "resource":
 		log.Println(ansi.Green+"[set resource]"+ansi.Reset, message)
+	case "warning":
+		log.Println(ansi.Yellow+"[warning]"+ansi.Reset, message)
 	// Generic error
 	case "error":
 		log.Println(ansi.Red+"[error]"+ansi.Reset, message)
@@ -33,6 +38,7 @@ func Message(kind string, message interface{}) {
 
 // ShowMessage prints an attractive message to STDOUT
 func ShowMessage(params ...string) {
+	logger.Flush()
 	var action = ""
 	var msg = ""
 	if len(params) > 1 {
@@ -46,6 +52,7 @@ func ShowMessage(params ...string) {
 
 // AskForConfirmation presents a blocking choice to users
 func AskForConfirmation(s string) bool {
+	logger.Flush()
 	// Quiet implies yes. This might not be the right choice.
 	reader := bufio.NewReader(os.Stdin)
 
@@ -74,16 +81,19 @@ func Delay(durationMs int) {
 
 // ValidationFailure pretty prints a validation failure message
 func ValidationFailure(err error) {
+	logger.Flush()
 	fmt.Fprintln(os.Stderr, ansi.Red+"▸ Manifest Invalid "+ansi.Reset+err.Error())
 }
 
 // ValidationSuccess pretty prints a validation success message
 func ValidationSuccess() {
+	logger.Flush()
 	fmt.Fprintln(os.Stderr, ansi.Green+"▸ Manifest Valid "+ansi.Reset)
 }
 
 // ValidationError pretty prints a validation error message
 func ValidationError(err error) {
+	logger.Flush()
 	fmt.Fprintln(os.Stderr, ansi.Red+"▸ Error validating manifest "+ansi.Reset+err.Error())
 }
 
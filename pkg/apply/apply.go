@@ -5,14 +5,25 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	hclog "github.com/hashicorp/go-hclog"
 	plugin "github.com/hashicorp/go-plugin"
 	"github.com/lyraproj/hiera/lookup"
 	"github.com/lyraproj/hiera/provider"
 	"github.com/lyraproj/lyra/cmd/lyra/ui"
+	"github.com/lyraproj/lyra/pkg/audit"
+	"github.com/lyraproj/lyra/pkg/cassette"
+	"github.com/lyraproj/lyra/pkg/chaos"
+	"github.com/lyraproj/lyra/pkg/event"
+	"github.com/lyraproj/lyra/pkg/incremental"
+	"github.com/lyraproj/lyra/pkg/leak"
 	"github.com/lyraproj/lyra/pkg/loader"
 	"github.com/lyraproj/lyra/pkg/logger"
+	"github.com/lyraproj/lyra/pkg/noop"
+	"github.com/lyraproj/lyra/pkg/safe"
+	"github.com/lyraproj/lyra/pkg/stepcred"
+	"github.com/lyraproj/lyra/pkg/throttle"
 	"github.com/lyraproj/puppet-evaluator/eval"
 	"github.com/lyraproj/puppet-evaluator/types"
 	"github.com/lyraproj/servicesdk/serviceapi"
@@ -28,6 +39,59 @@ import (
 // Applicator is used to apply workflows
 type Applicator struct {
 	HomeDir string
+
+	// EventSink is the URI of the sink that will receive lifecycle events. The LYRA_EVENT_SINK
+	// environment variable is used when it is empty. No events are emitted when neither is set.
+	EventSink string
+
+	// AuditLog is the path of a hash chained log that records all lifecycle events. The LYRA_AUDIT_LOG
+	// environment variable is used when it is empty.
+	AuditLog string
+
+	// SafeEval disables functions with side effects, such as exec, in workflow expressions. The
+	// LYRA_SAFE_EVAL environment variable enables it when this field is false.
+	SafeEval bool
+
+	// SkipLeakCheck disables the warnings about resources that contain secrets in plaintext
+	SkipLeakCheck bool
+
+	// StepRole is the AWS role that is assumed, with a session policy for the type of the resource, for
+	// each step that manages an AWS resource. The LYRA_STEP_ROLE environment variable is used when it is
+	// empty. Providers use their own credentials when neither is set.
+	StepRole string
+
+	// Incremental uses the states recorded by the last successful incremental run in place of reading
+	// resources whose desired state is unchanged. Changes made to those resources outside of lyra are
+	// not detected.
+	Incremental bool
+
+	// ProviderLimits caps the calls made to each provider, see throttle.ParseLimits for their form. The
+	// LYRA_PROVIDER_LIMITS environment variable is used when it is empty.
+	ProviderLimits []string
+
+	// Record is the path of a cassette file where the calls made to providers, and their results, are
+	// recorded
+	Record string
+
+	// Replay is the path of a cassette file whose recorded calls are replayed in place of calling the
+	// providers
+	Replay string
+
+	// Noop rehearses the run. Resources are read but creates, updates and deletes are simulated, and
+	// neither the identity store nor the states recorded for incremental runs are changed.
+	Noop bool
+
+	// Chaos are the faults that are injected into the calls made to providers, see chaos.ParseFaults for
+	// their form. The LYRA_CHAOS environment variable is used when it is empty.
+	Chaos []string
+
+	// ChaosSeed seeds the faults that are injected with a probability, so that a run can be repeated with
+	// the same faults. A seed based on the current time is used when it is zero.
+	ChaosSeed int64
+
+	// Plugins keeps the plugin processes that provide resources alive between runs. Plugins are started
+	// for each run when it is nil.
+	Plugins *loader.PluginPool
 }
 
 type cmdError string
@@ -48,7 +112,7 @@ func (a *Applicator) applyWithHieraData(workflowName string, hieraData map[strin
 	tp := func(ic lookup.ProviderContext, key string, _ map[string]eval.Value) (eval.Value, bool) {
 		return v.Get4(key)
 	}
-	lookup.DoWithParent(context.Background(), tp, nil, applyWithContext(workflowName, intent))
+	lookup.DoWithParent(context.Background(), tp, nil, a.applyWithContext(workflowName, intent))
 }
 
 //convertToDeepMap converts a map[string]string with entries like {k:"aws.tags.created_by", v:"user@company.com"}
@@ -87,7 +151,6 @@ func (a *Applicator) ApplyWorkflow(workflowName, hieraDataFilename string, inten
 			return 1
 		}
 	}
-
 	defer func() {
 		plugin.CleanupClients()
 		logger.Get().Debug("all plugins cleaned up")
@@ -101,20 +164,157 @@ func (a *Applicator) ApplyWorkflow(workflowName, hieraDataFilename string, inten
 		}
 	}()
 
+	dataProvider := lookup.LookupKey(provider.Yaml)
+	data, encrypted, err := readSopsFile(context.Background(), hieraDataFilename)
+	if err != nil {
+		panic(cmdError(err.Error()))
+	}
+	if encrypted {
+		dataProvider = sopsProvider(data)
+	}
+
 	lookupOptions := map[string]eval.Value{
 		`path`:                      types.WrapString(hieraDataFilename),
-		provider.LookupProvidersKey: types.WrapRuntime([]lookup.LookupKey{provider.Yaml, provider.Environment})}
+		provider.LookupProvidersKey: types.WrapRuntime([]lookup.LookupKey{dataProvider, provider.Environment})}
 
-	lookup.DoWithParent(context.Background(), provider.MuxLookup, lookupOptions, applyWithContext(workflowName, intent))
+	lookup.DoWithParent(context.Background(), provider.MuxLookup, lookupOptions, a.applyWithContext(workflowName, intent))
 	return 0
 }
 
-func applyWithContext(workflowName string, intent wfapi.Operation) func(eval.Context) {
+// newEmitter returns an event emitter for the configured event sink and audit log or nil if neither is
+// configured. A workflow is never run without its audit log so failure to open the log is an error.
+func (a *Applicator) newEmitter(logger hclog.Logger) *event.Emitter {
+	var sinks []event.Sink
+	auditLog, err := audit.OpenConfigured(a.AuditLog)
+	if err != nil {
+		panic(cmdError(fmt.Sprintf("Unable to open audit log: %s", err)))
+	}
+	if auditLog != nil {
+		sinks = append(sinks, auditLog)
+	}
+
+	uri := a.EventSink
+	if uri == `` {
+		uri = os.Getenv(event.SinkEnvVar)
+	}
+	if uri != `` {
+		sink, err := event.NewSink(uri)
+		if err != nil {
+			logger.Error("unable to create event sink, no events will be emitted", "sink", uri, "err", err)
+		} else {
+			sinks = append(sinks, sink)
+		}
+	}
+
+	var sink event.Sink
+	switch len(sinks) {
+	case 0:
+		return nil
+	case 1:
+		sink = sinks[0]
+	default:
+		sink = event.NewMultiSink(sinks...)
+	}
+	source := `/lyra`
+	if host, err := os.Hostname(); err == nil {
+		source += `/` + host
+	}
+	return event.NewEmitter(source, sink, logger)
+}
+
+func (a *Applicator) applyWithContext(workflowName string, intent wfapi.Operation) func(eval.Context) {
 	return func(c eval.Context) {
 		logger := logger.Get()
-		loader := loader.New(logger, c.Loader())
+		if a.SafeEval {
+			// The plugins that evaluate workflow expressions inherit the environment of this process
+			os.Setenv(safe.EnvVar, `true`)
+		}
+		emitter := a.newEmitter(logger)
+		defer emitter.Close()
+
+		var options []loader.Option
+		var simulator *noop.Simulator
+		if a.Noop {
+			if a.Record != `` {
+				panic(cmdError("Calls of a noop run cannot be recorded"))
+			}
+			// Added first so that the other wrappers see the simulated calls as if they were real
+			simulator = noop.New()
+			options = append(options, loader.WithServiceWrapper(simulator.WrapService()))
+		}
+		k := a.cassette()
+		if k != nil {
+			// Added first so that the calls are recorded, or replayed, as the providers see them
+			options = append(options, loader.WithServiceWrapper(k.WrapService()))
+			if a.Record != `` {
+				defer func() {
+					// Calls of failed runs are recorded too, so that failures can be replayed
+					if err := k.Save(); err != nil {
+						logger.Error("unable to save cassette", "path", a.Record, "err", err)
+					}
+				}()
+			}
+		}
+		injector := a.chaos(logger)
+		if injector != nil {
+			// Added before the event and throttle wrappers so that faults are seen, and retried, as real ones
+			options = append(options, loader.WithServiceWrapper(injector.WrapService()))
+			defer func() {
+				ui.ShowMessage("chaos:", fmt.Sprintf("%d faults were injected", injector.Injected()))
+			}()
+		}
+		if emitter != nil {
+			options = append(options, loader.WithServiceWrapper(event.WrapService(emitter)))
+		}
+		if !a.SkipLeakCheck {
+			options = append(options, loader.WithServiceWrapper(leak.WrapService(warnLeak)))
+		}
+		role := a.StepRole
+		if role == `` {
+			role = os.Getenv(stepcred.RoleEnvVar)
+		}
+		if role != `` {
+			options = append(options, loader.WithServiceWrapper(stepcred.WrapService(stepcred.NewAssumeRoleSource(role))))
+		}
+		if limits := a.providerLimits(); len(limits) > 0 {
+			options = append(options, loader.WithServiceWrapper(throttle.WrapService(limits, logger)))
+		}
+		if a.Plugins != nil {
+			options = append(options, loader.WithPluginPool(a.Plugins))
+		}
+		var store *incremental.Store
+		if a.Incremental && intent != wfapi.Delete {
+			var err error
+			if store, err = incremental.Open(incremental.DefaultFile); err != nil {
+				panic(cmdError(fmt.Sprintf("Unable to read recorded states: %s", err)))
+			}
+			// Added last so that resources that are skipped are not seen by the other wrappers
+			options = append(options, loader.WithServiceWrapper(store.WrapService()))
+		}
+		loader := loader.New(logger, c.Loader(), options...)
 		loader.PreLoad(c)
 		logger.Debug("all plugins loaded")
+
+		data := map[string]interface{}{`operation`: intent.String()}
+		if a.Noop {
+			data[`noop`] = true
+		}
+		if injector != nil {
+			data[`chaos`] = true
+		}
+		emitter.Emit(event.WorkflowStarted, workflowName, data)
+		defer func() {
+			if e := recover(); e != nil {
+				failed := map[string]interface{}{`error`: fmt.Sprint(e)}
+				for key, v := range data {
+					failed[key] = v
+				}
+				emitter.Emit(event.WorkflowFailed, workflowName, failed)
+				panic(e)
+			}
+			emitter.Emit(event.WorkflowFinished, workflowName, data)
+		}()
+
 		c.DoWithLoader(loader, func() {
 			if intent == wfapi.Delete {
 				logger.Debug("calling delete")
@@ -128,9 +328,90 @@ func applyWithContext(workflowName string, intent wfapi.Operation) func(eval.Con
 				logger.Debug("apply finished")
 			}
 		})
+		if simulator != nil {
+			creates, updates, deletes := simulator.Simulated()
+			ui.ShowMessage("noop:", fmt.Sprintf("%d creates, %d updates and %d deletes were simulated", creates, updates, deletes))
+		} else if store != nil {
+			if err := store.Commit(); err != nil {
+				logger.Error("unable to record states, the next incremental run will read all resources", "err", err)
+			} else {
+				logger.Debug("states recorded", "skipped", store.Skipped())
+			}
+		}
+		if k != nil && a.Replay != `` {
+			if n := k.Unplayed(); n > 0 {
+				ui.Message("warning", fmt.Sprintf("%d calls recorded in %s were not replayed", n, a.Replay))
+			}
+		}
 	}
 }
 
+// cassette returns the cassette that records or replays the calls made to providers, or nil if calls
+// are neither recorded nor replayed
+func (a *Applicator) cassette() *cassette.Cassette {
+	switch {
+	case a.Record != `` && a.Replay != ``:
+		panic(cmdError("Calls cannot be recorded and replayed in the same run"))
+	case a.Record != ``:
+		return cassette.Create(a.Record)
+	case a.Replay != ``:
+		k, err := cassette.Load(a.Replay)
+		if err != nil {
+			panic(cmdError(fmt.Sprintf("Unable to read cassette: %s", err)))
+		}
+		return k
+	}
+	return nil
+}
+
+// chaos returns the injector of the configured faults, or nil if no faults are configured
+func (a *Applicator) chaos(logger hclog.Logger) *chaos.Injector {
+	specs := a.Chaos
+	if len(specs) == 0 {
+		for _, spec := range strings.Split(os.Getenv(chaos.FaultsEnvVar), `;`) {
+			if spec = strings.TrimSpace(spec); spec != `` {
+				specs = append(specs, spec)
+			}
+		}
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+	faults, err := chaos.ParseFaults(specs)
+	if err != nil {
+		panic(cmdError(err.Error()))
+	}
+	seed := a.ChaosSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	logger.Info("injecting faults", "faults", len(faults), "seed", seed)
+	return chaos.New(faults, seed, logger)
+}
+
+// providerLimits returns the configured limits of the providers
+func (a *Applicator) providerLimits() map[string]throttle.Limit {
+	specs := a.ProviderLimits
+	if len(specs) == 0 {
+		for _, spec := range strings.Split(os.Getenv(throttle.LimitsEnvVar), `;`) {
+			if spec = strings.TrimSpace(spec); spec != `` {
+				specs = append(specs, spec)
+			}
+		}
+	}
+	limits, err := throttle.ParseLimits(specs)
+	if err != nil {
+		panic(cmdError(err.Error()))
+	}
+	return limits
+}
+
+// warnLeak warns that a resource contains what looks like a secret before it is passed to the
+// provider and ends up in the state or in provider tags
+func warnLeak(identifier string, f leak.Finding) {
+	ui.Message("warning", fmt.Sprintf("%s: possible plaintext secret, %s. Consider wrapping it in Sensitive", identifier, f))
+}
+
 func loadActivity(c eval.Context, activityID string) api.Activity {
 	def, ok := eval.Load(c, eval.NewTypedName(eval.NsDefinition, activityID))
 	if !ok {
@@ -1,9 +1,16 @@
 package apply
 
 import (
+	"context"
 	"fmt"
-	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConvertToDeepMap(t *testing.T) {
@@ -28,3 +35,63 @@ func TestConvertToDeepMap(t *testing.T) {
 	lifetime := tags["lifetime"].(string)
 	require.Equal(t, "2hrs", lifetime)
 }
+
+const sopsEncrypted = `db:
+    user: admin
+    password: ENC[AES256_GCM,data:3ZQd,iv:AAAA,tag:BBBB,type:str]
+tokens:
+    - ENC[AES256_GCM,data:f00=,iv:AAAA,tag:BBBB,type:str]
+region: eu-west-1
+sops:
+    mac: ENC[AES256_GCM,data:abc,iv:AAAA,tag:BBBB,type:str]
+    version: 3.2.0
+`
+
+const fakeSops = `#!/bin/sh
+echo '{"db":{"user":"admin","password":"s3cret"},"tokens":["t0ken"],"region":"eu-west-1"}'
+`
+
+func TestReadSopsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sops")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sops := filepath.Join(dir, "sops")
+	require.Nil(t, ioutil.WriteFile(sops, []byte(fakeSops), 0755))
+	os.Setenv(SopsEnvVar, sops)
+	defer os.Unsetenv(SopsEnvVar)
+
+	plain := filepath.Join(dir, "data.yaml")
+	require.Nil(t, ioutil.WriteFile(plain, []byte("region: eu-west-1\n"), 0644))
+	_, encrypted, err := readSopsFile(context.Background(), plain)
+	require.Nil(t, err)
+	require.False(t, encrypted)
+
+	_, encrypted, err = readSopsFile(context.Background(), filepath.Join(dir, "missing.yaml"))
+	require.Nil(t, err)
+	require.False(t, encrypted)
+
+	secret := filepath.Join(dir, "secret.yaml")
+	require.Nil(t, ioutil.WriteFile(secret, []byte(sopsEncrypted), 0644))
+	data, encrypted, err := readSopsFile(context.Background(), secret)
+	require.Nil(t, err)
+	require.True(t, encrypted)
+
+	_, ok := data.Get4("sops")
+	require.False(t, ok)
+	region, _ := data.Get4("region")
+	require.Equal(t, "eu-west-1", region.String())
+	db, _ := data.Get4("db")
+	user, _ := db.(eval.OrderedMap).Get4("user")
+	require.Equal(t, "admin", user.String())
+	password, _ := db.(eval.OrderedMap).Get4("password")
+	require.IsType(t, &types.SensitiveValue{}, password)
+	require.Equal(t, "s3cret", password.(*types.SensitiveValue).Unwrap().String())
+	tokens, _ := data.Get4("tokens")
+	require.IsType(t, &types.SensitiveValue{}, tokens.(eval.List).At(0))
+
+	os.Setenv(SopsEnvVar, "/bin/false")
+	_, encrypted, err = readSopsFile(context.Background(), secret)
+	require.True(t, encrypted)
+	require.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package apply
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/lyraproj/hiera/lookup"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// SopsEnvVar can be used to override the sops executable used to decrypt data files
+	SopsEnvVar = "LYRA_SOPS"
+
+	defaultSopsExecutable = "sops"
+	sopsEncryptedPrefix   = "ENC["
+)
+
+// readSopsFile returns the decrypted contents of the given data file if it has been encrypted with
+// sops. The file is decrypted by the sops executable so the user's KMS, age and PGP configuration
+// applies. The decrypted content is kept in memory only. All values that were encrypted in the file
+// are made Sensitive. The returned bool is false if the file isn't a sops file.
+func readSopsFile(ctx context.Context, path string) (eval.OrderedMap, bool, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	var encrypted interface{}
+	if err = yaml.Unmarshal(bts, &encrypted); err != nil {
+		// Not for us to complain about, the normal data provider will report the error
+		return nil, false, nil
+	}
+	em, ok := normalize(encrypted).(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+	if _, ok = em[`sops`]; !ok {
+		return nil, false, nil
+	}
+
+	exe := defaultSopsExecutable
+	if v := os.Getenv(SopsEnvVar); v != `` {
+		exe = v
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, `--decrypt`, `--output-type`, `json`, path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return nil, true, fmt.Errorf("unable to decrypt %s: %s: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	var decrypted map[string]interface{}
+	if err = json.Unmarshal(stdout.Bytes(), &decrypted); err != nil {
+		return nil, true, fmt.Errorf("unable to decrypt %s: sops produced invalid output", path)
+	}
+	// sops normally strips its metadata when decrypting but make sure that it doesn't end up as data
+	data := make(map[string]interface{}, len(decrypted))
+	for k, v := range decrypted {
+		if k != `sops` {
+			data[k] = v
+		}
+	}
+	return wrapDecrypted(em, data).(eval.OrderedMap), true, nil
+}
+
+// wrapDecrypted converts the decrypted value into an eval.Value. Values that are encrypted in the
+// corresponding position of the encrypted value become Sensitive.
+func wrapDecrypted(encrypted, decrypted interface{}) eval.Value {
+	switch d := decrypted.(type) {
+	case map[string]interface{}:
+		e, _ := encrypted.(map[string]interface{})
+		entries := make([]*types.HashEntry, 0, len(d))
+		for _, k := range sortedKeys(d) {
+			entries = append(entries, types.WrapHashEntry2(k, wrapDecrypted(e[k], d[k])))
+		}
+		return types.WrapHash(entries)
+	case []interface{}:
+		e, _ := encrypted.([]interface{})
+		elements := make([]eval.Value, len(d))
+		for i, v := range d {
+			var ev interface{}
+			if i < len(e) {
+				ev = e[i]
+			}
+			elements[i] = wrapDecrypted(ev, v)
+		}
+		return types.WrapValues(elements)
+	default:
+		v := eval.Wrap(nil, d)
+		if s, ok := encrypted.(string); ok && strings.HasPrefix(s, sopsEncryptedPrefix) {
+			return types.WrapSensitive(v)
+		}
+		return v
+	}
+}
+
+// normalize converts the maps produced by the YAML parser into maps with string keys
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range x {
+			x[i] = normalize(e)
+		}
+		return x
+	default:
+		return v
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sopsProvider returns a lookup provider that serves the given decrypted data
+func sopsProvider(data eval.OrderedMap) lookup.LookupKey {
+	return func(ic lookup.ProviderContext, key string, _ map[string]eval.Value) (eval.Value, bool) {
+		return data.Get4(key)
+	}
+}
@@ -0,0 +1,211 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyraproj/lyra/pkg/event"
+	"github.com/lyraproj/lyra/pkg/signing"
+)
+
+const (
+	// LogEnvVar names the audit log file when none is given on the command line
+	LogEnvVar = "LYRA_AUDIT_LOG"
+
+	// KeyEnvVar names the private key used to sign audit log entries
+	KeyEnvVar = "LYRA_AUDIT_KEY"
+)
+
+// Entry is one line in the audit log. Each entry contains the hash of the entry before it so that
+// removing, reordering or altering entries breaks the chain. Entries are optionally signed.
+type Entry struct {
+	Seq    uint64          `json:"seq"`
+	Time   time.Time       `json:"time"`
+	Prev   string          `json:"prev"`
+	Event  json.RawMessage `json:"event"`
+	Hash   string          `json:"hash"`
+	Sig    string          `json:"sig,omitempty"`
+	Signer string          `json:"signer,omitempty"`
+}
+
+// computeHash returns the hash that chains the entry to its predecessor
+func (e *Entry) computeHash() string {
+	h := sha256.New()
+	io.WriteString(h, strconv.FormatUint(e.Seq, 10)+"\n"+e.Time.Format(time.RFC3339Nano)+"\n"+e.Prev+"\n")
+	h.Write(e.Event)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Log is an append-only, hash chained audit log file. It implements event.Sink so that it can
+// record the events emitted during workflow execution.
+type Log struct {
+	lock   sync.Mutex
+	file   *os.File
+	seq    uint64
+	prev   string
+	key    signing.PrivateKey
+	signer string
+}
+
+// Open opens the audit log at the given path for appending, creating it if necessary. When key is
+// non-nil, every entry is signed with it and the name of the signer is recorded in the entry.
+func Open(path string, key signing.PrivateKey, signer string) (*Log, error) {
+	l := &Log{key: key, signer: signer}
+	if f, err := os.Open(path); err == nil {
+		// Continue the chain from the last entry
+		err = readEntries(f, func(e *Entry) error {
+			l.seq = e.Seq
+			l.prev = e.Hash
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to continue audit log %s: %s", path, err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+// OpenConfigured opens the audit log at the given path or, when path is empty, the log named by the
+// LYRA_AUDIT_LOG environment variable. Entries are signed with the private key named by the
+// LYRA_AUDIT_KEY environment variable when it is set. A nil Log is returned when no log is configured.
+func OpenConfigured(path string) (*Log, error) {
+	if path == `` {
+		path = os.Getenv(LogEnvVar)
+	}
+	if path == `` {
+		return nil, nil
+	}
+	var key signing.PrivateKey
+	signer := ``
+	if keyFile := os.Getenv(KeyEnvVar); keyFile != `` {
+		var err error
+		if key, err = signing.ReadPrivateKey(keyFile); err != nil {
+			return nil, err
+		}
+		signer = strings.TrimSuffix(filepath.Base(keyFile), signing.PrivateKeyExt)
+	}
+	return Open(path, key, signer)
+}
+
+// Send appends the event to the log
+func (l *Log) Send(ev *event.Event) error {
+	bts, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return l.Append(bts)
+}
+
+// Append appends an entry for the given JSON document to the log and syncs the file
+func (l *Log) Append(doc json.RawMessage) error {
+	// The document is hashed in the compact form that it is written in
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, doc); err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e := &Entry{Seq: l.seq + 1, Time: time.Now().UTC(), Prev: l.prev, Event: buf.Bytes()}
+	e.Hash = e.computeHash()
+	if l.key != nil {
+		e.Sig = base64.StdEncoding.EncodeToString(signing.Sign(l.key, []byte(e.Hash)))
+		e.Signer = l.signer
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = l.file.Sync(); err != nil {
+		return err
+	}
+	l.seq = e.Seq
+	l.prev = e.Hash
+	return nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Result summarizes a verified audit log
+type Result struct {
+	// Entries is the number of entries in the log
+	Entries uint64
+	// Signed is the number of entries with a verified signature
+	Signed uint64
+	// LastHash is the hash of the last entry. Recording it elsewhere makes truncation of the log detectable.
+	LastHash string
+}
+
+// Verify reads an audit log and verifies that the hash chain is intact. When a verifier is given, every
+// entry must also be signed by one of its trusted keys.
+func Verify(r io.Reader, verifier *signing.Verifier) (*Result, error) {
+	res := &Result{}
+	err := readEntries(r, func(e *Entry) error {
+		if e.Seq != res.Entries+1 {
+			return fmt.Errorf("entry %d: expected sequence number %d", e.Seq, res.Entries+1)
+		}
+		if e.Prev != res.LastHash {
+			return fmt.Errorf("entry %d: chain is broken, previous hash does not match", e.Seq)
+		}
+		if e.computeHash() != e.Hash {
+			return fmt.Errorf("entry %d: content does not match its hash", e.Seq)
+		}
+		if verifier != nil {
+			sig, err := base64.StdEncoding.DecodeString(e.Sig)
+			if err != nil || e.Sig == `` {
+				return fmt.Errorf("entry %d: not signed", e.Seq)
+			}
+			if _, ok := verifier.Verify([]byte(e.Hash), sig); !ok {
+				return fmt.Errorf("entry %d: not signed by a trusted key", e.Seq)
+			}
+			res.Signed++
+		}
+		res.Entries = e.Seq
+		res.LastHash = e.Hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func readEntries(r io.Reader, f func(e *Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		e := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lyraproj/lyra/pkg/event"
+	"github.com/lyraproj/lyra/pkg/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeLog(t *testing.T, path string, signed bool, subjects ...string) {
+	var l *Log
+	var err error
+	if signed {
+		os.Setenv(KeyEnvVar, filepath.Join(filepath.Dir(path), "audit.key"))
+		defer os.Unsetenv(KeyEnvVar)
+	}
+	l, err = OpenConfigured(path)
+	require.Nil(t, err)
+	for _, s := range subjects {
+		require.Nil(t, l.Send(event.New("/lyra/test", event.ResourceCreated, s, map[string]interface{}{"count": 1})))
+	}
+	require.Nil(t, l.Close())
+}
+
+func Test_Verify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, signing.GenerateKeyPair(filepath.Join(dir, "audit")))
+	verifier, err := signing.LoadVerifier(dir)
+	require.Nil(t, err)
+
+	path := filepath.Join(dir, "audit.log")
+	writeLog(t, path, true, "a", "b")
+	// Reopening continues the chain
+	writeLog(t, path, true, "c")
+
+	bts, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	res, err := Verify(bytes.NewReader(bts), verifier)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(3), res.Entries)
+	assert.Equal(t, uint64(3), res.Signed)
+
+	lines := strings.SplitAfter(string(bts), "\n")
+
+	// Altered content
+	altered := strings.Replace(string(bts), `"subject":"b"`, `"subject":"x"`, 1)
+	_, err = Verify(strings.NewReader(altered), nil)
+	assert.EqualError(t, err, "entry 2: content does not match its hash")
+
+	// Removed entry
+	_, err = Verify(strings.NewReader(lines[0]+lines[2]), nil)
+	assert.EqualError(t, err, "entry 3: expected sequence number 2")
+
+	// Unsigned entries appended by someone without the key
+	writeLog(t, path, false, "d")
+	f, err := os.Open(path)
+	require.Nil(t, err)
+	defer f.Close()
+	_, err = Verify(f, verifier)
+	assert.EqualError(t, err, "entry 4: not signed")
+}
+
+func Test_OpenConfigured(t *testing.T) {
+	l, err := OpenConfigured("")
+	assert.Nil(t, err)
+	assert.Nil(t, l)
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	i := &testIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": i.URL, "jwks_uri": i.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "test",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	i.Server = httptest.NewServer(mux)
+	return i
+}
+
+func (i *testIssuer) token(t *testing.T, kid string, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		bts, err := json.Marshal(v)
+		require.Nil(t, err)
+		return base64.RawURLEncoding.EncodeToString(bts)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": kid}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	require.Nil(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (i *testIssuer) claims(overrides map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"iss":    i.URL,
+		"sub":    "u123",
+		"aud":    "lyra",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"email":  "dev@example.com",
+		"groups": []string{"ops"},
+	}
+	for k, v := range overrides {
+		c[k] = v
+	}
+	return c
+}
+
+func Test_OIDCVerify(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	a := NewOIDCAuthenticator(OIDCConfig{Issuer: issuer.URL, ClientID: "lyra"})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+issuer.token(t, "test", issuer.claims(nil)))
+	p, err := a.Authenticate(req)
+	require.Nil(t, err)
+	assert.Equal(t, &Principal{Subject: "u123", Email: "dev@example.com", Groups: []string{"ops"}}, p)
+
+	_, err = a.Verify(req.Context(), issuer.token(t, "test", issuer.claims(map[string]interface{}{"aud": []string{"other", "lyra"}})))
+	require.Nil(t, err)
+
+	failures := map[string]string{
+		"expired":       issuer.token(t, "test", issuer.claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"audience":      issuer.token(t, "test", issuer.claims(map[string]interface{}{"aud": "other"})),
+		"issuer":        issuer.token(t, "test", issuer.claims(map[string]interface{}{"iss": "https://evil.example.com"})),
+		"not yet valid": issuer.token(t, "test", issuer.claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"unknown key":   issuer.token(t, "other", issuer.claims(nil)),
+		"malformed":     "not.a-token",
+	}
+	tampered := issuer.token(t, "test", issuer.claims(nil))
+	failures["tampered"] = tampered[:len(tampered)-4] + "AAAA"
+	for title, token := range failures {
+		_, err = a.Verify(req.Context(), token)
+		assert.Error(t, err, title)
+	}
+}
+
+func Test_Middleware(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	a := NewOIDCAuthenticator(OIDCConfig{Issuer: issuer.URL, ClientID: "lyra"})
+	policy := &Policy{Bindings: []Binding{{Role: "plan", Groups: []string{"ops"}}}}
+
+	handler := Middleware(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perm := Plan
+		if r.Method == http.MethodPost {
+			perm = Apply
+		}
+		if policy.Require(w, r, perm, "attach", "default") {
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	token := "Bearer " + issuer.token(t, "test", issuer.claims(nil))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", token)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", token)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+var authorizeTests = []struct {
+	title     string
+	principal *Principal
+	perm      Permission
+	workflow  string
+	workspace string
+	allowed   bool
+}{
+	{title: "user binding", principal: &Principal{Subject: "alice"}, perm: Apply, workflow: "attach", workspace: "dev", allowed: true},
+	{title: "email binding", principal: &Principal{Subject: "u1", Email: "bob@example.com"}, perm: Admin, workflow: "any", workspace: "prod", allowed: true},
+	{title: "role too weak", principal: &Principal{Subject: "carol", Groups: []string{"viewers"}}, perm: Plan, workflow: "attach", workspace: "dev", allowed: false},
+	{title: "lower permission granted", principal: &Principal{Subject: "carol", Groups: []string{"viewers"}}, perm: Read, workflow: "attach", workspace: "prod", allowed: true},
+	{title: "workspace not matched", principal: &Principal{Subject: "alice"}, perm: Apply, workflow: "attach", workspace: "prod", allowed: false},
+	{title: "workflow not matched", principal: &Principal{Subject: "alice"}, perm: Apply, workflow: "network", workspace: "dev", allowed: false},
+	{title: "unknown principal", principal: &Principal{Subject: "mallory"}, perm: Read, workflow: "attach", workspace: "dev", allowed: false},
+	{title: "anonymous", principal: nil, perm: Read, workflow: "attach", workspace: "dev", allowed: false},
+}
+
+func Test_Authorize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rbac.yaml")
+	require.Nil(t, ioutil.WriteFile(file, []byte(`
+bindings:
+  - role: apply
+    users: [alice]
+    workflows: [attach*]
+    workspaces: [dev, test-*]
+  - role: admin
+    users: [bob@example.com]
+  - role: read
+    groups: [viewers]
+`), 0644))
+	policy, err := LoadPolicy(file)
+	require.Nil(t, err)
+
+	for _, test := range authorizeTests {
+		t.Run(test.title, func(t *testing.T) {
+			err := policy.Authorize(test.principal, test.perm, test.workflow, test.workspace)
+			if test.allowed {
+				assert.Nil(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	require.Nil(t, ioutil.WriteFile(file, []byte("bindings:\n  - role: owner\n    users: [alice]\n"), 0644))
+	_, err = LoadPolicy(file)
+	assert.Error(t, err)
+}
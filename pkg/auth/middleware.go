@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+type principalKey struct{}
+
+// Authenticator establishes the identity of the caller of an HTTP request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Middleware returns a handler that authenticates every request before passing it on to the next
+// handler. Requests that fail authentication are rejected with 401 Unauthorized. The principal is
+// made available to the next handler through the request context.
+func Middleware(authn Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authn.Authenticate(r)
+		if err != nil {
+			w.Header().Set(`WWW-Authenticate`, `Bearer`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// WithPrincipal returns a context that carries the given principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal carried by the given context
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Require checks that the principal of the request has been granted the given permission for the
+// workflow in the workspace. When that isn't the case, the request is rejected with 403 Forbidden
+// and false is returned.
+func (p *Policy) Require(w http.ResponseWriter, r *http.Request, perm Permission, workflow, workspace string) bool {
+	principal, _ := PrincipalFrom(r.Context())
+	if err := p.Authorize(principal, perm, workflow, workspace); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGroupsClaim = `groups`
+	clockSkew          = time.Minute
+	keyRefreshInterval = time.Minute
+	httpTimeout        = 30 * time.Second
+)
+
+// OIDCConfig configures the OIDCAuthenticator
+type OIDCConfig struct {
+	// Issuer is the URL of the OpenID Connect provider. It must match the iss claim of the tokens.
+	Issuer string
+
+	// ClientID is the audience that tokens must have been issued for
+	ClientID string
+
+	// GroupsClaim is the name of the claim that contains the groups of the principal. Defaults to "groups".
+	GroupsClaim string
+}
+
+// OIDCAuthenticator authenticates requests that carry an OpenID Connect ID token as a bearer token. The
+// signing keys of the issuer are found using OpenID Connect discovery and are refreshed when a token is
+// signed with an unknown key.
+type OIDCAuthenticator struct {
+	config OIDCConfig
+	client *http.Client
+
+	lock        sync.Mutex
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+	now         func() time.Time
+}
+
+// NewOIDCAuthenticator creates an authenticator for the given configuration
+func NewOIDCAuthenticator(config OIDCConfig) *OIDCAuthenticator {
+	if config.GroupsClaim == `` {
+		config.GroupsClaim = defaultGroupsClaim
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, `/`)
+	return &OIDCAuthenticator{config: config, client: &http.Client{Timeout: httpTimeout}, now: time.Now}
+}
+
+// Authenticate verifies the bearer token of the request and returns the principal that it identifies
+func (a *OIDCAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	h := r.Header.Get(`Authorization`)
+	if !strings.HasPrefix(h, `Bearer `) {
+		return nil, fmt.Errorf("missing bearer token")
+	}
+	return a.Verify(r.Context(), strings.TrimSpace(strings.TrimPrefix(h, `Bearer `)))
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type audience []string
+
+func (a *audience) UnmarshalJSON(bts []byte) error {
+	var s string
+	if err := json.Unmarshal(bts, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(bts, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+// Verify verifies the signature and the claims of the given ID token and returns the principal that it identifies
+func (a *OIDCAuthenticator) Verify(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, `.`)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %s", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %s", err)
+	}
+	key, err := a.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifySignature(header.Alg, key, parts[0]+`.`+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]json.RawMessage
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %s", err)
+	}
+	var std struct {
+		Issuer    string   `json:"iss"`
+		Subject   string   `json:"sub"`
+		Audience  audience `json:"aud"`
+		Expiry    int64    `json:"exp"`
+		NotBefore int64    `json:"nbf"`
+		Email     string   `json:"email"`
+	}
+	if err = decodeSegment(parts[1], &std); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %s", err)
+	}
+	now := a.now()
+	switch {
+	case std.Issuer != a.config.Issuer:
+		return nil, fmt.Errorf("token issued by %s, expected %s", std.Issuer, a.config.Issuer)
+	case !std.Audience.contains(a.config.ClientID):
+		return nil, fmt.Errorf("token not issued for %s", a.config.ClientID)
+	case std.Expiry == 0 || now.After(time.Unix(std.Expiry, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("token has expired")
+	case std.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(std.NotBefore, 0)):
+		return nil, fmt.Errorf("token is not yet valid")
+	case std.Subject == ``:
+		return nil, fmt.Errorf("token has no subject")
+	}
+
+	principal := &Principal{Subject: std.Subject, Email: std.Email}
+	if raw, ok := claims[a.config.GroupsClaim]; ok {
+		if err = json.Unmarshal(raw, &principal.Groups); err != nil {
+			return nil, fmt.Errorf("claim %s is not a list of strings", a.config.GroupsClaim)
+		}
+	}
+	return principal, nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	bts, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bts, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case `RS256`:
+		if k, ok := key.(*rsa.PublicKey); ok {
+			if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil {
+				return nil
+			}
+			return fmt.Errorf("invalid token signature")
+		}
+	case `ES256`:
+		if k, ok := key.(*ecdsa.PublicKey); ok {
+			if len(sig) == 64 && ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+				return nil
+			}
+			return fmt.Errorf("invalid token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported token signing algorithm '%s'", alg)
+	}
+	return fmt.Errorf("token signing algorithm %s does not match the key", alg)
+}
+
+// key returns the issuer key with the given id. The keys are fetched when the id is unknown unless
+// they were fetched very recently.
+func (a *OIDCAuthenticator) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	if a.keys != nil && a.now().Sub(a.lastRefresh) < keyRefreshInterval {
+		return nil, fmt.Errorf("token signed with unknown key '%s'", kid)
+	}
+	keys, err := a.fetchKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch signing keys of %s: %s", a.config.Issuer, err)
+	}
+	a.keys = keys
+	a.lastRefresh = a.now()
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("token signed with unknown key '%s'", kid)
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (a *OIDCAuthenticator) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		JwksURI string `json:"jwks_uri"`
+	}
+	if err := a.getJSON(ctx, a.config.Issuer+`/.well-known/openid-configuration`, &discovery); err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := a.getJSON(ctx, discovery.JwksURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != `` && k.Use != `sig` {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Keys of unsupported types are ignored
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case `RSA`:
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case `EC`:
+		if k.Crv != `P-256` {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func (a *OIDCAuthenticator) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s responded with status %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
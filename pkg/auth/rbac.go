@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Permission is something that a principal may be permitted to do with a workflow
+type Permission int
+
+const (
+	// Read permits reading workflows, their state and outputs
+	Read Permission = iota
+	// Plan permits previewing the changes that applying a workflow would make
+	Plan
+	// Apply permits applying and deleting workflows
+	Apply
+	// Admin permits everything, including administration of the service itself
+	Admin
+)
+
+var permissionNames = []string{`read`, `plan`, `apply`, `admin`}
+
+// String returns the name of the permission which is also the name of the role that grants it
+func (p Permission) String() string {
+	if p < Read || p > Admin {
+		return fmt.Sprintf("Permission(%d)", int(p))
+	}
+	return permissionNames[p]
+}
+
+// ParseRole returns the permission granted by the role with the given name. A role grants its own
+// permission and all permissions below it, i.e. "apply" also grants "plan" and "read".
+func ParseRole(name string) (Permission, error) {
+	for i, n := range permissionNames {
+		if n == name {
+			return Permission(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role '%s', expected one of %v", name, permissionNames)
+}
+
+// Principal is an authenticated API caller
+type Principal struct {
+	Subject string
+	Email   string
+	Groups  []string
+}
+
+// Binding grants a role to users and groups for the workflows and workspaces that match the given
+// glob patterns. An empty list of workflows or workspaces matches all of them.
+type Binding struct {
+	Role       string   `yaml:"role"`
+	Users      []string `yaml:"users,omitempty"`
+	Groups     []string `yaml:"groups,omitempty"`
+	Workflows  []string `yaml:"workflows,omitempty"`
+	Workspaces []string `yaml:"workspaces,omitempty"`
+}
+
+// Policy holds the role bindings that determine what principals are permitted to do. Everything
+// that isn't explicitly permitted is denied.
+type Policy struct {
+	Bindings []Binding `yaml:"bindings"`
+}
+
+// LoadPolicy reads a YAML policy from the file at the given path
+func LoadPolicy(path string) (*Policy, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if err = yaml.UnmarshalStrict(bts, p); err != nil {
+		return nil, fmt.Errorf("invalid RBAC policy %s: %s", path, err)
+	}
+	for i, b := range p.Bindings {
+		if _, err = ParseRole(b.Role); err != nil {
+			return nil, fmt.Errorf("invalid RBAC policy %s: binding %d: %s", path, i, err)
+		}
+		if len(b.Users) == 0 && len(b.Groups) == 0 {
+			return nil, fmt.Errorf("invalid RBAC policy %s: binding %d has no users or groups", path, i)
+		}
+		for _, pattern := range append(append([]string{}, b.Workflows...), b.Workspaces...) {
+			if _, err = filepath.Match(pattern, ``); err != nil {
+				return nil, fmt.Errorf("invalid RBAC policy %s: binding %d: bad pattern '%s'", path, i, pattern)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Authorize returns an error unless the principal has been granted the given permission for the
+// workflow in the workspace
+func (p *Policy) Authorize(principal *Principal, perm Permission, workflow, workspace string) error {
+	if principal != nil {
+		for _, b := range p.Bindings {
+			granted, err := ParseRole(b.Role)
+			if err != nil || granted < perm {
+				continue
+			}
+			if b.appliesTo(principal) && matchAny(b.Workflows, workflow) && matchAny(b.Workspaces, workspace) {
+				return nil
+			}
+		}
+	}
+	who := `anonymous`
+	if principal != nil {
+		who = principal.Subject
+	}
+	return fmt.Errorf("%s does not have %s permission for workflow '%s' in workspace '%s'", who, perm, workflow, workspace)
+}
+
+func (b *Binding) appliesTo(principal *Principal) bool {
+	for _, u := range b.Users {
+		if u == principal.Subject || (principal.Email != `` && u == principal.Email) {
+			return true
+		}
+	}
+	for _, g := range b.Groups {
+		for _, pg := range principal.Groups {
+			if g == pg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package benchmarks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/lyraproj/lyra/cmd/goplugin-identity/identity"
+	"github.com/lyraproj/lyra/cmd/lyra/cmd"
+	"github.com/lyraproj/lyra/pkg/incremental"
+	"github.com/lyraproj/lyra/pkg/loader"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/service"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/servicesdk/wfapi"
+	wfservice "github.com/lyraproj/wfe/service"
+	"github.com/lyraproj/wfe/wfe"
+)
+
+// providerArg makes the test binary serve the synthetic provider named by the argument that follows it
+const providerArg = `synthetic-provider`
+
+func TestMain(m *testing.M) {
+	if len(os.Args) == 3 && os.Args[1] == providerArg {
+		Serve(os.Args[2])
+		return
+	}
+	if len(os.Args) > 1 && (os.Args[1] == loader.PluginExecCommand || os.Args[1] == `--debug`) {
+		// The loader starts all plugins, including the embedded ones, using the lyra binary. The test
+		// binary takes its place.
+		if err := cmd.NewRootCmd().Execute(); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// inTempDir runs the benchmark in a temporary directory. The embedded plugins keep their state in the
+// current directory.
+func inTempDir(b *testing.B) (string, func()) {
+	dir, err := ioutil.TempDir(``, `benchmarks`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		b.Fatal(err)
+	}
+	return dir, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+// writePlugins writes the given number of plugins to the given directory. Each plugin runs the test
+// binary as a synthetic provider with a name of its own.
+func writePlugins(b *testing.B, dir string, count int) {
+	if runtime.GOOS == `windows` {
+		b.Skip(`plugins are shell scripts`)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		b.Fatal(err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		script := fmt.Sprintf("#!/bin/sh\nexec '%s' %s Synthetic%d\n", self, providerArg, i)
+		if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("goplugin-synthetic%d", i)), []byte(script), 0700); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// preLoad measures loaders that load everything found in the plugin directory
+func preLoad(b *testing.B, options ...loader.Option) {
+	options = append(options, loader.WithPluginPath(`plugins`))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		eval.Puppet.Do(func(c eval.Context) {
+			loader.New(hclog.NewNullLogger(), c.Loader(), options...).PreLoad(c)
+		})
+		b.StopTimer()
+		plugin.CleanupClients()
+		b.StartTimer()
+	}
+}
+
+// BenchmarkLoaderColdStart measures the loading of the embedded plugins when nothing is cached
+func BenchmarkLoaderColdStart(b *testing.B) {
+	_, done := inTempDir(b)
+	defer done()
+	preLoad(b, loader.WithMetadataCache(``))
+}
+
+// BenchmarkMetadataLoad measures the loading of the metadata of plugins, with and without the metadata
+// cache, for different numbers of plugins
+func BenchmarkMetadataLoad(b *testing.B) {
+	for _, count := range []int{1, 4, 16} {
+		for _, cached := range []bool{false, true} {
+			b.Run(fmt.Sprintf("plugins=%d/cached=%t", count, cached), func(b *testing.B) {
+				dir, done := inTempDir(b)
+				defer done()
+				writePlugins(b, `plugins`, count)
+				cache := ``
+				if cached {
+					cache = filepath.Join(dir, `cache`)
+					eval.Puppet.Do(func(c eval.Context) {
+						loader.New(hclog.NewNullLogger(), c.Loader(), loader.WithPluginPath(`plugins`), loader.WithMetadataCache(cache)).PreLoad(c)
+					})
+					plugin.CleanupClients()
+				}
+				preLoad(b, loader.WithMetadataCache(cache))
+			})
+		}
+	}
+}
+
+// register makes an in-process service and its definitions available to the given loader
+func register(c eval.Context, l *loader.Loader, s serviceapi.Service) {
+	l.RegisterService(c, s)
+	l.RegisterMetadata(c, s)
+}
+
+// newIdentityService creates an in-process identity service that keeps its state in the given file
+func newIdentityService(b *testing.B, c eval.Context, file string) serviceapi.Service {
+	id, err := identity.NewIdentity(file)
+	if err != nil {
+		b.Fatal(err)
+	}
+	sb := service.NewServerBuilder(c, `Default::Identity::Service`)
+	sb.RegisterAPI(serviceapi.IdentityName, id)
+	return sb.Server()
+}
+
+// loadManifest loads a YAML manifest in the same way as the loader does when it finds one
+func loadManifest(b *testing.B, c eval.Context, l *loader.Loader, file string) {
+	for _, f := range loader.Frontends() {
+		if f.Detect(file) {
+			s, err := f.Parse(c, file)
+			if err == nil {
+				err = f.RegisterDefinitions(c, l, s)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			return
+		}
+	}
+	b.Fatalf("no front-end for %s", file)
+}
+
+// applyWorkflow applies a workflow in the same way as the apply command does
+func applyWorkflow(c eval.Context, name string) {
+	c.Scope().Set(wfservice.ActivityContextKey, types.SingletonHash2(`operation`, types.WrapInteger(int64(wfapi.Upsert))))
+	wfservice.StartEra(c)
+	def, ok := eval.Load(c, eval.NewTypedName(eval.NsDefinition, name))
+	if !ok {
+		panic(fmt.Errorf("unable to find definition for activity %s", name))
+	}
+	a := wfe.CreateActivity(def.(serviceapi.Definition))
+	a.Run(c, eval.EMPTY_MAP)
+	wfservice.SweepAndGC(c, a.Identifier()+`/`)
+}
+
+// BenchmarkApply1kSteps measures the scheduling of a workflow of 1000 resources that form 10 chains of
+// dependencies. The workflow is created by the first apply, so the measured applies read every resource
+// and find it unchanged. All services run in this process.
+func BenchmarkApply1kSteps(b *testing.B) {
+	dir, done := inTempDir(b)
+	defer done()
+	manifest := filepath.Join(dir, `bench.yaml`)
+	if err := ioutil.WriteFile(manifest, Manifest(`bench`, `Synthetic`, 1000, 10), 0600); err != nil {
+		b.Fatal(err)
+	}
+	eval.Puppet.Do(func(c eval.Context) {
+		l := loader.New(hclog.NewNullLogger(), c.Loader(), loader.WithPluginPath(dir), loader.WithMetadataCache(``))
+		c.DoWithLoader(l, func() {
+			register(c, l, newIdentityService(b, c, filepath.Join(dir, `identity.db`)))
+			register(c, l, Server(c, `Synthetic`))
+			loadManifest(b, c, l, manifest)
+			applyWorkflow(c, `bench`)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				applyWorkflow(c, `bench`)
+			}
+		})
+	})
+}
+
+// BenchmarkIdentityAssociate measures the recording of the external IDs of new resources
+func BenchmarkIdentityAssociate(b *testing.B) {
+	dir, done := inTempDir(b)
+	defer done()
+	id, err := identity.NewIdentity(filepath.Join(dir, `identity.db`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = id.Associate(`bench/item`+strconv.Itoa(i), strconv.Itoa(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// newIdentity creates an identity store that holds the given number of resources
+func newIdentity(b *testing.B, dir string, count int) *identity.Identity {
+	id, err := identity.NewIdentity(filepath.Join(dir, `identity.db`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		if err = id.Associate(`bench/item`+strconv.Itoa(i), strconv.Itoa(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return id
+}
+
+// BenchmarkIdentityGetExternal measures the lookup of the external IDs of existing resources
+func BenchmarkIdentityGetExternal(b *testing.B) {
+	dir, done := inTempDir(b)
+	defer done()
+	id := newIdentity(b, dir, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := id.GetExternal(`bench/item` + strconv.Itoa(i%1000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkIdentitySweep measures the garbage collection that follows the apply of a workflow of 1000
+// resources that are all still in use
+func BenchmarkIdentitySweep(b *testing.B) {
+	dir, done := inTempDir(b)
+	defer done()
+	id := newIdentity(b, dir, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := id.Sweep(`bench/`); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := id.Garbage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkIncrementalCommit measures the recording of the states of 1000 resources by an incremental apply
+func BenchmarkIncrementalCommit(b *testing.B) {
+	dir, done := inTempDir(b)
+	defer done()
+	file := filepath.Join(dir, incremental.DefaultFile)
+	eval.Puppet.Do(func(c eval.Context) {
+		s := Server(c, `Synthetic`)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			store, err := incremental.Open(file)
+			if err != nil {
+				b.Fatal(err)
+			}
+			svc := store.WrapService()(s)
+			for j := 0; j < 1000; j++ {
+				svc.Invoke(c, `Synthetic::ItemHandler`, `create`, eval.Wrap(c, &Item{Name: `item`, Value: int64(j)}))
+			}
+			if err = store.Commit(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,108 @@
+// Package benchmarks contains the benchmarks used to catch performance regressions before a release, and
+// the synthetic provider that they use. The provider manages items in memory, so the benchmarks measure
+// lyra rather than a cloud API. Run the benchmarks with
+//
+//	go test -run=^$ -bench=. -benchmem ./pkg/benchmarks
+//
+// and compare the results of two versions using benchstat.
+package benchmarks
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/lyraproj/lyra/pkg/grpc"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/service"
+)
+
+// Item is the resource managed by the synthetic provider
+type Item struct {
+	Name  string `puppet:"type=>String, value=>''"`
+	Value int64  `puppet:"type=>Integer, value=>0"`
+}
+
+// ItemHandler performs CRUD operations on items that it keeps in memory
+type ItemHandler struct {
+	lock  sync.Mutex
+	items map[string]Item
+	next  int
+}
+
+// NewItemHandler creates a handler that has no items
+func NewItemHandler() *ItemHandler {
+	return &ItemHandler{items: map[string]Item{}}
+}
+
+// Create creates a new item
+func (h *ItemHandler) Create(desiredState *Item) (*Item, string, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.next++
+	externalID := strconv.Itoa(h.next)
+	h.items[externalID] = *desiredState
+	return desiredState, externalID, nil
+}
+
+// Read reads an existing item
+func (h *ItemHandler) Read(externalID string) (*Item, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	item, ok := h.items[externalID]
+	if !ok {
+		return nil, fmt.Errorf("no item with id %s", externalID)
+	}
+	return &item, nil
+}
+
+// Update updates an existing item
+func (h *ItemHandler) Update(externalID string, desiredState *Item) (*Item, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.items[externalID] = *desiredState
+	return desiredState, nil
+}
+
+// Delete deletes an existing item
+func (h *ItemHandler) Delete(externalID string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.items, externalID)
+	return nil
+}
+
+// Server returns a synthetic provider with the given name. The Item type and its handler are declared in
+// the namespace of the same name, so that providers with different names can be loaded together.
+func Server(c eval.Context, name string) *service.Server {
+	sb := service.NewServerBuilder(c, name)
+	types := sb.RegisterTypes(name, Item{})
+	sb.RegisterHandler(name+"::ItemHandler", NewItemHandler(), types[0])
+	return sb.Server()
+}
+
+// Serve serves the synthetic provider with the given name as a plugin
+func Serve(name string) {
+	eval.Puppet.Do(func(c eval.Context) {
+		grpc.Serve(c, Server(c, name))
+	})
+}
+
+// Manifest returns a YAML workflow with the given name that declares the given number of items of the
+// provider with the given name. The items form the given number of chains in which each item is named
+// after the item that precedes it, so the workflow engine must apply the chains concurrently and the
+// items of each chain in order. Chains are used rather than a graph that widens, since the engine
+// blocks when the activities that it runs schedule more dependents than its queue holds.
+func Manifest(workflow, provider string, steps, chains int) []byte {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "%s:\n  typespace: %s\n  activities:\n", workflow, provider)
+	for i := 0; i < steps; i++ {
+		name := fmt.Sprintf("chain%d", i)
+		if i >= chains {
+			name = fmt.Sprintf("$n%d", i-chains)
+		}
+		fmt.Fprintf(b, "    item%d:\n      type: %s::Item\n      output:\n        n%d: name\n      state:\n        name: %s\n        value: %d\n", i, provider, i, name, i)
+	}
+	return b.Bytes()
+}
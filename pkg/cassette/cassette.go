@@ -0,0 +1,217 @@
+// Package cassette records the calls that a run makes to the handlers of providers, and to the identity
+// service, together with their results, and replays them in a later run in place of making the calls.
+// A replayed run needs neither access to the cloud nor an identity store, so a cassette recorded from a
+// real apply makes a deterministic regression test, or an offline demo, of the workflow.
+//
+// A call is replayed when a call with the same arguments was recorded. Calls with equal arguments are
+// replayed in the order they were recorded. A call that was not recorded fails the replayed run, so any
+// change to the desired states of a workflow is detected.
+//
+// Cassettes contain the states of resources, including the content of Sensitive values, and must be
+// protected like the resources themselves.
+package cassette
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/lyraproj/data-protobuf/datapb"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	sdkgrpc "github.com/lyraproj/servicesdk/grpc"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// handlerMethods are the methods of handlers whose calls are recorded
+var handlerMethods = map[string]bool{`create`: true, `read`: true, `update`: true, `upsert`: true, `delete`: true}
+
+// interaction is a recorded call
+type interaction struct {
+	// Identifier is the identifier of the handler or API that was called
+	Identifier string `json:"identifier"`
+
+	// Method is the name of the method that was called
+	Method string `json:"method"`
+
+	// Arguments is a digest of the arguments of the call
+	Arguments string `json:"arguments"`
+
+	// Result is the result of the call, encoded using the service protocol. It is empty when the call failed.
+	Result []byte `json:"result,omitempty"`
+
+	// Error is the message of the error of a call that failed
+	Error string `json:"error,omitempty"`
+}
+
+// Cassette holds the calls recorded by a run, or the calls to replay
+type Cassette struct {
+	path         string
+	replay       bool
+	lock         sync.Mutex
+	interactions []*interaction
+	queues       map[string][]*interaction
+}
+
+// Create returns an empty cassette that records calls. The calls are written to the given file by Save.
+func Create(path string) *Cassette {
+	return &Cassette{path: path}
+}
+
+// Load reads the calls recorded in the given file and returns a cassette that replays them
+func Load(path string) (*Cassette, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k := &Cassette{path: path, replay: true, queues: map[string][]*interaction{}}
+	if err = json.Unmarshal(bs, &k.interactions); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %s", path, err)
+	}
+	for _, i := range k.interactions {
+		key := callKey(i.Identifier, i.Method, i.Arguments)
+		k.queues[key] = append(k.queues[key], i)
+	}
+	return k, nil
+}
+
+// Unplayed returns the number of recorded calls that have not been replayed
+func (k *Cassette) Unplayed() int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	n := 0
+	for _, q := range k.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// Save writes the recorded calls to the file of the cassette. Calls are written in the order they
+// completed.
+func (k *Cassette) Save() error {
+	if k.replay {
+		return errors.New(`a cassette that is replayed cannot be saved`)
+	}
+	k.lock.Lock()
+	bs, err := json.MarshalIndent(k.interactions, ``, `  `)
+	k.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Write and rename so that a run that is interrupted never leaves a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(k.path), filepath.Base(k.path)+`.*.tmp`)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(bs)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), k.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// WrapService returns a function that wraps a service so that the calls to its handlers, and to the
+// identity service, are recorded, or replayed without calling the service
+func (k *Cassette) WrapService() func(serviceapi.Service) serviceapi.Service {
+	return func(s serviceapi.Service) serviceapi.Service {
+		return &service{Service: s, cassette: k}
+	}
+}
+
+type service struct {
+	serviceapi.Service
+	cassette *Cassette
+}
+
+func (s *service) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	if !handlerMethods[name] && identifier != serviceapi.IdentityName {
+		return s.Service.Invoke(c, identifier, name, arguments...)
+	}
+	args := digest(types.WrapValues(arguments))
+	if s.cassette.replay {
+		return s.cassette.play(c, identifier, name, args)
+	}
+	return s.record(c, identifier, name, args, arguments)
+}
+
+// record makes the call and records its result, or its error
+func (s *service) record(c eval.Context, identifier, name, args string, arguments []eval.Value) eval.Value {
+	i := &interaction{Identifier: identifier, Method: name, Arguments: args}
+	defer func() {
+		if e := recover(); e != nil {
+			i.Error = fmt.Sprint(e)
+			s.cassette.add(i)
+			panic(e)
+		}
+	}()
+	result := s.Service.Invoke(c, identifier, name, arguments...)
+	bs, err := proto.Marshal(sdkgrpc.ToDataPB(result))
+	if err != nil {
+		panic(fmt.Errorf("unable to record result of %s on %s: %s", name, identifier, err))
+	}
+	i.Result = bs
+	s.cassette.add(i)
+	return result
+}
+
+func (k *Cassette) add(i *interaction) {
+	k.lock.Lock()
+	k.interactions = append(k.interactions, i)
+	k.lock.Unlock()
+}
+
+// play returns the recorded result of the next recorded call with the given arguments, or panics with
+// its recorded error
+func (k *Cassette) play(c eval.Context, identifier, name, args string) eval.Value {
+	key := callKey(identifier, name, args)
+	k.lock.Lock()
+	q := k.queues[key]
+	if len(q) == 0 {
+		k.lock.Unlock()
+		panic(fmt.Errorf("cassette %s has no recorded call of %s on %s with the given arguments", k.path, name, identifier))
+	}
+	i := q[0]
+	if len(q) == 1 {
+		delete(k.queues, key)
+	} else {
+		k.queues[key] = q[1:]
+	}
+	k.lock.Unlock()
+
+	if i.Error != `` {
+		panic(errors.New(i.Error))
+	}
+	data := &datapb.Data{}
+	if err := proto.Unmarshal(i.Result, data); err != nil {
+		panic(fmt.Errorf("invalid result of %s on %s in cassette %s: %s", name, identifier, k.path, err))
+	}
+	return sdkgrpc.FromDataPB(c, data)
+}
+
+func callKey(identifier, name, args string) string {
+	return identifier + "\x00" + name + "\x00" + args
+}
+
+// digest returns a digest of the given value. Values that are Sensitive contribute their content.
+func digest(v eval.Value) string {
+	bs, err := proto.Marshal(sdkgrpc.ToDataPB(v))
+	if err != nil {
+		return ``
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,106 @@
+package cassette
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	// Ensure that pcore is initialized
+	_ "github.com/lyraproj/puppet-evaluator/pcore"
+)
+
+var errNotFound = errors.New(`NotFound`)
+
+// panicMessage returns the message of the error that the given function panics with
+func panicMessage(f func()) (msg string) {
+	defer func() {
+		if err, ok := recover().(error); ok {
+			msg = err.Error()
+		}
+	}()
+	f()
+	return
+}
+
+type testService struct {
+	serviceapi.Service
+	calls int
+}
+
+func (s *testService) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	s.calls++
+	switch name {
+	case `create`:
+		return types.WrapValues([]eval.Value{arguments[0], types.WrapString(`id-` + arguments[0].String())})
+	case `read`:
+		panic(errNotFound)
+	}
+	return types.WrapInteger(int64(s.calls))
+}
+
+func Test_RecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `cassette`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, `cassette.json`)
+
+	s := &testService{}
+	eval.Puppet.Do(func(c eval.Context) {
+		recorder := Create(path)
+		svc := recorder.WrapService()(s)
+		assert.Equal(t, `['a', 'id-a']`, svc.Invoke(c, `Aws::VpcHandler`, `create`, types.WrapString(`a`)).String())
+		assert.Equal(t, `['b', 'id-b']`, svc.Invoke(c, `Aws::VpcHandler`, `create`, types.WrapString(`b`)).String())
+		assert.PanicsWithValue(t, errNotFound, func() { svc.Invoke(c, `Aws::VpcHandler`, `read`, types.WrapString(`id-c`)) })
+		assert.Equal(t, `4`, svc.Invoke(c, serviceapi.IdentityName, `getExternal`, types.WrapString(`x`)).String())
+		assert.Equal(t, `5`, svc.Invoke(c, serviceapi.IdentityName, `getExternal`, types.WrapString(`x`)).String())
+
+		// Calls that are not made to handlers are not recorded
+		assert.Equal(t, `6`, svc.Invoke(c, `Aws::Vpc`, `state`).String())
+		require.NoError(t, recorder.Save())
+	})
+	assert.Equal(t, 6, s.calls)
+
+	player, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 5, player.Unplayed())
+	assert.Error(t, player.Save())
+
+	s.calls = 0
+	eval.Puppet.Do(func(c eval.Context) {
+		svc := player.WrapService()(s)
+		assert.Equal(t, `['b', 'id-b']`, svc.Invoke(c, `Aws::VpcHandler`, `create`, types.WrapString(`b`)).String())
+		assert.Equal(t, `['a', 'id-a']`, svc.Invoke(c, `Aws::VpcHandler`, `create`, types.WrapString(`a`)).String())
+		assert.Equal(t, `NotFound`, panicMessage(func() { svc.Invoke(c, `Aws::VpcHandler`, `read`, types.WrapString(`id-c`)) }))
+
+		// Equal calls are replayed in the order they were recorded
+		assert.Equal(t, `4`, svc.Invoke(c, serviceapi.IdentityName, `getExternal`, types.WrapString(`x`)).String())
+		assert.Equal(t, `5`, svc.Invoke(c, serviceapi.IdentityName, `getExternal`, types.WrapString(`x`)).String())
+		assert.Equal(t, 0, player.Unplayed())
+
+		assert.Panics(t, func() { svc.Invoke(c, serviceapi.IdentityName, `getExternal`, types.WrapString(`x`)) })
+		assert.Panics(t, func() { svc.Invoke(c, `Aws::VpcHandler`, `create`, types.WrapString(`c`)) })
+		assert.Equal(t, `1`, svc.Invoke(c, `Aws::Vpc`, `state`).String())
+	})
+	assert.Equal(t, 1, s.calls)
+}
+
+func Test_LoadErrors(t *testing.T) {
+	_, err := Load(`testdata/missing.json`)
+	assert.True(t, os.IsNotExist(err))
+
+	dir, err := ioutil.TempDir(``, `cassette`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, `cassette.json`)
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{`), 0600))
+	_, err = Load(path)
+	assert.Error(t, err)
+}
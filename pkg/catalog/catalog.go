@@ -0,0 +1,129 @@
+package catalog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/lyraproj/lyra/pkg/loader"
+	"github.com/lyraproj/lyra/pkg/logger"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/wfe/api"
+	"github.com/lyraproj/wfe/service"
+	"github.com/lyraproj/wfe/wfe"
+)
+
+// Generate loads the named workflow and writes Backstage catalog entities describing it and the
+// resources it manages to w. External IDs are read from the identity store so that the entities
+// can be linked to the real resources that have been provisioned.
+func Generate(workflowName string, opts Options, w io.Writer) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("unable to generate catalog for %s: %v", workflowName, e)
+		}
+	}()
+
+	var wf *Workflow
+	eval.Puppet.Do(func(c eval.Context) {
+		log := logger.Get()
+		l := loader.New(log, c.Loader())
+		l.PreLoad(c)
+		c.DoWithLoader(l, func() {
+			def, ok := eval.Load(c, eval.NewTypedName(eval.NsDefinition, workflowName))
+			if !ok {
+				panic(fmt.Sprintf("no definition found for workflow %s", workflowName))
+			}
+			a := wfe.CreateActivity(def.(serviceapi.Definition))
+			wf = FromActivity(a)
+			assignExternalIDs(c, a.Identifier()+"/", wf)
+			log.Debug("collected catalog resources", "workflow", workflowName, "count", len(wf.Resources))
+		})
+	})
+	return Write(w, Entities(wf, opts))
+}
+
+// LoadWorkflows loads all workflows within reach and returns them sorted by name
+func LoadWorkflows() (wfs []*Workflow, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("unable to load workflows: %v", e)
+		}
+	}()
+
+	eval.Puppet.Do(func(c eval.Context) {
+		log := logger.Get()
+		l := loader.New(log, c.Loader())
+		l.PreLoad(c)
+		c.DoWithLoader(l, func() {
+			names := l.Discover(c, func(tn eval.TypedName) bool { return tn.Namespace() == eval.NsDefinition })
+			for _, name := range names {
+				def, ok := eval.Load(c, name)
+				if !ok {
+					continue
+				}
+				if style, ok := def.(serviceapi.Definition).Properties().Get4(`style`); !ok || style.String() != `workflow` {
+					continue
+				}
+				wfs = append(wfs, FromActivity(wfe.CreateActivity(def.(serviceapi.Definition))))
+			}
+			log.Debug("collected workflows", "count", len(wfs))
+		})
+	})
+	sort.Slice(wfs, func(i, j int) bool { return wfs[i].Name < wfs[j].Name })
+	return wfs, nil
+}
+
+// FromActivity collects the resources of the given activity and all activities nested within it
+func FromActivity(a api.Activity) *Workflow {
+	wf := &Workflow{Name: a.Name()}
+	collectResources(a, wf)
+	return wf
+}
+
+func collectResources(a api.Activity, wf *Workflow) {
+	switch a := a.(type) {
+	case api.Workflow:
+		for _, sa := range a.Activities() {
+			collectResources(sa, wf)
+		}
+	case api.Iterator:
+		collectResources(a.Producer(), wf)
+	case api.Resource:
+		wf.Resources = append(wf.Resources, &Resource{
+			Name:       service.LeafName(a.Name()),
+			Type:       a.Type().Name(),
+			InternalID: a.Identifier(),
+			Input:      parameterNames(a.Input()),
+			Output:     parameterNames(a.Output()),
+		})
+	}
+}
+
+func parameterNames(params []eval.Parameter) []string {
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// assignExternalIDs searches the identity store for mappings below the given prefix and assigns
+// the external IDs found to the corresponding resources
+func assignExternalIDs(c eval.Context, prefix string, wf *Workflow) {
+	idef := service.GetDefinition(c, service.IdentityId)
+	identity := service.GetService(c, idef.ServiceId())
+	found, ok := identity.Invoke(c, idef.Identifier().Name(), `search`, types.WrapString(prefix)).(eval.List)
+	if !ok {
+		return
+	}
+	ids := make(map[string]string, found.Len())
+	found.Each(func(t eval.Value) {
+		tuple := t.(eval.List)
+		ids[tuple.At(0).String()] = tuple.At(1).String()
+	})
+	for _, r := range wf.Resources {
+		r.ExternalID = ids[r.InternalID]
+	}
+}
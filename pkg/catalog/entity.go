@@ -0,0 +1,192 @@
+package catalog
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	apiVersion = "backstage.io/v1alpha1"
+
+	// Annotations added to the generated entities
+	workflowAnnotation     = "lyraproj.io/workflow"
+	resourceTypeAnnotation = "lyraproj.io/resource-type"
+	internalIDAnnotation   = "lyraproj.io/internal-id"
+	externalIDAnnotation   = "lyraproj.io/external-id"
+
+	maxNameLength = 63
+)
+
+// Options control the ownership and lifecycle of the generated entities
+type Options struct {
+	Owner     string
+	Lifecycle string
+	System    string
+}
+
+// Entity is a Backstage catalog entity
+type Entity struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Metadata   Metadata `yaml:"metadata"`
+	Spec       Spec     `yaml:"spec"`
+}
+
+// Metadata is the metadata of a Backstage catalog entity
+type Metadata struct {
+	Name        string            `yaml:"name"`
+	Title       string            `yaml:"title,omitempty"`
+	Description string            `yaml:"description,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	Tags        []string          `yaml:"tags,omitempty"`
+}
+
+// Spec is the spec of a Backstage Component or Resource entity
+type Spec struct {
+	Type         string   `yaml:"type"`
+	Lifecycle    string   `yaml:"lifecycle,omitempty"`
+	Owner        string   `yaml:"owner"`
+	System       string   `yaml:"system,omitempty"`
+	DependsOn    []string `yaml:"dependsOn,omitempty"`
+	DependencyOf []string `yaml:"dependencyOf,omitempty"`
+}
+
+// Workflow is the part of a workflow that is relevant to the catalog
+type Workflow struct {
+	Name      string
+	Resources []*Resource
+}
+
+// Resource is a resource activity of a workflow together with the external ID that
+// it has been assigned, if it has been applied
+type Resource struct {
+	Name       string
+	Type       string
+	InternalID string
+	ExternalID string
+	Input      []string
+	Output     []string
+}
+
+// DependsOn returns the resources of the workflow whose output the given resource consumes as input,
+// in the order of the inputs that consume them
+func (wf *Workflow) DependsOn(r *Resource) []*Resource {
+	producers := map[string]*Resource{}
+	for _, p := range wf.Resources {
+		for _, o := range p.Output {
+			producers[o] = p
+		}
+	}
+	var dependsOn []*Resource
+	seen := map[*Resource]bool{}
+	for _, i := range r.Input {
+		if p, ok := producers[i]; ok && p != r && !seen[p] {
+			seen[p] = true
+			dependsOn = append(dependsOn, p)
+		}
+	}
+	return dependsOn
+}
+
+// Entities returns a Component entity for the workflow followed by one Resource entity for each
+// of its resources. Dependencies between resources are derived from how the output of one
+// resource is consumed as input by another.
+func Entities(wf *Workflow, opts Options) []*Entity {
+	wfName := EntityName(wf.Name)
+	component := &Entity{
+		APIVersion: apiVersion,
+		Kind:       "Component",
+		Metadata: Metadata{
+			Name:        wfName,
+			Title:       wf.Name,
+			Description: "Lyra workflow " + wf.Name,
+			Annotations: map[string]string{workflowAnnotation: wf.Name},
+			Tags:        []string{"lyra"},
+		},
+		Spec: Spec{Type: "lyra-workflow", Lifecycle: opts.Lifecycle, Owner: opts.Owner, System: opts.System},
+	}
+	entities := []*Entity{component}
+
+	for _, r := range wf.Resources {
+		name := resourceName(wf, r)
+		component.Spec.DependsOn = append(component.Spec.DependsOn, "resource:"+name)
+
+		annotations := map[string]string{
+			workflowAnnotation:     wf.Name,
+			resourceTypeAnnotation: r.Type,
+		}
+		if r.InternalID != "" {
+			annotations[internalIDAnnotation] = r.InternalID
+		}
+		if r.ExternalID != "" {
+			annotations[externalIDAnnotation] = r.ExternalID
+		}
+
+		dependsOn := []string{}
+		for _, p := range wf.DependsOn(r) {
+			dependsOn = append(dependsOn, "resource:"+resourceName(wf, p))
+		}
+		sort.Strings(dependsOn)
+
+		entities = append(entities, &Entity{
+			APIVersion: apiVersion,
+			Kind:       "Resource",
+			Metadata: Metadata{
+				Name:        name,
+				Title:       r.Name,
+				Annotations: annotations,
+				Tags:        []string{"lyra"},
+			},
+			Spec: Spec{
+				Type:         typeName(r.Type),
+				Owner:        opts.Owner,
+				System:       opts.System,
+				DependsOn:    dependsOn,
+				DependencyOf: []string{"component:" + wfName},
+			},
+		})
+	}
+	return entities
+}
+
+// Write writes the entities as a multi document YAML stream
+func Write(w io.Writer, entities []*Entity) error {
+	for _, e := range entities {
+		bts, err := yaml.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(w, "---\n"); err != nil {
+			return err
+		}
+		if _, err = w.Write(bts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resourceName(wf *Workflow, r *Resource) string {
+	return EntityName(wf.Name + "-" + r.Name)
+}
+
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// EntityName converts a Lyra name into a valid Backstage entity name
+func EntityName(name string) string {
+	name = invalidNameChars.ReplaceAllString(strings.Replace(name, "::", "-", -1), "-")
+	name = strings.Trim(name, "-_.")
+	if len(name) > maxNameLength {
+		name = strings.TrimRight(name[:maxNameLength], "-_.")
+	}
+	return strings.ToLower(name)
+}
+
+// typeName converts a resource type name such as Aws::Vpc into a Backstage type such as aws-vpc
+func typeName(t string) string {
+	return strings.ToLower(strings.Replace(t, "::", "-", -1))
+}
@@ -0,0 +1,73 @@
+package catalog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var entityNameTests = []struct {
+	title    string
+	name     string
+	expected string
+}{
+	{title: "simple", name: "aws_vpc_yaml", expected: "aws_vpc_yaml"},
+	{title: "namespaced", name: "Aws::Vpc", expected: "aws-vpc"},
+	{title: "invalid characters", name: "my workflow!", expected: "my-workflow"},
+	{title: "too long", name: strings.Repeat("a", 70), expected: strings.Repeat("a", 63)},
+}
+
+func Test_EntityName(t *testing.T) {
+	for _, test := range entityNameTests {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.expected, EntityName(test.name))
+		})
+	}
+}
+
+func sampleWorkflow() *Workflow {
+	return &Workflow{
+		Name: "aws_vpc_yaml",
+		Resources: []*Resource{
+			{Name: "vpc", Type: "Aws::Vpc", InternalID: "lyra://puppet.com/aws_vpc_yaml/vpc", ExternalID: "vpc-123", Input: []string{"tags"}, Output: []string{"vpcId"}},
+			{Name: "subnet", Type: "Aws::Subnet", Input: []string{"vpcId", "tags"}, Output: []string{"subnetId"}},
+		},
+	}
+}
+
+func Test_Entities(t *testing.T) {
+	entities := Entities(sampleWorkflow(), Options{Owner: "team-infra", Lifecycle: "production"})
+	require.Equal(t, 3, len(entities))
+
+	component := entities[0]
+	assert.Equal(t, "Component", component.Kind)
+	assert.Equal(t, "aws_vpc_yaml", component.Metadata.Name)
+	assert.Equal(t, "team-infra", component.Spec.Owner)
+	assert.Equal(t, "production", component.Spec.Lifecycle)
+	assert.Equal(t, []string{"resource:aws_vpc_yaml-vpc", "resource:aws_vpc_yaml-subnet"}, component.Spec.DependsOn)
+
+	vpc := entities[1]
+	assert.Equal(t, "Resource", vpc.Kind)
+	assert.Equal(t, "aws-vpc", vpc.Spec.Type)
+	assert.Equal(t, "vpc-123", vpc.Metadata.Annotations[externalIDAnnotation])
+	assert.Equal(t, []string{}, vpc.Spec.DependsOn)
+	assert.Equal(t, []string{"component:aws_vpc_yaml"}, vpc.Spec.DependencyOf)
+
+	subnet := entities[2]
+	assert.Equal(t, []string{"resource:aws_vpc_yaml-vpc"}, subnet.Spec.DependsOn)
+	_, ok := subnet.Metadata.Annotations[externalIDAnnotation]
+	assert.False(t, ok)
+}
+
+func Test_Write(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	require.Nil(t, Write(buf, Entities(sampleWorkflow(), Options{Owner: "team-infra"})))
+	out := buf.String()
+	assert.Equal(t, 3, strings.Count(out, "---\n"))
+	assert.Contains(t, out, "apiVersion: backstage.io/v1alpha1")
+	assert.Contains(t, out, "kind: Component")
+	assert.Contains(t, out, "lyraproj.io/external-id: vpc-123")
+}
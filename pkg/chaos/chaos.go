@@ -0,0 +1,287 @@
+// Package chaos injects faults into the calls that runs make to providers, so that users can verify that
+// their retry settings, and the way they resume failed runs, behave as expected before a real fault
+// occurs. A fault is injected where the call would fail in a real run: a timeout or a transient error
+// is returned as the gRPC client returns it, without calling the provider, while a crash after create
+// lets the provider create the resource and then aborts the run before the engine stores the identity
+// of the resource.
+package chaos
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FaultsEnvVar holds the faults, separated by semicolons, that are injected when none are given explicitly
+const FaultsEnvVar = "LYRA_CHAOS"
+
+// Kinds of faults
+const (
+	// Timeout waits for the delay of the fault and fails the call with DeadlineExceeded
+	Timeout = `timeout`
+
+	// Error fails the call with Unavailable, the error of a transient failure
+	Error = `error`
+
+	// Throttle fails the call with ResourceExhausted, the error of a call that the API of the provider
+	// throttles. Such calls are retried when the provider has a limit, see package throttle.
+	Throttle = `throttle`
+
+	// CrashAfterCreate aborts the run after the provider has created a resource and before the engine
+	// has stored its identity
+	CrashAfterCreate = `crash-after-create`
+)
+
+// DefaultTimeout is the delay of a timeout when a fault doesn't specify it
+const DefaultTimeout = 5 * time.Second
+
+// sleep is replaced by tests
+var sleep = time.Sleep
+
+// Fault describes the calls that fail and how they fail
+type Fault struct {
+	// Kind is Timeout, Error, Throttle or CrashAfterCreate
+	Kind string
+
+	// Handler is a glob pattern for the identifiers of the handlers whose calls fail, e.g. Aws::*. It
+	// matches all handlers when it is empty.
+	Handler string
+
+	// Method is the method of the calls that fail, e.g. create. It matches all methods when it is empty.
+	Method string
+
+	// Probability is the probability that a matching call fails
+	Probability float64
+
+	// After is the number of matching calls that are made before the fault is injected
+	After int
+
+	// Times is the number of times that the fault is injected. Zero means no limit.
+	Times int
+
+	// Delay is the time that a timeout waits before it fails the call
+	Delay time.Duration
+
+	// Message is the message of the error, if any
+	Message string
+}
+
+// ParseFaults parses faults of the form <kind>[:<key>=<value>,...] where the keys are handler, method,
+// probability, after, times, delay and message, e.g. error:handler=Aws::*,method=create,times=2.
+func ParseFaults(specs []string) ([]*Fault, error) {
+	faults := make([]*Fault, 0, len(specs))
+	for _, spec := range specs {
+		kindAndSettings := strings.SplitN(spec, `:`, 2)
+		f := &Fault{Kind: strings.TrimSpace(kindAndSettings[0]), Probability: 1, Delay: DefaultTimeout}
+		switch f.Kind {
+		case Timeout, Error, Throttle:
+		case CrashAfterCreate:
+			f.Method = `create`
+		default:
+			return nil, fmt.Errorf("unknown kind of fault '%s' in '%s', expected %s, %s, %s or %s", f.Kind, spec, Timeout, Error, Throttle, CrashAfterCreate)
+		}
+		if len(kindAndSettings) == 1 {
+			faults = append(faults, f)
+			continue
+		}
+		// A handler pattern may contain the separators of the settings, so settings are split on ,key=
+		for _, setting := range splitSettings(kindAndSettings[1]) {
+			kv := strings.SplitN(setting, `=`, 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid setting '%s' in fault '%s'", setting, spec)
+			}
+			key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+			var err error
+			switch key {
+			case `handler`:
+				if _, err = path.Match(value, ``); err == nil {
+					f.Handler = value
+				}
+			case `method`:
+				if f.Kind == CrashAfterCreate && value != `create` {
+					err = fmt.Errorf("a crash after create is only injected in create")
+				}
+				f.Method = value
+			case `probability`:
+				f.Probability, err = strconv.ParseFloat(value, 64)
+				if err == nil && (f.Probability < 0 || f.Probability > 1) {
+					err = fmt.Errorf("must be between 0 and 1")
+				}
+			case `after`:
+				f.After, err = parseCount(value)
+			case `times`:
+				f.Times, err = parseCount(value)
+			case `delay`:
+				f.Delay, err = time.ParseDuration(value)
+			case `message`:
+				f.Message = value
+			default:
+				return nil, fmt.Errorf("unknown setting '%s' in fault '%s'", key, spec)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s in fault '%s': %s", key, spec, err)
+			}
+		}
+		faults = append(faults, f)
+	}
+	return faults, nil
+}
+
+// splitSettings splits the given settings at each comma that is followed by a key and an equal sign, so
+// that values may contain commas
+func splitSettings(settings string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(settings); i++ {
+		if settings[i] != ',' {
+			continue
+		}
+		rest := settings[i+1:]
+		if eq := strings.IndexByte(rest, '='); eq > 0 && !strings.ContainsAny(rest[:eq], `,:*`) {
+			parts = append(parts, settings[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, settings[start:])
+}
+
+func parseCount(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("must not be negative")
+	}
+	return n, err
+}
+
+// matches returns true if the fault applies to calls of the given method of the given handler
+func (f *Fault) matches(handler, method string) bool {
+	if f.Method != `` && f.Method != method {
+		return false
+	}
+	if f.Handler == `` {
+		return true
+	}
+	ok, _ := path.Match(f.Handler, handler)
+	return ok
+}
+
+// message returns the message of the error of the fault
+func (f *Fault) message(handler, method string) string {
+	if f.Message != `` {
+		return `chaos: ` + f.Message
+	}
+	switch f.Kind {
+	case Timeout:
+		return fmt.Sprintf("chaos: %s of %s timed out after %s", method, handler, f.Delay)
+	case Throttle:
+		return fmt.Sprintf("chaos: %s of %s was throttled: Rate exceeded", method, handler)
+	default:
+		return fmt.Sprintf("chaos: %s of %s failed with a transient error", method, handler)
+	}
+}
+
+// Injector injects the faults of one run
+type Injector struct {
+	faults   []*Fault
+	logger   hclog.Logger
+	lock     sync.Mutex
+	random   *rand.Rand
+	matched  []int
+	injected []int
+}
+
+// New returns an injector of the given faults. The seed makes the faults that have a probability below
+// one fail the same calls when a run is repeated, as long as the calls are made in the same order.
+func New(faults []*Fault, seed int64, logger hclog.Logger) *Injector {
+	return &Injector{
+		faults:   faults,
+		logger:   logger,
+		random:   rand.New(rand.NewSource(seed)),
+		matched:  make([]int, len(faults)),
+		injected: make([]int, len(faults)),
+	}
+}
+
+// Injected returns the number of faults that have been injected
+func (i *Injector) Injected() int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	n := 0
+	for _, c := range i.injected {
+		n += c
+	}
+	return n
+}
+
+// WrapService returns a function that wraps a service so that faults are injected in the calls to its
+// handlers. The calls that the engine makes to the identity service are never failed.
+func (i *Injector) WrapService() func(serviceapi.Service) serviceapi.Service {
+	return func(s serviceapi.Service) serviceapi.Service {
+		return &service{Service: s, injector: i}
+	}
+}
+
+// fault returns the fault that is injected in the given call, or nil if the call is made as usual
+func (i *Injector) fault(handler, method string) *Fault {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for fi, f := range i.faults {
+		if !f.matches(handler, method) {
+			continue
+		}
+		i.matched[fi]++
+		if i.matched[fi] <= f.After || f.Times > 0 && i.injected[fi] >= f.Times {
+			continue
+		}
+		if f.Probability < 1 && i.random.Float64() >= f.Probability {
+			continue
+		}
+		i.injected[fi]++
+		return f
+	}
+	return nil
+}
+
+type service struct {
+	serviceapi.Service
+	injector *Injector
+}
+
+func (s *service) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	if identifier == serviceapi.IdentityName {
+		return s.Service.Invoke(c, identifier, name, arguments...)
+	}
+	f := s.injector.fault(identifier, name)
+	if f == nil {
+		return s.Service.Invoke(c, identifier, name, arguments...)
+	}
+	s.injector.logger.Warn("injecting fault", "kind", f.Kind, "identifier", identifier, "name", name)
+	switch f.Kind {
+	case Timeout:
+		sleep(f.Delay)
+		panic(status.Error(codes.DeadlineExceeded, f.message(identifier, name)))
+	case Throttle:
+		panic(status.Error(codes.ResourceExhausted, f.message(identifier, name)))
+	case CrashAfterCreate:
+		result := s.Service.Invoke(c, identifier, name, arguments...)
+		externalID := ``
+		if l, ok := result.(eval.List); ok && l.Len() > 1 {
+			externalID = l.At(1).String()
+		}
+		if f.Message != `` {
+			panic(fmt.Errorf("chaos: %s", f.Message))
+		}
+		panic(fmt.Errorf("chaos: crashed after %s created %s and before its identity was stored", identifier, externalID))
+	}
+	panic(status.Error(codes.Unavailable, f.message(identifier, name)))
+}
@@ -0,0 +1,137 @@
+package chaos
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/lyra/pkg/throttle"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// Ensure that pcore is initialized
+	_ "github.com/lyraproj/puppet-evaluator/pcore"
+)
+
+func Test_ParseFaults(t *testing.T) {
+	faults, err := ParseFaults([]string{`error:handler=Aws::*,method=create,times=2,message=busy, try again`, `timeout:delay=1s,probability=0.5,after=3`, `crash-after-create`})
+	require.NoError(t, err)
+	assert.Equal(t, []*Fault{
+		{Kind: Error, Handler: `Aws::*`, Method: `create`, Probability: 1, Times: 2, Delay: DefaultTimeout, Message: `busy, try again`},
+		{Kind: Timeout, Probability: 0.5, After: 3, Delay: time.Second},
+		{Kind: CrashAfterCreate, Method: `create`, Probability: 1, Delay: DefaultTimeout},
+	}, faults)
+
+	for _, spec := range []string{`explode`, `error:handler`, `error:colour=red`, `error:probability=2`, `error:times=-1`, `timeout:delay=soon`, `error:handler=[`, `crash-after-create:method=update`} {
+		_, err = ParseFaults([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+type testService struct {
+	serviceapi.Service
+	calls []string
+}
+
+func (s *testService) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	s.calls = append(s.calls, identifier+`.`+name)
+	if name == `create` {
+		return types.WrapValues([]eval.Value{arguments[0], types.WrapString(`id-1`)})
+	}
+	return eval.UNDEF
+}
+
+// invoke returns the error that the call panics with, or nil
+func invoke(s serviceapi.Service, identifier, name string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = e.(error)
+		}
+	}()
+	eval.Puppet.Do(func(c eval.Context) {
+		s.Invoke(c, identifier, name, types.WrapString(`desired`))
+	})
+	return nil
+}
+
+func newService(t *testing.T, specs ...string) (*Injector, *testService, serviceapi.Service) {
+	faults, err := ParseFaults(specs)
+	require.NoError(t, err)
+	injector := New(faults, 1, hclog.NewNullLogger())
+	ts := &testService{}
+	return injector, ts, injector.WrapService()(ts)
+}
+
+func Test_Error(t *testing.T) {
+	injector, ts, s := newService(t, `error:handler=Aws::*,method=create,after=1,times=2`)
+	assert.NoError(t, invoke(s, `Aws::Vpc`, `create`))
+	err := invoke(s, `Aws::Vpc`, `create`)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, `chaos: create of Aws::Vpc failed with a transient error`, status.Convert(err).Message())
+	assert.Error(t, invoke(s, `Aws::Subnet`, `create`))
+	assert.NoError(t, invoke(s, `Aws::Vpc`, `create`))
+	assert.NoError(t, invoke(s, `Aws::Vpc`, `read`))
+	assert.NoError(t, invoke(s, `Azure::Vm`, `create`))
+	assert.Equal(t, 2, injector.Injected())
+
+	// Calls that fail never reach the provider
+	assert.Equal(t, []string{`Aws::Vpc.create`, `Aws::Vpc.create`, `Aws::Vpc.read`, `Azure::Vm.create`}, ts.calls)
+}
+
+func Test_Timeout(t *testing.T) {
+	var slept time.Duration
+	sleep = func(d time.Duration) { slept += d }
+	defer func() { sleep = time.Sleep }()
+
+	_, _, s := newService(t, `timeout:delay=30s`)
+	err := invoke(s, `Aws::Vpc`, `read`)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Equal(t, 30*time.Second, slept)
+}
+
+func Test_Throttle(t *testing.T) {
+	_, _, s := newService(t, `throttle`)
+	err := invoke(s, `Aws::Vpc`, `create`)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.True(t, throttle.IsThrottled(err))
+}
+
+func Test_CrashAfterCreate(t *testing.T) {
+	_, ts, s := newService(t, `crash-after-create`)
+	assert.NoError(t, invoke(s, `Aws::Vpc`, `read`))
+	assert.EqualError(t, invoke(s, `Aws::Vpc`, `create`), `chaos: crashed after Aws::Vpc created id-1 and before its identity was stored`)
+
+	// The resource is created before the crash
+	assert.Equal(t, []string{`Aws::Vpc.read`, `Aws::Vpc.create`}, ts.calls)
+}
+
+func Test_IdentityIsNeverFailed(t *testing.T) {
+	injector, _, s := newService(t, `error`)
+	assert.NoError(t, invoke(s, serviceapi.IdentityName, `associate`))
+	assert.Equal(t, 0, injector.Injected())
+}
+
+func Test_Probability(t *testing.T) {
+	run := func(seed int64) []bool {
+		faults, err := ParseFaults([]string{`error:probability=0.5`})
+		require.NoError(t, err)
+		s := New(faults, seed, hclog.NewNullLogger()).WrapService()(&testService{})
+		failed := make([]bool, 20)
+		for i := range failed {
+			failed[i] = invoke(s, `Aws::Vpc`, fmt.Sprintf(`m%d`, i)) != nil
+		}
+		return failed
+	}
+	first := run(42)
+	assert.Contains(t, first, true)
+	assert.Contains(t, first, false)
+
+	// The same seed fails the same calls
+	assert.Equal(t, first, run(42))
+}
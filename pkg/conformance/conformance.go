@@ -0,0 +1,385 @@
+// Package conformance certifies that the handlers of a provider behave the way the workflow engine
+// expects them to. Each Case takes the resource of one type through its full lifecycle:
+//
+//	create   the handler returns an external ID and a state of the resource type
+//	read     reading the new resource returns the state that create returned
+//	update   the handler returns the desired state with the changes of the case, and so does a read
+//	reapply  the engine finds nothing to change when the desired state is applied again, and an update
+//	         with an unchanged desired state changes nothing
+//	delete   the resource cannot be read once it is deleted
+//
+// The handlers are called the way the engine calls them, so the provider can manage real resources or
+// those of an emulator, e.g. when its plugin is given the endpoint of the emulator in its environment.
+// Providers that are written in Go can also be checked in process, see WithService.
+package conformance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/annotation"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/wfe/service"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Steps of the lifecycle of a case, in the order they are taken
+const (
+	Create  = `create`
+	Read    = `read`
+	Update  = `update`
+	Reapply = `reapply`
+	Delete  = `delete`
+)
+
+// Statuses of a step
+const (
+	Passed  = `passed`
+	Failed  = `failed`
+	Skipped = `skipped`
+)
+
+// Case is the lifecycle test of the handler of one resource type
+type Case struct {
+	// Name names the case in the results. The default is the name of the type.
+	Name string `yaml:"name"`
+
+	// Type is the name of the resource type, e.g. Aws::Vpc
+	Type string `yaml:"type"`
+
+	// Create is the desired state of the resource that is created
+	Create map[string]interface{} `yaml:"create"`
+
+	// Update are the attributes that the update step changes. The step is skipped when there are none or
+	// when the handler has no update method.
+	Update map[string]interface{} `yaml:"update"`
+
+	// Ignore are the attributes that are not compared, e.g. timestamps that change on every read
+	Ignore []string `yaml:"ignore"`
+}
+
+// Result is the outcome of one step of a case
+type Result struct {
+	Case    string `json:"case"`
+	Step    string `json:"step"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+func (r *Result) String() string {
+	if r.Message == `` {
+		return fmt.Sprintf("%s %s: %s", r.Case, r.Step, r.Status)
+	}
+	return fmt.Sprintf("%s %s: %s, %s", r.Case, r.Step, r.Status, r.Message)
+}
+
+// AnyFailed returns true if any of the results is a failure
+func AnyFailed(results []*Result) bool {
+	for _, r := range results {
+		if r.Status == Failed {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadCases reads the cases of the YAML file at the given path. The file has a cases key with a list of
+// cases, e.g.
+//
+//	cases:
+//	  - type: Example::Person
+//	    create: { name: Bob, age: 30 }
+//	    update: { age: 31 }
+func LoadCases(path string) ([]*Case, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Cases []*Case `yaml:"cases"`
+	}
+	if err = yaml.UnmarshalStrict(bts, &file); err != nil {
+		return nil, fmt.Errorf("invalid conformance cases %s: %s", path, err)
+	}
+	for i, cs := range file.Cases {
+		if cs.Type == `` {
+			return nil, fmt.Errorf("conformance case %d in %s has no type", i+1, path)
+		}
+		cs.Create = plain(cs.Create).(map[string]interface{})
+		cs.Update = plain(cs.Update).(map[string]interface{})
+	}
+	return file.Cases, nil
+}
+
+// plain returns the value with the maps that YAML keys by interface{} keyed by string, so that they can
+// be wrapped
+func plain(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = plain(e)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = plain(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = plain(e)
+		}
+		return a
+	}
+	return v
+}
+
+// Check takes the resources of the cases through their lifecycle using the handlers known to the loader
+// of the given context, and returns the result of each step. The steps of a case that follow a failed
+// step are skipped, but a resource that has been created is always deleted.
+func Check(c eval.Context, cases []*Case) []*Result {
+	handlers := findHandlers(c)
+	var results []*Result
+	for _, cs := range cases {
+		results = append(results, check(c, cs, handlers)...)
+	}
+	return results
+}
+
+// findHandlers returns the definitions of the handlers known to the loader of the context keyed by the
+// name of the type that they handle
+func findHandlers(c eval.Context) map[string]serviceapi.Definition {
+	handlers := map[string]serviceapi.Definition{}
+	for _, tn := range c.Loader().Discover(c, func(tn eval.TypedName) bool { return tn.Namespace() == eval.NsDefinition }) {
+		def, ok := eval.Load(c, tn)
+		if !ok {
+			continue
+		}
+		if hf, ok := def.(serviceapi.Definition).Properties().Get4(`handlerFor`); ok {
+			if t, ok := hf.(eval.Type); ok {
+				handlers[t.Name()] = def.(serviceapi.Definition)
+			}
+		}
+	}
+	return handlers
+}
+
+// lifecycle is the state of one case while its steps are taken
+type lifecycle struct {
+	c          eval.Context
+	cs         *Case
+	typ        eval.ObjectType
+	handler    serviceapi.Service
+	name       string
+	hasUpdate  bool
+	externalID eval.Value
+	failed     bool
+	results    []*Result
+}
+
+func check(c eval.Context, cs *Case, handlers map[string]serviceapi.Definition) []*Result {
+	caseName := cs.Name
+	if caseName == `` {
+		caseName = cs.Type
+	}
+	l := &lifecycle{c: c, cs: cs}
+	def, ok := handlers[cs.Type]
+	if !ok {
+		return []*Result{{Case: caseName, Step: Create, Status: Failed, Message: fmt.Sprintf("no handler for %s is found", cs.Type)}}
+	}
+	l.name = def.Identifier().Name()
+	l.handler = service.GetService(c, def.ServiceId())
+	if crd, ok := def.Properties().Get4(`interface`); ok {
+		if it, ok := crd.(eval.ObjectType); ok {
+			_, l.hasUpdate = it.Member(Update)
+		}
+	}
+	if t, ok := eval.Load(c, eval.NewTypedName(eval.NsType, cs.Type)); ok {
+		l.typ, _ = t.(eval.ObjectType)
+	}
+	if l.typ == nil {
+		l.typ, _ = def.Properties().Get5(`handlerFor`, nil).(eval.ObjectType)
+	}
+
+	var desired, state eval.PuppetObject
+	l.step(caseName, Create, func() string {
+		desired = l.object(cs.Create)
+		result, ok := l.invoke(Create, desired).(eval.List)
+		if !ok || result.Len() != 2 {
+			return `create did not return a state and an external ID`
+		}
+		if id := result.At(1); !eval.Equals(id, eval.UNDEF) && id.String() != `` {
+			l.externalID = id
+		}
+		if l.externalID == nil {
+			return `create returned an empty external ID`
+		}
+		state, ok = result.At(0).(eval.PuppetObject)
+		if !ok || !eval.IsInstance(l.typ, state) {
+			return fmt.Sprintf("create returned a state that is not a %s", cs.Type)
+		}
+		return ``
+	})
+	l.step(caseName, Read, func() string {
+		return l.readBack(state)
+	})
+	if len(cs.Update) == 0 || !l.hasUpdate {
+		l.skip(caseName, Update)
+	} else {
+		l.step(caseName, Update, func() string {
+			attrs := make(map[string]interface{}, len(cs.Create)+len(cs.Update))
+			for k, v := range cs.Create {
+				attrs[k] = v
+			}
+			for k, v := range cs.Update {
+				attrs[k] = v
+			}
+			desired = l.object(attrs)
+			if state, ok = l.invoke(Update, l.externalID, desired).(eval.PuppetObject); !ok {
+				return `update did not return a state`
+			}
+			if diff := l.changes(desired, state); len(diff) > 0 {
+				return fmt.Sprintf("update returned a state that differs from the desired state in %s", strings.Join(diff, `, `))
+			}
+			return l.readBack(state)
+		})
+	}
+	l.step(caseName, Reapply, func() string {
+		current, ok := l.invoke(Read, l.externalID).(eval.PuppetObject)
+		if !ok {
+			return `read did not return a state`
+		}
+		if diff := l.changes(desired, current); len(diff) > 0 {
+			return fmt.Sprintf("applying the same desired state again would change %s", strings.Join(diff, `, `))
+		}
+		if l.hasUpdate {
+			if _, ok = l.invoke(Update, l.externalID, desired).(eval.PuppetObject); !ok {
+				return `update did not return a state`
+			}
+			return l.readBack(current)
+		}
+		return ``
+	})
+
+	if l.externalID == nil {
+		l.skip(caseName, Delete)
+		return l.results
+	}
+	// The resource is deleted even when an earlier step failed
+	l.attempt(caseName, Delete, func() string {
+		l.invoke(Delete, l.externalID)
+		if !l.gone() {
+			return `the resource can still be read after it was deleted`
+		}
+		return ``
+	})
+	return l.results
+}
+
+// step takes the given step unless an earlier step of the case has failed. The step returns a message
+// when it fails and panics when a call fails.
+func (l *lifecycle) step(caseName, step string, f func() string) {
+	if l.failed {
+		l.skip(caseName, step)
+		return
+	}
+	l.attempt(caseName, step, f)
+}
+
+// attempt takes the given step
+func (l *lifecycle) attempt(caseName, step string, f func() string) {
+	r := &Result{Case: caseName, Step: step, Status: Passed}
+	l.results = append(l.results, r)
+	defer func() {
+		if e := recover(); e != nil {
+			r.Status, r.Message = Failed, fmt.Sprint(e)
+		}
+		if r.Status == Failed {
+			l.failed = true
+		}
+	}()
+	if msg := f(); msg != `` {
+		r.Status, r.Message = Failed, msg
+	}
+}
+
+func (l *lifecycle) skip(caseName, step string) {
+	l.results = append(l.results, &Result{Case: caseName, Step: step, Status: Skipped})
+}
+
+func (l *lifecycle) invoke(method string, arguments ...eval.Value) eval.Value {
+	return l.handler.Invoke(l.c, l.name, method, arguments...)
+}
+
+// object returns an instance of the resource type with the given attributes
+func (l *lifecycle) object(attrs map[string]interface{}) eval.PuppetObject {
+	if l.typ == nil {
+		panic(fmt.Errorf("type %s is not found", l.cs.Type))
+	}
+	return types.NewObjectValue2(l.c, l.typ, types.WrapStringToInterfaceMap(l.c, attrs)).(eval.PuppetObject)
+}
+
+// readBack reads the resource and returns a message if its state differs from the expected state
+func (l *lifecycle) readBack(expected eval.PuppetObject) string {
+	actual, ok := l.invoke(Read, l.externalID).(eval.PuppetObject)
+	if !ok {
+		return `read did not return a state`
+	}
+	if diff := l.differences(expected, actual, nil); len(diff) > 0 {
+		return fmt.Sprintf("read returned a state that differs in %s", strings.Join(diff, `, `))
+	}
+	return ``
+}
+
+// gone returns true if the resource cannot be read
+func (l *lifecycle) gone() (gone bool) {
+	defer func() {
+		if recover() != nil {
+			gone = true
+		}
+	}()
+	_, ok := l.invoke(Read, l.externalID).(eval.PuppetObject)
+	return !ok
+}
+
+// changes returns the attributes that the workflow engine would find changed between the desired and the
+// actual state. Attributes that the provider provides are not compared when they are not desired.
+func (l *lifecycle) changes(desired, actual eval.PuppetObject) []string {
+	var provided eval.List
+	if a, ok := l.typ.Annotations(l.c).Get(annotation.ResourceType); ok {
+		if v, ok := a.(annotation.Resource).Get(`providedAttributes`); ok {
+			provided, _ = v.(eval.List)
+		}
+	}
+	return l.differences(desired, actual, func(a eval.Attribute, v eval.Value) bool {
+		return provided != nil && a.Default(v) && provided.Any(func(p eval.Value) bool { return p.String() == a.Name() })
+	})
+}
+
+// differences returns the sorted names of the attributes whose values differ between the states, except
+// for the attributes that the case ignores and those for which skip returns true
+func (l *lifecycle) differences(expected, actual eval.PuppetObject, skip func(eval.Attribute, eval.Value) bool) []string {
+	ignored := map[string]bool{}
+	for _, n := range l.cs.Ignore {
+		ignored[n] = true
+	}
+	var diff []string
+	for _, a := range l.typ.AttributesInfo().Attributes() {
+		ev := a.Get(expected)
+		if ignored[a.Name()] || skip != nil && skip(a, ev) {
+			continue
+		}
+		if !ev.Equals(a.Get(actual), nil) {
+			diff = append(diff, a.Name())
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
@@ -0,0 +1,180 @@
+package conformance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	// Ensure that pcore is initialized
+	_ "github.com/lyraproj/puppet-evaluator/pcore"
+)
+
+// personService is an emulated provider of Test::Person resources. Its faults make it break the contract.
+type personService struct {
+	serviceapi.Service
+	people map[string]eval.PuppetObject
+	next   int
+
+	// noUpdate removes the update method from the interface of the handler
+	noUpdate bool
+
+	// ageOnRead is added to the age of a person when it is read
+	ageOnRead int64
+
+	// keepOnDelete keeps the person when it is deleted
+	keepOnDelete bool
+}
+
+func (s *personService) Identifier(c eval.Context) eval.TypedName {
+	return eval.NewTypedName(eval.NsService, `Test`)
+}
+
+func (s *personService) Metadata(c eval.Context) (eval.TypeSet, []serviceapi.Definition) {
+	methods := []*types.HashEntry{
+		types.WrapHashEntry2(`create`, c.ParseType2(`Callable[Object, Tuple[Object, String]]`)),
+		types.WrapHashEntry2(`read`, c.ParseType2(`Callable[String, Object]`)),
+		types.WrapHashEntry2(`delete`, c.ParseType2(`Callable[String]`)),
+	}
+	if !s.noUpdate {
+		methods = append(methods, types.WrapHashEntry2(`update`, c.ParseType2(`Callable[String, Object, Object]`)))
+	}
+	crud := types.NewObjectType(`Test::PersonHandlerApi`, nil, types.SingletonHash2(`functions`, types.WrapHash(methods))).Resolve(c)
+	props := types.WrapHash([]*types.HashEntry{
+		types.WrapHashEntry2(`interface`, crud),
+		types.WrapHashEntry2(`style`, types.WrapString(`callable`)),
+		types.WrapHashEntry2(`handlerFor`, personType(c)),
+	})
+	return nil, []serviceapi.Definition{serviceapi.NewDefinition(eval.NewTypedName(eval.NsDefinition, `Test::PersonHandler`), s.Identifier(c), props)}
+}
+
+func (s *personService) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	switch name {
+	case `create`:
+		s.next++
+		id := strconv.Itoa(s.next)
+		s.people[id] = arguments[0].(eval.PuppetObject)
+		return types.WrapValues([]eval.Value{arguments[0], types.WrapString(id)})
+	case `read`:
+		p, ok := s.people[arguments[0].String()]
+		if !ok {
+			panic(fmt.Errorf("no person with id %s", arguments[0]))
+		}
+		if s.ageOnRead != 0 {
+			age := p.InitHash().Get5(`age`, nil).(eval.IntegerValue).Int() + s.ageOnRead
+			return person(c, p.InitHash().Get5(`name`, nil).String(), age)
+		}
+		return p
+	case `update`:
+		s.people[arguments[0].String()] = arguments[1].(eval.PuppetObject)
+		return arguments[1]
+	case `delete`:
+		if !s.keepOnDelete {
+			delete(s.people, arguments[0].String())
+		}
+		return eval.UNDEF
+	}
+	panic(fmt.Errorf("no method %s", name))
+}
+
+func personType(c eval.Context) eval.ObjectType {
+	return c.ParseType2(`Object[{name => 'Test::Person', attributes => { name => String, age => Integer }}]`).(eval.ResolvableType).Resolve(c).(eval.ObjectType)
+}
+
+func person(c eval.Context, name string, age int64) eval.PuppetObject {
+	return types.NewObjectValue2(c, personType(c), types.WrapStringToInterfaceMap(c, map[string]interface{}{`name`: name, `age`: age})).(eval.PuppetObject)
+}
+
+var personCase = &Case{Type: `Test::Person`, Create: map[string]interface{}{`name`: `Bob`, `age`: 30}, Update: map[string]interface{}{`age`: 31}}
+
+func statuses(results []*Result) map[string]string {
+	s := make(map[string]string, len(results))
+	for _, r := range results {
+		s[r.Step] = r.Status
+	}
+	return s
+}
+
+func Test_Conforming(t *testing.T) {
+	s := &personService{people: map[string]eval.PuppetObject{}}
+	results, err := Run([]*Case{personCase}, WithService(s))
+	require.NoError(t, err)
+	assert.Equal(t, []*Result{
+		{Case: `Test::Person`, Step: Create, Status: Passed},
+		{Case: `Test::Person`, Step: Read, Status: Passed},
+		{Case: `Test::Person`, Step: Update, Status: Passed},
+		{Case: `Test::Person`, Step: Reapply, Status: Passed},
+		{Case: `Test::Person`, Step: Delete, Status: Passed},
+	}, results)
+	assert.False(t, AnyFailed(results))
+	assert.Empty(t, s.people)
+}
+
+func Test_NoUpdate(t *testing.T) {
+	results, err := Run([]*Case{personCase}, WithService(&personService{people: map[string]eval.PuppetObject{}, noUpdate: true}))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{Create: Passed, Read: Passed, Update: Skipped, Reapply: Passed, Delete: Passed}, statuses(results))
+}
+
+func Test_ReadDiffers(t *testing.T) {
+	s := &personService{people: map[string]eval.PuppetObject{}, ageOnRead: 1}
+	results, err := Run([]*Case{personCase}, WithService(s))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{Create: Passed, Read: Failed, Update: Skipped, Reapply: Skipped, Delete: Passed}, statuses(results))
+	assert.Equal(t, `read returned a state that differs in age`, results[1].Message)
+	assert.True(t, AnyFailed(results))
+
+	// The created resource is deleted although the case failed
+	assert.Empty(t, s.people)
+
+	// An attribute that is ignored is not compared
+	ignoring := &Case{Type: personCase.Type, Create: personCase.Create, Ignore: []string{`age`}}
+	results, err = Run([]*Case{ignoring}, WithService(&personService{people: map[string]eval.PuppetObject{}, ageOnRead: 1}))
+	require.NoError(t, err)
+	assert.False(t, AnyFailed(results))
+}
+
+func Test_KeptOnDelete(t *testing.T) {
+	results, err := Run([]*Case{personCase}, WithService(&personService{people: map[string]eval.PuppetObject{}, keepOnDelete: true}))
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Case: `Test::Person`, Step: Delete, Status: Failed, Message: `the resource can still be read after it was deleted`}, results[4])
+}
+
+func Test_NoHandler(t *testing.T) {
+	results, err := Run([]*Case{{Name: `vpc`, Type: `Aws::Vpc`}})
+	require.NoError(t, err)
+	assert.Equal(t, []*Result{{Case: `vpc`, Step: Create, Status: Failed, Message: `no handler for Aws::Vpc is found`}}, results)
+}
+
+func Test_LoadCases(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `conformance`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, `cases.yaml`)
+	require.NoError(t, ioutil.WriteFile(path, []byte(`cases:
+  - type: Test::Person
+    create: { name: Bob, age: 30, tags: { team: infra } }
+    update: { age: 31 }
+    ignore: [ updated ]
+`), 0644))
+	cases, err := LoadCases(path)
+	require.NoError(t, err)
+	assert.Equal(t, []*Case{{
+		Type:   `Test::Person`,
+		Create: map[string]interface{}{`name`: `Bob`, `age`: 30, `tags`: map[string]interface{}{`team`: `infra`}},
+		Update: map[string]interface{}{`age`: 31},
+		Ignore: []string{`updated`},
+	}}, cases)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("cases:\n  - create: { name: Bob }\n"), 0644))
+	_, err = LoadCases(path)
+	assert.EqualError(t, err, fmt.Sprintf("conformance case 1 in %s has no type", path))
+}
@@ -0,0 +1,81 @@
+package conformance
+
+import (
+	"fmt"
+
+	hclog "github.com/hashicorp/go-hclog"
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/lyraproj/lyra/pkg/loader"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// Option configures the environment in which Run checks the cases
+type Option func(*config)
+
+type config struct {
+	logger   hclog.Logger
+	services []serviceapi.Service
+	options  []loader.Option
+	plugins  bool
+}
+
+// WithService makes the handlers of the given service available to the cases. The service is called in
+// process, so a provider that is written in Go can be checked by a go test, either against the real
+// target or against an emulated one.
+func WithService(s serviceapi.Service) Option {
+	return func(cfg *config) {
+		cfg.services = append(cfg.services, s)
+	}
+}
+
+// WithPlugins makes the handlers of the plugins on the plugin path available to the cases. The plugins
+// are started and stopped by Run.
+func WithPlugins(dirs ...string) Option {
+	return func(cfg *config) {
+		cfg.plugins = true
+		if len(dirs) > 0 {
+			cfg.options = append(cfg.options, loader.WithPluginPath(dirs...))
+		}
+	}
+}
+
+// WithLogger sets the logger of the loader. The default discards all log entries.
+func WithLogger(logger hclog.Logger) Option {
+	return func(cfg *config) {
+		cfg.logger = logger
+	}
+}
+
+// Run checks the cases against the handlers of the services given with WithService and of the plugins
+// when WithPlugins is given. An error is returned when the handlers cannot be loaded, not when a case
+// fails.
+func Run(cases []*Case, options ...Option) (results []*Result, err error) {
+	cfg := &config{logger: hclog.NewNullLogger()}
+	for _, option := range options {
+		option(cfg)
+	}
+	if cfg.plugins {
+		defer plugin.CleanupClients()
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("unable to load handlers: %v", e)
+		}
+	}()
+
+	eval.Puppet.Do(func(c eval.Context) {
+		l := loader.New(cfg.logger, c.Loader(), cfg.options...)
+		if cfg.plugins {
+			l.PreLoadPlugins(c)
+		}
+		c.DoWithLoader(l, func() {
+			for _, s := range cfg.services {
+				l.RegisterService(c, s)
+				l.RegisterMetadata(c, s)
+			}
+			results = Check(c, cases)
+		})
+	})
+	return results, nil
+}
@@ -0,0 +1,147 @@
+// Package credhelper implements a protocol that lets providers obtain credentials at runtime from a
+// helper binary instead of relying on ambient environment variables. The protocol is modelled after
+// the docker credential helpers.
+//
+// A helper is configured for a provider using the LYRA_CREDENTIAL_HELPER_<PROVIDER> environment
+// variable, or for all providers using LYRA_CREDENTIAL_HELPER. The value is either a path to the
+// helper binary or a name. A name is resolved to a binary called lyra-credential-<name> found in
+// the PATH.
+//
+// The helper is executed with the single argument "get". A JSON Request is written to its stdin and
+// it must write a JSON Credentials object to its stdout and exit with status zero. Anything written
+// to stderr is included in the error returned when the helper fails.
+package credhelper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HelperEnvVar configures the helper used for providers that don't have a helper of their own
+	HelperEnvVar = "LYRA_CREDENTIAL_HELPER"
+
+	// HelperPrefix is prepended to helper names to form the name of the helper binary
+	HelperPrefix = "lyra-credential-"
+
+	// expiryMargin makes cached credentials expire a little before they actually do
+	expiryMargin = 30 * time.Second
+)
+
+// ErrNoHelper is returned by Get when no helper has been configured for the provider
+var ErrNoHelper = errors.New("no credential helper configured")
+
+// Request is sent to the helper
+type Request struct {
+	// Provider is the name of the provider that requests credentials, e.g. "aws"
+	Provider string `json:"provider"`
+
+	// Scope optionally narrows down what the credentials are for, e.g. an account or a region
+	Scope string `json:"scope,omitempty"`
+}
+
+// Credentials is the response from the helper
+type Credentials struct {
+	// Values contains the credentials. The keys are provider specific, e.g. AWS_ACCESS_KEY_ID
+	Values map[string]string `json:"values"`
+
+	// Expires is the time when the credentials expire. Credentials without expiry are cached
+	// for the lifetime of the process.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Get returns the value with the given key or an empty string
+func (c *Credentials) Get(key string) string {
+	return c.Values[key]
+}
+
+func (c *Credentials) expired(now time.Time) bool {
+	return c.Expires != nil && now.Add(expiryMargin).After(*c.Expires)
+}
+
+var (
+	cacheLock sync.Mutex
+	cache     = map[Request]*Credentials{}
+)
+
+// HelperFor returns the path of the helper configured for the given provider or an empty string if no
+// helper has been configured
+func HelperFor(provider string) (string, error) {
+	helper := os.Getenv(HelperEnvVar + `_` + strings.ToUpper(strings.Replace(provider, `-`, `_`, -1)))
+	if helper == `` {
+		helper = os.Getenv(HelperEnvVar)
+	}
+	if helper == `` {
+		return ``, nil
+	}
+	if strings.ContainsRune(helper, filepath.Separator) {
+		return helper, nil
+	}
+	path, err := exec.LookPath(HelperPrefix + helper)
+	if err != nil {
+		return ``, fmt.Errorf("credential helper %s not found: %s", helper, err)
+	}
+	return path, nil
+}
+
+// Get returns credentials for the given provider and scope from the configured helper. Credentials are
+// cached until they expire. ErrNoHelper is returned when no helper has been configured for the provider.
+func Get(ctx context.Context, provider, scope string) (*Credentials, error) {
+	helper, err := HelperFor(provider)
+	if err != nil {
+		return nil, err
+	}
+	if helper == `` {
+		return nil, ErrNoHelper
+	}
+
+	rq := Request{Provider: provider, Scope: scope}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	if c, ok := cache[rq]; ok && !c.expired(time.Now()) {
+		return c, nil
+	}
+	c, err := Run(ctx, helper, &rq)
+	if err != nil {
+		return nil, err
+	}
+	cache[rq] = c
+	return c, nil
+}
+
+// Run executes the given helper with the given request
+func Run(ctx context.Context, helper string, rq *Request) (*Credentials, error) {
+	in, err := json.Marshal(rq)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, helper, `get`)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != `` {
+			return nil, fmt.Errorf("credential helper %s failed: %s: %s", helper, err, msg)
+		}
+		return nil, fmt.Errorf("credential helper %s failed: %s", helper, err)
+	}
+	c := &Credentials{}
+	if err = json.Unmarshal(stdout.Bytes(), c); err != nil {
+		// The output is not included since it may contain secrets
+		return nil, fmt.Errorf("credential helper %s produced invalid output", helper)
+	}
+	if len(c.Values) == 0 {
+		return nil, fmt.Errorf("credential helper %s returned no credentials for %s", helper, rq.Provider)
+	}
+	return c, nil
+}
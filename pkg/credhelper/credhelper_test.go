@@ -0,0 +1,62 @@
+package credhelper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testHelper = `#!/bin/sh
+[ "$1" = "get" ] || exit 2
+read rq
+echo "$rq" >> "$(dirname "$0")/requests"
+case "$rq" in
+  *'"provider":"aws"'*) echo '{"values":{"AWS_ACCESS_KEY_ID":"AKID","AWS_SECRET_ACCESS_KEY":"secret"}}' ;;
+  *'"provider":"broken"'*) echo 'not json' ;;
+  *) echo "unknown provider" >&2; exit 1 ;;
+esac
+`
+
+func Test_Get(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credhelper")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, HelperPrefix+"test"), []byte(testHelper), 0755))
+
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	ctx := context.Background()
+
+	_, err = Get(ctx, "aws", "")
+	assert.Equal(t, ErrNoHelper, err)
+
+	os.Setenv(HelperEnvVar, "test")
+	defer os.Unsetenv(HelperEnvVar)
+
+	c, err := Get(ctx, "aws", "")
+	require.Nil(t, err)
+	assert.Equal(t, "AKID", c.Get("AWS_ACCESS_KEY_ID"))
+
+	// Credentials without expiry are cached
+	_, err = Get(ctx, "aws", "")
+	require.Nil(t, err)
+	requests, err := ioutil.ReadFile(filepath.Join(dir, "requests"))
+	require.Nil(t, err)
+	assert.Equal(t, "{\"provider\":\"aws\"}\n", string(requests))
+
+	_, err = Get(ctx, "broken", "")
+	assert.EqualError(t, err, "credential helper "+filepath.Join(dir, HelperPrefix+"test")+" produced invalid output")
+
+	_, err = Get(ctx, "azure", "")
+	assert.Contains(t, err.Error(), "unknown provider")
+
+	// Provider specific helpers take precedence
+	os.Setenv(HelperEnvVar+"_AZURE", "missing")
+	defer os.Unsetenv(HelperEnvVar + "_AZURE")
+	_, err = Get(ctx, "azure", "")
+	assert.Contains(t, err.Error(), "credential helper missing not found")
+}
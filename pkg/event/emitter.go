@@ -0,0 +1,63 @@
+package event
+
+import (
+	"sync"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+const queueSize = 256
+
+// Emitter delivers events asynchronously to a Sink so that a slow sink does not slow down
+// the workflow. A nil Emitter is valid and discards all events.
+type Emitter struct {
+	source string
+	sink   Sink
+	logger hclog.Logger
+	queue  chan *Event
+	wg     sync.WaitGroup
+}
+
+// NewEmitter creates an emitter that delivers events from the given source to the sink
+func NewEmitter(source string, sink Sink, logger hclog.Logger) *Emitter {
+	e := &Emitter{
+		source: source,
+		sink:   sink,
+		logger: logger.Named("event"),
+		queue:  make(chan *Event, queueSize),
+	}
+	e.wg.Add(1)
+	go e.deliver()
+	return e
+}
+
+// Emit queues an event of the given type for delivery
+func (e *Emitter) Emit(eventType, subject string, data map[string]interface{}) {
+	if e == nil {
+		return
+	}
+	e.queue <- New(e.source, eventType, subject, data)
+}
+
+// Close delivers all queued events and then closes the sink
+func (e *Emitter) Close() {
+	if e == nil {
+		return
+	}
+	close(e.queue)
+	e.wg.Wait()
+	if err := e.sink.Close(); err != nil {
+		e.logger.Error("failed to close event sink", "err", err)
+	}
+}
+
+func (e *Emitter) deliver() {
+	defer e.wg.Done()
+	for ev := range e.queue {
+		if err := e.sink.Send(ev); err != nil {
+			e.logger.Error("failed to send event", "type", ev.Type, "subject", ev.Subject, "err", err)
+		} else {
+			e.logger.Debug("sent event", "type", ev.Type, "subject", ev.Subject)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package event
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// CloudEvents specification version used for all emitted events
+const SpecVersion = "1.0"
+
+// Event types emitted during the lifecycle of a workflow
+const (
+	WorkflowStarted  = "io.lyraproj.workflow.started"
+	WorkflowFinished = "io.lyraproj.workflow.finished"
+	WorkflowFailed   = "io.lyraproj.workflow.failed"
+	StepStarted      = "io.lyraproj.step.started"
+	StepFinished     = "io.lyraproj.step.finished"
+	StepFailed       = "io.lyraproj.step.failed"
+	ResourceCreated  = "io.lyraproj.resource.created"
+	ResourceUpdated  = "io.lyraproj.resource.updated"
+	ResourceDeleted  = "io.lyraproj.resource.deleted"
+	ResourceFailed   = "io.lyraproj.resource.failed"
+)
+
+// Event is a CNCF CloudEvent in its structured JSON representation
+type Event struct {
+	SpecVersion     string                 `json:"specversion"`
+	ID              string                 `json:"id"`
+	Source          string                 `json:"source"`
+	Type            string                 `json:"type"`
+	Time            time.Time              `json:"time"`
+	DataContentType string                 `json:"datacontenttype,omitempty"`
+	Subject         string                 `json:"subject,omitempty"`
+	Data            map[string]interface{} `json:"data,omitempty"`
+}
+
+// New creates an event of the given type with a unique id and the current time
+func New(source, eventType, subject string, data map[string]interface{}) *Event {
+	e := &Event{
+		SpecVersion: SpecVersion,
+		ID:          newID(),
+		Source:      source,
+		Type:        eventType,
+		Time:        time.Now().UTC(),
+		Subject:     subject,
+		Data:        data,
+	}
+	if data != nil {
+		e.DataContentType = "application/json"
+	}
+	return e
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
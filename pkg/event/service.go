@@ -0,0 +1,78 @@
+package event
+
+import (
+	"fmt"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// resourceEvents maps handler methods to the event emitted when they succeed
+var resourceEvents = map[string]string{
+	`create`: ResourceCreated,
+	`update`: ResourceUpdated,
+	`upsert`: ResourceUpdated,
+	`delete`: ResourceDeleted,
+}
+
+type service struct {
+	serviceapi.Service
+	emitter *Emitter
+}
+
+// WrapService returns a function that wraps a service so that step and resource lifecycle events
+// are emitted for its invocations
+func WrapService(e *Emitter) func(serviceapi.Service) serviceapi.Service {
+	return func(s serviceapi.Service) serviceapi.Service {
+		return &service{Service: s, emitter: e}
+	}
+}
+
+func (s *service) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	if name == `do` {
+		return s.invokeStep(c, identifier, name, arguments)
+	}
+	if et, ok := resourceEvents[name]; ok {
+		return s.invokeResource(c, et, identifier, name, arguments)
+	}
+	return s.Service.Invoke(c, identifier, name, arguments...)
+}
+
+func (s *service) State(c eval.Context, name string, input eval.OrderedMap) eval.PuppetObject {
+	s.emitter.Emit(StepStarted, name, map[string]interface{}{`style`: `resource`})
+	return s.Service.State(c, name, input)
+}
+
+func (s *service) invokeStep(c eval.Context, identifier, name string, arguments []eval.Value) eval.Value {
+	s.emitter.Emit(StepStarted, identifier, map[string]interface{}{`style`: `action`})
+	defer s.emitOnPanic(StepFailed, identifier, map[string]interface{}{`style`: `action`})
+	result := s.Service.Invoke(c, identifier, name, arguments...)
+	s.emitter.Emit(StepFinished, identifier, map[string]interface{}{`style`: `action`})
+	return result
+}
+
+func (s *service) invokeResource(c eval.Context, eventType, identifier, name string, arguments []eval.Value) eval.Value {
+	data := map[string]interface{}{`handler`: identifier, `operation`: name}
+	if name != `create` && name != `upsert` && len(arguments) > 0 {
+		data[`externalId`] = arguments[0].String()
+	}
+	defer s.emitOnPanic(ResourceFailed, identifier, data)
+	result := s.Service.Invoke(c, identifier, name, arguments...)
+	if l, ok := result.(eval.List); ok && name == `create` && l.Len() > 1 {
+		data[`externalId`] = l.At(1).String()
+	}
+	s.emitter.Emit(eventType, identifier, data)
+	return result
+}
+
+func (s *service) emitOnPanic(eventType, subject string, data map[string]interface{}) {
+	if e := recover(); e != nil {
+		failed := make(map[string]interface{}, len(data)+1)
+		for k, v := range data {
+			failed[k] = v
+		}
+		failed[`error`] = fmt.Sprint(e)
+		s.emitter.Emit(eventType, subject, failed)
+		panic(e)
+	}
+}
@@ -0,0 +1,192 @@
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SinkEnvVar can be used to configure the event sink when none is given on the command line
+	SinkEnvVar = "LYRA_EVENT_SINK"
+
+	contentType        = "application/cloudevents+json"
+	defaultNatsSubject = "lyra.events"
+	sendTimeout        = 10 * time.Second
+)
+
+// Sink is the destination of emitted events
+type Sink interface {
+	// Send delivers the event to the sink
+	Send(e *Event) error
+
+	// Close releases any resources held by the sink
+	Close() error
+}
+
+// NewSink creates a sink for the given URI. The scheme of the URI determines the kind of sink:
+//
+//   http://host/path and https://host/path  POST each event to the URL
+//   nats://host:port/subject                publish each event on the NATS subject (default 'lyra.events')
+func NewSink(uri string) (Sink, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &httpSink{url: u.String(), client: &http.Client{Timeout: sendTimeout}}, nil
+	case "nats":
+		subject := strings.Trim(u.Path, "/")
+		if subject == "" {
+			subject = defaultNatsSubject
+		}
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "4222")
+		}
+		return &natsSink{address: host, subject: subject}, nil
+	default:
+		return nil, fmt.Errorf("unsupported event sink '%s'", uri)
+	}
+}
+
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Send(e *Event) error {
+	bts, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, contentType, bytes.NewReader(bts))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("event sink '%s' responded with %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
+
+// natsSink publishes events using the NATS client protocol
+type natsSink struct {
+	lock    sync.Mutex
+	address string
+	subject string
+	conn    net.Conn
+}
+
+func (s *natsSink) Send(e *Event) error {
+	bts, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err = s.publish(bts); err != nil {
+		// Retry once on a fresh connection in case the server dropped the old one
+		s.disconnect()
+		err = s.publish(bts)
+	}
+	return err
+}
+
+func (s *natsSink) publish(payload []byte) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(sendTimeout))
+	_, err := fmt.Fprintf(s.conn, "PUB %s %d\r\n%s\r\n", s.subject, len(payload), payload)
+	return err
+}
+
+func (s *natsSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.address, sendTimeout)
+	if err != nil {
+		return err
+	}
+	// The server greets with an INFO line that must be consumed before sending CONNECT
+	conn.SetReadDeadline(time.Now().Add(sendTimeout))
+	info, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting from NATS server at %s: %s", s.address, strings.TrimSpace(info))
+	}
+	if _, err = fmt.Fprint(conn, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"lyra\"}\r\n"); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *natsSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *natsSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.disconnect()
+	return nil
+}
+
+type multiSink []Sink
+
+// NewMultiSink returns a sink that sends every event to all of the given sinks
+func NewMultiSink(sinks ...Sink) Sink {
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+	return multiSink(sinks)
+}
+
+func (m multiSink) Send(e *Event) error {
+	var errs []string
+	for _, s := range m {
+		if err := s.Send(e); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (m multiSink) Close() error {
+	var errs []string
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
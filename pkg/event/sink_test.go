@@ -0,0 +1,124 @@
+package event
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var newSinkTests = []struct {
+	title string
+	uri   string
+	error bool
+}{
+	{title: "http", uri: "http://localhost:8080/events"},
+	{title: "https", uri: "https://events.example.com"},
+	{title: "nats", uri: "nats://localhost/lyra.events"},
+	{title: "unsupported scheme", uri: "kafka://localhost:9092/lyra", error: true},
+}
+
+func Test_NewSink(t *testing.T) {
+	for _, test := range newSinkTests {
+		t.Run(test.title, func(t *testing.T) {
+			_, err := NewSink(test.uri)
+			if test.error {
+				assert.Error(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func Test_HTTPSinkThroughEmitter(t *testing.T) {
+	var lock sync.Mutex
+	var received []*Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, contentType, r.Header.Get("Content-Type"))
+		bts, _ := ioutil.ReadAll(r.Body)
+		e := &Event{}
+		assert.Nil(t, json.Unmarshal(bts, e))
+		lock.Lock()
+		received = append(received, e)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(server.URL)
+	require.Nil(t, err)
+	emitter := NewEmitter("/lyra/test", sink, hclog.NewNullLogger())
+	emitter.Emit(WorkflowStarted, "sample", map[string]interface{}{"operation": "upsert"})
+	emitter.Emit(WorkflowFinished, "sample", nil)
+	emitter.Close()
+
+	require.Equal(t, 2, len(received))
+	assert.Equal(t, SpecVersion, received[0].SpecVersion)
+	assert.Equal(t, "/lyra/test", received[0].Source)
+	assert.Equal(t, WorkflowStarted, received[0].Type)
+	assert.Equal(t, "sample", received[0].Subject)
+	assert.Equal(t, "upsert", received[0].Data["operation"])
+	assert.Equal(t, WorkflowFinished, received[1].Type)
+	assert.NotEqual(t, received[0].ID, received[1].ID)
+}
+
+func Test_HTTPSinkRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(server.URL)
+	require.Nil(t, err)
+	assert.Error(t, sink.Send(New("/lyra/test", WorkflowStarted, "sample", nil)))
+}
+
+func Test_NatsSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+
+	lines := make(chan string, 3)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		r := bufio.NewReader(conn)
+		for i := 0; i < 3; i++ {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- strings.TrimSpace(line)
+		}
+	}()
+
+	sink, err := NewSink("nats://" + listener.Addr().String() + "/lyra.test")
+	require.Nil(t, err)
+	defer sink.Close()
+	require.Nil(t, sink.Send(New("/lyra/test", WorkflowStarted, "sample", nil)))
+
+	assert.True(t, strings.HasPrefix(<-lines, "CONNECT "))
+	assert.True(t, strings.HasPrefix(<-lines, "PUB lyra.test "))
+	e := &Event{}
+	assert.Nil(t, json.Unmarshal([]byte(<-lines), e))
+	assert.Equal(t, WorkflowStarted, e.Type)
+}
+
+func Test_NilEmitter(t *testing.T) {
+	var emitter *Emitter
+	emitter.Emit(WorkflowStarted, "sample", nil)
+	emitter.Close()
+}
@@ -0,0 +1,282 @@
+// Package example generates sample workflows for the resource types that plugins provide, so that users
+// who are new to a provider get a runnable starting point without reading its type set.
+package example
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	hclog "github.com/hashicorp/go-hclog"
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/lyraproj/lyra/pkg/loader"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/annotation"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Name returns the default name of the sample workflow of the named type, e.g. aws_vpc for Aws::Vpc
+func Name(typeName string) string {
+	return strings.ToLower(strings.Replace(typeName, `::`, `_`, -1))
+}
+
+// Generate writes a sample workflow with the given name for the named type to w. The type and the types
+// that it can be wired to are loaded from the plugins on the plugin path.
+func Generate(typeName, name string, w io.Writer, logger hclog.Logger) (err error) {
+	defer plugin.CleanupClients()
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("unable to load types: %v", e)
+		}
+	}()
+
+	eval.Puppet.Do(func(c eval.Context) {
+		l := loader.New(logger, c.Loader())
+		l.PreLoadPlugins(c)
+		var typ eval.ObjectType
+		var related []eval.ObjectType
+		for _, ts := range typeSets(c, l) {
+			var found eval.ObjectType
+			var others []eval.ObjectType
+			ts.Types().EachValue(func(v eval.Value) {
+				if t, ok := v.(eval.ObjectType); ok {
+					if t.Name() == typeName {
+						found = t
+					} else {
+						others = append(others, t)
+					}
+				}
+			})
+			if found != nil {
+				typ, related = found, others
+				break
+			}
+		}
+		if typ == nil {
+			err = fmt.Errorf("no type named %s is provided by the plugins", typeName)
+			return
+		}
+		_, err = io.WriteString(w, Workflow(c, name, typ, related))
+	})
+	return err
+}
+
+// typeSets returns the type sets of the services known to the given loader
+func typeSets(c eval.Context, l *loader.Loader) []eval.TypeSet {
+	var sets []eval.TypeSet
+	for _, sn := range l.Discover(c, func(tn eval.TypedName) bool { return tn.Namespace() == eval.NsService }) {
+		if s, ok := l.LoadEntry(c, sn).Value().(serviceapi.Service); ok {
+			if ts, _ := s.Metadata(c); ts != nil {
+				sets = append(sets, ts)
+			}
+		}
+	}
+	return sets
+}
+
+// Workflow returns a YAML workflow with the given name that manages a resource of the given type. Its
+// required attributes are given placeholder values that must be replaced, and its optional attributes
+// are listed in comments. The attributes that the provider provides are declared as output and passed
+// to a follow-up step, a resource of one of the related types that refers to them, when there is one.
+// Otherwise they are the output of the workflow.
+func Workflow(c eval.Context, name string, t eval.ObjectType, related []eval.ObjectType) string {
+	step := stepName(t)
+	provided := providedAttributes(c, t)
+	followUp, wiring := findFollowUp(c, t, provided, related)
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "# A sample workflow that manages a resource of type %s, generated by lyra example.\n", t.Name())
+	fmt.Fprintf(b, "# Replace the placeholders, save it as plugins/%s.yaml and run it with: lyra apply %s\n", name, name)
+	fmt.Fprintf(b, "%s:\n", name)
+	if len(provided) > 0 && followUp == nil {
+		b.WriteString("  output:\n")
+		for _, p := range provided {
+			fmt.Fprintf(b, "  - %s\n", p)
+		}
+	}
+	b.WriteString("  activities:\n")
+	writeStep(b, step, t, provided, true, nil)
+	if followUp != nil {
+		b.WriteString("\n")
+		fmt.Fprintf(b, "    # A follow-up step that uses the output of %s\n", step)
+		followUpStep := stepName(followUp)
+		if followUpStep == step {
+			followUpStep += `2`
+		}
+		writeStep(b, followUpStep, followUp, providedAttributes(c, followUp), false, wiring)
+	}
+	return b.String()
+}
+
+// stepName returns the name of a step that manages a resource of the given type, e.g. vpc for Aws::Vpc
+func stepName(t eval.ObjectType) string {
+	segments := strings.Split(t.Name(), `::`)
+	return strings.ToLower(segments[len(segments)-1])
+}
+
+// providedAttributes returns the names of the attributes of the given type that its provider provides
+func providedAttributes(c eval.Context, t eval.ObjectType) []string {
+	if a, ok := t.Annotations(c).Get(annotation.ResourceType); ok {
+		if r, ok := a.(annotation.Resource); ok {
+			return r.ProvidedAttributes()
+		}
+	}
+	return nil
+}
+
+// findFollowUp returns the related type of a resource that refers to the provided attributes of the given
+// type, and the attribute of that resource that each of its attributes are wired to. Relationships that
+// the given type declares to contained and consuming resources are preferred over attributes that merely
+// share the name of a provided attribute.
+func findFollowUp(c eval.Context, t eval.ObjectType, provided []string, related []eval.ObjectType) (eval.ObjectType, map[string]string) {
+	isProvided := make(map[string]bool, len(provided))
+	for _, p := range provided {
+		isProvided[p] = true
+	}
+	if a, ok := t.Annotations(c).Get(annotation.ResourceType); ok {
+		if r, ok := a.(annotation.Resource); ok {
+			rels := r.Relationships()
+			names := make([]string, 0, len(rels))
+			for n := range rels {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				rel := rels[n]
+				ot, ok := rel.Type.(eval.ObjectType)
+				if !ok || rel.Kind != annotation.KindContained && rel.Kind != annotation.KindConsumer {
+					continue
+				}
+				wiring := map[string]string{}
+				for i := 0; i+1 < len(rel.Keys); i += 2 {
+					if isProvided[rel.Keys[i]] {
+						wiring[rel.Keys[i+1]] = rel.Keys[i]
+					}
+				}
+				if len(wiring) > 0 {
+					return ot, wiring
+				}
+			}
+		}
+	}
+
+	sort.Slice(related, func(i, j int) bool { return related[i].Name() < related[j].Name() })
+	for _, ot := range related {
+		if _, ok := ot.Annotations(c).Get(annotation.ResourceType); !ok {
+			continue
+		}
+		ownProvided := map[string]bool{}
+		for _, p := range providedAttributes(c, ot) {
+			ownProvided[p] = true
+		}
+		wiring := map[string]string{}
+		for _, p := range provided {
+			if a, ok := ot.Member(p); ok {
+				if _, ok := a.(eval.Attribute); ok && !ownProvided[p] {
+					wiring[p] = p
+				}
+			}
+		}
+		if len(wiring) > 0 {
+			return ot, wiring
+		}
+	}
+	return nil, nil
+}
+
+// writeStep writes a step that manages a resource of the given type. The provided attributes are left out
+// of its state, and declared as its output when output is true. The wired attributes refer to the output
+// of another step.
+func writeStep(b *bytes.Buffer, step string, t eval.ObjectType, provided []string, output bool, wiring map[string]string) {
+	isProvided := make(map[string]bool, len(provided))
+	for _, p := range provided {
+		isProvided[p] = true
+	}
+	fmt.Fprintf(b, "    %s:\n", step)
+	fmt.Fprintf(b, "      type: %s\n", t.Name())
+	if output && len(provided) > 0 {
+		b.WriteString("      output:\n")
+		for _, p := range provided {
+			fmt.Fprintf(b, "      - %s\n", p)
+		}
+	}
+	var state []string
+	given := 0
+	for _, a := range t.AttributesInfo().Attributes() {
+		n := a.Name()
+		if a.Kind() == types.CONSTANT || a.Kind() == types.DERIVED {
+			continue
+		}
+		switch {
+		case wiring[n] != ``:
+			state = append(state, fmt.Sprintf("        %s: $%s\n", n, wiring[n]))
+			given++
+		case isProvided[n]:
+			// Set by the provider
+		case required(a):
+			state = append(state, fmt.Sprintf("        %s: %s # %s\n", n, placeholder(n, a.Type()), a.Type()))
+			given++
+		default:
+			state = append(state, fmt.Sprintf("        # %s: %s\n", n, a.Type()))
+		}
+	}
+	if given == 0 {
+		// A state that is all comments would be null
+		b.WriteString("      state: {}\n")
+	} else {
+		b.WriteString("      state:\n")
+	}
+	for _, l := range state {
+		b.WriteString(l)
+	}
+}
+
+// required returns true if the attribute has neither a default value nor a type that accepts undef
+func required(a eval.Attribute) bool {
+	return !a.HasValue() && !a.Type().IsInstance(eval.UNDEF, nil)
+}
+
+// placeholder returns a YAML value of the given type that stands in for the value of the named attribute
+func placeholder(name string, t eval.Type) string {
+	switch t := t.(type) {
+	case *types.NotUndefType:
+		return placeholder(name, t.ContainedType())
+	case *types.TypeAliasType:
+		return placeholder(name, t.ResolvedType())
+	case *types.VariantType:
+		if ts := t.Types(); len(ts) > 0 {
+			return placeholder(name, ts[0])
+		}
+	case *types.EnumType:
+		if vs := t.Parameters(); len(vs) > 0 {
+			return scalar(vs[0].String())
+		}
+	case *types.BooleanType:
+		return `false`
+	case *types.IntegerType:
+		if min := t.Min(); min > 0 {
+			return fmt.Sprint(min)
+		}
+		return `0`
+	case *types.FloatType:
+		if min := t.Min(); min > 0 {
+			return fmt.Sprint(min)
+		}
+		return `0.0`
+	case *types.ArrayType, *types.TupleType:
+		return `[]`
+	case *types.HashType, *types.StructType, eval.ObjectType:
+		return `{}`
+	}
+	return scalar(`<` + name + `>`)
+}
+
+// scalar returns the given string as a YAML scalar, quoted when needed
+func scalar(s string) string {
+	bs, _ := yaml.Marshal(s)
+	return strings.TrimSuffix(string(bs), "\n")
+}
@@ -0,0 +1,17 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package fips
+
+import (
+	"crypto/boring"
+
+	// Restricts TLS to FIPS approved versions, cipher suites and curves
+	_ "crypto/tls/fipsonly"
+)
+
+const module = `BoringCrypto`
+
+func enabled() bool {
+	return boring.Enabled()
+}
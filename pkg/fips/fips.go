@@ -0,0 +1,44 @@
+// Package fips reports whether lyra uses a FIPS 140 validated cryptographic module. Such a build is
+// made with make FIPS=1, which uses the Go Cryptographic Module of Go 1.24 and later, or with
+// make FIPS=boringcrypto, which links BoringCrypto. All cryptography used by lyra, i.e. TLS between
+// lyra and its plugins, signing of manifests and audit logs, and decryption of sops files, is then
+// performed by that module.
+package fips
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// EnvVar is the environment variable that, when true, makes lyra and its plugins refuse to start
+// unless they use a FIPS 140 validated cryptographic module
+const EnvVar = "LYRA_FIPS"
+
+// Enabled returns true when the process uses a FIPS 140 validated cryptographic module
+func Enabled() bool {
+	return enabled()
+}
+
+// Module returns the name of the FIPS 140 validated cryptographic module in use or an empty string
+func Module() string {
+	if !enabled() {
+		return ``
+	}
+	return module
+}
+
+// Required returns true when the LYRA_FIPS environment variable is set to true
+func Required() bool {
+	b, err := strconv.ParseBool(os.Getenv(EnvVar))
+	return err == nil && b
+}
+
+// Check returns an error when FIPS mode is required but the process doesn't use a FIPS 140
+// validated cryptographic module
+func Check() error {
+	if Required() && !Enabled() {
+		return fmt.Errorf("%s is set but %s was not built for FIPS mode, rebuild it using make FIPS=1", EnvVar, os.Args[0])
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build go1.24 && !boringcrypto
+// +build go1.24,!boringcrypto
+
+package fips
+
+import "crypto/fips140"
+
+const module = `Go Cryptographic Module`
+
+// enabled is true when the binary was built with GOFIPS140 or when FIPS mode was enabled using
+// GODEBUG=fips140=on
+func enabled() bool {
+	return fips140.Enabled()
+}
@@ -0,0 +1,30 @@
+package fips
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Check(t *testing.T) {
+	defer os.Unsetenv(EnvVar)
+
+	os.Unsetenv(EnvVar)
+	assert.False(t, Required())
+	assert.NoError(t, Check())
+
+	os.Setenv(EnvVar, `false`)
+	assert.False(t, Required())
+	assert.NoError(t, Check())
+
+	os.Setenv(EnvVar, `true`)
+	assert.True(t, Required())
+	if Enabled() {
+		assert.NoError(t, Check())
+		assert.NotEmpty(t, Module())
+	} else {
+		assert.Error(t, Check())
+		assert.Empty(t, Module())
+	}
+}
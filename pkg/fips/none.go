@@ -0,0 +1,11 @@
+//go:build !go1.24 && !boringcrypto
+// +build !go1.24,!boringcrypto
+
+package fips
+
+const module = ``
+
+// enabled is always false since this Go version has no FIPS mode
+func enabled() bool {
+	return false
+}
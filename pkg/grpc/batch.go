@@ -0,0 +1,217 @@
+package grpc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lyraproj/data-protobuf/datapb"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	sdkgrpc "github.com/lyraproj/servicesdk/grpc"
+	"github.com/lyraproj/servicesdk/servicepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BatchIdentifier is the identifier used in an invocation that carries a batch of invocations. The
+// arguments of such an invocation are tuples of identifier, method name and arguments. The result is
+// an array with the result of each invocation, or the error that it raised, in the same order.
+const BatchIdentifier = `Lyra::Batch`
+
+// batchedMethods are the methods of resource handlers. Invocations of other methods are never batched.
+var batchedMethods = map[string]bool{`read`: true, `create`: true, `update`: true, `upsert`: true, `delete`: true}
+
+// nestedMethod is the method through which a service that provides other services, such as the Puppet
+// DSL service that provides a service for each manifest, is asked to invoke a method of one of them. Its
+// arguments are the identifier and the method name of the nested invocation followed by its arguments.
+const nestedMethod = `invoke`
+
+// batched returns true if the given invocation is one of a resource handler, either made directly or
+// nested in an invocation of the nestedMethod
+func batched(name string, arguments []eval.Value) bool {
+	if name == nestedMethod && len(arguments) >= 2 {
+		if s, ok := arguments[1].(eval.StringValue); ok {
+			name = s.String()
+		}
+	}
+	return batchedMethods[name]
+}
+
+// Invocation is an invocation of a method of an API provided by a service
+type Invocation struct {
+	Identifier string
+	Name       string
+	Arguments  []eval.Value
+}
+
+// InvokeBatch sends the given invocations to the plugin in one call. The result of each invocation is
+// returned in the same order as the invocations. The result of a failed invocation is an eval.ErrorObject.
+func (c *Client) InvokeBatch(ctx eval.Context, invocations []Invocation) ([]eval.Value, error) {
+	tuples := make([]eval.Value, len(invocations))
+	for i, iv := range invocations {
+		tuples[i] = types.WrapValues([]eval.Value{types.WrapString(iv.Identifier), types.WrapString(iv.Name), types.WrapValues(iv.Arguments)})
+	}
+	rq := servicepb.InvokeRequest{
+		Identifier: BatchIdentifier,
+		Arguments:  sdkgrpc.ToDataPB(types.WrapValues(tuples)),
+	}
+	rr, err := c.invoke(withCredentials(withToken(ctx, c.token), ctx), &rq)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := sdkgrpc.FromDataPB(ctx, rr).(*types.ArrayValue)
+	if !ok || result.Len() != len(invocations) {
+		return nil, status.Error(codes.Unimplemented, `invalid batch result`)
+	}
+	return result.AppendTo(make([]eval.Value, 0, len(invocations))), nil
+}
+
+// invocationResult returns the given result of an invocation or panics if it is an error
+func invocationResult(identifier, name string, result eval.Value) eval.Value {
+	if eo, ok := result.(eval.ErrorObject); ok {
+		panic(eval.Error(sdkgrpc.WF_INVOCATION_ERROR, issue.H{`identifier`: identifier, `name`: name, `code`: eo.IssueCode(), `message`: eo.Message()}))
+	}
+	return result
+}
+
+// invokeBatch serves an invocation of the BatchIdentifier. The invocations of the batch are served
+// concurrently.
+func (a *Server) invokeBatch(setup func(c eval.Context), r *servicepb.InvokeRequest) (result *datapb.Data, err error) {
+	var tuples []eval.Value
+	if err = a.do(func(c eval.Context) {
+		tuples = sdkgrpc.FromDataPB(c, r.Arguments).(*types.ArrayValue).AppendTo([]eval.Value{})
+	}); err != nil {
+		return nil, err
+	}
+
+	results := make([]eval.Value, len(tuples))
+	wg := sync.WaitGroup{}
+	for i, tuple := range tuples {
+		wg.Add(1)
+		go func(i int, tuple *types.ArrayValue) {
+			defer wg.Done()
+			a.do(func(c eval.Context) {
+				defer func() {
+					if x := recover(); x != nil {
+						if e, ok := x.(issue.Reported); ok {
+							results[i] = eval.ErrorFromReported(c, e)
+							return
+						}
+						panic(x)
+					}
+				}()
+				setup(c)
+				arguments := tuple.At(2).(*types.ArrayValue).AppendTo([]eval.Value{})
+				results[i] = a.impl.Invoke(c, tuple.At(0).String(), tuple.At(1).String(), arguments...)
+			})
+		}(i, tuple.(*types.ArrayValue))
+	}
+	wg.Wait()
+
+	err = a.do(func(c eval.Context) {
+		result = sdkgrpc.ToDataPB(types.WrapValues(results))
+	})
+	return
+}
+
+// Batcher is a service that sends the invocations of resource handlers that are made concurrently,
+// with the same credentials, to the plugin in batches. This reduces the number of calls when many
+// resources of the same kind are managed. Invocations are sent one by one to plugins that don't
+// support batches.
+type Batcher struct {
+	*Client
+	delay       time.Duration
+	size        int
+	lock        sync.Mutex
+	pending     map[interface{}]*batch
+	unsupported bool
+}
+
+type batch struct {
+	invocations []Invocation
+	full        chan struct{}
+	done        chan struct{}
+	results     []eval.Value
+	err         error
+}
+
+// NewBatcher returns a service that collects the invocations made during the given delay, or until
+// size invocations are collected, and sends them to the plugin of the given client in one call
+func NewBatcher(client *Client, delay time.Duration, size int) *Batcher {
+	return &Batcher{Client: client, delay: delay, size: size, pending: map[interface{}]*batch{}}
+}
+
+// Invoke invokes a method of an API provided by the service. The invocation is added to a batch when
+// it is an invocation of a resource handler.
+func (b *Batcher) Invoke(ctx eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	if !batched(name, arguments) {
+		return b.Client.Invoke(ctx, identifier, name, arguments...)
+	}
+
+	// Only invocations with the same credentials can share a call
+	key, _ := ctx.Get(credentialsKey)
+	b.lock.Lock()
+	if b.unsupported {
+		b.lock.Unlock()
+		return b.Client.Invoke(ctx, identifier, name, arguments...)
+	}
+	bt, ok := b.pending[key]
+	leader := !ok
+	if leader {
+		bt = &batch{full: make(chan struct{}), done: make(chan struct{})}
+		b.pending[key] = bt
+	}
+	index := len(bt.invocations)
+	bt.invocations = append(bt.invocations, Invocation{Identifier: identifier, Name: name, Arguments: arguments})
+	if len(bt.invocations) >= b.size {
+		delete(b.pending, key)
+		close(bt.full)
+	}
+	b.lock.Unlock()
+
+	if leader {
+		// The first invocation of a batch waits for more and then sends the batch
+		timer := time.NewTimer(b.delay)
+		select {
+		case <-timer.C:
+		case <-bt.full:
+			timer.Stop()
+		}
+		b.lock.Lock()
+		if b.pending[key] == bt {
+			delete(b.pending, key)
+		}
+		single := len(bt.invocations) == 1
+		b.lock.Unlock()
+		if single {
+			close(bt.done)
+			return b.Client.Invoke(ctx, identifier, name, arguments...)
+		}
+		b.send(ctx, bt)
+		close(bt.done)
+	} else {
+		<-bt.done
+	}
+
+	if bt.err != nil {
+		// The batch could not be sent so each invocation is sent on its own
+		return b.Client.Invoke(ctx, identifier, name, arguments...)
+	}
+	return invocationResult(identifier, name, bt.results[index])
+}
+
+// send sends the given batch and records its results
+func (b *Batcher) send(ctx eval.Context, bt *batch) {
+	bt.results, bt.err = b.Client.InvokeBatch(ctx, bt.invocations)
+	if bt.err != nil {
+		switch status.Code(bt.err) {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		default:
+			// The plugin doesn't know the BatchIdentifier
+			b.lock.Lock()
+			b.unsupported = true
+			b.lock.Unlock()
+		}
+	}
+}
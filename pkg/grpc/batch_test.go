@@ -0,0 +1,176 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lyraproj/data-protobuf/datapb"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/lyra/pkg/credhelper"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/servicesdk/servicepb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// directClient calls a server directly instead of using a connection
+type directClient struct {
+	servicepb.DefinitionServiceClient
+	server  *Server
+	noBatch bool
+	lock    sync.Mutex
+	calls   []string
+}
+
+func (d *directClient) Invoke(ctx context.Context, in *servicepb.InvokeRequest, opts ...grpc.CallOption) (*datapb.Data, error) {
+	d.lock.Lock()
+	d.calls = append(d.calls, in.Identifier)
+	d.lock.Unlock()
+	if d.noBatch && in.Identifier == BatchIdentifier {
+		// The response of a plugin that doesn't know about batches
+		return nil, status.Error(codes.Unknown, `no such API`)
+	}
+	return d.server.Invoke(incoming(ctx), in)
+}
+
+// echoService returns the identifier, the method name, and the first argument of each invocation,
+// followed by the credentials if any. Invocations of delete fail.
+type echoService struct {
+	serviceapi.Service
+}
+
+func (s *echoService) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	if name == `delete` {
+		panic(eval.Error(eval.EVAL_FAILURE, issue.H{`message`: `cannot delete`}))
+	}
+	result := identifier + ` ` + name + ` ` + arguments[0].String()
+	if creds, ok := c.Get(credentialsKey); ok {
+		result += ` ` + creds.(*credhelper.Credentials).Get(`KEY`)
+	}
+	return types.WrapString(result)
+}
+
+func newDirectClient(c eval.Context) (*Client, *directClient) {
+	dc := &directClient{server: &Server{ctx: c, impl: &echoService{}}}
+	return &Client{client: dc}, dc
+}
+
+func Test_InvokeBatch(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		client, dc := newDirectClient(c)
+		results, err := client.InvokeBatch(c, []Invocation{
+			{Identifier: `Test::Handler`, Name: `read`, Arguments: []eval.Value{types.WrapString(`a`)}},
+			{Identifier: `Test::Handler`, Name: `delete`, Arguments: []eval.Value{types.WrapString(`b`)}},
+			{Identifier: `Test::Handler`, Name: `create`, Arguments: []eval.Value{types.WrapString(`c`)}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{BatchIdentifier}, dc.calls)
+		require.Len(t, results, 3)
+		assert.Equal(t, `Test::Handler read a`, results[0].String())
+		require.Implements(t, (*eval.ErrorObject)(nil), results[1])
+		assert.Contains(t, results[1].(eval.ErrorObject).Message(), `cannot delete`)
+		assert.Equal(t, `Test::Handler create c`, results[2].String())
+
+		dc.noBatch = true
+		_, err = client.InvokeBatch(c, []Invocation{{Identifier: `Test::Handler`, Name: `read`, Arguments: []eval.Value{types.WrapString(`a`)}}})
+		assert.Error(t, err)
+	})
+}
+
+// invokeConcurrently invokes read on the given service from n goroutines and returns the results
+func invokeConcurrently(c eval.Context, s serviceapi.Service, contexts ...eval.Context) []string {
+	results := make([]string, len(contexts))
+	wg := sync.WaitGroup{}
+	for i, ic := range contexts {
+		wg.Add(1)
+		go func(i int, ic eval.Context) {
+			defer wg.Done()
+			results[i] = s.Invoke(ic, `Test::Handler`, `read`, types.WrapInteger(int64(i))).String()
+		}(i, ic)
+	}
+	wg.Wait()
+	return results
+}
+
+func Test_Batcher(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		client, dc := newDirectClient(c)
+		b := NewBatcher(client, time.Minute, 3)
+
+		// A full batch is sent without waiting for the delay
+		results := invokeConcurrently(c, b, c.Fork(), c.Fork(), c.Fork())
+		assert.Equal(t, []string{`Test::Handler read 0`, `Test::Handler read 1`, `Test::Handler read 2`}, results)
+		assert.Equal(t, []string{BatchIdentifier}, dc.calls)
+
+		// Invocations of other methods are sent on their own
+		dc.calls = nil
+		assert.Equal(t, `Test::Handler other x`, b.Invoke(c, `Test::Handler`, `other`, types.WrapString(`x`)).String())
+		assert.Equal(t, []string{`Test::Handler`}, dc.calls)
+
+		// Errors are raised by the invocation that failed
+		b = NewBatcher(client, 10*time.Millisecond, 100)
+		assert.Panics(t, func() { b.Invoke(c, `Test::Handler`, `delete`, types.WrapString(`x`)) })
+	})
+}
+
+func Test_BatcherNested(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		client, dc := newDirectClient(c)
+		b := NewBatcher(client, time.Minute, 2)
+
+		// Handler invocations of a service provided by another service are batched too
+		results := make([]string, 2)
+		wg := sync.WaitGroup{}
+		for i := range results {
+			wg.Add(1)
+			go func(i int, ic eval.Context) {
+				defer wg.Done()
+				results[i] = b.Invoke(ic, `Test::Manifest`, `invoke`, types.WrapString(`Test::Handler`), types.WrapString(`read`)).String()
+			}(i, c.Fork())
+		}
+		wg.Wait()
+		assert.Equal(t, []string{`Test::Manifest invoke Test::Handler`, `Test::Manifest invoke Test::Handler`}, results)
+		assert.Equal(t, []string{BatchIdentifier}, dc.calls)
+
+		dc.calls = nil
+		b.Invoke(c, `Test::Manifest`, `invoke`, types.WrapString(`Test::Handler`), types.WrapString(`other`))
+		assert.Equal(t, []string{`Test::Manifest`}, dc.calls)
+	})
+}
+
+func Test_BatcherCredentials(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		client, dc := newDirectClient(c)
+		b := NewBatcher(client, 100*time.Millisecond, 100)
+
+		// Invocations with different credentials are never sent together
+		creds := &credhelper.Credentials{Values: map[string]string{`KEY`: `secret`}}
+		results := invokeConcurrently(c, b, WithCredentials(c, creds), WithCredentials(c, creds), c.Fork(), c.Fork())
+		assert.Equal(t, []string{`Test::Handler read 0 secret`, `Test::Handler read 1 secret`, `Test::Handler read 2`, `Test::Handler read 3`}, results)
+		assert.Equal(t, []string{BatchIdentifier, BatchIdentifier}, dc.calls)
+	})
+}
+
+func Test_BatcherUnsupported(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		client, dc := newDirectClient(c)
+		dc.noBatch = true
+		b := NewBatcher(client, 100*time.Millisecond, 2)
+
+		results := invokeConcurrently(c, b, c.Fork(), c.Fork())
+		assert.Equal(t, []string{`Test::Handler read 0`, `Test::Handler read 1`}, results)
+		assert.Equal(t, []string{BatchIdentifier, `Test::Handler`, `Test::Handler`}, dc.calls)
+
+		// No more batches are tried once the plugin has refused one
+		dc.calls = nil
+		invokeConcurrently(c, b, c.Fork(), c.Fork())
+		assert.Equal(t, []string{`Test::Handler`, `Test::Handler`}, dc.calls)
+	})
+}
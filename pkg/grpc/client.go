@@ -0,0 +1,106 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-plugin"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	sdkgrpc "github.com/lyraproj/servicesdk/grpc"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/servicesdk/servicepb"
+	"google.golang.org/grpc"
+)
+
+// PluginClient is the go-plugin client side of a plugin that provides a service. It sends the
+// token of the plugin launch with every call.
+type PluginClient struct {
+	plugin.NetRPCUnsupportedPlugin
+
+	// Token is the token that was passed to the plugin when it was launched. No token is sent when it is empty.
+	Token string
+
+	// MaxMessageSize is the maximum size of a message sent to or received from the plugin. The default
+	// of gRPC is used when it is zero.
+	MaxMessageSize int
+}
+
+// GRPCServer is not implemented by the client
+func (a *PluginClient) GRPCServer(*plugin.GRPCBroker, *grpc.Server) error {
+	return fmt.Errorf(`%T has no server implementation for rpc`, a)
+}
+
+// GRPCClient returns a serviceapi.Service that calls the plugin using the given connection
+func (a *PluginClient) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, clientConn *grpc.ClientConn) (interface{}, error) {
+	return &Client{client: servicepb.NewDefinitionServiceClient(clientConn), conn: clientConn, token: a.Token, maxMessageSize: a.MaxMessageSize}, nil
+}
+
+// Client is a serviceapi.Service that is provided by a plugin. Invocations, states, and metadata are
+// streamed in chunks when the plugin supports it so that they are not limited by the maximum message size.
+type Client struct {
+	client         servicepb.DefinitionServiceClient
+	conn           *grpc.ClientConn
+	token          string
+	maxMessageSize int
+	lock           sync.Mutex
+	noStreams      bool
+}
+
+// Identifier returns the identifier of the service
+func (c *Client) Identifier(ctx eval.Context) eval.TypedName {
+	rr, err := c.client.Identity(withToken(ctx, c.token), &servicepb.EmptyRequest{}, c.callOptions()...)
+	if err != nil {
+		panic(err)
+	}
+	return sdkgrpc.FromDataPB(ctx, rr).(eval.TypedName)
+}
+
+// Invoke invokes a method of an API provided by the service
+func (c *Client) Invoke(ctx eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	rq := servicepb.InvokeRequest{
+		Identifier: identifier,
+		Method:     name,
+		Arguments:  sdkgrpc.ToDataPB(types.WrapValues(arguments)),
+	}
+	rr, err := c.invoke(withCredentials(withToken(ctx, c.token), ctx), &rq)
+	if err != nil {
+		panic(err)
+	}
+	return invocationResult(identifier, name, sdkgrpc.FromDataPB(ctx, rr))
+}
+
+// Metadata returns the type set and the definitions of the service
+func (c *Client) Metadata(ctx eval.Context) (typeSet eval.TypeSet, definitions []serviceapi.Definition) {
+	rr, err := c.metadata(withToken(ctx, c.token))
+	if err != nil {
+		panic(err)
+	}
+	return metadataFromPB(ctx, rr)
+}
+
+// EncodedMetadata returns the type set and the definitions of the service in the encoding used by the
+// service protocol. The result can be decoded using DecodeMetadata.
+func (c *Client) EncodedMetadata(ctx eval.Context) []byte {
+	rr, err := c.metadata(withToken(ctx, c.token))
+	if err != nil {
+		panic(err)
+	}
+	bs, err := proto.Marshal(rr)
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+// State returns the state with the given identifier for the given input
+func (c *Client) State(ctx eval.Context, identifier string, input eval.OrderedMap) eval.PuppetObject {
+	rq := servicepb.StateRequest{Identifier: identifier, Input: sdkgrpc.ToDataPB(input)}
+	rr, err := c.state(withToken(ctx, c.token), &rq)
+	if err != nil {
+		panic(err)
+	}
+	return sdkgrpc.FromDataPB(ctx, rr).(eval.PuppetObject)
+}
@@ -0,0 +1,72 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/lyraproj/lyra/pkg/credhelper"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/threadlocal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// CredentialsMetadataKey is the gRPC metadata key used to pass credentials with an invocation.
+	// The -bin suffix makes gRPC encode the value.
+	CredentialsMetadataKey = "lyra-credentials-bin"
+
+	// credentialsKey is the key of the context variable that holds the credentials for an invocation
+	credentialsKey = "lyra::credentials"
+)
+
+// WithCredentials returns a fork of the given context. Services invoked using the fork pass the given
+// credentials to the plugin, which will use them for that invocation only.
+func WithCredentials(c eval.Context, creds *credhelper.Credentials) eval.Context {
+	fc := c.Fork()
+	fc.Set(credentialsKey, creds)
+	return fc
+}
+
+// InvocationCredentials returns the credentials that were passed with the invocation that is being
+// served by the current goroutine
+func InvocationCredentials() (*credhelper.Credentials, bool) {
+	if c, ok := threadlocal.Get(eval.PuppetContextKey); ok {
+		if v, ok := c.(eval.Context).Get(credentialsKey); ok {
+			return v.(*credhelper.Credentials), true
+		}
+	}
+	return nil, false
+}
+
+// withCredentials adds the credentials held by the given context, if any, to the outgoing metadata
+func withCredentials(ctx context.Context, c eval.Context) context.Context {
+	v, ok := c.Get(credentialsKey)
+	if !ok {
+		return ctx
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return metadata.AppendToOutgoingContext(ctx, CredentialsMetadataKey, string(bs))
+}
+
+// credentialsFrom returns the credentials in the incoming metadata of the given context or nil
+func credentialsFrom(ctx context.Context) (*credhelper.Credentials, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	vs := md.Get(CredentialsMetadataKey)
+	if len(vs) == 0 {
+		return nil, nil
+	}
+	creds := &credhelper.Credentials{}
+	if err := json.Unmarshal([]byte(vs[0]), creds); err != nil {
+		// The value is not included since it may contain secrets
+		return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+	}
+	return creds, nil
+}
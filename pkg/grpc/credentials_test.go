@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lyraproj/lyra/pkg/credhelper"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/threadlocal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func Test_Credentials(t *testing.T) {
+	expires := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	creds := &credhelper.Credentials{Values: map[string]string{`AWS_ACCESS_KEY_ID`: `AKID`}, Expires: &expires}
+
+	eval.Puppet.Do(func(c eval.Context) {
+		// No credentials unless given
+		received, err := credentialsFrom(incoming(withCredentials(context.Background(), c)))
+		require.NoError(t, err)
+		assert.Nil(t, received)
+
+		fc := WithCredentials(c, creds)
+		_, ok := c.Get(credentialsKey)
+		assert.False(t, ok, `credentials must not leak into the original context`)
+
+		received, err = credentialsFrom(incoming(withCredentials(context.Background(), fc)))
+		require.NoError(t, err)
+		assert.Equal(t, `AKID`, received.Get(`AWS_ACCESS_KEY_ID`))
+		assert.True(t, expires.Equal(*received.Expires))
+
+		threadlocal.Init()
+		threadlocal.Set(eval.PuppetContextKey, fc)
+		current, ok := InvocationCredentials()
+		assert.True(t, ok)
+		assert.Equal(t, creds, current)
+
+		threadlocal.Set(eval.PuppetContextKey, c)
+		_, ok = InvocationCredentials()
+		assert.False(t, ok)
+	})
+}
+
+func Test_InvalidCredentials(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(CredentialsMetadataKey, `{`))
+	_, err := credentialsFrom(ctx)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
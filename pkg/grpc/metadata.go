@@ -0,0 +1,49 @@
+package grpc
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	sdkgrpc "github.com/lyraproj/servicesdk/grpc"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/servicesdk/servicepb"
+)
+
+// DecodeMetadata decodes a type set and definitions that were obtained using Client.EncodedMetadata.
+// The decoding panics if the metadata refers to types that cannot be resolved.
+func DecodeMetadata(c eval.Context, bs []byte) (eval.TypeSet, []serviceapi.Definition, error) {
+	rr := &servicepb.MetadataResponse{}
+	if err := proto.Unmarshal(bs, rr); err != nil {
+		return nil, nil, err
+	}
+	typeSet, definitions := metadataFromPB(c, rr)
+	return typeSet, definitions, nil
+}
+
+// EncodeMetadata encodes a type set and definitions in the encoding used by the service protocol. The
+// result can be decoded using DecodeMetadata.
+func EncodeMetadata(typeSet eval.TypeSet, definitions []serviceapi.Definition) ([]byte, error) {
+	return proto.Marshal(metadataToPB(typeSet, definitions))
+}
+
+func metadataToPB(typeSet eval.TypeSet, definitions []serviceapi.Definition) *servicepb.MetadataResponse {
+	vs := make([]eval.Value, len(definitions))
+	for i, d := range definitions {
+		vs[i] = d
+	}
+	rr := &servicepb.MetadataResponse{Definitions: sdkgrpc.ToDataPB(types.WrapValues(vs))}
+	if typeSet != nil {
+		rr.Typeset = sdkgrpc.ToDataPB(typeSet)
+	}
+	return rr
+}
+
+func metadataFromPB(c eval.Context, rr *servicepb.MetadataResponse) (typeSet eval.TypeSet, definitions []serviceapi.Definition) {
+	if ts := rr.GetTypeset(); ts != nil {
+		typeSet = sdkgrpc.FromDataPB(c, ts).(eval.TypeSet)
+	}
+	ds := sdkgrpc.FromDataPB(c, rr.GetDefinitions()).(eval.List)
+	definitions = make([]serviceapi.Definition, ds.Len())
+	ds.EachWithIndex(func(d eval.Value, i int) { definitions[i] = d.(serviceapi.Definition) })
+	return
+}
@@ -0,0 +1,163 @@
+// Package grpc serves and consumes services provided by plugins. It is a replacement for the grpc
+// package of the servicesdk that authenticates the caller of a plugin using a token that is created
+// for each plugin launch.
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/rpc"
+	"os"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/lyraproj/data-protobuf/datapb"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/lyra/pkg/fips"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/threadlocal"
+	"github.com/lyraproj/puppet-evaluator/types"
+	sdkgrpc "github.com/lyraproj/servicesdk/grpc"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/servicesdk/servicepb"
+	"google.golang.org/grpc"
+)
+
+// Handshake is the go-plugin handshake shared by lyra and its plugins. It is the same as the one used
+// by the servicesdk.
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "PLUGIN_MAGIC_COOKIE",
+	MagicCookieValue: "7468697320697320616e20616d617a696e67206d6167696320636f6f6b69652c206e6f6d206e6f6d206e6f6d",
+}
+
+// Server is the go-plugin server side of a plugin that provides a service
+type Server struct {
+	ctx            eval.Context
+	impl           serviceapi.Service
+	maxMessageSize int
+}
+
+// Server is not implemented since only gRPC is supported
+func (a *Server) Server(*plugin.MuxBroker) (interface{}, error) {
+	return nil, fmt.Errorf(`%T has no server implementation for rpc`, a)
+}
+
+// Client is not implemented since only gRPC is supported
+func (a *Server) Client(*plugin.MuxBroker, *rpc.Client) (interface{}, error) {
+	return nil, fmt.Errorf(`%T has no RPC client implementation for rpc`, a)
+}
+
+// GRPCServer registers the definition service, and the service that streams its values, with the given server
+func (a *Server) GRPCServer(broker *plugin.GRPCBroker, impl *grpc.Server) error {
+	servicepb.RegisterDefinitionServiceServer(impl, a)
+	impl.RegisterService(&streamDesc, a)
+	return nil
+}
+
+// GRPCClient is not implemented by the server
+func (a *Server) GRPCClient(context.Context, *plugin.GRPCBroker, *grpc.ClientConn) (interface{}, error) {
+	return nil, fmt.Errorf(`%T has no client implementation for rpc`, a)
+}
+
+func (a *Server) do(doer func(c eval.Context)) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			if e, ok := x.(issue.Reported); ok {
+				err = e
+			} else {
+				panic(x)
+			}
+		}
+	}()
+	c := a.ctx.Fork()
+	threadlocal.Init()
+	threadlocal.Set(eval.PuppetContextKey, c)
+	doer(c)
+	return nil
+}
+
+// Identity returns the identifier of the service
+func (a *Server) Identity(context.Context, *servicepb.EmptyRequest) (result *datapb.Data, err error) {
+	err = a.do(func(c eval.Context) {
+		result = sdkgrpc.ToDataPB(a.impl.Identifier(c))
+	})
+	return
+}
+
+// Invoke invokes a method of an API provided by the service, or all the invocations of a batch when the
+// identifier is the BatchIdentifier. Credentials passed by the caller are made available to the method
+// using InvocationCredentials.
+func (a *Server) Invoke(ctx context.Context, r *servicepb.InvokeRequest) (result *datapb.Data, err error) {
+	creds, err := credentialsFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	setup := func(c eval.Context) {
+		if creds != nil {
+			c.Set(credentialsKey, creds)
+		}
+	}
+	if r.Identifier == BatchIdentifier {
+		return a.invokeBatch(setup, r)
+	}
+	err = a.do(func(c eval.Context) {
+		setup(c)
+		wrappedArgs := sdkgrpc.FromDataPB(c, r.Arguments)
+		arguments := wrappedArgs.(*types.ArrayValue).AppendTo([]eval.Value{})
+		result = sdkgrpc.ToDataPB(a.impl.Invoke(c, r.Identifier, r.Method, arguments...))
+	})
+	return
+}
+
+// Metadata returns the type set and the definitions of the service
+func (a *Server) Metadata(_ context.Context, r *servicepb.EmptyRequest) (result *servicepb.MetadataResponse, err error) {
+	err = a.do(func(c eval.Context) {
+		result = metadataToPB(a.impl.Metadata(c))
+	})
+	return
+}
+
+// State returns the state with the given identifier for the given input
+func (a *Server) State(_ context.Context, r *servicepb.StateRequest) (result *datapb.Data, err error) {
+	err = a.do(func(c eval.Context) {
+		result = sdkgrpc.ToDataPB(a.impl.State(c, r.Identifier, sdkgrpc.FromDataPB(c, r.Input).(eval.OrderedMap)))
+	})
+	return
+}
+
+// Serve serves the given service as a go-plugin. When the plugin was launched with a token in the
+// LYRA_PLUGIN_TOKEN environment variable, calls that don't carry that token are rejected. The
+// variable is removed from the environment so that it isn't inherited by processes that the plugin starts.
+// Messages are limited to the size given by the LYRA_PLUGIN_MAX_MESSAGE_SIZE environment variable.
+// The plugin exits when FIPS mode is required but it wasn't built for it.
+func Serve(c eval.Context, s serviceapi.Service) {
+	if err := fips.Check(); err != nil {
+		log.Fatal(err)
+	}
+	maxMessageSize, err := MaxMessageSizeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token := os.Getenv(TokenEnvVar)
+	os.Unsetenv(TokenEnvVar)
+	cfg := &plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins: map[string]plugin.Plugin{
+			"server": &Server{ctx: c, impl: s, maxMessageSize: maxMessageSize},
+		},
+		GRPCServer: func(opts []grpc.ServerOption) *grpc.Server {
+			opts = append(opts, grpc.MaxRecvMsgSize(maxMessageSize), grpc.MaxSendMsgSize(maxMessageSize))
+			if token != `` {
+				opts = append(opts, grpc.UnaryInterceptor(requireToken(token)), grpc.StreamInterceptor(requireStreamToken(token)))
+			}
+			return plugin.DefaultGRPCServer(opts)
+		},
+		Logger: hclog.Default(),
+	}
+	id := s.Identifier(c)
+	log.Printf("Starting to serve %s\n", id)
+	plugin.Serve(cfg)
+	log.Printf("Done serve %s\n", id)
+}
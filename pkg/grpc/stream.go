@@ -0,0 +1,222 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/lyraproj/data-protobuf/datapb"
+	"github.com/lyraproj/servicesdk/servicepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MaxMessageSizeEnvVar is the maximum size, in bytes, of a single message sent between lyra and a
+	// plugin. Larger values are streamed in chunks to plugins that support it.
+	MaxMessageSizeEnvVar = "LYRA_PLUGIN_MAX_MESSAGE_SIZE"
+
+	// DefaultMaxMessageSize is the maximum message size used when none is configured. It is the default
+	// of gRPC.
+	DefaultMaxMessageSize = 4 << 20
+
+	// maxChunkSize is the largest chunk sent when a value is streamed
+	maxChunkSize = 1 << 20
+
+	// streamPrefix is the prefix of all methods of the stream service
+	streamPrefix = "/lyra.ValueStream/"
+)
+
+// streamDesc describes the service that transfers the requests and responses of the definition service
+// in chunks. Each chunk is a wrappers.BytesValue. The request is sent in full before the response is
+// received.
+var streamDesc = grpc.ServiceDesc{
+	ServiceName: "lyra.ValueStream",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Invoke", Handler: streamInvoke, ServerStreams: true, ClientStreams: true},
+		{StreamName: "State", Handler: streamState, ServerStreams: true, ClientStreams: true},
+		{StreamName: "Metadata", Handler: streamMetadata, ServerStreams: true, ClientStreams: true},
+	},
+	Metadata: "lyra/stream",
+}
+
+// MaxMessageSizeFromEnv returns the maximum message size given by the LYRA_PLUGIN_MAX_MESSAGE_SIZE
+// environment variable or the DefaultMaxMessageSize
+func MaxMessageSizeFromEnv() (int, error) {
+	v := os.Getenv(MaxMessageSizeEnvVar)
+	if v == `` {
+		return DefaultMaxMessageSize, nil
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil || size < 1024 {
+		return 0, fmt.Errorf("invalid %s '%s', expected a number of bytes of at least 1024", MaxMessageSizeEnvVar, v)
+	}
+	return size, nil
+}
+
+// chunkSize returns the size of the chunks sent when the maximum message size is the given size. Room
+// is left for the framing of the chunk.
+func chunkSize(maxMessageSize int) int {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+	if size := maxMessageSize - 1024; size < maxChunkSize {
+		return size
+	}
+	return maxChunkSize
+}
+
+// sendChunks marshals the given message and sends it in chunks of at most the given size
+func sendChunks(send func(interface{}) error, msg proto.Message, size int) error {
+	bs, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	for {
+		n := len(bs)
+		if n > size {
+			n = size
+		}
+		if err = send(&wrappers.BytesValue{Value: bs[:n]}); err != nil {
+			return err
+		}
+		bs = bs[n:]
+		if len(bs) == 0 {
+			return nil
+		}
+	}
+}
+
+// receiveChunks receives chunks until the end of the stream and unmarshals them into the given message
+func receiveChunks(receive func(interface{}) error, msg proto.Message) error {
+	var bs []byte
+	for {
+		chunk := &wrappers.BytesValue{}
+		err := receive(chunk)
+		if err == io.EOF {
+			return proto.Unmarshal(bs, msg)
+		}
+		if err != nil {
+			return err
+		}
+		bs = append(bs, chunk.Value...)
+	}
+}
+
+// serveChunked receives the request of a stream, calls the given function, and streams its response
+func (a *Server) serveChunked(stream grpc.ServerStream, rq proto.Message, call func(context.Context) (proto.Message, error)) error {
+	if err := receiveChunks(stream.RecvMsg, rq); err != nil {
+		return err
+	}
+	rr, err := call(stream.Context())
+	if err != nil {
+		return err
+	}
+	return sendChunks(stream.SendMsg, rr, chunkSize(a.maxMessageSize))
+}
+
+func streamInvoke(srv interface{}, stream grpc.ServerStream) error {
+	a := srv.(*Server)
+	rq := &servicepb.InvokeRequest{}
+	return a.serveChunked(stream, rq, func(ctx context.Context) (proto.Message, error) { return a.Invoke(ctx, rq) })
+}
+
+func streamState(srv interface{}, stream grpc.ServerStream) error {
+	a := srv.(*Server)
+	rq := &servicepb.StateRequest{}
+	return a.serveChunked(stream, rq, func(ctx context.Context) (proto.Message, error) { return a.State(ctx, rq) })
+}
+
+func streamMetadata(srv interface{}, stream grpc.ServerStream) error {
+	a := srv.(*Server)
+	rq := &servicepb.EmptyRequest{}
+	return a.serveChunked(stream, rq, func(ctx context.Context) (proto.Message, error) { return a.Metadata(ctx, rq) })
+}
+
+// callChunked streams the given request to the given method of the stream service and receives the
+// response into rr. The second return value is false when the plugin doesn't provide the stream service.
+func (c *Client) callChunked(ctx context.Context, method string, rq, rr proto.Message) (bool, error) {
+	stream, err := c.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, streamPrefix+method, c.callOptions()...)
+	if err != nil {
+		return true, err
+	}
+	if err = sendChunks(stream.SendMsg, rq, chunkSize(c.maxMessageSize)); err != nil && err != io.EOF {
+		return true, err
+	}
+	// An io.EOF from SendMsg means that the server has ended the stream. The reason is returned by RecvMsg.
+	if err = stream.CloseSend(); err != nil {
+		return true, err
+	}
+	err = receiveChunks(stream.RecvMsg, rr)
+	if status.Code(err) == codes.Unimplemented {
+		return false, nil
+	}
+	return true, err
+}
+
+// callOptions returns the options used for all calls to the plugin
+func (c *Client) callOptions() []grpc.CallOption {
+	if c.maxMessageSize <= 0 {
+		return nil
+	}
+	return []grpc.CallOption{grpc.MaxCallRecvMsgSize(c.maxMessageSize), grpc.MaxCallSendMsgSize(c.maxMessageSize)}
+}
+
+// streaming returns true unless the plugin is known to not provide the stream service
+func (c *Client) streaming() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.conn != nil && !c.noStreams
+}
+
+func (c *Client) disableStreaming() {
+	c.lock.Lock()
+	c.noStreams = true
+	c.lock.Unlock()
+}
+
+// invoke sends the given invocation to the plugin, in chunks unless the plugin doesn't support it
+func (c *Client) invoke(ctx context.Context, rq *servicepb.InvokeRequest) (*datapb.Data, error) {
+	if c.streaming() {
+		rr := &datapb.Data{}
+		ok, err := c.callChunked(ctx, `Invoke`, rq, rr)
+		if ok {
+			return rr, err
+		}
+		c.disableStreaming()
+	}
+	return c.client.Invoke(ctx, rq, c.callOptions()...)
+}
+
+// state requests the given state from the plugin, in chunks unless the plugin doesn't support it
+func (c *Client) state(ctx context.Context, rq *servicepb.StateRequest) (*datapb.Data, error) {
+	if c.streaming() {
+		rr := &datapb.Data{}
+		ok, err := c.callChunked(ctx, `State`, rq, rr)
+		if ok {
+			return rr, err
+		}
+		c.disableStreaming()
+	}
+	return c.client.State(ctx, rq, c.callOptions()...)
+}
+
+// metadata requests the metadata of the plugin, in chunks unless the plugin doesn't support it
+func (c *Client) metadata(ctx context.Context) (*servicepb.MetadataResponse, error) {
+	rq := &servicepb.EmptyRequest{}
+	if c.streaming() {
+		rr := &servicepb.MetadataResponse{}
+		ok, err := c.callChunked(ctx, `Metadata`, rq, rr)
+		if ok {
+			return rr, err
+		}
+		c.disableStreaming()
+	}
+	return c.client.Metadata(ctx, rq, c.callOptions()...)
+}
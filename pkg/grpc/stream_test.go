@@ -0,0 +1,126 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/servicepb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testMaxMessageSize = 64 * 1024
+
+// serveTest serves the echoService over an in-memory connection and returns a client for it. The
+// stream service is only registered when streams is true.
+func serveTest(t *testing.T, c eval.Context, streams bool) (*Client, func()) {
+	token, err := NewToken()
+	require.NoError(t, err)
+	a := &Server{ctx: c, impl: &echoService{}, maxMessageSize: testMaxMessageSize}
+	server := grpc.NewServer(
+		grpc.MaxRecvMsgSize(testMaxMessageSize), grpc.MaxSendMsgSize(testMaxMessageSize),
+		grpc.UnaryInterceptor(requireToken(token)), grpc.StreamInterceptor(requireStreamToken(token)))
+	servicepb.RegisterDefinitionServiceServer(server, a)
+	if streams {
+		server.RegisterService(&streamDesc, a)
+	}
+	listener := bufconn.Listen(1024 * 1024)
+	go server.Serve(listener)
+
+	conn, err := grpc.Dial(`bufconn`, grpc.WithInsecure(), grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	require.NoError(t, err)
+	client, err := (&PluginClient{Token: token, MaxMessageSize: testMaxMessageSize}).GRPCClient(context.Background(), nil, conn)
+	require.NoError(t, err)
+	return client.(*Client), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func Test_StreamLargeValues(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		client, stop := serveTest(t, c, true)
+		defer stop()
+
+		// Both the arguments and the result are larger than the maximum message size
+		large := strings.Repeat(`x`, 4*testMaxMessageSize)
+		result := client.Invoke(c, `Test::Handler`, `read`, types.WrapString(large))
+		assert.Equal(t, `Test::Handler read `+large, result.String())
+		assert.False(t, client.noStreams)
+
+		results, err := client.InvokeBatch(c, []Invocation{
+			{Identifier: `Test::Handler`, Name: `read`, Arguments: []eval.Value{types.WrapString(large)}},
+			{Identifier: `Test::Handler`, Name: `create`, Arguments: []eval.Value{types.WrapString(large)}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, `Test::Handler create `+large, results[1].String())
+	})
+}
+
+func Test_StreamUnsupported(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		client, stop := serveTest(t, c, false)
+		defer stop()
+
+		// Small values are sent in single messages to plugins without the stream service
+		assert.Equal(t, `Test::Handler read x`, client.Invoke(c, `Test::Handler`, `read`, types.WrapString(`x`)).String())
+		assert.True(t, client.noStreams)
+
+		large := strings.Repeat(`x`, 4*testMaxMessageSize)
+		err := func() (err error) {
+			defer func() { err, _ = recover().(error) }()
+			client.Invoke(c, `Test::Handler`, `read`, types.WrapString(large))
+			return nil
+		}()
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+}
+
+func Test_StreamRequiresToken(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		client, stop := serveTest(t, c, true)
+		defer stop()
+
+		client.token = `invalid`
+		_, err := client.invoke(withToken(context.Background(), client.token), &servicepb.InvokeRequest{Identifier: `Test::Handler`, Method: `read`})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.False(t, client.noStreams)
+	})
+}
+
+func Test_ChunkSize(t *testing.T) {
+	assert.Equal(t, maxChunkSize, chunkSize(0))
+	assert.Equal(t, maxChunkSize, chunkSize(DefaultMaxMessageSize))
+	assert.Equal(t, 63*1024, chunkSize(testMaxMessageSize))
+}
+
+func Test_MaxMessageSizeFromEnv(t *testing.T) {
+	defer os.Unsetenv(MaxMessageSizeEnvVar)
+
+	size, err := MaxMessageSizeFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMaxMessageSize, size)
+
+	os.Setenv(MaxMessageSizeEnvVar, `16777216`)
+	size, err = MaxMessageSizeFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, 16<<20, size)
+
+	for _, v := range []string{`big`, `100`} {
+		os.Setenv(MaxMessageSizeEnvVar, v)
+		_, err = MaxMessageSizeFromEnv()
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,77 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TokenEnvVar is the environment variable that passes the token of a plugin launch to the plugin
+const TokenEnvVar = "LYRA_PLUGIN_TOKEN"
+
+// TokenMetadataKey is the gRPC metadata key used when sending the token with each call
+const TokenMetadataKey = "lyra-plugin-token"
+
+// servicePrefix is the prefix of all methods of the definition service
+const servicePrefix = "/puppet.service.DefinitionService/"
+
+var errNoToken = status.Error(codes.Unauthenticated, "missing or invalid plugin token")
+
+// NewToken returns a new random token
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return ``, err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// withToken returns a context that sends the given token as metadata
+func withToken(ctx context.Context, token string) context.Context {
+	if token == `` {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, TokenMetadataKey, token)
+}
+
+// requireToken returns an interceptor that rejects calls to the definition service that do not
+// carry the given token. The health and broker services of go-plugin are not affected.
+func requireToken(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, servicePrefix) && !hasToken(ctx, token) {
+			return nil, errNoToken
+		}
+		return handler(ctx, req)
+	}
+}
+
+// requireStreamToken returns an interceptor that rejects streams of the stream service that do not
+// carry the given token. The streams of go-plugin are not affected.
+func requireStreamToken(token string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, streamPrefix) && !hasToken(ss.Context(), token) {
+			return errNoToken
+		}
+		return handler(srv, ss)
+	}
+}
+
+func hasToken(ctx context.Context, token string) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, t := range md.Get(TokenMetadataKey) {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// incoming returns the context that a server receives when the client uses the given context
+func incoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func Test_RequireToken(t *testing.T) {
+	token, err := NewToken()
+	require.NoError(t, err)
+	other, err := NewToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+
+	interceptor := requireToken(token)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return `ok`, nil }
+	invoke := &grpc.UnaryServerInfo{FullMethod: servicePrefix + `Invoke`}
+	health := &grpc.UnaryServerInfo{FullMethod: `/grpc.health.v1.Health/Check`}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		info *grpc.UnaryServerInfo
+		ok   bool
+	}{
+		{`valid token`, incoming(withToken(context.Background(), token)), invoke, true},
+		{`invalid token`, incoming(withToken(context.Background(), other)), invoke, false},
+		{`no token`, incoming(withToken(context.Background(), ``)), invoke, false},
+		{`no metadata`, context.Background(), invoke, false},
+		{`other service`, context.Background(), health, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := interceptor(tt.ctx, nil, tt.info, handler)
+			if tt.ok {
+				assert.NoError(t, err)
+				assert.Equal(t, `ok`, result)
+			} else {
+				assert.Equal(t, codes.Unauthenticated, status.Code(err))
+				assert.Nil(t, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+// Package incremental makes repeated applies of an unchanged workflow fast. The desired state of each
+// resource, and the state that its handler returned, is recorded when a run succeeds. A later run that
+// computes the same desired state for the resource uses the recorded state instead of reading the
+// resource from its provider. The outputs of such a resource are then unchanged too, so whole subgraphs
+// of a workflow whose inputs and manifests are unchanged are applied without calling any provider.
+//
+// Changes made to resources outside of lyra are not detected for resources that are skipped. A run
+// that isn't incremental reads all resources and detects them.
+package incremental
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/lyraproj/data-protobuf/datapb"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	pbproto "github.com/lyraproj/puppet-evaluator/proto"
+	"github.com/lyraproj/puppet-evaluator/serialization"
+	"github.com/lyraproj/puppet-evaluator/types"
+	sdkgrpc "github.com/lyraproj/servicesdk/grpc"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// DefaultFile is the file, relative to the directory that workflows are applied in, where the states
+// are recorded
+const DefaultFile = "incremental.json"
+
+// desiredKey is the key of the desired state of the resource that the current activity is applying
+const desiredKey = `lyra.incremental.desired`
+
+// record is the recorded state of a resource
+type record struct {
+	// Desired is a digest of the desired state of the resource
+	Desired string `json:"desired"`
+
+	// State is the state returned by the handler, encoded using the service protocol
+	State []byte `json:"state"`
+}
+
+// Store holds the states recorded by the last successful run and those recorded by the current run
+type Store struct {
+	path     string
+	lock     sync.Mutex
+	previous map[string]*record
+	current  map[string]*record
+	deleted  map[string]bool
+	skipped  int
+}
+
+// Open reads the states recorded in the given file. A missing file is an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, previous: map[string]*record{}, current: map[string]*record{}, deleted: map[string]bool{}}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(bs, &s.previous); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Skipped returns the number of resources that were not read from their provider during the current run
+func (s *Store) Skipped() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.skipped
+}
+
+// Commit records the states of the current run. It must only be called when the run has succeeded.
+// The states of resources that the run didn't apply are kept.
+func (s *Store) Commit() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	records := make(map[string]*record, len(s.previous)+len(s.current))
+	for k, r := range s.previous {
+		if !s.deleted[k] {
+			records[k] = r
+		}
+	}
+	for k, r := range s.current {
+		records[k] = r
+	}
+	bs, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	// Write and rename so that a run that is interrupted never leaves a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+`.*.tmp`)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(bs)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// WrapService returns a function that wraps a service so that the states of the resources that its
+// handlers apply are recorded in the store, and recorded states are used in place of reading unchanged
+// resources
+func (s *Store) WrapService() func(serviceapi.Service) serviceapi.Service {
+	return func(svc serviceapi.Service) serviceapi.Service {
+		return &service{Service: svc, store: s}
+	}
+}
+
+type service struct {
+	serviceapi.Service
+	store *Store
+}
+
+// State remembers the desired state of the resource. The workflow engine reads the resource, using
+// the same context, right after it has obtained its desired state.
+func (s *service) State(c eval.Context, name string, input eval.OrderedMap) eval.PuppetObject {
+	desired := s.Service.State(c, name, input)
+	c.Set(desiredKey, desired)
+	return desired
+}
+
+func (s *service) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	var desired eval.Value
+	switch name {
+	case `read`, `create`, `update`, `upsert`, `delete`:
+		// The desired state only applies to the first handler invocation that follows it
+		if v, ok := c.Get(desiredKey); ok && v != nil {
+			desired = v.(eval.Value)
+			c.Set(desiredKey, nil)
+		}
+	default:
+		return s.Service.Invoke(c, identifier, name, arguments...)
+	}
+
+	switch name {
+	case `read`:
+		if desired == nil || len(arguments) != 1 {
+			break
+		}
+		key := resourceKey(identifier, arguments[0])
+		digest := digest(desired)
+		if state, ok := s.store.recorded(c, key, digest); ok {
+			return state
+		}
+		result := s.Service.Invoke(c, identifier, name, arguments...)
+		s.store.record(key, digest, result)
+		return result
+	case `create`:
+		result := s.Service.Invoke(c, identifier, name, arguments...)
+		if l, ok := result.(eval.List); ok && l.Len() > 1 && len(arguments) > 0 {
+			s.store.record(resourceKey(identifier, l.At(1)), digest(arguments[0]), l.At(0))
+		}
+		return result
+	case `update`:
+		result := s.Service.Invoke(c, identifier, name, arguments...)
+		if len(arguments) > 1 {
+			s.store.record(resourceKey(identifier, arguments[0]), digest(arguments[1]), result)
+		}
+		return result
+	case `delete`:
+		result := s.Service.Invoke(c, identifier, name, arguments...)
+		if len(arguments) > 0 {
+			s.store.forget(resourceKey(identifier, arguments[0]))
+		}
+		return result
+	}
+	return s.Service.Invoke(c, identifier, name, arguments...)
+}
+
+// recorded returns the state recorded by the last successful run for the given resource when its
+// desired state had the given digest. The state is recorded again for the current run.
+func (s *Store) recorded(c eval.Context, key, digest string) (eval.Value, bool) {
+	s.lock.Lock()
+	r, ok := s.previous[key]
+	s.lock.Unlock()
+	if !ok || digest == `` || r.Desired != digest {
+		return nil, false
+	}
+	data := &datapb.Data{}
+	if err := proto.Unmarshal(r.State, data); err != nil {
+		return nil, false
+	}
+	state := sdkgrpc.FromDataPB(c, data)
+
+	s.lock.Lock()
+	s.current[key] = r
+	s.skipped++
+	s.lock.Unlock()
+	return state, true
+}
+
+func (s *Store) record(key, digest string, state eval.Value) {
+	bs, err := proto.Marshal(sdkgrpc.ToDataPB(state))
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	s.current[key] = &record{Desired: digest, State: bs}
+	delete(s.deleted, key)
+	s.lock.Unlock()
+}
+
+func (s *Store) forget(key string) {
+	s.lock.Lock()
+	delete(s.current, key)
+	s.deleted[key] = true
+	s.lock.Unlock()
+}
+
+// Recorded returns the states recorded in the given file by the last successful incremental run, keyed by
+// the external IDs of the resources. The states are plain data that marshals to JSON. Objects are hashes
+// whose __ptype key is the name of their type, and the content of Sensitive values is redacted. A missing
+// file has no states.
+func Recorded(path string) (map[string]interface{}, error) {
+	s, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]interface{}, len(s.previous))
+	for key, r := range s.previous {
+		data := &datapb.Data{}
+		if err = proto.Unmarshal(r.State, data); err != nil {
+			return nil, fmt.Errorf("invalid state of %s in %s: %s", strings.Replace(key, "\x00", ` `, 1), path, err)
+		}
+		collector := serialization.NewCollector()
+		pbproto.ConsumePBData(data, collector)
+		states[key[strings.IndexByte(key, 0)+1:]] = plain(collector.Value())
+	}
+	return states, nil
+}
+
+// plain converts a value that is data to plain Go values
+func plain(v eval.Value) interface{} {
+	switch v := v.(type) {
+	case eval.StringValue:
+		return v.String()
+	case eval.IntegerValue:
+		return v.Int()
+	case eval.FloatValue:
+		return v.Float()
+	case eval.BooleanValue:
+		return v.Bool()
+	case *types.BinaryValue:
+		return v.Bytes()
+	case eval.OrderedMap:
+		h := make(map[string]interface{}, v.Len())
+		v.EachPair(func(k, e eval.Value) { h[k.String()] = plain(e) })
+		if t, ok := v.Get4(`__ptype`); ok {
+			// The type is given by value the first time it is used
+			if th, ok := t.(eval.OrderedMap); ok {
+				t = th.Get5(`name`, t)
+			}
+			if t.String() == `Sensitive` {
+				return map[string]interface{}{`__ptype`: `Sensitive`, `__pvalue`: `redacted`}
+			}
+			h[`__ptype`] = t.String()
+		}
+		return h
+	case eval.List:
+		a := make([]interface{}, v.Len())
+		v.EachWithIndex(func(e eval.Value, i int) { a[i] = plain(e) })
+		return a
+	}
+	return nil
+}
+
+// resourceKey identifies a resource by the handler that manages it and its external ID
+func resourceKey(handler string, externalID eval.Value) string {
+	return handler + "\x00" + externalID.String()
+}
+
+// digest returns a digest of the given value. Values that are Sensitive contribute their content.
+func digest(v eval.Value) string {
+	bs, err := proto.Marshal(sdkgrpc.ToDataPB(v))
+	if err != nil {
+		return ``
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,185 @@
+package incremental
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type person struct {
+	Name string
+	Age  int64
+}
+
+func addPersonType(c eval.Context) {
+	c.AddTypes(c.Reflector().TypeFromReflect(`Test::Person`, nil, reflect.TypeOf(&person{})))
+}
+
+// testService is a manifest service that returns the desired state held in its input, and a handler
+// that counts its invocations
+type testService struct {
+	serviceapi.Service
+	invoked map[string]int
+}
+
+func (s *testService) State(c eval.Context, name string, input eval.OrderedMap) eval.PuppetObject {
+	return eval.Wrap(c, &person{input.Get5(`name`, eval.UNDEF).String(), input.Get5(`age`, eval.UNDEF).(eval.IntegerValue).Int()}).(eval.PuppetObject)
+}
+
+func (s *testService) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	s.invoked[name]++
+	switch name {
+	case `read`:
+		return eval.Wrap(c, &person{`Read`, 1})
+	case `create`:
+		return types.WrapValues([]eval.Value{arguments[0], types.WrapString(`ext-1`)})
+	case `update`:
+		return arguments[1]
+	}
+	return eval.UNDEF
+}
+
+func desired(name string, age int64) eval.OrderedMap {
+	return types.WrapStringToInterfaceMap(nil, map[string]interface{}{`name`: name, `age`: age})
+}
+
+// apply does what the workflow engine does for a resource that exists
+func apply(c eval.Context, svc serviceapi.Service, input eval.OrderedMap) eval.Value {
+	svc.State(c, `test::person`, input)
+	return svc.Invoke(c, `Test::PersonHandler`, `read`, types.WrapString(`ext-1`))
+}
+
+func Test_SkipUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `incremental`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, DefaultFile)
+
+	eval.Puppet.Do(func(c eval.Context) {
+		addPersonType(c)
+		s := &testService{invoked: map[string]int{}}
+
+		store, err := Open(file)
+		require.NoError(t, err)
+		first := apply(c, store.WrapService()(s), desired(`Bob`, 30))
+		assert.Equal(t, 1, s.invoked[`read`])
+		assert.Equal(t, 0, store.Skipped())
+		require.NoError(t, store.Commit())
+
+		// The state recorded by the first run is used in place of a read
+		store, err = Open(file)
+		require.NoError(t, err)
+		second := apply(c, store.WrapService()(s), desired(`Bob`, 30))
+		assert.Equal(t, 1, s.invoked[`read`])
+		assert.Equal(t, 1, store.Skipped())
+		assert.Equal(t, first.String(), second.String())
+
+		// A read that isn't preceded by a desired state is never skipped
+		store.WrapService()(s).Invoke(c, `Test::PersonHandler`, `read`, types.WrapString(`ext-1`))
+		assert.Equal(t, 2, s.invoked[`read`])
+
+		// A changed desired state is read
+		apply(c, store.WrapService()(s), desired(`Bob`, 31))
+		assert.Equal(t, 3, s.invoked[`read`])
+		assert.Equal(t, 1, store.Skipped())
+	})
+}
+
+func Test_RecordChanges(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `incremental`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, DefaultFile)
+
+	eval.Puppet.Do(func(c eval.Context) {
+		addPersonType(c)
+		s := &testService{invoked: map[string]int{}}
+
+		// A created resource is recorded with the state that was created
+		store, err := Open(file)
+		require.NoError(t, err)
+		svc := store.WrapService()(s)
+		state := svc.State(c, `test::person`, desired(`Bob`, 30))
+		svc.Invoke(c, `Test::PersonHandler`, `create`, state)
+		require.NoError(t, store.Commit())
+
+		store, err = Open(file)
+		require.NoError(t, err)
+		assert.Equal(t, state.String(), apply(c, store.WrapService()(s), desired(`Bob`, 30)).String())
+		assert.Equal(t, 0, s.invoked[`read`])
+
+		// An updated resource is recorded with its new desired state
+		svc = store.WrapService()(s)
+		state = svc.State(c, `test::person`, desired(`Bob`, 31))
+		svc.Invoke(c, `Test::PersonHandler`, `update`, types.WrapString(`ext-1`), state)
+		require.NoError(t, store.Commit())
+
+		store, err = Open(file)
+		require.NoError(t, err)
+		apply(c, store.WrapService()(s), desired(`Bob`, 31))
+		assert.Equal(t, 0, s.invoked[`read`])
+
+		// A deleted resource is forgotten
+		store.WrapService()(s).Invoke(c, `Test::PersonHandler`, `delete`, types.WrapString(`ext-1`))
+		require.NoError(t, store.Commit())
+
+		store, err = Open(file)
+		require.NoError(t, err)
+		apply(c, store.WrapService()(s), desired(`Bob`, 31))
+		assert.Equal(t, 1, s.invoked[`read`])
+		assert.Equal(t, 0, store.Skipped())
+	})
+}
+
+func Test_Digest(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		secret := func(s string) eval.Value { return types.WrapSensitive(types.WrapString(s)) }
+		assert.Equal(t, digest(secret(`a`)), digest(secret(`a`)))
+		assert.NotEqual(t, digest(secret(`a`)), digest(secret(`b`)))
+		assert.NotEqual(t, digest(types.WrapString(`a`)), digest(types.WrapString(`b`)))
+	})
+}
+
+func Test_OpenInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `incremental`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, DefaultFile)
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{`), 0600))
+	_, err = Open(file)
+	assert.Error(t, err)
+}
+
+func Test_Recorded(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `incremental`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, DefaultFile)
+
+	states, err := Recorded(file)
+	require.NoError(t, err)
+	assert.Empty(t, states)
+
+	eval.Puppet.Do(func(c eval.Context) {
+		addPersonType(c)
+		store, err := Open(file)
+		require.NoError(t, err)
+		store.record(resourceKey(`Test::PersonHandler`, types.WrapString(`ext-1`)), ``, eval.Wrap(c, &person{`Bob`, 30}))
+		store.record(resourceKey(`Test::SecretHandler`, types.WrapString(`ext-2`)), ``, types.WrapStringToInterfaceMap(c, map[string]interface{}{
+			`password`: types.WrapSensitive(types.WrapString(`s3cret`))}))
+		require.NoError(t, store.Commit())
+	})
+
+	states, err = Recorded(file)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{`__ptype`: `Test::Person`, `name`: `Bob`, `age`: int64(30)}, states[`ext-1`])
+	assert.Equal(t, map[string]interface{}{`password`: map[string]interface{}{`__ptype`: `Sensitive`, `__pvalue`: `redacted`}}, states[`ext-2`])
+}
@@ -0,0 +1,163 @@
+// Package leak scans workflow values for secrets that are given in plaintext. Values that are wrapped
+// in Sensitive are never reported since they are neither logged nor revealed in output.
+package leak
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+)
+
+const (
+	// minEntropyLength is the minimum length of a token for it to be considered by the entropy check
+	minEntropyLength = 20
+
+	// base64Entropy and hexEntropy are the Shannon entropy, in bits per character, above which a
+	// base64 or hex token is considered random enough to be a secret
+	base64Entropy = 4.5
+	hexEntropy    = 3.5
+)
+
+// Finding describes a plaintext value that looks like a secret. It never contains the value itself.
+type Finding struct {
+	// Path is the location of the value, e.g. tags.owner or users[2].password
+	Path string
+
+	// Rule is a description of the rule that matched
+	Rule string
+}
+
+func (f Finding) String() string {
+	return f.Rule + ` at ` + f.Path
+}
+
+type pattern struct {
+	rule   string
+	regexp *regexp.Regexp
+}
+
+// patterns are known credential formats
+var patterns = []pattern{
+	{`AWS access key id`, regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{`private key`, regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----`)},
+	{`GitHub token`, regexp.MustCompile(`\bgh[pousr]_[0-9A-Za-z]{36,}\b`)},
+	{`Slack token`, regexp.MustCompile(`\bxox[abprs]-[0-9A-Za-z-]{10,}`)},
+	{`Google API key`, regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{`JSON web token`, regexp.MustCompile(`\beyJ[0-9A-Za-z_-]{10,}\.eyJ[0-9A-Za-z_-]{10,}\.[0-9A-Za-z_-]+`)},
+	{`credentials in URL`, regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^/\s:@]+:[^/\s@]+@`)},
+}
+
+// secretKey matches names of keys and attributes that are expected to hold secrets
+var secretKey = regexp.MustCompile(`(?:^|_)(?:password|passwd|secret|token|api_?key|private_?key|secret_?key|access_?key|client_?secret)$`)
+
+var base64Token = regexp.MustCompile(`^[0-9A-Za-z+/_-]+={0,2}$`)
+var hexToken = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// Scan returns a finding for each plaintext string in the given value that looks like a secret
+func Scan(v eval.Value) []Finding {
+	var findings []Finding
+	scan(v, ``, ``, func(f Finding) { findings = append(findings, f) })
+	return findings
+}
+
+func scan(v eval.Value, path, key string, report func(Finding)) {
+	switch v := v.(type) {
+	case *types.SensitiveValue:
+		return
+	case eval.StringValue:
+		scanString(v.String(), path, key, report)
+	case eval.OrderedMap:
+		v.EachPair(func(k, e eval.Value) {
+			name := k.String()
+			scan(e, join(path, name), name, report)
+		})
+	case eval.List:
+		v.EachWithIndex(func(e eval.Value, i int) {
+			scan(e, path+`[`+strconv.Itoa(i)+`]`, ``, report)
+		})
+	case eval.PuppetObject:
+		scan(v.InitHash(), path, ``, report)
+	}
+}
+
+func join(path, name string) string {
+	if path == `` {
+		return name
+	}
+	return path + `.` + name
+}
+
+func scanString(s, path, key string, report func(Finding)) {
+	if path == `` {
+		path = `value`
+	}
+	for _, p := range patterns {
+		if p.regexp.MatchString(s) {
+			report(Finding{Path: path, Rule: p.rule})
+			return
+		}
+	}
+	if s != `` && secretKey.MatchString(snakeCase(key)) {
+		report(Finding{Path: path, Rule: `value of ` + key})
+		return
+	}
+	for _, t := range tokens(s) {
+		if highEntropy(t) {
+			report(Finding{Path: path, Rule: `high entropy string`})
+			return
+		}
+	}
+}
+
+// tokens splits a string into the parts that may hold a secret, so that a key embedded in e.g. a
+// command line or an assignment is checked on its own
+func tokens(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`"'=:,;`, r)
+	})
+}
+
+func highEntropy(t string) bool {
+	if len(t) < minEntropyLength {
+		return false
+	}
+	if hexToken.MatchString(t) {
+		return len(t) >= 32 && entropy(t) >= hexEntropy
+	}
+	return base64Token.MatchString(t) && entropy(t) >= base64Entropy
+}
+
+// entropy returns the Shannon entropy of the given string in bits per character
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	n := float64(len(s))
+	e := 0.0
+	for _, c := range counts {
+		p := float64(c) / n
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// snakeCase converts camel case names such as apiKey to lower case snake case
+func snakeCase(s string) string {
+	b := strings.Builder{}
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,46 @@
+package leak
+
+import (
+	"sync"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// scannedMethods are the handler methods that pass a resource to a provider
+var scannedMethods = map[string]bool{
+	`create`: true,
+	`update`: true,
+	`upsert`: true,
+}
+
+type service struct {
+	serviceapi.Service
+	report   func(identifier string, f Finding)
+	reported *sync.Map
+}
+
+// WrapService returns a function that wraps a service so that the resources passed to its handlers are
+// scanned before they reach the provider. Each finding is reported once per handler.
+func WrapService(report func(identifier string, f Finding)) func(serviceapi.Service) serviceapi.Service {
+	reported := &sync.Map{}
+	return func(s serviceapi.Service) serviceapi.Service {
+		return &service{Service: s, report: report, reported: reported}
+	}
+}
+
+func (s *service) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	if scannedMethods[name] && len(arguments) > 0 {
+		// The resource is the last argument, update is also given the external id
+		s.scan(identifier, arguments[len(arguments)-1])
+	}
+	return s.Service.Invoke(c, identifier, name, arguments...)
+}
+
+func (s *service) scan(identifier string, v eval.Value) {
+	for _, f := range Scan(v) {
+		if _, loaded := s.reported.LoadOrStore(identifier+`#`+f.String(), true); !loaded {
+			s.report(identifier, f)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// execRule is a rule that is implemented by an executable
+type execRule struct {
+	path        string
+	id          string
+	description string
+	level       Level
+}
+
+// NewExecRule returns a rule that is implemented by the executable at the given path, so that rules
+// can be written in any language. The executable is first run with the single argument --describe and
+// must print a JSON object with the id, description and level of the rule, e.g.
+//
+//	{"id": "acme-naming", "description": "Resources are named after the team.", "level": "warning"}
+//
+// It is then run with the path of each manifest as its single argument and must print a JSON array of
+// findings, e.g. [{"message": "resource vpc is not named after the team", "line": 12}], and exit with
+// status 0.
+func NewExecRule(path string) (Rule, error) {
+	out, err := run(path, `--describe`)
+	if err != nil {
+		return nil, err
+	}
+	r := &execRule{path: path}
+	desc := struct {
+		ID          string `json:"id"`
+		Description string `json:"description"`
+		Level       Level  `json:"level"`
+	}{}
+	if err = json.Unmarshal(out, &desc); err != nil {
+		return nil, fmt.Errorf("invalid description of lint rule %s: %s", path, err)
+	}
+	if desc.ID == `` {
+		return nil, fmt.Errorf("lint rule %s has no id", path)
+	}
+	r.id, r.description, r.level = desc.ID, desc.Description, desc.Level
+	switch r.level {
+	case Error, Warning, Note:
+	case ``:
+		r.level = Warning
+	default:
+		return nil, fmt.Errorf("lint rule %s has invalid level %s", path, r.level)
+	}
+	return r, nil
+}
+
+func (r *execRule) ID() string { return r.id }
+
+func (r *execRule) Description() string { return r.description }
+
+func (r *execRule) Level() Level { return r.level }
+
+func (r *execRule) Check(m *Manifest, cfg *Config) ([]Finding, error) {
+	out, err := run(r.path, m.Path)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	if err = json.Unmarshal(out, &findings); err != nil {
+		return nil, fmt.Errorf("invalid findings: %s", err)
+	}
+	for i := range findings {
+		// A rule can't report findings for other rules or other manifests
+		findings[i].RuleID = r.id
+		findings[i].File = m.Path
+	}
+	return findings, nil
+}
+
+// run runs the executable with the given argument and returns what it printed on stdout
+func run(path, arg string) ([]byte, error) {
+	cmd := exec.Command(path, arg)
+	stderr := bytes.NewBufferString(``)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != `` {
+			err = fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, fmt.Errorf("lint rule %s failed: %s", path, err)
+	}
+	return out, nil
+}
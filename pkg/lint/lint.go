@@ -0,0 +1,172 @@
+// Package lint finds problems in YAML workflow manifests that are valid, and so are not reported when
+// the manifests are loaded, but are likely to be mistakes or to break the policies of an organization,
+// such as parameters that are never used or secrets that are given in plaintext.
+//
+// Each check is a Rule. Organizations add their own rules either in Go, by registering them with
+// RegisterRule, or as executables in any language, see ExecRule.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Level is the severity of a finding. The levels are those of SARIF.
+type Level string
+
+const (
+	// Error is a finding that must be fixed
+	Error = Level(`error`)
+
+	// Warning is a finding that should be fixed
+	Warning = Level(`warning`)
+
+	// Note is a finding that is worth knowing about
+	Note = Level(`note`)
+)
+
+// Finding is a problem found in a manifest by a rule
+type Finding struct {
+	// RuleID is the ID of the rule that found the problem
+	RuleID string `json:"ruleId"`
+
+	// Level is the severity of the problem. It is the level of the rule when a rule leaves it empty.
+	Level Level `json:"level,omitempty"`
+
+	// Message describes the problem
+	Message string `json:"message"`
+
+	// File is the path of the manifest
+	File string `json:"file"`
+
+	// Line is the line of the manifest where the problem is found, or 0 if it isn't known
+	Line int `json:"line,omitempty"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s [%s]", f.File, f.Line, f.Level, f.Message, f.RuleID)
+}
+
+// Rule checks manifests for one kind of problem
+type Rule interface {
+	// ID returns the identifier of the rule, e.g. unused-parameter
+	ID() string
+
+	// Description returns a one sentence description of what the rule finds
+	Description() string
+
+	// Level returns the level of the findings of the rule
+	Level() Level
+
+	// Check returns the problems that the rule finds in the given manifest. The RuleID, Level and File
+	// of the findings are filled in by Lint when they are empty.
+	Check(m *Manifest, cfg *Config) ([]Finding, error)
+}
+
+// Config holds the settings of the rules of one lint run
+type Config struct {
+	// RequiredTags are the keys that the tags of the resources checked by the missing-tags rule must have
+	RequiredTags []string
+
+	// TaggedTypespaces are the typespaces, e.g. Aws, of the resources that the missing-tags rule checks.
+	// DefaultTaggedTypespaces are checked when it is nil.
+	TaggedTypespaces []string
+
+	// DeprecatedTypes maps the names of deprecated resource types to the types that replace them. The
+	// replacement may be empty.
+	DeprecatedTypes map[string]string
+
+	// Disabled are the IDs of rules that are not run
+	Disabled []string
+
+	// Rules are rules that are run in addition to the registered ones, e.g. ExecRules
+	Rules []Rule
+}
+
+var (
+	rulesLock sync.Mutex
+	rules     []Rule
+)
+
+func init() {
+	RegisterRule(&unusedParameter{})
+	RegisterRule(&unreferencedOutput{})
+	RegisterRule(&hardcodedSecret{})
+	RegisterRule(&missingTags{})
+	RegisterRule(&deprecatedType{})
+}
+
+// RegisterRule makes a rule part of all lint runs that follow the call. It panics if a rule with the
+// same ID is already registered.
+func RegisterRule(r Rule) {
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	for _, er := range rules {
+		if er.ID() == r.ID() {
+			panic(fmt.Errorf("lint rule %s is already registered", r.ID()))
+		}
+	}
+	rules = append(rules, r)
+}
+
+// Rules returns the rules that a run with the given configuration uses, in registration order followed
+// by the rules of the configuration
+func Rules(cfg *Config) []Rule {
+	rulesLock.Lock()
+	all := append(append([]Rule{}, rules...), cfg.Rules...)
+	rulesLock.Unlock()
+
+	enabled := make([]Rule, 0, len(all))
+	for _, r := range all {
+		if !cfg.disabled(r.ID()) {
+			enabled = append(enabled, r)
+		}
+	}
+	return enabled
+}
+
+func (cfg *Config) disabled(id string) bool {
+	for _, d := range cfg.Disabled {
+		if d == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Lint checks the YAML workflow manifest at the given path with all rules of the configuration and
+// returns the findings sorted by line
+func Lint(path string, cfg *Config) ([]Finding, error) {
+	m, err := Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	for _, r := range Rules(cfg) {
+		fs, err := r.Check(m, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("lint rule %s failed on %s: %s", r.ID(), path, err)
+		}
+		for _, f := range fs {
+			if f.RuleID == `` {
+				f.RuleID = r.ID()
+			}
+			if f.Level == `` {
+				f.Level = r.Level()
+			}
+			if f.File == `` {
+				f.File = path
+			}
+			findings = append(findings, f)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Line != findings[j].Line {
+			return findings[i].Line < findings[j].Line
+		}
+		return strings.Compare(findings[i].RuleID, findings[j].RuleID) < 0
+	})
+	return findings, nil
+}
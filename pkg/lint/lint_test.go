@@ -0,0 +1,103 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var vpcConfig = &Config{
+	RequiredTags:    []string{`owner`},
+	DeprecatedTypes: map[string]string{`Aws::InternetGateway`: `Aws::Native::InternetGateway`},
+}
+
+func Test_Parse(t *testing.T) {
+	m, err := Parse(`testdata/vpc.yaml`)
+	require.NoError(t, err)
+	require.Len(t, m.Workflows, 1)
+	w := m.Workflows[0]
+	assert.True(t, w.IsWorkflow())
+	assert.Equal(t, 3, w.Line)
+	require.Len(t, w.Activities, 4)
+
+	subnet := w.Activities[1]
+	assert.False(t, subnet.IsWorkflow())
+	assert.Equal(t, `Aws::Subnet`, subnet.Type)
+	assert.Equal(t, w, subnet.Parent)
+	assert.Equal(t, 23, subnet.Line)
+	assert.Equal(t, []string{`vpcId`}, subnet.References())
+	assert.Equal(t, `Aws::InternetGateway`, w.Activities[2].Type)
+	assert.Equal(t, 28, m.Locate(subnet, `state`, `tags`))
+	assert.Equal(t, 25, m.Locate(subnet, `state`, `nothing`))
+
+	_, err = Parse(`testdata/missing.yaml`)
+	assert.Error(t, err)
+}
+
+func Test_Lint(t *testing.T) {
+	findings, err := Lint(`testdata/vpc.yaml`, vpcConfig)
+	require.NoError(t, err)
+	var lines []string
+	for _, f := range findings {
+		lines = append(lines, f.String())
+	}
+	assert.Equal(t, []string{
+		`testdata/vpc.yaml:9: warning: input region of workflow vpc_workflow is never used [unused-parameter]`,
+		`testdata/vpc.yaml:12: error: possible plaintext secret in the default value of input password of workflow vpc_workflow, value of password at password [hardcoded-secret]`,
+		`testdata/vpc.yaml:12: warning: input password of workflow vpc_workflow is never used [unused-parameter]`,
+		`testdata/vpc.yaml:24: warning: output subnetId of resource subnet is never used [unreferenced-output]`,
+		`testdata/vpc.yaml:28: warning: tags of resource subnet lack owner [missing-tags]`,
+		`testdata/vpc.yaml:30: warning: resource gateway has the deprecated type Aws::InternetGateway, use Aws::Native::InternetGateway instead [deprecated-type]`,
+		`testdata/vpc.yaml:30: warning: resource gateway of type Aws::InternetGateway has no tags [missing-tags]`,
+		`testdata/vpc.yaml:36: error: possible plaintext secret in the state of resource instance, AWS access key id at userData [hardcoded-secret]`,
+	}, lines)
+}
+
+func Test_Disabled(t *testing.T) {
+	findings, err := Lint(`testdata/vpc.yaml`, &Config{Disabled: []string{`unused-parameter`, `hardcoded-secret`, `unreferenced-output`}, TaggedTypespaces: []string{}})
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+}
+
+func Test_ExecRule(t *testing.T) {
+	r, err := NewExecRule(`testdata/rule.sh`)
+	require.NoError(t, err)
+	assert.Equal(t, `acme-review`, r.ID())
+	assert.Equal(t, `All manifests are reviewed.`, r.Description())
+	assert.Equal(t, Note, r.Level())
+
+	findings, err := Lint(`testdata/vpc.yaml`, &Config{Disabled: []string{`unused-parameter`, `hardcoded-secret`, `unreferenced-output`, `missing-tags`}, Rules: []Rule{r}})
+	require.NoError(t, err)
+	assert.Equal(t, []Finding{{RuleID: `acme-review`, Level: Note, Message: `review testdata/vpc.yaml`, File: `testdata/vpc.yaml`, Line: 3}}, findings)
+
+	_, err = NewExecRule(`testdata/bad_rule.sh`)
+	assert.EqualError(t, err, `lint rule testdata/bad_rule.sh failed: exit status 1: no rule here`)
+}
+
+func Test_RegisterRule(t *testing.T) {
+	assert.Panics(t, func() { RegisterRule(&unusedParameter{}) })
+}
+
+func Test_WriteSARIF(t *testing.T) {
+	findings, err := Lint(`testdata/vpc.yaml`, vpcConfig)
+	require.NoError(t, err)
+	b := bytes.NewBufferString(``)
+	require.NoError(t, WriteSARIF(b, Rules(vpcConfig), findings))
+
+	var log map[string]interface{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &log))
+	assert.Equal(t, `2.1.0`, log[`version`])
+	run := log[`runs`].([]interface{})[0].(map[string]interface{})
+	rules := run[`tool`].(map[string]interface{})[`driver`].(map[string]interface{})[`rules`].([]interface{})
+	assert.Len(t, rules, 5)
+	results := run[`results`].([]interface{})
+	require.Len(t, results, len(findings))
+	result := results[0].(map[string]interface{})
+	assert.Equal(t, `unused-parameter`, result[`ruleId`])
+	loc := result[`locations`].([]interface{})[0].(map[string]interface{})[`physicalLocation`].(map[string]interface{})
+	assert.Equal(t, `testdata/vpc.yaml`, loc[`artifactLocation`].(map[string]interface{})[`uri`])
+	assert.Equal(t, float64(9), loc[`region`].(map[string]interface{})[`startLine`])
+}
@@ -0,0 +1,372 @@
+package lint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Manifest is a parsed YAML workflow manifest
+type Manifest struct {
+	// Path is the path of the manifest file
+	Path string
+
+	// Workflows are the activities declared at the top of the manifest
+	Workflows []*Activity
+
+	lines []string
+}
+
+// Activity is a workflow or a resource of a manifest
+type Activity struct {
+	// Name is the name of the activity
+	Name string
+
+	// Line is the line of the manifest where the activity is declared
+	Line int
+
+	// Parent is the workflow that contains the activity, or nil for a workflow of the manifest
+	Parent *Activity
+
+	// Type is the name of the type of a resource, e.g. Aws::Vpc. It is empty for a workflow.
+	Type string
+
+	// Input are the parameters that the activity declares
+	Input []*Parameter
+
+	// Output are the values that the activity declares as its output
+	Output []*Parameter
+
+	// When is the guard expression of the activity
+	When string
+
+	// Iteration is the iteration of the activity or nil
+	Iteration *Iteration
+
+	// State is the desired state of a resource
+	State yaml.MapSlice
+
+	// Activities are the activities of a workflow
+	Activities []*Activity
+
+	workflow  bool
+	typespace string
+	indent    int
+}
+
+// Parameter is an input or output of an activity
+type Parameter struct {
+	// Name is the name of the parameter
+	Name string
+
+	// Line is the line of the manifest where the parameter is declared
+	Line int
+
+	// Lookup is the key that an input is looked up with, if any
+	Lookup string
+
+	// Value is the default value of an input, if any
+	Value interface{}
+}
+
+// Iteration repeats an activity
+type Iteration struct {
+	// Name is the name of the output of the iteration
+	Name string
+
+	// Over is the value that is iterated over, often a reference to an input
+	Over interface{}
+}
+
+// varName matches the names that can be referenced by a $ in a state
+var varName = regexp.MustCompile(`\A[a-z]\w*(?:\.[a-z]\w*)*\z`)
+
+// guardWord matches the names in a guard expression
+var guardWord = regexp.MustCompile(`[a-z]\w*`)
+
+// IsWorkflow returns true if the activity is a workflow, false if it is a resource
+func (a *Activity) IsWorkflow() bool {
+	return a.workflow
+}
+
+// Walk calls f with the activity and then with each activity that it contains, depth first
+func (a *Activity) Walk(f func(*Activity)) {
+	f(a)
+	for _, sa := range a.Activities {
+		sa.Walk(f)
+	}
+}
+
+// References returns the names of the values that the activity itself refers to in its state, guard
+// and iteration, and of the inputs that it declares without a lookup or a default value, which it gets
+// from the workflow that contains it
+func (a *Activity) References() []string {
+	return references(a, a.Parent != nil)
+}
+
+func references(a *Activity, inputs bool) []string {
+	var refs []string
+	collectReferences(a.State, func(name string) { refs = append(refs, name) })
+	for _, w := range guardWord.FindAllString(a.When, -1) {
+		if w != `and` && w != `or` && w != `not` {
+			refs = append(refs, w)
+		}
+	}
+	if a.Iteration != nil {
+		switch over := a.Iteration.Over.(type) {
+		case string:
+			refs = append(refs, strings.TrimPrefix(over, `$`))
+		case []interface{}:
+			for _, o := range over {
+				if s, ok := o.(string); ok {
+					refs = append(refs, strings.TrimPrefix(s, `$`))
+				}
+			}
+		}
+	}
+	if inputs {
+		for _, p := range a.Input {
+			if p.Lookup == `` && p.Value == nil {
+				refs = append(refs, p.Name)
+			}
+		}
+	}
+	return refs
+}
+
+// Reference returns the name that the given value of a state refers to, or false if it isn't a reference
+func Reference(v interface{}) (string, bool) {
+	if s, ok := v.(string); ok && len(s) > 1 && s[0] == '$' && varName.MatchString(s[1:]) {
+		// A dotted name refers to the value of the first name
+		return strings.SplitN(s[1:], `.`, 2)[0], true
+	}
+	return ``, false
+}
+
+func collectReferences(v interface{}, f func(string)) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for _, e := range v {
+			collectReferences(e.Value, f)
+		}
+	case []interface{}:
+		for _, e := range v {
+			collectReferences(e, f)
+		}
+	default:
+		if name, ok := Reference(v); ok {
+			f(name)
+		}
+	}
+}
+
+// Locate returns the line of the key that is found by following the given keys from the declaration of
+// the activity, e.g. Locate(a, `state`, `tags`). The line of the deepest key found is returned when the
+// manifest isn't written in block style.
+func (m *Manifest) Locate(a *Activity, keys ...string) int {
+	line, _, _ := m.locate(a, keys...)
+	return line
+}
+
+// locate returns the line and indentation of the key that is found by following the given keys from
+// the declaration of the activity, and true if all keys were found
+func (m *Manifest) locate(a *Activity, keys ...string) (int, int, bool) {
+	line, indent := a.Line, a.indent
+	for _, key := range keys {
+		l, i, ok := m.find(line, indent, key)
+		if !ok {
+			return line, indent, false
+		}
+		line, indent = l, i
+	}
+	return line, indent, true
+}
+
+// find returns the line and indentation of the given key within the block that follows the given line,
+// i.e. before the first line that isn't indented more than the given indentation. Lines are 1-based and
+// line 0 is the start of the manifest.
+func (m *Manifest) find(line, indent int, key string) (int, int, bool) {
+	for i := line; i < len(m.lines); i++ {
+		s := m.lines[i]
+		t := strings.TrimLeft(s, ` `)
+		if t == `` || t[0] == '#' {
+			continue
+		}
+		ind := len(s) - len(t)
+		if ind <= indent {
+			break
+		}
+		t = strings.TrimPrefix(t, `- `)
+		for _, q := range []string{``, `'`, `"`} {
+			if strings.HasPrefix(t, q+key+q+`:`) {
+				return i + 1, ind, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Parse reads the YAML workflow manifest at the given path
+func Parse(path string) (*Manifest, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.MapSlice
+	if err = yaml.Unmarshal(bs, &doc); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err)
+	}
+	m := &Manifest{Path: path, lines: strings.Split(string(bs), "\n")}
+	for _, e := range doc {
+		a, err := m.activity(fmt.Sprint(e.Key), e.Value, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		m.Workflows = append(m.Workflows, a)
+	}
+	return m, nil
+}
+
+// activity creates the activity with the given name from its declaration
+func (m *Manifest) activity(name string, v interface{}, parent *Activity) (*Activity, error) {
+	props, ok := v.(yaml.MapSlice)
+	if !ok {
+		return nil, fmt.Errorf("activity %s is not a hash", name)
+	}
+	names := strings.Split(name, `::`)
+	a := &Activity{Name: names[len(names)-1], Parent: parent}
+	line, indent := 0, -1
+	if parent != nil {
+		line, indent, _ = m.locate(parent, `activities`)
+	}
+	if a.Line, a.indent, ok = m.find(line, indent, name); !ok {
+		a.Line, a.indent = line, indent
+	}
+
+	var activities yaml.MapSlice
+	var typeName string
+	for _, p := range props {
+		key := fmt.Sprint(p.Key)
+		switch key {
+		case `typespace`:
+			a.typespace = fmt.Sprint(p.Value)
+		case `type`:
+			typeName = fmt.Sprint(p.Value)
+		case `when`:
+			a.When = fmt.Sprint(p.Value)
+		case `state`:
+			if a.State, ok = p.Value.(yaml.MapSlice); !ok {
+				a.State = yaml.MapSlice{}
+			}
+		case `activities`:
+			a.workflow = true
+			activities, _ = p.Value.(yaml.MapSlice)
+		case `iteration`:
+			a.Iteration = &Iteration{}
+			if it, ok := p.Value.(yaml.MapSlice); ok {
+				for _, e := range it {
+					switch fmt.Sprint(e.Key) {
+					case `name`:
+						a.Iteration.Name = fmt.Sprint(e.Value)
+					case `over`:
+						a.Iteration.Over = e.Value
+					}
+				}
+			}
+		}
+	}
+	if a.typespace == `` && parent != nil {
+		a.typespace = parent.typespace
+	}
+	if !a.workflow {
+		if a.State == nil {
+			return nil, fmt.Errorf("activity %s has neither activities nor state", name)
+		}
+		if typeName == `` {
+			typeName = a.Name
+			if a.typespace != `` {
+				typeName = a.typespace + `::` + a.Name
+			}
+		}
+		a.Type = capitalize(typeName)
+	}
+
+	for _, p := range props {
+		switch fmt.Sprint(p.Key) {
+		case `input`:
+			a.Input = m.parameters(a, `input`, p.Value)
+		case `output`:
+			a.Output = m.parameters(a, `output`, p.Value)
+		}
+	}
+	if a.Iteration != nil && a.Iteration.Name != `` {
+		// The outputs of each iteration are collected in one output
+		a.Output = []*Parameter{{Name: a.Iteration.Name, Line: m.Locate(a, `iteration`, `name`)}}
+	}
+
+	for _, e := range activities {
+		sa, err := m.activity(fmt.Sprint(e.Key), e.Value, a)
+		if err != nil {
+			return nil, err
+		}
+		a.Activities = append(a.Activities, sa)
+	}
+	return a, nil
+}
+
+// parameters returns the parameters declared by the given input or output field of an activity
+func (m *Manifest) parameters(a *Activity, field string, v interface{}) []*Parameter {
+	var params []*Parameter
+	add := func(name string) *Parameter {
+		p := &Parameter{Name: name, Line: m.Locate(a, field, name)}
+		params = append(params, p)
+		return p
+	}
+	switch v := v.(type) {
+	case string:
+		add(v)
+	case []interface{}:
+		for _, e := range v {
+			switch e := e.(type) {
+			case string:
+				add(e)
+			case []interface{}:
+				// An attribute and its alias
+				if len(e) == 2 {
+					add(fmt.Sprint(e[1]))
+				}
+			}
+		}
+	case yaml.MapSlice:
+		for _, e := range v {
+			p := add(fmt.Sprint(e.Key))
+			if field == `input` {
+				if decl, ok := e.Value.(yaml.MapSlice); ok {
+					for _, d := range decl {
+						switch fmt.Sprint(d.Key) {
+						case `lookup`:
+							p.Lookup = fmt.Sprint(d.Value)
+						case `value`:
+							p.Value = d.Value
+						}
+					}
+				}
+			}
+		}
+	}
+	return params
+}
+
+// capitalize returns the given type name with each segment capitalized, e.g. Aws::Vpc for aws::vpc
+func capitalize(name string) string {
+	segments := strings.Split(name, `::`)
+	for i, s := range segments {
+		if s != `` {
+			segments[i] = strings.ToUpper(s[:1]) + s[1:]
+		}
+	}
+	return strings.Join(segments, `::`)
+}
@@ -0,0 +1,270 @@
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lyraproj/lyra/pkg/leak"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultTaggedTypespaces are the typespaces of the resources that the missing-tags rule checks when
+// the configuration doesn't name any
+var DefaultTaggedTypespaces = []string{`Aws`, `TerraformAws`, `TerraformAzurerm`}
+
+// kind returns workflow or resource
+func kind(a *Activity) string {
+	if a.IsWorkflow() {
+		return `workflow`
+	}
+	return `resource`
+}
+
+// unusedParameter finds inputs that are declared but never referenced
+type unusedParameter struct{}
+
+func (*unusedParameter) ID() string { return `unused-parameter` }
+
+func (*unusedParameter) Description() string {
+	return `An input is declared by an activity but not used by it or by the activities it contains.`
+}
+
+func (*unusedParameter) Level() Level { return Warning }
+
+func (*unusedParameter) Check(m *Manifest, cfg *Config) ([]Finding, error) {
+	var findings []Finding
+	for _, w := range m.Workflows {
+		w.Walk(func(a *Activity) {
+			if len(a.Input) == 0 {
+				return
+			}
+			used := map[string]bool{}
+			for _, name := range references(a, false) {
+				used[name] = true
+			}
+			for _, sa := range a.Activities {
+				sa.Walk(func(d *Activity) {
+					for _, name := range d.References() {
+						used[name] = true
+					}
+				})
+			}
+			for _, p := range a.Input {
+				if !used[p.Name] {
+					findings = append(findings, Finding{Line: p.Line, Message: fmt.Sprintf("input %s of %s %s is never used", p.Name, kind(a), a.Name)})
+				}
+			}
+		})
+	}
+	return findings, nil
+}
+
+// unreferencedOutput finds outputs of activities that no other activity uses and that are not outputs
+// of the workflows that contain them
+type unreferencedOutput struct{}
+
+func (*unreferencedOutput) ID() string { return `unreferenced-output` }
+
+func (*unreferencedOutput) Description() string {
+	return `An output of an activity is neither used by another activity nor an output of its workflow.`
+}
+
+func (*unreferencedOutput) Level() Level { return Warning }
+
+func (*unreferencedOutput) Check(m *Manifest, cfg *Config) ([]Finding, error) {
+	var findings []Finding
+	for _, w := range m.Workflows {
+		w.Walk(func(a *Activity) {
+			if a.Parent == nil || len(a.Output) == 0 {
+				return
+			}
+			used := map[string]bool{}
+			w.Walk(func(o *Activity) {
+				if o != a {
+					for _, name := range o.References() {
+						used[name] = true
+					}
+				}
+			})
+			for p := a.Parent; p != nil; p = p.Parent {
+				for _, o := range p.Output {
+					used[o.Name] = true
+				}
+			}
+			for _, o := range a.Output {
+				if !used[o.Name] {
+					findings = append(findings, Finding{Line: o.Line, Message: fmt.Sprintf("output %s of %s %s is never used", o.Name, kind(a), a.Name)})
+				}
+			}
+		})
+	}
+	return findings, nil
+}
+
+// hardcodedSecret finds values in states and default values of inputs that look like secrets
+type hardcodedSecret struct{}
+
+func (*hardcodedSecret) ID() string { return `hardcoded-secret` }
+
+func (*hardcodedSecret) Description() string {
+	return `A value that looks like a secret is given in plaintext in the manifest.`
+}
+
+func (*hardcodedSecret) Level() Level { return Error }
+
+func (*hardcodedSecret) Check(m *Manifest, cfg *Config) ([]Finding, error) {
+	var findings []Finding
+	for _, w := range m.Workflows {
+		w.Walk(func(a *Activity) {
+			for _, p := range a.Input {
+				if p.Value != nil {
+					// The name of the input tells whether its value is expected to be a secret
+					for _, f := range leak.Scan(toValue(yaml.MapSlice{{Key: p.Name, Value: p.Value}})) {
+						findings = append(findings, Finding{Line: p.Line, Message: fmt.Sprintf("possible plaintext secret in the default value of input %s of %s %s, %s", p.Name, kind(a), a.Name, f)})
+					}
+				}
+			}
+			if a.State != nil {
+				for _, f := range leak.Scan(toValue(a.State)) {
+					key := strings.FieldsFunc(f.Path, func(r rune) bool { return r == '.' || r == '[' })[0]
+					findings = append(findings, Finding{Line: m.Locate(a, `state`, key), Message: fmt.Sprintf("possible plaintext secret in the state of resource %s, %s", a.Name, f)})
+				}
+			}
+		})
+	}
+	return findings, nil
+}
+
+// toValue converts a value of a manifest to a value that can be scanned for secrets. References to
+// other values are not secrets and become undef.
+func toValue(v interface{}) eval.Value {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		entries := make([]*types.HashEntry, len(v))
+		for i, e := range v {
+			entries[i] = types.WrapHashEntry2(fmt.Sprint(e.Key), toValue(e.Value))
+		}
+		return types.WrapHash(entries)
+	case []interface{}:
+		values := make([]eval.Value, len(v))
+		for i, e := range v {
+			values[i] = toValue(e)
+		}
+		return types.WrapValues(values)
+	case string:
+		if _, ok := Reference(v); ok {
+			return eval.UNDEF
+		}
+		return types.WrapString(v)
+	case nil:
+		return eval.UNDEF
+	}
+	// Numbers and booleans are never secrets
+	return eval.UNDEF
+}
+
+// missingTags finds resources of the tagged typespaces that have no tags, or whose tags lack a
+// required key
+type missingTags struct{}
+
+func (*missingTags) ID() string { return `missing-tags` }
+
+func (*missingTags) Description() string {
+	return `A resource of a provider that supports tags has no tags, or lacks a required tag.`
+}
+
+func (*missingTags) Level() Level { return Warning }
+
+func (*missingTags) Check(m *Manifest, cfg *Config) ([]Finding, error) {
+	typespaces := cfg.TaggedTypespaces
+	if typespaces == nil {
+		typespaces = DefaultTaggedTypespaces
+	}
+	tagged := map[string]bool{}
+	for _, ts := range typespaces {
+		tagged[strings.ToLower(ts)] = true
+	}
+
+	var findings []Finding
+	for _, w := range m.Workflows {
+		w.Walk(func(a *Activity) {
+			if a.IsWorkflow() || !tagged[strings.ToLower(strings.Split(a.Type, `::`)[0])] {
+				return
+			}
+			var tags interface{}
+			key := ``
+			for _, e := range a.State {
+				if k := fmt.Sprint(e.Key); strings.ToLower(k) == `tags` {
+					key, tags = k, e.Value
+				}
+			}
+			if key == `` {
+				findings = append(findings, Finding{Line: a.Line, Message: fmt.Sprintf("resource %s of type %s has no tags", a.Name, a.Type)})
+				return
+			}
+			hash, ok := tags.(yaml.MapSlice)
+			if !ok {
+				// The tags are given by a reference and are checked where they are declared
+				return
+			}
+			var missing []string
+			for _, rt := range cfg.RequiredTags {
+				found := false
+				for _, e := range hash {
+					if fmt.Sprint(e.Key) == rt {
+						found = true
+						break
+					}
+				}
+				if !found {
+					missing = append(missing, rt)
+				}
+			}
+			if len(missing) > 0 {
+				sort.Strings(missing)
+				findings = append(findings, Finding{Line: m.Locate(a, `state`, key), Message: fmt.Sprintf("tags of resource %s lack %s", a.Name, strings.Join(missing, `, `))})
+			}
+		})
+	}
+	return findings, nil
+}
+
+// deprecatedType finds resources whose type is deprecated
+type deprecatedType struct{}
+
+func (*deprecatedType) ID() string { return `deprecated-type` }
+
+func (*deprecatedType) Description() string {
+	return `A resource has a type that is deprecated.`
+}
+
+func (*deprecatedType) Level() Level { return Warning }
+
+func (*deprecatedType) Check(m *Manifest, cfg *Config) ([]Finding, error) {
+	deprecated := make(map[string]string, len(cfg.DeprecatedTypes))
+	for t, r := range cfg.DeprecatedTypes {
+		deprecated[strings.ToLower(t)] = r
+	}
+
+	var findings []Finding
+	for _, w := range m.Workflows {
+		w.Walk(func(a *Activity) {
+			if a.IsWorkflow() {
+				return
+			}
+			replacement, ok := deprecated[strings.ToLower(a.Type)]
+			if !ok {
+				return
+			}
+			msg := fmt.Sprintf("resource %s has the deprecated type %s", a.Name, a.Type)
+			if replacement != `` {
+				msg += `, use ` + replacement + ` instead`
+			}
+			findings = append(findings, Finding{Line: a.Line, Message: msg})
+		})
+	}
+	return findings, nil
+}
@@ -0,0 +1,90 @@
+package lint
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+)
+
+// sarifSchema and sarifVersion identify the version of SARIF that WriteSARIF writes
+const (
+	sarifSchema  = `https://json.schemastore.org/sarif-2.1.0.json`
+	sarifVersion = `2.1.0`
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string       `json:"id"`
+	ShortDescription     sarifMessage `json:"shortDescription"`
+	DefaultConfiguration struct {
+		Level Level `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     Level           `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// WriteSARIF writes the findings of the given rules as a SARIF log, the format that code scanning
+// services such as GitHub code scanning read. File paths are written relative to the current directory,
+// which should be the root of the repository.
+func WriteSARIF(w io.Writer, rules []Rule, findings []Finding) error {
+	driver := sarifDriver{Name: `lyra`, InformationURI: `https://github.com/lyraproj/lyra`, Rules: make([]sarifRule, len(rules))}
+	for i, r := range rules {
+		driver.Rules[i].ID = r.ID()
+		driver.Rules[i].ShortDescription.Text = r.Description()
+		driver.Rules[i].DefaultConfiguration.Level = r.Level()
+	}
+	results := make([]sarifResult, len(findings))
+	for i, f := range findings {
+		loc := sarifLocation{}
+		loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(filepath.Clean(f.File))
+		if f.Line > 0 {
+			loc.PhysicalLocation.Region = &sarifRegion{StartLine: f.Line}
+		}
+		results[i] = sarifResult{RuleID: f.RuleID, Level: f.Level, Message: sarifMessage{Text: f.Message}, Locations: []sarifLocation{loc}}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent(``, `  `)
+	return enc.Encode(&sarifLog{Schema: sarifSchema, Version: sarifVersion, Runs: []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}}})
+}
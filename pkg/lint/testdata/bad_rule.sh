@@ -0,0 +1,3 @@
+#!/bin/sh
+echo 'no rule here' >&2
+exit 1
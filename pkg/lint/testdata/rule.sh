@@ -0,0 +1,7 @@
+#!/bin/sh
+# A lint rule that reports every manifest
+if [ "$1" = "--describe" ]; then
+  echo '{"id": "acme-review", "description": "All manifests are reviewed.", "level": "note"}'
+else
+  echo "[{\"message\": \"review $1\", \"line\": 3, \"ruleId\": \"other\"}]"
+fi
@@ -0,0 +1,135 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lyraproj/lyra/pkg/grpc"
+	"github.com/lyraproj/lyra/pkg/loader/integrity"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// MetadataCacheEnvVar names the directory where the metadata of plugins is cached. Caching is
+// disabled when it is set to "off".
+const MetadataCacheEnvVar = "LYRA_PLUGIN_CACHE"
+
+// cacheFormat is part of every fingerprint so that entries written by incompatible versions are ignored
+const cacheFormat = `lyra-metadata-1`
+
+// metadataCache stores the metadata of plugins keyed by a fingerprint of the plugin file. A plugin
+// whose fingerprint is found in the cache doesn't need to be started until one of its services is used.
+type metadataCache struct {
+	dir string
+}
+
+// WithMetadataCache makes the loader cache plugin metadata in the given directory. It takes precedence
+// over the LYRA_PLUGIN_CACHE environment variable. An empty dir disables caching.
+func WithMetadataCache(dir string) Option {
+	return func(l *Loader) {
+		l.cache = &metadataCache{dir: dir}
+	}
+}
+
+// cacheFromEnv returns the cache named by the LYRA_PLUGIN_CACHE environment variable or, when it
+// isn't set, a cache in the lyra directory of the user cache directory
+func cacheFromEnv() *metadataCache {
+	dir, ok := os.LookupEnv(MetadataCacheEnvVar)
+	if ok && dir == `off` {
+		return &metadataCache{}
+	}
+	if !ok || dir == `` {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return &metadataCache{}
+		}
+		dir = filepath.Join(userDir, `lyra`, `metadata`)
+	}
+	return &metadataCache{dir: dir}
+}
+
+func (mc *metadataCache) enabled() bool {
+	return mc != nil && mc.dir != ``
+}
+
+// fingerprint returns a key that identifies the plugin started using the given command and arguments.
+// The key changes when the plugin file changes.
+func fingerprint(p *Plugin, cmd string, cmdArgs []string) (string, error) {
+	sum, err := checksum(p)
+	if err != nil {
+		return ``, err
+	}
+	h := sha256.New()
+	for _, s := range append([]string{cacheFormat, sum, p.Path, cmd}, cmdArgs...) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// selfChecksum is the checksum of this binary. It provides the embedded plugins and is large, so its
+// checksum is computed once rather than for each embedded plugin and each run.
+var selfChecksum struct {
+	once sync.Once
+	sum  string
+	err  error
+}
+
+// checksum returns the checksum of the file that holds the given plugin
+func checksum(p *Plugin) (string, error) {
+	if p.Source != SourceEmbedded {
+		return integrity.Sha256sumFile(p.Path)
+	}
+	selfChecksum.once.Do(func() {
+		selfChecksum.sum, selfChecksum.err = integrity.Sha256sumFile(p.Path)
+	})
+	return selfChecksum.sum, selfChecksum.err
+}
+
+func (mc *metadataCache) path(key string) string {
+	return filepath.Join(mc.dir, key+`.pb`)
+}
+
+// get returns the cached metadata for the given key. The last return value is false when there is
+// no usable entry.
+func (mc *metadataCache) get(c eval.Context, key string) (typeSet eval.TypeSet, defs []serviceapi.Definition, ok bool) {
+	bs, err := ioutil.ReadFile(mc.path(key))
+	if err != nil {
+		return nil, nil, false
+	}
+	defer func() {
+		// An entry that refers to types that are no longer known is stale
+		if r := recover(); r != nil {
+			typeSet, defs, ok = nil, nil, false
+		}
+	}()
+	typeSet, defs, err = grpc.DecodeMetadata(c, bs)
+	return typeSet, defs, err == nil
+}
+
+// put stores the given encoded metadata under the given key
+func (mc *metadataCache) put(key string, bs []byte) error {
+	if err := os.MkdirAll(mc.dir, 0700); err != nil {
+		return err
+	}
+	// Write and rename so that concurrent runs never see a partial entry
+	tmp, err := ioutil.TempFile(mc.dir, key+`.*.tmp`)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(bs)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), mc.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package loader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	hclog "github.com/hashicorp/go-hclog"
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	sdkgrpc "github.com/lyraproj/servicesdk/grpc"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/servicesdk/servicepb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	// Provides serviceapi.NewDefinition
+	_ "github.com/lyraproj/servicesdk/service"
+)
+
+func Test_Fingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "goplugin-test")
+	require.Nil(t, ioutil.WriteFile(file, []byte("v1"), 0755))
+	p := &Plugin{Name: "goplugin-test", Path: file, Source: SourceFile}
+
+	key, err := fingerprint(p, file, nil)
+	require.Nil(t, err)
+	again, err := fingerprint(p, file, nil)
+	require.Nil(t, err)
+	assert.Equal(t, key, again)
+
+	withArgs, err := fingerprint(p, file, []string{"--debug"})
+	require.Nil(t, err)
+	assert.NotEqual(t, key, withArgs)
+
+	require.Nil(t, ioutil.WriteFile(file, []byte("v2"), 0755))
+	changed, err := fingerprint(p, file, nil)
+	require.Nil(t, err)
+	assert.NotEqual(t, key, changed)
+
+	_, err = fingerprint(&Plugin{Path: filepath.Join(dir, "missing")}, "missing", nil)
+	assert.Error(t, err)
+}
+
+func Test_MetadataCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mc := &metadataCache{dir: filepath.Join(dir, "metadata")}
+	eval.Puppet.Do(func(c eval.Context) {
+		def := serviceapi.NewDefinition(
+			eval.NewTypedName(eval.NsActivity, "test::step"),
+			eval.NewTypedName(eval.NsService, "Test"),
+			types.SingletonHash2("style", types.WrapString("action")))
+
+		// The encoding used by the service protocol
+		bs, err := proto.Marshal(&servicepb.MetadataResponse{Definitions: sdkgrpc.ToDataPB(types.WrapValues([]eval.Value{def}))})
+		require.Nil(t, err)
+
+		_, _, ok := mc.get(c, "key")
+		assert.False(t, ok)
+
+		require.Nil(t, mc.put("key", bs))
+		_, defs, ok := mc.get(c, "key")
+		require.True(t, ok)
+		require.Len(t, defs, 1)
+		assert.Equal(t, def.Identifier(), defs[0].Identifier())
+		assert.Equal(t, def.ServiceId(), defs[0].ServiceId())
+
+		require.Nil(t, ioutil.WriteFile(mc.path("key"), []byte("garbage"), 0600))
+		_, _, ok = mc.get(c, "key")
+		assert.False(t, ok)
+	})
+}
+
+func Test_CacheFromEnv(t *testing.T) {
+	defer os.Unsetenv(MetadataCacheEnvVar)
+
+	os.Setenv(MetadataCacheEnvVar, "off")
+	assert.False(t, cacheFromEnv().enabled())
+
+	os.Setenv(MetadataCacheEnvVar, "/var/cache/lyra")
+	assert.Equal(t, &metadataCache{dir: "/var/cache/lyra"}, cacheFromEnv())
+
+	l := New(hclog.NewNullLogger(), eval.Puppet.SystemLoader(), WithMetadataCache(""))
+	assert.False(t, l.cache.enabled())
+}
+
+func Test_EmbeddedPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer plugin.CleanupClients()
+
+	// The test binary serves the test plugin when it is started as an embedded plugin
+	args := []string{"--debug", "plugin", testPluginArg}
+	serviceID := eval.NewTypedName(eval.NsService, "Test")
+	newLoader := func() *Loader {
+		return New(hclog.NewNullLogger(), eval.Puppet.SystemLoader(), WithPluginTLS(TLSOff), WithMetadataCache(dir))
+	}
+	eval.Puppet.Do(func(c eval.Context) {
+		// The process that provided the metadata is kept for the run
+		l := newLoader()
+		require.Nil(t, l.loadEmbeddedPlugin(c, os.Args[0], args...))
+		require.Len(t, l.services, 1)
+		assert.True(t, l.services[serviceID.MapKey()] == l.loadService(c, serviceID))
+
+		// The next run finds the metadata in the cache and starts the plugin when it is used
+		l = newLoader()
+		require.Nil(t, l.loadEmbeddedPlugin(c, os.Args[0], args...))
+		assert.Empty(t, l.services)
+		_, ok := l.DefiningLoader.LoadEntry(c, eval.NewTypedName(eval.NsDefinition, "Test::Echo")).Value().(serviceapi.Definition)
+		assert.True(t, ok)
+		s := l.loadService(c, serviceID)
+		require.NotNil(t, s)
+		assert.Equal(t, "hello", s.Invoke(c, "Test::Echo", "echo", types.WrapString("hello")).String())
+	})
+}
@@ -0,0 +1,130 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/lyraproj/lyra/pkg/grpc"
+	"github.com/lyraproj/lyra/pkg/loader/integrity"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// manifestCacheFormat is part of every manifest key so that entries written by incompatible versions are ignored
+const manifestCacheFormat = `lyra-manifest-1`
+
+// compiledManifest is the service of a manifest whose type set and definitions are known. The metadata
+// of a manifest that hasn't changed since it was last compiled is found in the metadata cache, and the
+// manifest is then compiled when its service is first invoked rather than when it is loaded. A run that
+// doesn't use a manifest never compiles it.
+type compiledManifest struct {
+	loader      *Loader
+	frontend    Frontend
+	file        string
+	id          eval.TypedName
+	typeSet     eval.TypeSet
+	definitions []serviceapi.Definition
+	lock        sync.Mutex
+	service     serviceapi.Service
+}
+
+// Service returns the service of the compiled manifest. The manifest is compiled on first use.
+func (m *compiledManifest) Service(c eval.Context) serviceapi.Service {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.service == nil {
+		m.loader.logger.Debug("compiling cached manifest", "file", m.file, "frontend", m.frontend.Name())
+		service, err := m.frontend.Parse(c, m.file)
+		if err != nil {
+			panic(err)
+		}
+		m.service = service
+	}
+	return m.service
+}
+
+func (m *compiledManifest) Identifier(eval.Context) eval.TypedName {
+	return m.id
+}
+
+func (m *compiledManifest) Metadata(eval.Context) (eval.TypeSet, []serviceapi.Definition) {
+	return m.typeSet, m.definitions
+}
+
+func (m *compiledManifest) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	return m.Service(c).Invoke(c, identifier, name, arguments...)
+}
+
+func (m *compiledManifest) State(c eval.Context, name string, input eval.OrderedMap) eval.PuppetObject {
+	return m.Service(c).State(c, name, input)
+}
+
+// manifestKey returns the key that the metadata of the given manifest is cached under. The key changes
+// when the manifest, a file in the types directory next to it, or the set of loaded plugins changes. It
+// is empty when the metadata cannot be cached.
+func (l *Loader) manifestKey(f Frontend, file string) string {
+	if !l.cache.enabled() || l.pluginSetUnknown {
+		return ``
+	}
+	sum, err := integrity.Sha256sumFile(file)
+	if err != nil {
+		return ``
+	}
+	typeFiles, err := filepath.Glob(filepath.Join(filepath.Dir(file), `types`, `*`))
+	if err != nil {
+		return ``
+	}
+	h := sha256.New()
+	parts := []string{manifestCacheFormat, f.Name(), file, sum}
+	for _, tf := range typeFiles {
+		if tsum, err := integrity.Sha256sumFile(tf); err == nil {
+			parts = append(parts, tf, tsum)
+		}
+	}
+	plugins := append([]string{}, l.pluginSet...)
+	sort.Strings(plugins)
+	for _, s := range append(parts, plugins...) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedManifest returns the service of a manifest whose metadata is found in the metadata cache under
+// the given key
+func (l *Loader) cachedManifest(c eval.Context, f Frontend, file, key string) (serviceapi.Service, bool) {
+	if key == `` {
+		return nil, false
+	}
+	typeSet, defs, ok := l.cache.get(c, key)
+	if !ok || len(defs) == 0 {
+		return nil, false
+	}
+	l.logger.Debug("using cached manifest", "file", file, "frontend", f.Name())
+	return &compiledManifest{loader: l, frontend: f, file: file, id: defs[0].ServiceId(), typeSet: typeSet, definitions: defs}, true
+}
+
+// cacheManifest caches the metadata of the service of a manifest that has been compiled under the given
+// key and returns a service that provides the metadata without asking the compiled service for it again
+func (l *Loader) cacheManifest(c eval.Context, f Frontend, file, key string, service serviceapi.Service) serviceapi.Service {
+	if key == `` {
+		return service
+	}
+	typeSet, defs := service.Metadata(c)
+	m := &compiledManifest{loader: l, frontend: f, file: file, id: service.Identifier(c), typeSet: typeSet, definitions: defs, service: service}
+	if len(defs) == 0 || defs[0].ServiceId().MapKey() != m.id.MapKey() {
+		// The service can't be recreated from its definitions
+		return m
+	}
+	bs, err := grpc.EncodeMetadata(typeSet, defs)
+	if err == nil {
+		err = l.cache.put(key, bs)
+	}
+	if err != nil {
+		l.logger.Debug("unable to cache manifest", "file", file, "err", err)
+	}
+	return m
+}
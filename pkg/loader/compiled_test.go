@@ -0,0 +1,80 @@
+package loader
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingFrontend counts the manifests parsed by a front-end
+type countingFrontend struct {
+	Frontend
+	parsed int
+}
+
+func (f *countingFrontend) Parse(c eval.Context, path string) (serviceapi.Service, error) {
+	f.parsed++
+	return f.Frontend.Parse(c, path)
+}
+
+func Test_CompiledManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := "yamlstream/testdata/workflow.yaml"
+	f := &countingFrontend{Frontend: &yamlFrontend{puppetFrontend{name: "yaml", extensions: []string{".yaml"}}}}
+	newLoader := func() *Loader {
+		return &Loader{logger: hclog.NewNullLogger(), cache: &metadataCache{dir: dir}, pluginSet: []string{"plugin"}}
+	}
+	eval.Puppet.Do(func(c eval.Context) {
+		// The first run parses the manifest and caches its metadata
+		l := newLoader()
+		s, err := l.parseManifest(c, f, file)
+		require.Nil(t, err)
+		assert.Equal(t, 1, f.parsed)
+		_, err = os.Stat(l.cache.path(l.manifestKey(f, file)))
+		assert.Nil(t, err)
+
+		// The next run finds the metadata in the cache and parses the manifest when its service is used
+		l = newLoader()
+		cached, err := l.parseManifest(c, f, file)
+		require.Nil(t, err)
+		assert.Equal(t, 1, f.parsed)
+		assert.Equal(t, s.Identifier(c).Name(), cached.Identifier(c).Name())
+		_, defs := cached.Metadata(c)
+		require.Len(t, defs, 1)
+		assert.Equal(t, "people", defs[0].Identifier().Name())
+
+		assert.Equal(t, s.Identifier(c).Name(), cached.(*compiledManifest).Service(c).Identifier(c).Name())
+		assert.Equal(t, 2, f.parsed)
+		cached.(*compiledManifest).Service(c)
+		assert.Equal(t, 2, f.parsed)
+	})
+}
+
+func Test_ManifestKey(t *testing.T) {
+	file := "yamlstream/testdata/workflow.yaml"
+	f := &yamlFrontend{puppetFrontend{name: "yaml", extensions: []string{".yaml"}}}
+	l := &Loader{cache: &metadataCache{dir: "cache"}, pluginSet: []string{"a", "b"}}
+	key := l.manifestKey(f, file)
+	assert.NotEqual(t, "", key)
+	assert.NotEqual(t, key, l.manifestKey(f, "yamlstream/testdata/anchors.yaml"))
+
+	// The order in which plugins are loaded doesn't matter but the plugins do
+	l.pluginSet = []string{"b", "a"}
+	assert.Equal(t, key, l.manifestKey(f, file))
+	l.pluginSet = []string{"a", "c"}
+	assert.NotEqual(t, key, l.manifestKey(f, file))
+
+	assert.Equal(t, "", l.manifestKey(f, "yamlstream/testdata/missing.yaml"))
+	l.pluginSetUnknown = true
+	assert.Equal(t, "", l.manifestKey(f, file))
+	assert.Equal(t, "", (&Loader{}).manifestKey(f, file))
+}
@@ -0,0 +1,122 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// PluginUserEnvVar names the user that plugins are executed as. The value is on the form
+// "user[:group]" where user and group are names or numeric ids.
+const PluginUserEnvVar = "LYRA_PLUGIN_USER"
+
+// Credential is the user and group that plugin processes are executed as
+type Credential struct {
+	Uid uint32
+	Gid uint32
+}
+
+// WithPluginCredential makes the loader start all plugin processes as the given user and group
+// so that they cannot access the files of the user running lyra. Lyra must run with sufficient
+// privileges to change user. On Linux, the process loses all capabilities when it switches to
+// an unprivileged user. It takes precedence over the LYRA_PLUGIN_USER environment variable.
+func WithPluginCredential(credential *Credential) Option {
+	return func(l *Loader) {
+		l.credential = credential
+	}
+}
+
+// LookupCredential returns the credential for the given "user[:group]" specification. The primary
+// group of the user is used when no group is given.
+func LookupCredential(spec string) (*Credential, error) {
+	userName := spec
+	groupName := ``
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		userName = spec[:i]
+		groupName = spec[i+1:]
+	}
+	if userName == `` {
+		return nil, fmt.Errorf("invalid plugin user '%s': no user given", spec)
+	}
+
+	cred := &Credential{}
+	u, err := lookupUser(userName)
+	if err == nil {
+		if cred.Uid, err = parseID(u.Uid); err != nil {
+			return nil, err
+		}
+		if cred.Gid, err = parseID(u.Gid); err != nil {
+			return nil, err
+		}
+	} else {
+		// A numeric id need not be known to the user database
+		uid, perr := parseID(userName)
+		if perr != nil {
+			return nil, fmt.Errorf("invalid plugin user '%s': %s", spec, err)
+		}
+		if groupName == `` {
+			return nil, fmt.Errorf("invalid plugin user '%s': a group must be given for unknown user id %d", spec, uid)
+		}
+		cred.Uid = uid
+	}
+
+	if groupName != `` {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				gid, perr := parseID(groupName)
+				if perr != nil {
+					return nil, fmt.Errorf("invalid plugin user '%s': %s", spec, err)
+				}
+				cred.Gid = gid
+				return cred, nil
+			}
+		}
+		if cred.Gid, err = parseID(g.Gid); err != nil {
+			return nil, err
+		}
+	}
+	return cred, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+func parseID(id string) (uint32, error) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a numeric id", id)
+	}
+	return uint32(n), nil
+}
+
+// credentialFromEnv returns the credential named by the LYRA_PLUGIN_USER environment variable
+// or nil if the variable is not set
+func credentialFromEnv() (*Credential, error) {
+	spec, ok := os.LookupEnv(PluginUserEnvVar)
+	if !ok || spec == `` {
+		return nil, nil
+	}
+	return LookupCredential(spec)
+}
+
+// applyCredential makes the given command run as the configured plugin user
+func (l *Loader) applyCredential(cmd *exec.Cmd) error {
+	if l.credentialErr != nil {
+		return l.credentialErr
+	}
+	if l.credential == nil {
+		return nil
+	}
+	return setCredential(cmd, l.credential)
+}
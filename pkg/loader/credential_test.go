@@ -0,0 +1,43 @@
+package loader
+
+import (
+	"errors"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LookupCredential(t *testing.T) {
+	c, err := LookupCredential("4711:4712")
+	require.Nil(t, err)
+	assert.Equal(t, &Credential{Uid: 4711, Gid: 4712}, c)
+
+	current, err := user.Current()
+	require.Nil(t, err)
+	c, err = LookupCredential(current.Username)
+	require.Nil(t, err)
+	assert.Equal(t, current.Uid, strconv.Itoa(int(c.Uid)))
+
+	c, err = LookupCredential(current.Username + ":4712")
+	require.Nil(t, err)
+	assert.Equal(t, uint32(4712), c.Gid)
+
+	for _, spec := range []string{"", ":4712", "4711", "no-such-user:4712", "4711:no-such-group"} {
+		_, err = LookupCredential(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func Test_ApplyCredentialError(t *testing.T) {
+	l := &Loader{credentialErr: errors.New("unknown user")}
+	assert.Error(t, l.applyCredential(exec.Command("true")))
+
+	l = &Loader{}
+	cmd := exec.Command("true")
+	assert.Nil(t, l.applyCredential(cmd))
+	assert.Nil(t, cmd.SysProcAttr)
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package loader
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setCredential(cmd *exec.Cmd, credential *Credential) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: credential.Uid, Gid: credential.Gid, Groups: []uint32{}}
+	return nil
+}
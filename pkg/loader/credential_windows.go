@@ -0,0 +1,10 @@
+package loader
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func setCredential(cmd *exec.Cmd, credential *Credential) error {
+	return fmt.Errorf("running plugins as another user is not supported on Windows")
+}
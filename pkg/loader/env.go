@@ -0,0 +1,106 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/lyraproj/lyra/pkg/grpc"
+)
+
+// PluginExecCommand is the hidden command of the lyra binary that starts a plugin with a scoped
+// environment, see RunPlugin
+const PluginExecCommand = "plugin-exec"
+
+// DefaultPluginEnv are glob patterns for the environment variables that are passed to all plugins.
+// Additional variables are granted to individual plugins using the env rules of the plugin policy.
+var DefaultPluginEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_*", "TZ", "TERM", "TMPDIR", "TMP", "TEMP",
+	"SYSTEMROOT", "SystemRoot", "http_proxy", "https_proxy", "no_proxy", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY",
+	"SSL_CERT_FILE", "SSL_CERT_DIR", "LYRA_*",
+}
+
+// handshakeEnv are patterns for the variables that go-plugin uses to pass the handshake to a plugin.
+// They are always passed.
+var handshakeEnv = []string{"PLUGIN_*", grpc.TokenEnvVar}
+
+// EnvRule grants the plugins matched by its rule access to the environment variables matched by
+// the glob patterns in Allow. A rule without match fields applies to all plugins.
+type EnvRule struct {
+	Rule  `yaml:",inline"`
+	Allow []string `yaml:"allow"`
+}
+
+// EnvFor returns the patterns of the environment variables that the given plugin may see. A nil
+// policy returns the DefaultPluginEnv.
+func (p *Policy) EnvFor(plugin *Plugin) ([]string, error) {
+	allow := append([]string{}, DefaultPluginEnv...)
+	if p == nil {
+		return allow, nil
+	}
+	sums := map[string]string{}
+	for _, r := range p.Env {
+		ok, err := r.matches(plugin, sums)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			allow = append(allow, r.Allow...)
+		}
+	}
+	return allow, nil
+}
+
+// scopedCommand returns a command that starts the given plugin command using the plugin-exec command
+// of this binary. The environment of the plugin will only contain the variables that match one of the
+// allow patterns. The variables cannot be removed by the loader itself since go-plugin always adds
+// the environment of the current process to the environment of the plugin.
+func scopedCommand(cmd string, cmdArgs []string, allow []string) (string, []string) {
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+	args := []string{PluginExecCommand, `--allow-env`, strings.Join(allow, `,`), `--`, cmd}
+	return self, append(args, cmdArgs...)
+}
+
+// RunPlugin replaces the current process with the given plugin command. The plugin only sees the
+// environment variables that match one of the allow patterns or that are needed for the plugin
+// handshake. RunPlugin only returns if the plugin cannot be started.
+func RunPlugin(allow []string, cmd string, cmdArgs []string) error {
+	path, err := exec.LookPath(cmd)
+	if err != nil {
+		return err
+	}
+	return execPlugin(path, cmdArgs, filterEnv(os.Environ(), append(allow, handshakeEnv...)))
+}
+
+// filterEnv returns the entries of the given "name=value" environment whose names match at least one
+// of the given patterns
+func filterEnv(env []string, allow []string) []string {
+	filtered := make([]string, 0, len(env))
+	for _, e := range env {
+		name := e
+		if i := strings.IndexByte(e, '='); i > 0 {
+			name = e[:i]
+		}
+		for _, pattern := range allow {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				filtered = append(filtered, e)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+func validateEnvPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ``); err != nil {
+			return fmt.Errorf("bad environment variable pattern '%s': %s", pattern, err)
+		}
+	}
+	return nil
+}
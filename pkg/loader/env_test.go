@@ -0,0 +1,67 @@
+package loader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EnvFor(t *testing.T) {
+	aws := &Plugin{Name: "goplugin-aws", Path: "/plugins/goplugin-aws", Source: SourceFile}
+	k8s := &Plugin{Name: "goplugin-tf-kubernetes", Path: "/plugins/goplugin-tf-kubernetes", Source: SourceFile}
+
+	allow, err := (*Policy)(nil).EnvFor(aws)
+	require.Nil(t, err)
+	assert.Equal(t, DefaultPluginEnv, allow)
+
+	p := &Policy{Env: []EnvRule{
+		{Rule: Rule{Name: "goplugin-aws"}, Allow: []string{"AWS_*"}},
+		{Allow: []string{"GOOGLE_APPLICATION_CREDENTIALS"}},
+	}}
+	allow, err = p.EnvFor(aws)
+	require.Nil(t, err)
+	assert.Contains(t, allow, "AWS_*")
+	assert.Contains(t, allow, "GOOGLE_APPLICATION_CREDENTIALS")
+
+	allow, err = p.EnvFor(k8s)
+	require.Nil(t, err)
+	assert.NotContains(t, allow, "AWS_*")
+	assert.Contains(t, allow, "GOOGLE_APPLICATION_CREDENTIALS")
+}
+
+func Test_FilterEnv(t *testing.T) {
+	env := []string{"PATH=/bin", "AWS_SECRET_ACCESS_KEY=secret", "LC_ALL=C", "PLUGIN_MIN_PORT=10000", "EMPTY="}
+	assert.Equal(t,
+		[]string{"PATH=/bin", "LC_ALL=C", "PLUGIN_MIN_PORT=10000"},
+		filterEnv(env, append(DefaultPluginEnv, handshakeEnv...)))
+	assert.Equal(t, env, filterEnv(env, []string{"*"}))
+	assert.Empty(t, filterEnv(env, nil))
+}
+
+func Test_ScopedCommand(t *testing.T) {
+	cmd, args := scopedCommand("goplugin-aws", []string{"--debug"}, []string{"PATH", "AWS_*"})
+	self, err := os.Executable()
+	require.Nil(t, err)
+	assert.Equal(t, self, cmd)
+	assert.Equal(t, []string{PluginExecCommand, "--allow-env", "PATH,AWS_*", "--", "goplugin-aws", "--debug"}, args)
+}
+
+func Test_LoadPolicyEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "policy.yaml")
+	require.Nil(t, ioutil.WriteFile(file, []byte("env:\n  - name: goplugin-aws\n    allow: [AWS_*]\n  - allow: [HOSTNAME]\n"), 0644))
+	p, err := LoadPolicy(file)
+	require.Nil(t, err)
+	assert.Equal(t, &Policy{Env: []EnvRule{{Rule: Rule{Name: "goplugin-aws"}, Allow: []string{"AWS_*"}}, {Allow: []string{"HOSTNAME"}}}}, p)
+
+	require.Nil(t, ioutil.WriteFile(file, []byte("env:\n  - allow: ['[']\n"), 0644))
+	_, err = LoadPolicy(file)
+	assert.Error(t, err)
+}
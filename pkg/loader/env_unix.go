@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package loader
+
+import "syscall"
+
+func execPlugin(path string, args []string, env []string) error {
+	return syscall.Exec(path, append([]string{path}, args...), env)
+}
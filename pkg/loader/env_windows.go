@@ -0,0 +1,23 @@
+package loader
+
+import (
+	"os"
+	"os/exec"
+)
+
+// execPlugin runs the plugin as a child process since Windows cannot replace the current process
+func execPlugin(path string, args []string, env []string) error {
+	cmd := exec.Command(path, args...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
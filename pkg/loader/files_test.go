@@ -66,3 +66,14 @@ func Test_FindFile(t *testing.T) {
 		})
 	}
 }
+
+func Test_FindFilesOrder(t *testing.T) {
+	l := Loader{
+		pluginPath: []string{"testdata/nested", "testdata/invaliddir", "testdata/files"},
+		logger:     logger.Initialise(logger.Spec{Name: "find-files", Level: "debug", Output: os.Stderr}),
+	}
+	expected := []string{"testdata/nested/types/prefix-c", "testdata/nested/prefix-d", "testdata/files/prefix-a", "testdata/files/prefix-b"}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, expected, l.findFiles("prefix-*"))
+	}
+}
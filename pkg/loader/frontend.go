@@ -0,0 +1,375 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/lyraproj/lyra/pkg/loader/yamlstream"
+	"github.com/lyraproj/lyra/pkg/signing"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/threadlocal"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/puppet-workflow/puppet"
+	"github.com/lyraproj/puppet-workflow/yaml"
+	sdkservice "github.com/lyraproj/servicesdk/service"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// Frontend is implemented by everything that is able to turn a workflow manifest of a particular
+// format into a service and its definitions. Front-ends are registered with RegisterFrontend (or
+// WithFrontend for a single loader) which enables support for formats that live outside of this
+// repository.
+type Frontend interface {
+	// Name returns the name of the manifest format, e.g. "puppet" or "yaml"
+	Name() string
+
+	// Patterns returns the file name glob patterns used when searching the plugin path for manifests
+	Patterns() []string
+
+	// Detect returns true if the file at the given path is a manifest that this front-end can parse
+	Detect(path string) bool
+
+	// Parse parses the manifest at the given path and returns the service that provides its definitions
+	Parse(c eval.Context, path string) (serviceapi.Service, error)
+
+	// RegisterDefinitions registers the service returned by Parse and its definitions with the given registry
+	RegisterDefinitions(c eval.Context, r Registry, service serviceapi.Service) error
+}
+
+// Registry is the part of the Loader that is made available to front-ends
+type Registry interface {
+	// RegisterService makes the given service loadable using its identifier
+	RegisterService(c eval.Context, service serviceapi.Service)
+
+	// RegisterMetadata registers all definitions found in the metadata of the given service
+	RegisterMetadata(c eval.Context, service serviceapi.Service)
+}
+
+var (
+	frontendsLock sync.Mutex
+	frontends     []Frontend
+)
+
+func init() {
+	RegisterFrontend(&puppetFrontend{name: `puppet`, extensions: []string{`.pp`}})
+	RegisterFrontend(&yamlFrontend{puppetFrontend{name: `yaml`, extensions: []string{`.yaml`}}})
+}
+
+// RegisterFrontend makes a front-end available to all loaders created after the call. It panics
+// if a front-end with the same name has already been registered.
+func RegisterFrontend(f Frontend) {
+	frontendsLock.Lock()
+	defer frontendsLock.Unlock()
+	for _, e := range frontends {
+		if e.Name() == f.Name() {
+			panic(fmt.Errorf("a manifest front-end named '%s' has already been registered", f.Name()))
+		}
+	}
+	frontends = append(frontends, f)
+}
+
+// Frontends returns all registered front-ends in registration order
+func Frontends() []Frontend {
+	frontendsLock.Lock()
+	defer frontendsLock.Unlock()
+	return append([]Frontend{}, frontends...)
+}
+
+// WithFrontend adds a front-end that is only used by the loader being created
+func WithFrontend(f Frontend) Option {
+	return func(l *Loader) {
+		l.frontends = append(l.frontends, f)
+	}
+}
+
+// RegisterService makes the given service loadable using its identifier
+func (l *Loader) RegisterService(c eval.Context, service serviceapi.Service) {
+	l.SetEntry(service.Identifier(c), eval.NewLoaderEntry(l.wrap(service), nil))
+}
+
+// RegisterMetadata registers all definitions found in the metadata of the given service
+func (l *Loader) RegisterMetadata(c eval.Context, service serviceapi.Service) {
+	l.loadMetadata(c, ``, nil, service)
+}
+
+// manifest is a manifest found on the plugin path together with the result of parsing it
+type manifest struct {
+	frontend Frontend
+	file     string
+	service  serviceapi.Service
+	err      error
+	panic    interface{}
+}
+
+// loadManifests finds all manifests in the plugin path and loads them using the front-end
+// that claims them. The first front-end that detects a file wins. Manifests are parsed
+// concurrently, using at most one goroutine per CPU, and then registered in the order they
+// were found so that conflicting definitions are always resolved the same way.
+func (l *Loader) loadManifests(c eval.Context) {
+	l.logger.Debug("reading manifests from filesystem")
+	var manifests []*manifest
+	seen := map[string]bool{}
+	for _, f := range l.frontends {
+		for _, pattern := range f.Patterns() {
+			for _, file := range l.findFiles(pattern) {
+				if seen[file] || !f.Detect(file) {
+					continue
+				}
+				seen[file] = true
+				manifests = append(manifests, &manifest{frontend: f, file: file})
+			}
+		}
+	}
+
+	sem := make(chan struct{}, runtime.NumCPU())
+	wg := sync.WaitGroup{}
+	for _, m := range manifests {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(m *manifest, fc eval.Context) {
+			defer func() {
+				// A panic is raised again by the loading goroutine
+				m.panic = recover()
+				threadlocal.Cleanup()
+				<-sem
+				wg.Done()
+			}()
+			threadlocal.Init()
+			threadlocal.Set(eval.PuppetContextKey, fc)
+			m.service, m.err = l.parseManifest(fc, m.frontend, m.file)
+		}(m, c.Fork())
+	}
+	wg.Wait()
+
+	for _, m := range manifests {
+		if m.panic != nil {
+			panic(m.panic)
+		}
+		l.registerManifest(c, m)
+	}
+}
+
+// WithVerifier makes the loader refuse manifests that have not been signed by a key trusted by the
+// given verifier. It takes precedence over the keys named by the LYRA_TRUSTED_KEYS environment variable.
+func WithVerifier(verifier *signing.Verifier) Option {
+	return func(l *Loader) {
+		l.verifier = verifier
+	}
+}
+
+// verifyManifest returns an error if signature verification is enabled and the manifest has
+// not been signed by a trusted key
+func (l *Loader) verifyManifest(file string) error {
+	if l.verifierErr != nil {
+		return l.verifierErr
+	}
+	if l.verifier == nil {
+		return nil
+	}
+	signer, err := l.verifier.VerifyFile(file)
+	if err != nil {
+		return err
+	}
+	l.logger.Debug("verified manifest signature", "file", file, "signer", signer)
+	return nil
+}
+
+// parseManifest verifies the signature of a manifest and parses it. A manifest that is unchanged since
+// it was last parsed isn't parsed until its service is used.
+func (l *Loader) parseManifest(c eval.Context, f Frontend, file string) (serviceapi.Service, error) {
+	if err := l.verifyManifest(file); err != nil {
+		return nil, fmt.Errorf("refusing to load manifest: %s", err)
+	}
+	key := l.manifestKey(f, file)
+	if service, ok := l.cachedManifest(c, f, file, key); ok {
+		return service, nil
+	}
+	l.logger.Debug("loading manifest", "file", file, "frontend", f.Name())
+	service, err := f.Parse(c, file)
+	if err != nil {
+		return nil, err
+	}
+	return l.cacheManifest(c, f, file, key, service), nil
+}
+
+func (l *Loader) registerManifest(c eval.Context, m *manifest) {
+	err := m.err
+	if err == nil {
+		err = m.frontend.RegisterDefinitions(c, l, m.service)
+	}
+	if err != nil {
+		l.logger.Error("failed to load manifest", "file", m.file, "frontend", m.frontend.Name(), "err", err)
+	}
+}
+
+// puppetFrontend loads manifests using the Puppet DSL service
+type puppetFrontend struct {
+	name       string
+	extensions []string
+}
+
+func (f *puppetFrontend) Name() string {
+	return f.name
+}
+
+func (f *puppetFrontend) Patterns() []string {
+	patterns := make([]string, len(f.extensions))
+	for i, ext := range f.extensions {
+		patterns[i] = `*` + ext
+	}
+	return patterns
+}
+
+func (f *puppetFrontend) Detect(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range f.extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *puppetFrontend) Parse(c eval.Context, path string) (service serviceapi.Service, err error) {
+	x, ok := eval.Load(c, eval.NewTypedName(eval.NsService, `Puppet`))
+	if !ok {
+		return nil, fmt.Errorf("failed to load Puppet DSL Service plugin")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			// Both issues and errors reported by the plugin, e.g. a call to a function that is
+			// unavailable in safe evaluation mode, are returned
+			if e, ok := r.(error); ok {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+	def := x.(serviceapi.Service).Invoke(
+		c, puppet.ManifestLoaderID, `loadManifest`,
+		types.WrapString(filepath.Dir(path)),
+		types.WrapString(path)).(serviceapi.Definition)
+	return &subService{def: def}, nil
+}
+
+func (f *puppetFrontend) RegisterDefinitions(c eval.Context, r Registry, service serviceapi.Service) error {
+	r.RegisterService(c, service)
+	r.RegisterMetadata(c, service)
+	return nil
+}
+
+// yamlFrontend loads YAML manifests in this process. Manifests are read one step at a time so that
+// manifests with thousands of steps are loaded with bounded memory.
+type yamlFrontend struct {
+	puppetFrontend
+}
+
+func (f *yamlFrontend) Parse(c eval.Context, path string) (service serviceapi.Service, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+	// Resource types are found in the types directory next to the manifest, just like they are by
+	// the Puppet DSL service
+	c.DoWithLoader(eval.NewFilebasedLoader(c.Loader(), filepath.Dir(path), ``, eval.PUPPET_DATA_TYPE_PATH), func() {
+		sb := sdkservice.NewServerBuilder(c, manifestServiceName(path))
+		sb.RegisterStateConverter(yaml.ResolveState)
+		sb.RegisterActivity(yamlstream.CreateActivity(c, path))
+		service = sb.Server()
+	})
+	return
+}
+
+// manifestServiceName returns the name of the service of the manifest at the given path. It is the
+// name that the Puppet DSL service gives to the manifests it loads, e.g. Plugins::Aws_vpcYaml for
+// plugins/aws_vpc.yaml.
+func manifestServiceName(path string) string {
+	b := bytes.NewBufferString(``)
+	upper := true
+	sep := true
+	for _, c := range path {
+		if c == '/' {
+			if !sep {
+				b.WriteString(`::`)
+				sep = true
+			}
+		} else if c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' {
+			if sep || upper {
+				c = unicode.ToUpper(c)
+			}
+			b.WriteRune(c)
+			sep = false
+			upper = false
+		} else {
+			upper = true
+		}
+	}
+	if sep {
+		b.Truncate(b.Len() - 2)
+	}
+	return b.String()
+}
+
+// subService is a service that is provided by another service, e.g. the service that represents
+// a manifest loaded by the Puppet DSL service. All invocations share the connection to the parent
+// service, so that concurrent invocations of resource handlers can be sent to it in batches.
+type subService struct {
+	def    serviceapi.Definition
+	lock   sync.Mutex
+	parent serviceapi.Service
+}
+
+// Parent returns the service that provides this service. It is loaded on first use.
+func (s *subService) Parent(c eval.Context) serviceapi.Service {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.parent == nil {
+		x, ok := eval.Load(c, s.def.ServiceId())
+		if !ok {
+			panic(fmt.Errorf("failed to load %s", s.def.ServiceId()))
+		}
+		s.parent = x.(serviceapi.Service)
+	}
+	return s.parent
+}
+
+func (s *subService) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	args := make([]eval.Value, 2, 2+len(arguments))
+	args[0] = types.WrapString(identifier)
+	args[1] = types.WrapString(name)
+	args = append(args, arguments...)
+	return s.Parent(c).Invoke(c, s.def.Identifier().Name(), "invoke", args...)
+}
+
+func (s *subService) Metadata(c eval.Context) (typeSet eval.TypeSet, definitions []serviceapi.Definition) {
+	v := s.Parent(c).Invoke(c, s.def.Identifier().Name(), "metadata").(eval.List)
+	if ts, ok := v.At(0).(eval.TypeSet); ok {
+		typeSet = ts
+	}
+	if dl, ok := v.At(1).(eval.List); ok {
+		definitions = make([]serviceapi.Definition, dl.Len())
+		dl.EachWithIndex(func(d eval.Value, i int) {
+			definitions[i] = d.(serviceapi.Definition)
+		})
+	}
+	return
+}
+
+func (s *subService) State(c eval.Context, name string, input eval.OrderedMap) eval.PuppetObject {
+	return s.Parent(c).Invoke(c, s.def.Identifier().Name(), "state", types.WrapString(name), input).(eval.PuppetObject)
+}
+
+func (s *subService) Identifier(eval.Context) eval.TypedName {
+	return eval.NewTypedName(eval.NsService, s.def.Identifier().Name())
+}
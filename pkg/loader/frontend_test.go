@@ -0,0 +1,162 @@
+package loader
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/lyra/pkg/signing"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testFrontend struct {
+	lock       sync.Mutex
+	name       string
+	patterns   []string
+	accept     string
+	parseError error
+	parseDelay func(path string) time.Duration
+	parsed     []string
+	registered []string
+}
+
+// testService is the result of parsing a manifest with the testFrontend
+type testService struct {
+	serviceapi.Service
+	path string
+}
+
+func (f *testFrontend) Name() string {
+	return f.name
+}
+
+func (f *testFrontend) Patterns() []string {
+	return f.patterns
+}
+
+func (f *testFrontend) Detect(path string) bool {
+	return f.accept == `` || path == f.accept
+}
+
+func (f *testFrontend) Parse(c eval.Context, path string) (serviceapi.Service, error) {
+	if f.parseDelay != nil {
+		time.Sleep(f.parseDelay(path))
+	}
+	f.lock.Lock()
+	f.parsed = append(f.parsed, path)
+	f.lock.Unlock()
+	return &testService{path: path}, f.parseError
+}
+
+func (f *testFrontend) RegisterDefinitions(c eval.Context, r Registry, service serviceapi.Service) error {
+	f.registered = append(f.registered, service.(*testService).path)
+	return nil
+}
+
+func Test_LoadManifests(t *testing.T) {
+	first := &testFrontend{name: `first`, patterns: []string{`prefix-*`}, accept: `testdata/files/prefix-a`}
+	second := &testFrontend{name: `second`, patterns: []string{`prefix-*`}}
+	failing := &testFrontend{name: `failing`, patterns: []string{`nomatch`}, parseError: errors.New(`bad manifest`)}
+	l := &Loader{
+		pluginPath: []string{`testdata/files`},
+		logger:     hclog.NewNullLogger(),
+		frontends:  []Frontend{first, second, failing},
+	}
+	eval.Puppet.Do(l.loadManifests)
+
+	// A file claimed by one front-end is never offered to the next one
+	assert.Equal(t, []string{`testdata/files/prefix-a`}, first.parsed)
+	assert.Equal(t, []string{`testdata/files/prefix-a`}, first.registered)
+	assert.Equal(t, []string{`testdata/files/prefix-b`}, second.parsed)
+	assert.Equal(t, []string{`testdata/files/prefix-b`}, second.registered)
+
+	// Definitions are not registered when parsing fails
+	assert.Equal(t, []string{`testdata/files/nomatch`}, failing.parsed)
+	assert.Empty(t, failing.registered)
+}
+
+func Test_LoadManifestsInOrder(t *testing.T) {
+	// The first manifest takes the longest to parse
+	f := &testFrontend{name: `slow`, patterns: []string{`*`}, parseDelay: func(path string) time.Duration {
+		if path == `testdata/files/nomatch` {
+			return 50 * time.Millisecond
+		}
+		return 0
+	}}
+	l := &Loader{
+		pluginPath: []string{`testdata/files`},
+		logger:     hclog.NewNullLogger(),
+		frontends:  []Frontend{f},
+	}
+	eval.Puppet.Do(l.loadManifests)
+	assert.ElementsMatch(t, []string{`testdata/files/nomatch`, `testdata/files/prefix-a`, `testdata/files/prefix-b`}, f.parsed)
+	assert.Equal(t, []string{`testdata/files/nomatch`, `testdata/files/prefix-a`, `testdata/files/prefix-b`}, f.registered)
+}
+
+func Test_LoadManifestsPanic(t *testing.T) {
+	f := &testFrontend{name: `panicking`, patterns: []string{`nomatch`}, parseDelay: func(path string) time.Duration {
+		panic(`parser crashed`)
+	}}
+	l := &Loader{
+		pluginPath: []string{`testdata/files`},
+		logger:     hclog.NewNullLogger(),
+		frontends:  []Frontend{f},
+	}
+	// A panic in a parsing goroutine is raised again by the caller
+	assert.PanicsWithValue(t, `parser crashed`, func() { eval.Puppet.Do(l.loadManifests) })
+}
+
+func Test_PuppetFrontendDetect(t *testing.T) {
+	f := &puppetFrontend{name: `puppet`, extensions: []string{`.pp`}}
+	assert.Equal(t, []string{`*.pp`}, f.Patterns())
+	assert.True(t, f.Detect(`/plugins/workflow.pp`))
+	assert.True(t, f.Detect(`/plugins/WORKFLOW.PP`))
+	assert.False(t, f.Detect(`/plugins/workflow.yaml`))
+}
+
+func Test_YamlFrontend(t *testing.T) {
+	f := &yamlFrontend{puppetFrontend{name: `yaml`, extensions: []string{`.yaml`}}}
+	assert.True(t, f.Detect(`/plugins/workflow.yaml`))
+	assert.False(t, f.Detect(`/plugins/workflow.pp`))
+
+	eval.Puppet.Do(func(c eval.Context) {
+		s, err := f.Parse(c, `yamlstream/testdata/workflow.yaml`)
+		require.NoError(t, err)
+		assert.Equal(t, `Yamlstream::Testdata::WorkflowYaml`, s.Identifier(c).Name())
+		_, defs := s.Metadata(c)
+		require.Len(t, defs, 1)
+		assert.Equal(t, `people`, defs[0].Identifier().Name())
+
+		_, err = f.Parse(c, `yamlstream/testdata/missing.yaml`)
+		assert.Error(t, err)
+	})
+}
+
+func Test_RegisterFrontendTwice(t *testing.T) {
+	assert.Panics(t, func() { RegisterFrontend(&testFrontend{name: `puppet`}) })
+}
+
+func Test_WithFrontend(t *testing.T) {
+	f := &testFrontend{name: `extra`}
+	l := New(hclog.NewNullLogger(), eval.Puppet.SystemLoader(), WithFrontend(f))
+	assert.Equal(t, len(Frontends())+1, len(l.frontends))
+	assert.Equal(t, f, l.frontends[len(l.frontends)-1])
+}
+
+func Test_LoadManifestsRequiresSignature(t *testing.T) {
+	f := &testFrontend{name: `test`, patterns: []string{`prefix-*`}}
+	l := &Loader{
+		pluginPath: []string{`testdata/files`},
+		logger:     hclog.NewNullLogger(),
+		frontends:  []Frontend{f},
+		verifier:   signing.NewVerifier(nil),
+	}
+	eval.Puppet.Do(l.loadManifests)
+	assert.Empty(t, f.parsed)
+	assert.Empty(t, f.registered)
+}
@@ -2,19 +2,23 @@ package loader
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/lyraproj/puppet-evaluator/types"
-	"github.com/lyraproj/puppet-workflow/puppet"
 
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/lyra/pkg/grpc"
+	"github.com/lyraproj/lyra/pkg/loader/sourcemap"
+	"github.com/lyraproj/lyra/pkg/signing"
 	"github.com/lyraproj/puppet-evaluator/eval"
 	"github.com/lyraproj/puppet-evaluator/yaml"
-	"github.com/lyraproj/servicesdk/grpc"
 	"github.com/lyraproj/servicesdk/serviceapi"
 )
 
@@ -25,17 +29,73 @@ var embeddedPluginNames = []string{
 
 var defaultLoadPath = []string{"./plugins", "./build"}
 
+const (
+	// NodeExecutableEnvVar can be used to override the node executable used to run TypeScript plugins
+	NodeExecutableEnvVar = "LYRA_NODE"
+
+	defaultNodeExecutable = "node"
+)
+
 // Loader implements the Loader API from go-servicesdk
 type Loader struct {
 	eval.DefiningLoader
-	serviceCmds    map[string]string
-	serviceCmdArgs map[string][]string
-	pluginPath     []string
-	logger         hclog.Logger
+	serviceCmds      map[string]string
+	serviceCmdArgs   map[string][]string
+	pluginPath       []string
+	logger           hclog.Logger
+	pluginLogger     hclog.Logger
+	wrappers         []func(serviceapi.Service) serviceapi.Service
+	frontends        []Frontend
+	policy           *Policy
+	credential       *Credential
+	credentialErr    error
+	verifier         *signing.Verifier
+	verifierErr      error
+	tlsMode          TLSMode
+	tlsConfig        *tls.Config
+	maxMessageSize   int
+	transportErr     error
+	cache            *metadataCache
+	pluginSet        []string
+	pluginSetUnknown bool
+	pool             *PluginPool
+	batchDelay       time.Duration
+	batchSize        int
+	services         map[string]serviceapi.Service
+	servicesLock     sync.Mutex
+}
+
+// Option configures optional behaviour of a Loader
+type Option func(*Loader)
+
+// WithServiceWrapper adds a function that wraps every service made available by the loader. Wrappers
+// are typically used to intercept invocations. They are applied in the order they are given.
+func WithServiceWrapper(wrapper func(serviceapi.Service) serviceapi.Service) Option {
+	return func(l *Loader) {
+		l.wrappers = append(l.wrappers, wrapper)
+	}
+}
+
+// WithPluginPath sets the directories where plugins and manifests are found. The default is the plugins
+// and build directories of the current directory.
+func WithPluginPath(dirs ...string) Option {
+	return func(l *Loader) {
+		l.pluginPath = dirs
+	}
+}
+
+// WithInvokeBatching sets how long the invocations of resource handlers are collected, and how many
+// are collected at most, before they are sent to a plugin in one call. A size below 2 disables batching.
+// The default is to collect up to 100 invocations during 5 milliseconds.
+func WithInvokeBatching(delay time.Duration, size int) Option {
+	return func(l *Loader) {
+		l.batchDelay = delay
+		l.batchSize = size
+	}
 }
 
 // New creates a loader instance
-func New(parentLogger hclog.Logger, parentLoader eval.Loader) *Loader {
+func New(parentLogger hclog.Logger, parentLoader eval.Loader, options ...Option) *Loader {
 	logger := parentLogger.Named("loader")
 	loader := &Loader{
 		DefiningLoader: eval.NewParentedLoader(parentLoader),
@@ -43,6 +103,34 @@ func New(parentLogger hclog.Logger, parentLoader eval.Loader) *Loader {
 		serviceCmdArgs: map[string][]string{},
 		pluginPath:     defaultLoadPath,
 		logger:         logger,
+		pluginLogger:   sourcemap.NewLogger(parentLogger),
+		frontends:      Frontends(),
+		batchDelay:     5 * time.Millisecond,
+		batchSize:      100,
+		services:       map[string]serviceapi.Service{},
+	}
+	for _, option := range options {
+		option(loader)
+	}
+	if loader.policy == nil {
+		policy, err := policyFromEnv()
+		if err != nil {
+			logger.Error("unable to read plugin policy, no plugins will be executed", "err", err)
+		}
+		loader.policy = policy
+	}
+	if loader.credential == nil {
+		// An unusable plugin user is reported when a plugin is started rather than silently ignored
+		loader.credential, loader.credentialErr = credentialFromEnv()
+	}
+	// An invalid TLS configuration or message size prevents all plugins from being started
+	loader.transportErr = loader.tlsFromEnv()
+	if loader.cache == nil {
+		loader.cache = cacheFromEnv()
+	}
+	if loader.verifier == nil {
+		// An unusable set of trusted keys causes all manifests to be refused
+		loader.verifier, loader.verifierErr = signing.VerifierFromEnv()
 	}
 	return loader
 }
@@ -75,26 +163,80 @@ func (l *Loader) LoadEntry(c eval.Context, name eval.TypedName) eval.LoaderEntry
 	return eval.NewLoaderEntry(s, nil)
 }
 
-// LoadService will load the named service. The caller is responsible for unloading it.
+// loadService will load the named service. A service is started once and then shared by all
+// invocations made during the lifetime of the loader so that concurrent invocations can be batched.
 func (l *Loader) loadService(c eval.Context, serviceID eval.TypedName) serviceapi.Service {
+	l.servicesLock.Lock()
+	defer l.servicesLock.Unlock()
+	if service, ok := l.services[serviceID.MapKey()]; ok {
+		return service
+	}
 	cmd, foundCmd := l.serviceCmds[serviceID.MapKey()]
 	cmdArgs, _ := l.serviceCmdArgs[serviceID.MapKey()]
 	if !foundCmd {
 		l.logger.Error("unknown service id", "serviceID", serviceID)
 		return nil
 	}
-	var serviceCmd *exec.Cmd
-	if cmdArgs == nil {
-		serviceCmd = exec.CommandContext(c, cmd)
+	var service serviceapi.Service
+	var err error
+	if pluginFor(cmd, cmdArgs).Source == SourceEmbedded {
+		// Embedded plugins are never pooled
+		service, err = l.start(context.Background(), cmd, cmdArgs)
 	} else {
-		serviceCmd = exec.CommandContext(c, cmd, cmdArgs...)
+		service, err = l.startProvider(context.Background(), cmd, cmdArgs)
 	}
-	// FIXME Load should probably handle the context
-	service, err := grpc.Load(serviceCmd, nil)
 	if err != nil {
 		l.logger.Error("service could not be started", "serviceID", serviceID, "err", err)
 		return nil
 	}
+	service = l.share(service)
+	l.services[serviceID.MapKey()] = service
+	return service
+}
+
+// share prepares a started service for being shared by all invocations made through the loader
+func (l *Loader) share(service serviceapi.Service) serviceapi.Service {
+	if client, ok := service.(*grpc.Client); ok && l.batchSize > 1 {
+		service = grpc.NewBatcher(client, l.batchDelay, l.batchSize)
+	}
+	return l.wrap(service)
+}
+
+// command creates the command that starts a plugin. An error is returned if the plugin policy does
+// not permit execution of the plugin or if it cannot be set up to run as the configured plugin user.
+// The plugin only sees the environment variables that the plugin policy grants it.
+func (l *Loader) command(ctx context.Context, p *Plugin, cmd string, cmdArgs []string) (*exec.Cmd, error) {
+	if l.policy != nil {
+		if err := l.policy.Check(p); err != nil {
+			return nil, err
+		}
+	}
+	allow, err := l.policy.EnvFor(p)
+	if err != nil {
+		return nil, err
+	}
+	cmd, cmdArgs = scopedCommand(cmd, cmdArgs, allow)
+	serviceCmd := exec.CommandContext(ctx, cmd, cmdArgs...)
+	if err := l.applyCredential(serviceCmd); err != nil {
+		return nil, err
+	}
+	return serviceCmd, nil
+}
+
+// startProvider starts a plugin that provides resources. The plugin is taken from the plugin pool when
+// the loader has one.
+func (l *Loader) startProvider(ctx context.Context, cmd string, cmdArgs []string) (serviceapi.Service, error) {
+	if l.pool != nil {
+		return l.pool.get(l, cmd, cmdArgs)
+	}
+	return l.start(ctx, cmd, cmdArgs)
+}
+
+// wrap applies all service wrappers to the given service
+func (l *Loader) wrap(service serviceapi.Service) serviceapi.Service {
+	for _, wrapper := range l.wrappers {
+		service = wrapper(service)
+	}
 	return service
 }
 
@@ -108,8 +250,11 @@ func (l *Loader) PreLoad(c eval.Context) {
 		// Go plugins
 		l.loadPlugins(c)
 
-		// Puppet DSL files
-		l.loadPuppetDSL(c)
+		// TypeScript plugins
+		l.loadTypeScriptPlugins(c)
+
+		// Manifests in all formats known to the registered front-ends
+		l.loadManifests(c)
 
 		// Lyra Links
 		l.loadLyraLinks(c)
@@ -128,17 +273,22 @@ func (l *Loader) PreLoadPlugins(c eval.Context) {
 
 		// Go plugins
 		l.loadPlugins(c)
+
+		// TypeScript plugins
+		l.loadTypeScriptPlugins(c)
 	})
 }
 
+// loadEmbeddedPlugins registers the services and definitions of the plugins embedded in this binary.
+// The plugins are not started until one of their services is used unless their metadata isn't cached.
 func (l *Loader) loadEmbeddedPlugins(c eval.Context) {
 	l.logger.Debug("reading embedded plugins")
 	l.logger.Debug(fmt.Sprintf("found %d embedded plugins", len(embeddedPluginNames)))
 	for _, plugin := range embeddedPluginNames {
 		cmd := os.Args[0] // This is this binary itself
-		err := l.loadLiveMetadataFromPlugin(c, cmd, "--debug", "plugin", plugin)
+		err := l.loadEmbeddedPlugin(c, cmd, "--debug", "plugin", plugin)
 		if err != nil {
-			l.logger.Error("failed to load embedded plugin", "cmd", cmd, "plugin", plugin)
+			l.logger.Error("failed to load embedded plugin", "cmd", cmd, "plugin", plugin, "err", err)
 		}
 	}
 }
@@ -149,51 +299,34 @@ func (l *Loader) loadPlugins(c eval.Context) {
 	for _, plugin := range plugins {
 		err := l.loadMetadataFromPlugin(c, plugin)
 		if err != nil {
-			l.logger.Error("failed to load plugin", "plugin", plugin)
+			l.logger.Error("failed to load plugin", "plugin", plugin, "err", err)
 		}
 	}
 }
 
-type subService struct {
-	def serviceapi.Definition
-}
-
-func (s *subService) Parent(c eval.Context) serviceapi.Service {
-	x, ok := eval.Load(c, s.def.ServiceId())
-	if !ok {
-		panic(fmt.Errorf("failed to load %s", s.def.ServiceId()))
-	}
-	return x.(serviceapi.Service)
-}
-
-func (s *subService) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
-	args := make([]eval.Value, 2, 2+len(arguments))
-	args[0] = types.WrapString(identifier)
-	args[1] = types.WrapString(name)
-	args = append(args, arguments...)
-	return s.Parent(c).Invoke(c, s.def.Identifier().Name(), "invoke", args...)
-}
-
-func (s *subService) Metadata(c eval.Context) (typeSet eval.TypeSet, definitions []serviceapi.Definition) {
-	v := s.Parent(c).Invoke(c, s.def.Identifier().Name(), "metadata").(eval.List)
-	if ts, ok := v.At(0).(eval.TypeSet); ok {
-		typeSet = ts
+// loadTypeScriptPlugins loads plugins written using the TypeScript SDK. The plugins must be compiled to
+// JavaScript and are executed using node. Errors reported by such plugins are translated back to their
+// TypeScript sources when a source map is found next to the JavaScript file.
+func (l *Loader) loadTypeScriptPlugins(c eval.Context) {
+	l.logger.Debug("reading TypeScript plugins from filesystem")
+	plugins := l.findFiles("tsplugin-*.js")
+	if len(plugins) == 0 {
+		return
 	}
-	if dl, ok := v.At(1).(eval.List); ok {
-		definitions = make([]serviceapi.Definition, dl.Len())
-		dl.EachWithIndex(func(d eval.Value, i int) {
-			definitions[i] = d.(serviceapi.Definition)
-		})
+	node := nodeExecutable()
+	for _, plugin := range plugins {
+		err := l.loadMetadataFromPlugin(c, node, plugin)
+		if err != nil {
+			l.logger.Error("failed to load TypeScript plugin", "plugin", plugin, "err", err)
+		}
 	}
-	return
 }
 
-func (s *subService) State(c eval.Context, name string, input eval.OrderedMap) eval.PuppetObject {
-	return s.Parent(c).Invoke(c, s.def.Identifier().Name(), "state", types.WrapString(name), input).(eval.PuppetObject)
-}
-
-func (s *subService) Identifier(eval.Context) eval.TypedName {
-	return eval.NewTypedName(eval.NsService, s.def.Identifier().Name())
+func nodeExecutable() string {
+	if v, ok := os.LookupEnv(NodeExecutableEnvVar); ok && v != `` {
+		return v
+	}
+	return defaultNodeExecutable
 }
 
 func (l *Loader) loadLyraLinks(c eval.Context) {
@@ -234,103 +367,178 @@ func (l *Loader) loadLyraLinks(c eval.Context) {
 	}
 }
 
-func (l *Loader) loadPuppetDSL(c eval.Context) {
-	x, ok := eval.Load(c, eval.NewTypedName(eval.NsService, `Puppet`))
-	if !ok {
-		l.logger.Error("failed to load Puppet DSL Service plugin")
-		return
-	}
-	ppServer := x.(serviceapi.Service)
-
-	l.logger.Debug("reading Puppet DSL and YAML from filesystem")
-
-	ppFiles := l.findFiles("*.pp")
-
-	yamlFiles := l.findFiles("*.yaml")
-
-	allFiles := append(ppFiles, yamlFiles...)
-	if len(allFiles) == 0 {
-		return
+// findFiles returns the files that match the given glob in the directories of the plugin path and in
+// their nested 'types' directories. The directories are searched concurrently since each search may
+// have to wait for a network filesystem. The files are returned in the order of the plugin path, the
+// files of a nested 'types' directory first, so the result doesn't depend on which search ends first.
+func (l *Loader) findFiles(glob string) []string {
+	found := make([][]string, len(l.pluginPath))
+	wg := sync.WaitGroup{}
+	for i, pluginDir := range l.pluginPath {
+		wg.Add(1)
+		go func(i int, pluginDir string) {
+			defer wg.Done()
+			found[i] = l.findFilesInDir(pluginDir, glob)
+		}(i, pluginDir)
 	}
+	wg.Wait()
 
-	for _, f := range allFiles {
-		l.logger.Debug("loading manifest", "file", f)
-		def := ppServer.Invoke(
-			c, puppet.ManifestLoaderID, `loadManifest`,
-			types.WrapString(filepath.Dir(f)),
-			types.WrapString(f)).(serviceapi.Definition)
-		sa := &subService{def}
-		l.SetEntry(sa.Identifier(c), eval.NewLoaderEntry(sa, nil))
-		l.loadMetadata(c, ``, nil, sa)
+	files := []string{}
+	for _, fs := range found {
+		files = append(files, fs...)
 	}
+	return files
 }
 
-func (l *Loader) findFiles(glob string) []string {
+// findFilesInDir returns the files that match the given glob in the given directory of the plugin path
+// and in its nested 'types' directory
+func (l *Loader) findFilesInDir(pluginDir, glob string) []string {
 	files := []string{}
-	for _, pluginDir := range l.pluginPath {
-		// Check for a nested 'types' dir first
-		typesDir := filepath.Join(pluginDir, "types")
-		stat, err := os.Stat(typesDir)
-		if err == nil && stat.IsDir() {
-			l.logger.Debug(fmt.Sprintf("checking '%s' for '%s' files ...", typesDir, glob))
-			fullGlob := filepath.Join(typesDir, glob)
-			fs, err := filepath.Glob(fullGlob)
-			if err != nil {
-				l.logger.Error("failed to load plugins from types dir", "typesDir", typesDir, "err", err)
-				continue
-			}
-			files = append(files, fs...)
-			l.logger.Debug(fmt.Sprintf("found %d files", len(fs)))
-		}
 
-		// Now load from the specified plugin dir
-		l.logger.Debug(fmt.Sprintf("checking '%s' for '%s' files ...", pluginDir, glob))
-		stat, err = os.Stat(pluginDir)
-		if err != nil && os.IsNotExist(err) {
-			l.logger.Error("specified plugins directory not found, ignoring", "pluginDir", pluginDir)
-			continue
-		}
-		if !stat.IsDir() {
-			l.logger.Error("specified plugins directory is not actually a directory, ignoring", "pluginDir", pluginDir)
-			continue
-		}
-		fullGlob := filepath.Join(pluginDir, glob)
+	// Check for a nested 'types' dir first
+	typesDir := filepath.Join(pluginDir, "types")
+	stat, err := os.Stat(typesDir)
+	if err == nil && stat.IsDir() {
+		l.logger.Debug(fmt.Sprintf("checking '%s' for '%s' files ...", typesDir, glob))
+		fullGlob := filepath.Join(typesDir, glob)
 		fs, err := filepath.Glob(fullGlob)
 		if err != nil {
-			l.logger.Error("failed to load plugins from dir", "pluginDir", pluginDir, "err", err)
-			continue
+			l.logger.Error("failed to load plugins from types dir", "typesDir", typesDir, "err", err)
+			return files
 		}
-
 		files = append(files, fs...)
 		l.logger.Debug(fmt.Sprintf("found %d files", len(fs)))
 	}
+
+	// Now load from the specified plugin dir
+	l.logger.Debug(fmt.Sprintf("checking '%s' for '%s' files ...", pluginDir, glob))
+	stat, err = os.Stat(pluginDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			l.logger.Error("specified plugins directory not found, ignoring", "pluginDir", pluginDir)
+		} else {
+			l.logger.Error("unable to read specified plugins directory, ignoring", "pluginDir", pluginDir, "err", err)
+		}
+		return files
+	}
+	if !stat.IsDir() {
+		l.logger.Error("specified plugins directory is not actually a directory, ignoring", "pluginDir", pluginDir)
+		return files
+	}
+	fullGlob := filepath.Join(pluginDir, glob)
+	fs, err := filepath.Glob(fullGlob)
+	if err != nil {
+		l.logger.Error("failed to load plugins from dir", "pluginDir", pluginDir, "err", err)
+		return files
+	}
+
+	files = append(files, fs...)
+	l.logger.Debug(fmt.Sprintf("found %d files", len(fs)))
 	return files
 }
 
+// loadMetadataFromPlugin registers the services and definitions of a plugin. The plugin is only started
+// when its metadata isn't found in the metadata cache. It is otherwise started when one of its services
+// is first used.
 func (l *Loader) loadMetadataFromPlugin(c eval.Context, cmd string, cmdArgs ...string) error {
+	key, found, err := l.cachedMetadata(c, cmd, cmdArgs)
+	if err != nil || found {
+		return err
+	}
+
 	context, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
-	// FIXME Load should probably handle the eval.Context
-	serviceCmd := exec.CommandContext(context, cmd, cmdArgs...)
-	service, err := grpc.Load(serviceCmd, nil)
+	// A plugin that isn't pooled is stopped once its metadata is loaded
+	service, err := l.startProvider(context, cmd, cmdArgs)
 	if err != nil {
 		return err
 	}
+	_, err = l.readMetadata(c, key, cmd, cmdArgs, service)
+	return err
+}
+
+// loadEmbeddedPlugin registers the services and definitions of an embedded plugin. A plugin that must be
+// started to read its metadata is kept running and shared by all uses of its service, so that a run never
+// starts more than one process for each embedded plugin.
+func (l *Loader) loadEmbeddedPlugin(c eval.Context, cmd string, cmdArgs ...string) error {
+	key, found, err := l.cachedMetadata(c, cmd, cmdArgs)
+	if err != nil || found {
+		return err
+	}
+
+	service, err := l.start(context.Background(), cmd, cmdArgs)
+	if err != nil {
+		return err
+	}
+	defs, err := l.readMetadata(c, key, cmd, cmdArgs, service)
+	if err != nil || len(defs) == 0 {
+		return err
+	}
+	l.servicesLock.Lock()
+	l.services[defs[0].ServiceId().MapKey()] = l.share(service)
+	l.servicesLock.Unlock()
+	return nil
+}
+
+// cachedMetadata registers the services and definitions of a plugin when its metadata is found in the
+// metadata cache. The returned key is the one to cache the metadata under when it wasn't found. It is
+// empty when the metadata cannot be cached.
+func (l *Loader) cachedMetadata(c eval.Context, cmd string, cmdArgs []string) (key string, found bool, err error) {
+	if !l.cache.enabled() {
+		return ``, false, nil
+	}
+	p := pluginFor(cmd, cmdArgs)
+	if err = l.policy.Check(p); err != nil {
+		return ``, false, err
+	}
+	if key, err = fingerprint(p, cmd, cmdArgs); err != nil {
+		l.logger.Debug("unable to fingerprint plugin, metadata will not be cached", "plugin", cmd, "err", err)
+		l.pluginSetUnknown = true
+		return ``, false, nil
+	}
+	l.pluginSet = append(l.pluginSet, key)
+	_, defs, ok := l.cache.get(c, key)
+	if !ok {
+		return key, false, nil
+	}
+	l.logger.Debug("using cached metadata", "plugin", cmd)
+	l.registerMetadata(cmd, cmdArgs, defs)
+	return key, true, nil
+}
+
+// readMetadata reads the metadata from the given service of a started plugin, registers its services and
+// definitions, and caches it under the given key unless the key is empty
+func (l *Loader) readMetadata(c eval.Context, key, cmd string, cmdArgs []string, service serviceapi.Service) ([]serviceapi.Definition, error) {
 	l.logger.Debug("loading metadata", "plugin", cmd)
-	l.loadMetadata(c, cmd, cmdArgs, service)
+	client, ok := service.(*grpc.Client)
+	if key == `` || !ok {
+		_, defs := service.Metadata(c)
+		l.registerMetadata(cmd, cmdArgs, defs)
+		l.logger.Debug("done loading metadata", "plugin", cmd)
+		return defs, nil
+	}
+
+	// The metadata is cached as received since definitions don't survive being encoded again
+	bs := client.EncodedMetadata(c)
+	_, defs, err := grpc.DecodeMetadata(c, bs)
+	if err != nil {
+		return nil, err
+	}
+	l.registerMetadata(cmd, cmdArgs, defs)
 	l.logger.Debug("done loading metadata", "plugin", cmd)
-	return nil
+	if err = l.cache.put(key, bs); err != nil {
+		l.logger.Debug("unable to cache metadata", "plugin", cmd, "err", err)
+	}
+	return defs, nil
 }
 
 func (l *Loader) loadLiveMetadataFromPlugin(c eval.Context, cmd string, cmdArgs ...string) error {
-	// FIXME Load should probably handle the eval.Context
-	serviceCmd := exec.CommandContext(c, cmd, cmdArgs...)
-	service, err := grpc.Load(serviceCmd, nil)
+	service, err := l.start(c, cmd, cmdArgs)
 	if err != nil {
 		return err
 	}
-	l.SetEntry(service.Identifier(c), eval.NewLoaderEntry(service, nil))
+	l.SetEntry(service.Identifier(c), eval.NewLoaderEntry(l.wrap(service), nil))
 
 	l.logger.Debug("loading metadata", "plugin", cmd)
 	l.loadMetadata(c, cmd, cmdArgs, service)
@@ -340,6 +548,11 @@ func (l *Loader) loadLiveMetadataFromPlugin(c eval.Context, cmd string, cmdArgs
 
 func (l *Loader) loadMetadata(c eval.Context, cmd string, cmdArgs []string, service serviceapi.Service) {
 	_, defs := service.Metadata(c)
+	l.registerMetadata(cmd, cmdArgs, defs)
+}
+
+// registerMetadata registers the service that provides the given definitions and the definitions themselves
+func (l *Loader) registerMetadata(cmd string, cmdArgs []string, defs []serviceapi.Definition) {
 	if len(defs) == 0 {
 		return
 	}
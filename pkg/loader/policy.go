@@ -0,0 +1,209 @@
+package loader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/lyraproj/lyra/pkg/loader/integrity"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PluginPolicyEnvVar names a file containing the policy that restricts which plugins may be executed
+const PluginPolicyEnvVar = "LYRA_PLUGIN_POLICY"
+
+// Plugin sources
+const (
+	// SourceEmbedded is the source of plugins that are embedded in the lyra binary
+	SourceEmbedded = "embedded"
+	// SourceFile is the source of plugins that are found on the plugin path
+	SourceFile = "file"
+)
+
+// Plugin identifies an executable plugin for the purpose of policy checks
+type Plugin struct {
+	// Name is the name of the plugin, i.e. the file name without extension for plugins found on the
+	// plugin path and the plugin name for embedded plugins
+	Name string
+	// Path is the absolute path of the file containing the plugin code
+	Path string
+	// Source is where the plugin came from, e.g. "embedded", "file" or the registry it was installed from
+	Source string
+	// TypeScript is true for plugins written using the TypeScript SDK. Such plugins are executed by node.
+	TypeScript bool
+}
+
+// Rule matches plugins. Name, Path and Source are glob patterns and Checksum is the hex encoded
+// sha256 sum of the plugin file, optionally prefixed with "sha256:". Empty fields match everything
+// but a rule must have at least one non-empty field.
+type Rule struct {
+	Name     string `yaml:"name,omitempty"`
+	Path     string `yaml:"path,omitempty"`
+	Checksum string `yaml:"checksum,omitempty"`
+	Source   string `yaml:"source,omitempty"`
+}
+
+// Policy restricts the plugins that the loader is permitted to execute. A plugin that matches a Deny
+// rule is never executed. When Allow is non-empty, a plugin must also match at least one of its rules.
+// Env rules grant plugins access to environment variables in addition to the DefaultPluginEnv.
+type Policy struct {
+	Allow []Rule    `yaml:"allow,omitempty"`
+	Deny  []Rule    `yaml:"deny,omitempty"`
+	Env   []EnvRule `yaml:"env,omitempty"`
+}
+
+// WithPolicy makes the loader check all plugins against the given policy before executing them. It
+// takes precedence over a policy file named by the LYRA_PLUGIN_POLICY environment variable.
+func WithPolicy(policy *Policy) Option {
+	return func(l *Loader) {
+		l.policy = policy
+	}
+}
+
+// LoadPolicy reads a YAML policy from the file at the given path
+func LoadPolicy(path string) (*Policy, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if err = yaml.UnmarshalStrict(bts, p); err != nil {
+		return nil, fmt.Errorf("invalid plugin policy %s: %s", path, err)
+	}
+	for _, rules := range [][]Rule{p.Allow, p.Deny} {
+		for _, r := range rules {
+			if r == (Rule{}) {
+				return nil, fmt.Errorf("invalid plugin policy %s: empty rule", path)
+			}
+			if err = r.validate(); err != nil {
+				return nil, fmt.Errorf("invalid plugin policy %s: %s", path, err)
+			}
+		}
+	}
+	for _, r := range p.Env {
+		if err = r.validate(); err == nil {
+			err = validateEnvPatterns(r.Allow)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin policy %s: %s", path, err)
+		}
+	}
+	return p, nil
+}
+
+// policyFromEnv returns the policy named by the LYRA_PLUGIN_POLICY environment variable or nil
+// if the variable is not set. A policy that cannot be read denies all plugins.
+func policyFromEnv() (*Policy, error) {
+	path, ok := os.LookupEnv(PluginPolicyEnvVar)
+	if !ok || path == `` {
+		return nil, nil
+	}
+	p, err := LoadPolicy(path)
+	if err != nil {
+		return &Policy{Deny: []Rule{{Name: `*`}}}, err
+	}
+	return p, nil
+}
+
+// Check returns an error unless the given plugin is permitted by the policy. A nil policy permits everything.
+func (p *Policy) Check(plugin *Plugin) error {
+	if p == nil {
+		return nil
+	}
+	sums := map[string]string{}
+	for _, r := range p.Deny {
+		ok, err := r.matches(plugin, sums)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fmt.Errorf("plugin '%s' (%s) is denied by the plugin policy", plugin.Name, plugin.Path)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, r := range p.Allow {
+		ok, err := r.matches(plugin, sums)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("plugin '%s' (%s) is not allowed by the plugin policy", plugin.Name, plugin.Path)
+}
+
+func (r *Rule) validate() error {
+	for _, pattern := range []string{r.Name, r.Path, r.Source} {
+		if _, err := filepath.Match(pattern, ``); err != nil {
+			return fmt.Errorf("bad pattern '%s': %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matches returns true if all non-empty fields of the rule match the plugin. The sums map caches
+// checksums so that the plugin file is read at most once per check.
+func (r *Rule) matches(plugin *Plugin, sums map[string]string) (bool, error) {
+	if !globMatch(r.Name, plugin.Name) || !globMatch(r.Path, plugin.Path) || !globMatch(r.Source, plugin.Source) {
+		return false, nil
+	}
+	if r.Checksum == `` {
+		return true, nil
+	}
+	sum, ok := sums[plugin.Path]
+	if !ok {
+		var err error
+		if sum, err = integrity.Sha256sumFile(plugin.Path); err != nil {
+			return false, fmt.Errorf("unable to compute checksum of plugin '%s': %s", plugin.Name, err)
+		}
+		sums[plugin.Path] = sum
+	}
+	return strings.EqualFold(strings.TrimPrefix(r.Checksum, `sha256:`), sum), nil
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == `` {
+		return true
+	}
+	ok, _ := filepath.Match(pattern, value)
+	return ok
+}
+
+// pluginFor determines what plugin is executed by the given command. Embedded plugins are
+// executed by this binary and TypeScript plugins are executed by node, so in those cases the
+// plugin is identified by the arguments rather than by the executable.
+func pluginFor(cmd string, cmdArgs []string) *Plugin {
+	if cmd == os.Args[0] {
+		for i, arg := range cmdArgs {
+			if arg == `plugin` && i+1 < len(cmdArgs) {
+				return &Plugin{Name: cmdArgs[i+1], Path: absPath(cmd), Source: SourceEmbedded}
+			}
+		}
+	}
+	path := cmd
+	ts := cmd == nodeExecutable() && len(cmdArgs) > 0 && strings.HasSuffix(cmdArgs[0], `.js`)
+	if ts {
+		path = cmdArgs[0]
+	}
+	base := filepath.Base(path)
+	return &Plugin{Name: strings.TrimSuffix(base, filepath.Ext(base)), Path: absPath(path), Source: SourceFile, TypeScript: ts}
+}
+
+func absPath(path string) string {
+	if !strings.ContainsRune(path, filepath.Separator) {
+		// A command found using $PATH
+		if found, err := exec.LookPath(path); err == nil {
+			path = found
+		}
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
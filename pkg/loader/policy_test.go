@@ -0,0 +1,137 @@
+package loader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lyraproj/lyra/pkg/loader/integrity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sha256 sum of testdata/files/prefix-a
+var prefixASum = func() string {
+	sum, err := integrity.Sha256sumFile("testdata/files/prefix-a")
+	if err != nil {
+		panic(err)
+	}
+	return sum
+}()
+
+var policyTests = []struct {
+	title   string
+	policy  *Policy
+	plugin  Plugin
+	allowed bool
+}{
+	{
+		title:   "no policy",
+		policy:  nil,
+		plugin:  Plugin{Name: "goplugin-aws", Path: "/plugins/goplugin-aws", Source: SourceFile},
+		allowed: true,
+	},
+	{
+		title:   "empty policy",
+		policy:  &Policy{},
+		plugin:  Plugin{Name: "goplugin-aws", Path: "/plugins/goplugin-aws", Source: SourceFile},
+		allowed: true,
+	},
+	{
+		title:   "allowed by name",
+		policy:  &Policy{Allow: []Rule{{Name: "goplugin-*"}}},
+		plugin:  Plugin{Name: "goplugin-aws", Path: "/plugins/goplugin-aws", Source: SourceFile},
+		allowed: true,
+	},
+	{
+		title:   "not in allow list",
+		policy:  &Policy{Allow: []Rule{{Name: "goplugin-*"}}},
+		plugin:  Plugin{Name: "tsplugin-aws", Path: "/plugins/tsplugin-aws.js", Source: SourceFile},
+		allowed: false,
+	},
+	{
+		title:   "all fields of a rule must match",
+		policy:  &Policy{Allow: []Rule{{Name: "goplugin-*", Path: "/opt/lyra/*"}}},
+		plugin:  Plugin{Name: "goplugin-aws", Path: "/plugins/goplugin-aws", Source: SourceFile},
+		allowed: false,
+	},
+	{
+		title:   "deny takes precedence",
+		policy:  &Policy{Allow: []Rule{{Name: "*"}}, Deny: []Rule{{Path: "/tmp/*"}}},
+		plugin:  Plugin{Name: "goplugin-aws", Path: "/tmp/goplugin-aws", Source: SourceFile},
+		allowed: false,
+	},
+	{
+		title:   "allowed by source",
+		policy:  &Policy{Allow: []Rule{{Source: SourceEmbedded}}},
+		plugin:  Plugin{Name: "identity", Path: "/usr/bin/lyra", Source: SourceEmbedded},
+		allowed: true,
+	},
+	{
+		title:   "allowed by checksum",
+		policy:  &Policy{Allow: []Rule{{Checksum: "sha256:" + prefixASum}}},
+		plugin:  Plugin{Name: "prefix-a", Path: "testdata/files/prefix-a", Source: SourceFile},
+		allowed: true,
+	},
+	{
+		title:   "checksum mismatch",
+		policy:  &Policy{Allow: []Rule{{Checksum: prefixASum}}},
+		plugin:  Plugin{Name: "prefix-b", Path: "testdata/files/prefix-b", Source: SourceFile},
+		allowed: false,
+	},
+	{
+		title:   "checksum of missing file",
+		policy:  &Policy{Deny: []Rule{{Checksum: prefixASum}}},
+		plugin:  Plugin{Name: "missing", Path: "testdata/files/missing", Source: SourceFile},
+		allowed: false,
+	},
+}
+
+func Test_PolicyCheck(t *testing.T) {
+	for _, test := range policyTests {
+		t.Run(test.title, func(t *testing.T) {
+			err := test.policy.Check(&test.plugin)
+			if test.allowed {
+				assert.Nil(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func Test_LoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "policy.yaml")
+	require.Nil(t, ioutil.WriteFile(file, []byte("allow:\n  - name: goplugin-*\n    source: file\ndeny:\n  - path: /tmp/*\n"), 0644))
+	p, err := LoadPolicy(file)
+	require.Nil(t, err)
+	assert.Equal(t, &Policy{Allow: []Rule{{Name: "goplugin-*", Source: "file"}}, Deny: []Rule{{Path: "/tmp/*"}}}, p)
+
+	require.Nil(t, ioutil.WriteFile(file, []byte("allow:\n  - {}\n"), 0644))
+	_, err = LoadPolicy(file)
+	assert.Error(t, err)
+
+	require.Nil(t, ioutil.WriteFile(file, []byte("allow:\n  - nmae: goplugin-*\n"), 0644))
+	_, err = LoadPolicy(file)
+	assert.Error(t, err)
+}
+
+func Test_PluginFor(t *testing.T) {
+	p := pluginFor(os.Args[0], []string{"--debug", "plugin", "identity"})
+	assert.Equal(t, "identity", p.Name)
+	assert.Equal(t, SourceEmbedded, p.Source)
+
+	p = pluginFor("testdata/files/prefix-a", nil)
+	assert.Equal(t, "prefix-a", p.Name)
+	assert.True(t, filepath.IsAbs(p.Path))
+	assert.Equal(t, SourceFile, p.Source)
+
+	p = pluginFor(nodeExecutable(), []string{"plugins/tsplugin-example.js"})
+	assert.Equal(t, "tsplugin-example", p.Name)
+	assert.True(t, p.TypeScript)
+}
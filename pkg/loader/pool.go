@@ -0,0 +1,107 @@
+package loader
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// PluginPool keeps plugin processes alive so that the loaders of consecutive runs in a long running
+// process, such as the controller, can share them instead of starting them again for each run. A
+// process that no run has asked for during the idle timeout is stopped.
+//
+// Only plugins that provide resources are pooled. The embedded plugins and the plugins started by
+// Lyra Links are always started for each run.
+type PluginPool struct {
+	lock        sync.Mutex
+	idleTimeout time.Duration
+	plugins     map[string]*pooledPlugin
+}
+
+type pooledPlugin struct {
+	client  *plugin.Client
+	service serviceapi.Service
+	modTime time.Time
+	size    int64
+	timer   *time.Timer
+}
+
+// NewPluginPool creates a pool that stops plugins that haven't been used for the given duration
+func NewPluginPool(idleTimeout time.Duration) *PluginPool {
+	return &PluginPool{idleTimeout: idleTimeout, plugins: map[string]*pooledPlugin{}}
+}
+
+// WithPluginPool makes the loader take plugins from the given pool rather than starting them for the
+// current run only
+func WithPluginPool(pool *PluginPool) Option {
+	return func(l *Loader) {
+		l.pool = pool
+	}
+}
+
+// get returns the service of a pooled plugin that was started using the given command and arguments,
+// starting the plugin if needed. A plugin that has exited, or whose file has changed since it was
+// started, is started again.
+func (p *PluginPool) get(l *Loader, cmd string, cmdArgs []string) (serviceapi.Service, error) {
+	pl := pluginFor(cmd, cmdArgs)
+	// The loader that asks for the plugin might be configured with a different policy
+	if l.policy != nil {
+		if err := l.policy.Check(pl); err != nil {
+			return nil, err
+		}
+	}
+	var modTime time.Time
+	var size int64
+	if fi, err := os.Stat(pl.Path); err == nil {
+		modTime, size = fi.ModTime(), fi.Size()
+	}
+
+	key := strings.Join(append([]string{cmd}, cmdArgs...), "\x00")
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if pp, ok := p.plugins[key]; ok {
+		if !pp.client.Exited() && pp.modTime.Equal(modTime) && pp.size == size {
+			l.logger.Debug("using running plugin", "plugin", cmd)
+			pp.timer.Reset(p.idleTimeout)
+			return pp.service, nil
+		}
+		p.remove(key, pp)
+	}
+
+	client, service, err := l.newClient(context.Background(), cmd, cmdArgs, false)
+	if err != nil {
+		return nil, err
+	}
+	pp := &pooledPlugin{client: client, service: service, modTime: modTime, size: size}
+	pp.timer = time.AfterFunc(p.idleTimeout, func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if p.plugins[key] == pp {
+			l.logger.Debug("stopping idle plugin", "plugin", cmd)
+			p.remove(key, pp)
+		}
+	})
+	p.plugins[key] = pp
+	return service, nil
+}
+
+// remove stops the given plugin and removes it from the pool. The caller must hold the lock.
+func (p *PluginPool) remove(key string, pp *pooledPlugin) {
+	pp.timer.Stop()
+	pp.client.Kill()
+	delete(p.plugins, key)
+}
+
+// Close stops all plugins in the pool
+func (p *PluginPool) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for key, pp := range p.plugins {
+		p.remove(key, pp)
+	}
+}
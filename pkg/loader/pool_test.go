@@ -0,0 +1,108 @@
+package loader
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/lyra/pkg/grpc"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPluginArg makes the test binary serve a plugin instead of running the tests
+const testPluginArg = `test-plugin`
+
+// testAPI is the API provided by the test plugin
+type testAPI struct{}
+
+func (*testAPI) Echo(s string) string {
+	return s
+}
+
+func TestMain(m *testing.M) {
+	if os.Args[len(os.Args)-1] == testPluginArg {
+		eval.Puppet.Do(func(c eval.Context) {
+			sb := service.NewServerBuilder(c, `Test`)
+			sb.RegisterAPI(`Test::Echo`, &testAPI{})
+			grpc.Serve(c, sb.Server())
+		})
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func newPoolLoader(pool *PluginPool) *Loader {
+	return New(hclog.NewNullLogger(), eval.Puppet.SystemLoader(), WithPluginPool(pool), WithPluginTLS(TLSOff), WithMetadataCache(``))
+}
+
+func Test_PluginPool(t *testing.T) {
+	pool := NewPluginPool(time.Minute)
+	defer pool.Close()
+	args := []string{`-test.run=^$`, testPluginArg}
+
+	first, err := newPoolLoader(pool).startProvider(nil, os.Args[0], args)
+	require.NoError(t, err)
+
+	// The loader of the next run gets the same process
+	second, err := newPoolLoader(pool).startProvider(nil, os.Args[0], args)
+	require.NoError(t, err)
+	assert.True(t, first == second)
+	require.Len(t, pool.plugins, 1)
+
+	// A plugin that has exited is started again
+	for _, pp := range pool.plugins {
+		pp.client.Kill()
+	}
+	third, err := newPoolLoader(pool).startProvider(nil, os.Args[0], args)
+	require.NoError(t, err)
+	assert.False(t, first == third)
+	assert.Len(t, pool.plugins, 1)
+
+	pool.Close()
+	assert.Empty(t, pool.plugins)
+}
+
+func Test_PluginPoolIdleTimeout(t *testing.T) {
+	pool := NewPluginPool(100 * time.Millisecond)
+	defer pool.Close()
+
+	_, err := newPoolLoader(pool).startProvider(nil, os.Args[0], []string{`-test.run=^$`, testPluginArg})
+	require.NoError(t, err)
+	pp := pool.plugins[testPluginKey()]
+	require.NotNil(t, pp)
+
+	for i := 0; i < 100 && pooled(pool) > 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, 0, pooled(pool))
+	assert.True(t, pp.client.Exited())
+}
+
+func Test_PluginPoolPolicy(t *testing.T) {
+	pool := NewPluginPool(time.Minute)
+	defer pool.Close()
+	args := []string{`-test.run=^$`, testPluginArg}
+
+	_, err := newPoolLoader(pool).startProvider(nil, os.Args[0], args)
+	require.NoError(t, err)
+
+	// A running plugin is not handed out to a loader whose policy denies it
+	l := newPoolLoader(pool)
+	l.policy = &Policy{Deny: []Rule{{Name: `*`}}}
+	_, err = l.startProvider(nil, os.Args[0], args)
+	assert.Error(t, err)
+}
+
+func testPluginKey() string {
+	return os.Args[0] + "\x00-test.run=^$\x00" + testPluginArg
+}
+
+func pooled(pool *PluginPool) int {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return len(pool.plugins)
+}
@@ -0,0 +1,106 @@
+package sourcemap
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// jsLocation matches locations such as '/path/to/file.js:12:34' found in node stack traces
+var jsLocation = regexp.MustCompile(`([^\s()'"]+\.js):(\d+):(\d+)`)
+
+// Rewriter translates JavaScript locations in text into locations in the original
+// sources using the '.js.map' files found next to the JavaScript files
+type Rewriter struct {
+	lock sync.Mutex
+	maps map[string]*Map
+}
+
+// NewRewriter creates a Rewriter with an empty source map cache
+func NewRewriter() *Rewriter {
+	return &Rewriter{maps: map[string]*Map{}}
+}
+
+// Rewrite returns text with all JavaScript locations that can be mapped replaced by their
+// original source locations
+func (r *Rewriter) Rewrite(text string) string {
+	return jsLocation.ReplaceAllStringFunc(text, func(loc string) string {
+		parts := jsLocation.FindStringSubmatch(loc)
+		m := r.mapFor(parts[1])
+		if m == nil {
+			return loc
+		}
+		line, _ := strconv.Atoi(parts[2])
+		col, _ := strconv.Atoi(parts[3])
+		if pos, ok := m.Lookup(line, col); ok {
+			return fmt.Sprintf("%s:%d:%d", pos.Source, pos.Line, pos.Col)
+		}
+		return loc
+	})
+}
+
+func (r *Rewriter) mapFor(jsFile string) *Map {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if m, ok := r.maps[jsFile]; ok {
+		return m
+	}
+	var m *Map
+	mapFile := jsFile + ".map"
+	if _, err := os.Stat(mapFile); err == nil {
+		if m, err = ParseFile(mapFile); err != nil {
+			hclog.Default().Debug("unable to read source map", "file", mapFile, "err", err)
+		}
+	}
+	// Misses are cached too so that the file system isn't consulted for every line
+	r.maps[jsFile] = m
+	return m
+}
+
+type logger struct {
+	hclog.Logger
+	rewriter *Rewriter
+}
+
+// NewLogger returns a logger that rewrites JavaScript locations in all logged messages before
+// passing them on to the given logger. It is used for plugins written in TypeScript so that
+// errors are reported against the TypeScript source rather than the compiled JavaScript.
+func NewLogger(l hclog.Logger) hclog.Logger {
+	return &logger{Logger: l, rewriter: NewRewriter()}
+}
+
+func (l *logger) Trace(msg string, args ...interface{}) {
+	l.Logger.Trace(l.rewriter.Rewrite(msg), args...)
+}
+
+func (l *logger) Debug(msg string, args ...interface{}) {
+	l.Logger.Debug(l.rewriter.Rewrite(msg), args...)
+}
+
+func (l *logger) Info(msg string, args ...interface{}) {
+	l.Logger.Info(l.rewriter.Rewrite(msg), args...)
+}
+
+func (l *logger) Warn(msg string, args ...interface{}) {
+	l.Logger.Warn(l.rewriter.Rewrite(msg), args...)
+}
+
+func (l *logger) Error(msg string, args ...interface{}) {
+	l.Logger.Error(l.rewriter.Rewrite(msg), args...)
+}
+
+func (l *logger) With(args ...interface{}) hclog.Logger {
+	return &logger{Logger: l.Logger.With(args...), rewriter: l.rewriter}
+}
+
+func (l *logger) Named(name string) hclog.Logger {
+	return &logger{Logger: l.Logger.Named(name), rewriter: l.rewriter}
+}
+
+func (l *logger) ResetNamed(name string) hclog.Logger {
+	return &logger{Logger: l.Logger.ResetNamed(name), rewriter: l.rewriter}
+}
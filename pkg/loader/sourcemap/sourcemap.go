@@ -0,0 +1,157 @@
+package sourcemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Map is a decoded version 3 source map as produced by the TypeScript compiler
+type Map struct {
+	Version    int      `json:"version"`
+	File       string   `json:"file"`
+	SourceRoot string   `json:"sourceRoot"`
+	Sources    []string `json:"sources"`
+	Mappings   string   `json:"mappings"`
+
+	dir   string
+	lines [][]segment
+}
+
+// segment maps a generated column to a position in one of the original sources
+type segment struct {
+	genCol int
+	source int
+	line   int
+	col    int
+}
+
+// Position is a position within an original source file. Line and Col are 1-based
+type Position struct {
+	Source string
+	Line   int
+	Col    int
+}
+
+// Parse decodes the source map contained in bts. The dir is used to resolve relative
+// source paths and is normally the directory of the map file
+func Parse(dir string, bts []byte) (*Map, error) {
+	m := &Map{dir: dir}
+	if err := json.Unmarshal(bts, m); err != nil {
+		return nil, err
+	}
+	if m.Version != 3 {
+		return nil, fmt.Errorf("unsupported source map version %d", m.Version)
+	}
+	if err := m.decode(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ParseFile reads and decodes the source map file at path
+func ParseFile(path string) (*Map, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(filepath.Dir(path), bts)
+}
+
+// Lookup returns the original position for the given 1-based line and column in the
+// generated file
+func (m *Map) Lookup(line, col int) (Position, bool) {
+	line--
+	col--
+	if line < 0 || line >= len(m.lines) {
+		return Position{}, false
+	}
+	segs := m.lines[line]
+	i := sort.Search(len(segs), func(i int) bool { return segs[i].genCol > col })
+	if i == 0 {
+		return Position{}, false
+	}
+	s := segs[i-1]
+	if s.source < 0 || s.source >= len(m.Sources) {
+		return Position{}, false
+	}
+	return Position{Source: m.sourcePath(s.source), Line: s.line + 1, Col: s.col + 1}, true
+}
+
+func (m *Map) sourcePath(i int) string {
+	src := m.Sources[i]
+	if m.SourceRoot != `` {
+		src = m.SourceRoot + `/` + src
+	}
+	if filepath.IsAbs(src) || m.dir == `` {
+		return src
+	}
+	return filepath.Join(m.dir, src)
+}
+
+func (m *Map) decode() error {
+	var source, line, col int
+	for _, ls := range strings.Split(m.Mappings, `;`) {
+		var segs []segment
+		genCol := 0
+		for _, ss := range strings.Split(ls, `,`) {
+			if ss == `` {
+				continue
+			}
+			fields, err := decodeVLQ(ss)
+			if err != nil {
+				return err
+			}
+			genCol += fields[0]
+			seg := segment{genCol: genCol, source: -1}
+			if len(fields) >= 4 {
+				source += fields[1]
+				line += fields[2]
+				col += fields[3]
+				seg.source = source
+				seg.line = line
+				seg.col = col
+			}
+			segs = append(segs, seg)
+		}
+		m.lines = append(m.lines, segs)
+	}
+	return nil
+}
+
+const base64Chars = `ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/`
+
+// decodeVLQ decodes a base64 VLQ encoded mapping segment into its fields
+func decodeVLQ(s string) ([]int, error) {
+	fields := make([]int, 0, 5)
+	value := 0
+	shift := uint(0)
+	for _, c := range s {
+		digit := strings.IndexRune(base64Chars, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base64 character '%c' in source map mapping", c)
+		}
+		value += (digit & 0x1f) << shift
+		if digit&0x20 != 0 {
+			shift += 5
+			continue
+		}
+		if value&1 != 0 {
+			fields = append(fields, -(value >> 1))
+		} else {
+			fields = append(fields, value>>1)
+		}
+		value = 0
+		shift = 0
+	}
+	if shift != 0 {
+		return nil, fmt.Errorf("unterminated VLQ value in source map mapping '%s'", s)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty source map segment")
+	}
+	return fields, nil
+}
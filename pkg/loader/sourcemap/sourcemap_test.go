@@ -0,0 +1,89 @@
+package sourcemap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMap = `{
+  "version": 3,
+  "file": "plugin.js",
+  "sourceRoot": "",
+  "sources": ["../src/plugin.ts"],
+  "names": [],
+  "mappings": "AAAA,IAAI;AAEA"
+}`
+
+var vlqTests = []struct {
+	title    string
+	encoded  string
+	error    bool
+	expected []int
+}{
+	{title: "zeroes", encoded: "AAAA", expected: []int{0, 0, 0, 0}},
+	{title: "negative", encoded: "D", expected: []int{-1}},
+	{title: "continuation", encoded: "gB", expected: []int{16}},
+	{title: "invalid character", encoded: "A*", error: true},
+	{title: "unterminated", encoded: "g", error: true},
+}
+
+func Test_DecodeVLQ(t *testing.T) {
+	for _, test := range vlqTests {
+		t.Run(test.title, func(t *testing.T) {
+			fields, err := decodeVLQ(test.encoded)
+			if test.error {
+				assert.Error(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, fields)
+		})
+	}
+}
+
+func Test_Lookup(t *testing.T) {
+	m, err := Parse("/project/dist", []byte(testMap))
+	require.Nil(t, err)
+
+	pos, ok := m.Lookup(1, 1)
+	assert.True(t, ok)
+	assert.Equal(t, Position{Source: "/project/src/plugin.ts", Line: 1, Col: 1}, pos)
+
+	pos, ok = m.Lookup(1, 6)
+	assert.True(t, ok)
+	assert.Equal(t, Position{Source: "/project/src/plugin.ts", Line: 1, Col: 5}, pos)
+
+	pos, ok = m.Lookup(2, 1)
+	assert.True(t, ok)
+	assert.Equal(t, Position{Source: "/project/src/plugin.ts", Line: 3, Col: 5}, pos)
+
+	_, ok = m.Lookup(3, 1)
+	assert.False(t, ok)
+}
+
+func Test_ParseUnsupportedVersion(t *testing.T) {
+	_, err := Parse("", []byte(`{"version": 2, "mappings": ""}`))
+	assert.Error(t, err)
+}
+
+func Test_Rewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sourcemap")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	jsFile := filepath.Join(dir, "plugin.js")
+	require.Nil(t, ioutil.WriteFile(jsFile+".map", []byte(testMap), 0644))
+
+	r := NewRewriter()
+	assert.Equal(t,
+		"at create ("+filepath.Join(filepath.Dir(dir), "src", "plugin.ts")+":3:5)",
+		r.Rewrite("at create ("+jsFile+":2:1)"))
+
+	// Locations without a map are left untouched
+	assert.Equal(t, "at other (/no/such/file.js:2:1)", r.Rewrite("at other (/no/such/file.js:2:1)"))
+}
@@ -0,0 +1,208 @@
+package loader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/lyraproj/lyra/pkg/grpc"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+const (
+	// PluginTLSEnvVar selects the TLS mode used for plugin connections, see TLSMode
+	PluginTLSEnvVar = "LYRA_PLUGIN_TLS"
+	// PluginTLSCertEnvVar names a PEM file with the client certificate used for plugin connections
+	PluginTLSCertEnvVar = "LYRA_PLUGIN_TLS_CERT"
+	// PluginTLSKeyEnvVar names a PEM file with the key of the client certificate
+	PluginTLSKeyEnvVar = "LYRA_PLUGIN_TLS_KEY"
+	// PluginTLSCAEnvVar names a PEM file with the CA certificates used to verify plugins
+	PluginTLSCAEnvVar = "LYRA_PLUGIN_TLS_CA"
+)
+
+// TLSMode determines when the gRPC connections between lyra and its plugins use TLS
+type TLSMode string
+
+const (
+	// TLSAuto uses mutual TLS with certificates generated for each session for all plugins written in Go,
+	// including the embedded plugins. Plugins written in other languages are connected without TLS.
+	TLSAuto = TLSMode("auto")
+	// TLSRequired uses mutual TLS for all plugins. Plugins that don't support it fail to start.
+	TLSRequired = TLSMode("required")
+	// TLSOff never uses TLS
+	TLSOff = TLSMode("off")
+)
+
+// ParseTLSMode returns the TLS mode with the given name
+func ParseTLSMode(s string) (TLSMode, error) {
+	switch m := TLSMode(s); m {
+	case TLSAuto, TLSRequired, TLSOff:
+		return m, nil
+	default:
+		return ``, fmt.Errorf("invalid plugin TLS mode '%s', expected %s, %s or %s", s, TLSAuto, TLSRequired, TLSOff)
+	}
+}
+
+// WithPluginTLS sets the TLS mode used for plugin connections. It takes precedence over the
+// LYRA_PLUGIN_TLS environment variable. The default mode is TLSAuto.
+func WithPluginTLS(mode TLSMode) Option {
+	return func(l *Loader) {
+		l.tlsMode = mode
+	}
+}
+
+// WithTLSConfig makes the loader use the given TLS configuration instead of generating certificates for
+// each session. This is needed when plugins run elsewhere and present certificates issued by a known CA.
+// It takes precedence over the certificates named by the LYRA_PLUGIN_TLS_* environment variables.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(l *Loader) {
+		l.tlsConfig = config
+	}
+}
+
+// LoadTLSConfig creates a TLS configuration that presents the given client certificate and verifies
+// servers using the CA certificates in caFile
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// WithMaxMessageSize sets the maximum size, in bytes, of a message sent between lyra and a plugin. The
+// plugin is given the same limit. Larger values are streamed in chunks to plugins that support it. It
+// takes precedence over the LYRA_PLUGIN_MAX_MESSAGE_SIZE environment variable.
+func WithMaxMessageSize(size int) Option {
+	return func(l *Loader) {
+		l.maxMessageSize = size
+	}
+}
+
+// tlsFromEnv initializes the TLS mode and configuration, and the maximum message size, from the environment
+// unless they were given as options
+func (l *Loader) tlsFromEnv() error {
+	if l.maxMessageSize == 0 {
+		size, err := grpc.MaxMessageSizeFromEnv()
+		if err != nil {
+			return err
+		}
+		l.maxMessageSize = size
+	}
+	if l.tlsMode == `` {
+		l.tlsMode = TLSAuto
+		if v := os.Getenv(PluginTLSEnvVar); v != `` {
+			mode, err := ParseTLSMode(v)
+			if err != nil {
+				return err
+			}
+			l.tlsMode = mode
+		}
+	}
+	if l.tlsConfig == nil {
+		certFile, keyFile, caFile := os.Getenv(PluginTLSCertEnvVar), os.Getenv(PluginTLSKeyEnvVar), os.Getenv(PluginTLSCAEnvVar)
+		if certFile == `` && keyFile == `` && caFile == `` {
+			return nil
+		}
+		config, err := LoadTLSConfig(certFile, keyFile, caFile)
+		if err != nil {
+			return fmt.Errorf("unable to load plugin TLS configuration: %s", err)
+		}
+		l.tlsConfig = config
+	}
+	return nil
+}
+
+// clientConfig returns the go-plugin configuration used when starting the given command. The token
+// is sent with every call so that the plugin can reject calls from other processes.
+func (l *Loader) clientConfig(cmd *exec.Cmd, useTLS bool, token string) *plugin.ClientConfig {
+	config := &plugin.ClientConfig{
+		HandshakeConfig: grpc.Handshake,
+		Plugins: map[string]plugin.Plugin{
+			"server": &grpc.PluginClient{Token: token, MaxMessageSize: l.maxMessageSize},
+		},
+		Managed:          true,
+		Cmd:              cmd,
+		Logger:           l.pluginLogger,
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+	}
+	if useTLS {
+		if l.tlsConfig != nil {
+			config.TLSConfig = l.tlsConfig.Clone()
+		} else {
+			config.AutoMTLS = true
+		}
+	}
+	return config
+}
+
+// useTLS returns true if the connection to the given plugin must use TLS
+func (l *Loader) useTLS(p *Plugin) bool {
+	switch l.tlsMode {
+	case TLSOff:
+		return false
+	case TLSRequired:
+		return true
+	default:
+		return !p.TypeScript
+	}
+}
+
+// start starts the plugin executed by the given command and returns the service that it provides
+func (l *Loader) start(ctx context.Context, cmd string, cmdArgs []string) (serviceapi.Service, error) {
+	_, service, err := l.newClient(ctx, cmd, cmdArgs, true)
+	return service, err
+}
+
+// newClient starts the plugin executed by the given command and returns its client together with the
+// service that it provides. A managed plugin is killed by plugin.CleanupClients.
+func (l *Loader) newClient(ctx context.Context, cmd string, cmdArgs []string, managed bool) (*plugin.Client, serviceapi.Service, error) {
+	if l.transportErr != nil {
+		return nil, nil, l.transportErr
+	}
+	p := pluginFor(cmd, cmdArgs)
+	serviceCmd, err := l.command(ctx, p, cmd, cmdArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := grpc.NewToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	serviceCmd.Env = append(serviceCmd.Env, grpc.TokenEnvVar+`=`+token)
+	if l.maxMessageSize > 0 {
+		serviceCmd.Env = append(serviceCmd.Env, grpc.MaxMessageSizeEnvVar+`=`+strconv.Itoa(l.maxMessageSize))
+	}
+
+	// FIXME Load should probably handle the context
+	config := l.clientConfig(serviceCmd, l.useTLS(p), token)
+	config.Managed = managed
+	client := plugin.NewClient(config)
+	grpcClient, err := client.Client()
+	if err != nil {
+		l.logger.Error("error creating GRPC client", "error", err)
+		client.Kill()
+		return nil, nil, err
+	}
+	raw, err := grpcClient.Dispense("server")
+	if err != nil {
+		l.logger.Error("error dispensing plugin", "plugin", "server", "error", err)
+		client.Kill()
+		return nil, nil, err
+	}
+	return client, raw.(serviceapi.Service), nil
+}
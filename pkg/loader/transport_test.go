@@ -0,0 +1,121 @@
+package loader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lyraproj/lyra/pkg/grpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseTLSMode(t *testing.T) {
+	for _, s := range []string{"auto", "required", "off"} {
+		m, err := ParseTLSMode(s)
+		assert.Nil(t, err)
+		assert.Equal(t, TLSMode(s), m)
+	}
+	_, err := ParseTLSMode("on")
+	assert.Error(t, err)
+}
+
+func Test_UseTLS(t *testing.T) {
+	goPlugin := &Plugin{Name: "goplugin-aws"}
+	tsPlugin := &Plugin{Name: "tsplugin-aws", TypeScript: true}
+
+	l := &Loader{}
+	require.Nil(t, l.tlsFromEnv())
+	assert.Equal(t, TLSAuto, l.tlsMode)
+	assert.True(t, l.useTLS(goPlugin))
+	assert.False(t, l.useTLS(tsPlugin))
+
+	l.tlsMode = TLSRequired
+	assert.True(t, l.useTLS(tsPlugin))
+
+	l.tlsMode = TLSOff
+	assert.False(t, l.useTLS(goPlugin))
+
+	os.Setenv(PluginTLSEnvVar, "sometimes")
+	defer os.Unsetenv(PluginTLSEnvVar)
+	assert.Error(t, (&Loader{}).tlsFromEnv())
+}
+
+func Test_ClientConfig(t *testing.T) {
+	l := &Loader{}
+	cmd := exec.Command("goplugin-example")
+
+	assert.True(t, l.clientConfig(cmd, true, ``).AutoMTLS)
+	assert.False(t, l.clientConfig(cmd, false, ``).AutoMTLS)
+	assert.Equal(t, `abc`, l.clientConfig(cmd, false, `abc`).Plugins[`server`].(*grpc.PluginClient).Token)
+
+	dir, err := ioutil.TempDir("", "transport")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	l.tlsConfig, err = LoadTLSConfig(certFile, keyFile, certFile)
+	require.Nil(t, err)
+	config := l.clientConfig(cmd, true, ``)
+	assert.False(t, config.AutoMTLS)
+	require.NotNil(t, config.TLSConfig)
+	assert.Len(t, config.TLSConfig.Certificates, 1)
+
+	_, err = LoadTLSConfig(certFile, keyFile, keyFile)
+	assert.Error(t, err)
+}
+
+func Test_MaxMessageSize(t *testing.T) {
+	l := &Loader{}
+	require.Nil(t, l.tlsFromEnv())
+	assert.Equal(t, grpc.DefaultMaxMessageSize, l.maxMessageSize)
+
+	os.Setenv(grpc.MaxMessageSizeEnvVar, "16777216")
+	defer os.Unsetenv(grpc.MaxMessageSizeEnvVar)
+	l = &Loader{}
+	require.Nil(t, l.tlsFromEnv())
+	assert.Equal(t, 16<<20, l.maxMessageSize)
+	assert.Equal(t, 16<<20, l.clientConfig(exec.Command("goplugin-example"), false, ``).Plugins[`server`].(*grpc.PluginClient).MaxMessageSize)
+
+	// The option takes precedence over the environment
+	l = &Loader{}
+	WithMaxMessageSize(1 << 20)(l)
+	require.Nil(t, l.tlsFromEnv())
+	assert.Equal(t, 1<<20, l.maxMessageSize)
+
+	os.Setenv(grpc.MaxMessageSizeEnvVar, "small")
+	assert.Error(t, (&Loader{}).tlsFromEnv())
+}
+
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
@@ -0,0 +1,18 @@
+type Test = TypeSet[{
+  pcore_uri => 'http://puppet.com/2016.1/pcore',
+  pcore_version => '1.0.0',
+  name_authority => 'http://puppet.com/2016.1/runtime',
+  name => 'Test',
+  version => '0.1.0',
+  types => {
+    Person => {
+      attributes => {
+        'name' => String,
+        'age' => {
+          'type' => Integer,
+          'value' => 0
+        }
+      }
+    }
+  }
+}]
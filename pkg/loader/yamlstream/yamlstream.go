@@ -0,0 +1,317 @@
+// Package yamlstream creates the activity of a YAML workflow manifest one step at a time. The manifest
+// is read line by line and each step of the workflow is parsed on its own, so memory use while loading
+// is bounded by the size of the largest step rather than by the size of the manifest. This matters for
+// generated manifests with thousands of steps.
+//
+// The steps are found using the indentation of the manifest, which works for manifests written in the
+// block style that is used for workflows. Manifests that can't be split that way, e.g. those that use
+// flow style for the workflow, anchors and aliases, or that declare the typespace of the workflow after
+// its activities, are parsed in full.
+package yamlstream
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-workflow/yaml"
+	"github.com/lyraproj/servicesdk/wf"
+	"github.com/lyraproj/servicesdk/wfapi"
+)
+
+// errNotStreamable is returned when a manifest must be parsed in full
+var errNotStreamable = errors.New(`manifest cannot be parsed one step at a time`)
+
+// CreateActivity creates the activity declared by the YAML workflow manifest in the given file
+func CreateActivity(c eval.Context, file string) wfapi.Activity {
+	activity, err := stream(c, file)
+	if err == errNotStreamable {
+		var content []byte
+		if content, err = ioutil.ReadFile(file); err == nil {
+			return yaml.CreateActivity(c, file, content)
+		}
+	}
+	if err != nil {
+		panic(eval.Error(eval.EVAL_UNABLE_TO_READ_FILE, issue.H{`path`: file, `detail`: err.Error()}))
+	}
+	return activity
+}
+
+// stream creates the activity of each step of the workflow as soon as the step has been read, and then
+// the workflow itself from what remains of the manifest
+func stream(c eval.Context, file string) (wfapi.Activity, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var steps []wfapi.Activity
+	head, err := split(f, func(doc []byte) error {
+		// The step is declared in a workflow of its own that has the same name and typespace as the
+		// real one, so the step gets the same qualified name and resource type
+		w, ok := yaml.CreateActivity(c, file, doc).(wfapi.Workflow)
+		if !ok || len(w.Activities()) != 1 {
+			return errNotStreamable
+		}
+		steps = append(steps, w.Activities()[0])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	w, ok := yaml.CreateActivity(c, file, head).(wfapi.Workflow)
+	if !ok {
+		return nil, errNotStreamable
+	}
+	return wf.NewWorkflow(w.Name(), w.When(), w.Input(), w.Output(), steps), nil
+}
+
+// split reads a workflow manifest and calls step with a document for each of its steps, in the order
+// they are declared. The document declares a workflow with the name and typespace of the manifest that
+// contains the step as its only activity. The returned document is the manifest without its steps.
+func split(r io.Reader, step func(doc []byte) error) ([]byte, error) {
+	s := &splitter{step: step}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if !bytes.HasSuffix(line, []byte{'\n'}) {
+				line = append(line, '\n')
+			}
+			if lerr := s.line(line); lerr != nil {
+				return nil, lerr
+			}
+		}
+		if err == io.EOF {
+			return s.end()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+type splitter struct {
+	step func(doc []byte) error
+
+	// name is the line that holds the name of the workflow
+	name []byte
+
+	// indent is the indentation of the properties of the workflow and stepIndent that of its steps
+	indent     int
+	stepIndent int
+
+	// head holds the properties of the workflow except for its activities, and typespace the
+	// typespace property
+	head      bytes.Buffer
+	typespace bytes.Buffer
+	property  string
+
+	// activities is the line that starts the activities, inActivities is true while they are read,
+	// and current holds the step that is being read
+	activities   []byte
+	inActivities bool
+	current      *bytes.Buffer
+}
+
+func (s *splitter) line(line []byte) error {
+	trimmed := bytes.TrimLeft(line, ` `)
+	content := bytes.TrimRight(trimmed, "\r\n")
+	indent := len(line) - len(trimmed)
+	if len(content) == 0 || content[0] == '#' {
+		// Blank lines and comments belong to whatever precedes them
+		s.append(line)
+		return nil
+	}
+	if content[0] == '\t' || hasAnchorOrAlias(content) {
+		return errNotStreamable
+	}
+
+	if indent == 0 {
+		if s.name != nil {
+			// Another definition or document
+			return errNotStreamable
+		}
+		if string(content) == `---` {
+			return nil
+		}
+		if _, value, ok := key(content); !ok || len(value) > 0 {
+			return errNotStreamable
+		}
+		s.name = line
+		return nil
+	}
+	if s.name == nil {
+		return errNotStreamable
+	}
+
+	if s.indent == 0 {
+		s.indent = indent
+	}
+	switch {
+	case indent < s.indent:
+		return errNotStreamable
+	case indent == s.indent:
+		if isSequenceEntry(content) {
+			// A sequence may be indented as much as the key that it is the value of
+			if s.inActivities {
+				return errNotStreamable
+			}
+			s.append(line)
+			return nil
+		}
+		if err := s.flush(); err != nil {
+			return err
+		}
+		name, value, ok := key(content)
+		if !ok {
+			return errNotStreamable
+		}
+		s.property = name
+		s.inActivities = false
+		switch name {
+		case `activities`:
+			if s.activities != nil || len(value) > 0 {
+				return errNotStreamable
+			}
+			s.activities = line
+			s.inActivities = true
+			return nil
+		case `typespace`:
+			if s.activities != nil {
+				// Steps that have been created already didn't get the typespace
+				return errNotStreamable
+			}
+		}
+		s.append(line)
+		return nil
+	}
+
+	if !s.inActivities {
+		s.append(line)
+		return nil
+	}
+	if s.stepIndent == 0 {
+		s.stepIndent = indent
+	}
+	switch {
+	case indent < s.stepIndent:
+		return errNotStreamable
+	case indent == s.stepIndent:
+		if _, _, ok := key(content); !ok {
+			return errNotStreamable
+		}
+		if err := s.flush(); err != nil {
+			return err
+		}
+		s.current = &bytes.Buffer{}
+	}
+	s.append(line)
+	return nil
+}
+
+// append adds the line to the step or property that is being read
+func (s *splitter) append(line []byte) {
+	switch {
+	case s.current != nil:
+		s.current.Write(line)
+	case s.inActivities || s.name == nil:
+	default:
+		s.head.Write(line)
+		if s.property == `typespace` {
+			s.typespace.Write(line)
+		}
+	}
+}
+
+// flush passes the step that has been read, if any, to the step function
+func (s *splitter) flush() error {
+	if s.current == nil {
+		return nil
+	}
+	doc := &bytes.Buffer{}
+	doc.Write(s.name)
+	doc.Write(s.typespace.Bytes())
+	doc.Write(s.activities)
+	doc.Write(s.current.Bytes())
+	s.current = nil
+	return s.step(doc.Bytes())
+}
+
+// end passes the last step to the step function and returns the manifest without its steps
+func (s *splitter) end() ([]byte, error) {
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	if s.activities == nil {
+		// Not a workflow
+		return nil, errNotStreamable
+	}
+	doc := &bytes.Buffer{}
+	doc.Write(s.name)
+	doc.Write(s.head.Bytes())
+	doc.Write(bytes.Repeat([]byte{' '}, s.indent))
+	doc.WriteString("activities: {}\n")
+	return doc.Bytes(), nil
+}
+
+// key returns the key of a line that starts a mapping entry and the value that follows it on the same
+// line, without any comment
+func key(content []byte) (string, []byte, bool) {
+	var name string
+	var rest []byte
+	if q := content[0]; q == '"' || q == '\'' {
+		end := bytes.IndexByte(content[1:], q)
+		if end < 0 || len(content) < end+3 || content[end+2] != ':' {
+			return ``, nil, false
+		}
+		name = string(content[1 : end+1])
+		rest = content[end+3:]
+	} else {
+		i := bytes.Index(content, []byte(`: `))
+		if i < 0 && content[len(content)-1] == ':' {
+			i = len(content) - 1
+		}
+		if i <= 0 {
+			return ``, nil, false
+		}
+		name = string(content[:i])
+		rest = content[i+1:]
+	}
+	if len(rest) > 0 && rest[0] != ' ' {
+		return ``, nil, false
+	}
+	rest = bytes.TrimSpace(rest)
+	if len(rest) > 0 && rest[0] == '#' {
+		rest = nil
+	}
+	return name, rest, true
+}
+
+func isSequenceEntry(content []byte) bool {
+	return content[0] == '-' && (len(content) == 1 || content[1] == ' ')
+}
+
+// hasAnchorOrAlias returns true if the content contains what looks like a YAML anchor or alias. Steps are
+// parsed on their own so an alias can't refer to an anchor in another step.
+func hasAnchorOrAlias(content []byte) bool {
+	for i := bytes.IndexAny(content, `&*`); i >= 0 && i+1 < len(content); {
+		if i == 0 || bytes.IndexByte([]byte(" \t[{,"), content[i-1]) >= 0 {
+			if bytes.IndexByte([]byte(" \t,]}"), content[i+1]) < 0 {
+				return true
+			}
+		}
+		n := bytes.IndexAny(content[i+1:], `&*`)
+		if n < 0 {
+			break
+		}
+		i += n + 1
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package yamlstream
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-workflow/yaml"
+	"github.com/lyraproj/servicesdk/wfapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	// Provides eval.Puppet
+	_ "github.com/lyraproj/puppet-evaluator/pcore"
+)
+
+func Test_Split(t *testing.T) {
+	manifest := `# generated
+people:
+  typespace: test
+  output:
+  - first
+  activities:
+    # first step
+    person:
+      state:
+        name: Bob
+
+    other:
+      state: {name: Tom}
+  when: ready
+`
+	var steps []string
+	head, err := split(strings.NewReader(manifest), func(doc []byte) error {
+		steps = append(steps, string(doc))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"people:\n  typespace: test\n  activities:\n    person:\n      state:\n        name: Bob\n\n",
+		"people:\n  typespace: test\n  activities:\n    other:\n      state: {name: Tom}\n",
+	}, steps)
+	assert.Equal(t, "people:\n  typespace: test\n  output:\n  - first\n  when: ready\n  activities: {}\n", string(head))
+}
+
+func Test_SplitNotStreamable(t *testing.T) {
+	for _, manifest := range []string{
+		"people: {activities: {person: {state: {name: Bob}}}}\n",
+		"people:\n  activities: {person: {state: {name: Bob}}}\n",
+		"people:\n  activities:\n  - person\n",
+		"people:\n  activities:\n    person:\n      state: &s\n        name: Bob\n",
+		"people:\n  activities:\n    person:\n      state: *s\n",
+		"people:\n  activities:\n    person:\n      state:\n        name: Bob\n  typespace: test\n",
+		"people:\n  activities:\n      person:\n        state: {}\n    other:\n      state: {}\n",
+		"people:\n  state:\n    name: Bob\n",
+		"people:\n  activities:\n    person:\n      state: {}\nother:\n  activities: {}\n",
+		"  people:\n    activities: {}\n",
+	} {
+		_, err := split(strings.NewReader(manifest), func([]byte) error { return nil })
+		assert.Equal(t, errNotStreamable, err, manifest)
+	}
+}
+
+func Test_HasAnchorOrAlias(t *testing.T) {
+	for _, s := range []string{`state: &s`, `state: *s`, `<<: *base`, `[*a, b]`, `{a: *a}`} {
+		assert.True(t, hasAnchorOrAlias([]byte(s)), s)
+	}
+	for _, s := range []string{`name: a*b`, `glob: '*.txt'`, `when: a & b`, `math: 2 * 3`, `end: &`} {
+		assert.False(t, hasAnchorOrAlias([]byte(s)), s)
+	}
+}
+
+func Test_CreateActivity(t *testing.T) {
+	for _, file := range []string{`testdata/workflow.yaml`, `testdata/anchors.yaml`} {
+		eval.Puppet.Do(func(c eval.Context) {
+			c.DoWithLoader(eval.NewFilebasedLoader(c.Loader(), `testdata`, ``, eval.PUPPET_DATA_TYPE_PATH), func() {
+				content, err := ioutil.ReadFile(file)
+				require.NoError(t, err)
+				expected := describe(yaml.CreateActivity(c, file, content))
+				assert.Equal(t, expected, describe(CreateActivity(c, file)), file)
+				assert.Contains(t, expected, `resource people::nested::inner`)
+			})
+		})
+	}
+}
+
+func Test_CreateActivityMissingFile(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		assert.Panics(t, func() { CreateActivity(c, `testdata/missing.yaml`) })
+	})
+}
+
+// describe returns a description of the given activity and everything it contains
+func describe(a wfapi.Activity) string {
+	b := &bytes.Buffer{}
+	describeTo(b, a, ``)
+	return b.String()
+}
+
+func describeTo(b *bytes.Buffer, a wfapi.Activity, indent string) {
+	fmt.Fprintf(b, "%s%s when %s input %v output %v\n", indent, a.Label(), a.When(), a.Input(), a.Output())
+	indent += `  `
+	switch a := a.(type) {
+	case wfapi.Workflow:
+		for _, child := range a.Activities() {
+			describeTo(b, child, indent)
+		}
+	case wfapi.Iterator:
+		fmt.Fprintf(b, "%s%s over %v variables %v\n", indent, a.IterationStyle(), a.Over(), a.Variables())
+		describeTo(b, a.Producer(), indent)
+	case wfapi.Resource:
+		fmt.Fprintf(b, "%s%s %v\n", indent, a.State().Type().Name(), a.State().State())
+	}
+}
@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"bufio"
+	"io"
+)
+
+// asyncWriter writes to its output in the background so that logging doesn't wait for the output.
+// Entries are buffered in a bounded queue and writers are blocked while the queue is full, so no
+// entry is ever dropped. The output is flushed whenever the queue has been drained.
+type asyncWriter struct {
+	out     *bufio.Writer
+	entries chan entry
+}
+
+// entry is either data to write or a request to flush the output, which is closed once the output has
+// been flushed
+type entry struct {
+	data    []byte
+	flushed chan struct{}
+}
+
+func newAsyncWriter(out io.Writer, size int) *asyncWriter {
+	w := &asyncWriter{out: bufio.NewWriter(out), entries: make(chan entry, size)}
+	go w.run()
+	return w
+}
+
+func (w *asyncWriter) run() {
+	for e := range w.entries {
+		if e.flushed != nil {
+			w.out.Flush()
+			close(e.flushed)
+			continue
+		}
+		w.out.Write(e.data)
+		if len(w.entries) == 0 {
+			w.out.Flush()
+		}
+	}
+}
+
+// Write queues a copy of the given data. It blocks while the queue is full.
+func (w *asyncWriter) Write(data []byte) (int, error) {
+	w.entries <- entry{data: append([]byte{}, data...)}
+	return len(data), nil
+}
+
+// Flush returns when everything that was queued before the call has been written to the output
+func (w *asyncWriter) Flush() {
+	flushed := make(chan struct{})
+	w.entries <- entry{flushed: flushed}
+	<-flushed
+}
@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingWriter blocks every write until it is released
+type blockingWriter struct {
+	release chan struct{}
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(data []byte) (int, error) {
+	<-w.release
+	return w.buf.Write(data)
+}
+
+func Test_AsyncWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := newAsyncWriter(out, 16)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				fmt.Fprintf(w, "%d %d\n", i, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	w.Flush()
+
+	// Nothing is lost and the entries of each writer are in order
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 400)
+	next := map[string]int{}
+	for _, line := range lines {
+		var i, j int
+		fmt.Sscanf(line, "%d %d", &i, &j)
+		assert.Equal(t, next[fmt.Sprint(i)], j, line)
+		next[fmt.Sprint(i)] = j + 1
+	}
+}
+
+func Test_AsyncWriterCopiesData(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{})}
+	w := newAsyncWriter(out, 4)
+	data := []byte("first\n")
+	w.Write(data)
+	copy(data, "other\n")
+	close(out.release)
+	w.Flush()
+	assert.Equal(t, "first\n", out.buf.String())
+}
+
+func Test_AsyncWriterBackpressure(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{})}
+	w := newAsyncWriter(out, 1)
+
+	// Entries are larger than the buffer of the output so they are not absorbed by it
+	entry := strings.Repeat("x", 5000)
+	written := make(chan struct{})
+	go func() {
+		// The first entry is taken by the background writer, the second is queued, and the third
+		// must wait for room in the queue
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "%d%s\n", i, entry)
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("writes did not block while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(out.release)
+	<-written
+	w.Flush()
+	assert.Equal(t, "0"+entry+"\n1"+entry+"\n2"+entry+"\n", out.buf.String())
+}
@@ -13,6 +13,7 @@ const (
 
 var logger hclog.Logger
 var once sync.Once
+var async *asyncWriter
 
 // Spec describes the logger to be created
 type Spec struct {
@@ -21,6 +22,10 @@ type Spec struct {
 	Output          io.Writer
 	JSON            bool
 	IncludeLocation bool
+
+	// BufferSize is the number of log entries that are queued while they are written to the output
+	// in the background. The output is written synchronously when it is zero.
+	BufferSize int
 }
 
 // Get returns the initialised Logger
@@ -43,8 +48,24 @@ func Initialise(spec Spec) hclog.Logger {
 		if spec.Output != nil {
 			hclog.DefaultOptions.Output = spec.Output
 		}
+		if spec.BufferSize > 0 {
+			output := hclog.DefaultOptions.Output
+			if output == nil {
+				output = hclog.DefaultOutput
+			}
+			async = newAsyncWriter(output, spec.BufferSize)
+			hclog.DefaultOptions.Output = async
+		}
 		l := hclog.Default()
 		logger = l
 	})
 	return logger
 }
+
+// Flush returns when all log entries have been written to the output. It must be called before the
+// process exits when the output is written in the background.
+func Flush() {
+	if async != nil {
+		async.Flush()
+	}
+}
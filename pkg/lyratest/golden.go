@@ -0,0 +1,104 @@
+package lyratest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GoldenFile returns the path of the golden file of the plan of the named workflow of the given
+// manifest. It is in the testdata directory next to the manifest, e.g. testdata/vpc.golden for the
+// workflow vpc of the manifest vpc.yaml.
+func GoldenFile(manifest, workflow string) string {
+	return filepath.Join(filepath.Dir(manifest), `testdata`, workflow+`.golden`)
+}
+
+// MatchGolden compares the given text with the content of the golden file at the given path and
+// returns an error that shows the difference when they differ. The golden file is written with the
+// text instead when update is true.
+func MatchGolden(path, text string, update bool) error {
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, []byte(text), 0644)
+	}
+	golden, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("golden file %s does not exist, create it with lyra test --update", path)
+		}
+		return err
+	}
+	if string(golden) == text {
+		return nil
+	}
+	return fmt.Errorf("plan differs from golden file %s:\n%s", path, diff(string(golden), text))
+}
+
+// CheckPlans plans all workflows of the given manifest using fake handlers for the resources that the
+// given providers don't handle, and matches each plan with its golden file. The golden files are
+// written instead when update is true. The error names all workflows whose plan failed or differed.
+func CheckPlans(manifest string, update bool, options ...Option) error {
+	var failed []string
+	err := Run(manifest, func(r *Runner) {
+		for _, workflow := range r.Workflows() {
+			plan, err := r.Plan(workflow, nil)
+			if err == nil {
+				err = MatchGolden(GoldenFile(manifest, workflow), plan.String(), update)
+			}
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %s", workflow, err))
+			}
+		}
+	}, append(options, WithFakes())...)
+	if err == nil && len(failed) > 0 {
+		err = fmt.Errorf("%s", strings.Join(failed, "\n"))
+	}
+	return err
+}
+
+// diff returns the lines that differ between a and b, prefixed with - for lines only in a and + for
+// lines only in b, and with two spaces for the lines that they have in common
+func diff(a, b string) string {
+	as := strings.Split(a, "\n")
+	bs := strings.Split(b, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of as[i:] and bs[j:]
+	lcs := make([][]int, len(as)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bs)+1)
+	}
+	for i := len(as) - 1; i >= 0; i-- {
+		for j := len(bs) - 1; j >= 0; j-- {
+			if as[i] == bs[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	out := bytes.NewBufferString(``)
+	i, j := 0, 0
+	for i < len(as) || j < len(bs) {
+		switch {
+		case i < len(as) && j < len(bs) && as[i] == bs[j]:
+			fmt.Fprintf(out, "  %s\n", as[i])
+			i++
+			j++
+		case i < len(as) && (j == len(bs) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(out, "- %s\n", as[i])
+			i++
+		default:
+			fmt.Fprintf(out, "+ %s\n", bs[j])
+			j++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,157 @@
+package lyratest
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var data = WithData(map[string]interface{}{`people`: map[string]interface{}{`age`: 28}})
+
+func methods(h *Handler) []string {
+	var ms []string
+	for _, call := range h.Calls() {
+		ms = append(ms, call.Method)
+	}
+	return ms
+}
+
+func Test_ApplyAndDelete(t *testing.T) {
+	p := NewProvider(`Test`)
+	people := p.Handle(`Test::Person`)
+	assert.Equal(t, people, p.Handle(`Test::Person`))
+
+	err := Run(`testdata/people.yaml`, func(r *Runner) {
+		output, err := r.Apply(`people`, nil)
+		require.NoError(t, err)
+		assert.Equal(t, `Bob`, output.Get5(`first`, eval.UNDEF).String())
+		assert.Equal(t, `Alice`, output.Get5(`second`, eval.UNDEF).String())
+		assert.Equal(t, []string{`create`, `create`}, methods(people))
+		assert.Equal(t, []string{`1`, `2`}, people.ExternalIDs())
+		second, _ := people.Get(`2`)
+		age, _ := second.Get(`age`)
+		assert.Equal(t, `28`, age.String())
+
+		// Nothing has changed
+		people.Reset()
+		_, err = r.Apply(`people`, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{`read`, `read`}, methods(people))
+
+		// The second person has been renamed outside of the workflow
+		people.Reset()
+		people.OnRead = func(externalID string) (eval.PuppetObject, error) {
+			// Both are now the first person
+			state, _ := people.Get(`1`)
+			return state, nil
+		}
+		_, err = r.Apply(`people`, nil)
+		require.NoError(t, err)
+		people.OnRead = nil
+		calls := people.Calls()
+		require.Len(t, calls, 3)
+		assert.Equal(t, Call{Method: `update`, ExternalID: `2`, State: calls[2].State}, calls[2])
+		name, _ := calls[2].State.Get(`name`)
+		assert.Equal(t, `Alice`, name.String())
+
+		require.NoError(t, r.Delete(`people`))
+		assert.Empty(t, people.ExternalIDs())
+	}, WithProvider(p), data)
+	require.NoError(t, err)
+}
+
+func Test_HandlerErrors(t *testing.T) {
+	p := NewProvider(`Test`)
+	people := p.Handle(`Test::Person`)
+	people.OnCreate = func(desired eval.PuppetObject) (eval.PuppetObject, string, error) {
+		return nil, ``, errors.New(`PersonLimitExceeded`)
+	}
+	err := Run(`testdata/people.yaml`, func(r *Runner) {
+		_, err := r.Apply(`people`, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `PersonLimitExceeded`)
+
+		// The second person is not passed to the handler
+		assert.Equal(t, []string{`create`}, methods(people))
+
+		// The runner has failed
+		people.OnCreate = nil
+		assert.Equal(t, err, r.Delete(`people`))
+		assert.Equal(t, []string{`create`}, methods(people))
+	}, WithProvider(p), data)
+	require.NoError(t, err)
+}
+
+func Test_Errors(t *testing.T) {
+	p := NewProvider(`Test`)
+	p.Handle(`Test::Person`)
+	err := Run(`testdata/people.yaml`, func(r *Runner) {
+		_, err := r.Apply(`nobody`, nil)
+		assert.EqualError(t, err, `unable to find definition for workflow nobody`)
+	}, WithProvider(p), data)
+	require.NoError(t, err)
+
+	called := false
+	err = Run(`testdata/people.txt`, func(r *Runner) { called = true })
+	assert.EqualError(t, err, `no front-end is able to load testdata/people.txt`)
+	assert.False(t, called)
+}
+
+func Test_Plan(t *testing.T) {
+	p := NewProvider(`Test`)
+	people := p.Handle(`Test::Person`)
+	err := Run(`testdata/people.yaml`, func(r *Runner) {
+		assert.Equal(t, []string{`people`}, r.Workflows())
+		plan, err := r.Plan(`people`, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "create Test::Person\n  name => 'Alice'\n  age => 28\n\ncreate Test::Person\n  name => 'Bob'\n  age => 28\n", plan.String())
+
+		// Nothing has changed
+		plan, err = r.Plan(`people`, nil)
+		require.NoError(t, err)
+		assert.Empty(t, plan)
+
+		people.OnRead = func(externalID string) (eval.PuppetObject, error) {
+			state, _ := people.Get(`1`)
+			return state, nil
+		}
+		plan, err = r.Plan(`people`, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "update Test::Person\n  name => 'Alice'\n  age => 28\n", plan.String())
+	}, WithProvider(p), data)
+	require.NoError(t, err)
+}
+
+func Test_CheckPlans(t *testing.T) {
+	// The golden file is testdata/testdata/people.golden
+	require.NoError(t, CheckPlans(`testdata/people.yaml`, false, data))
+
+	// A fake handler is added for the people when no provider handles them
+	p := NewProvider(`Test`)
+	p.Handle(`Test::Person`).OnCreate = func(desired eval.PuppetObject) (eval.PuppetObject, string, error) {
+		return nil, ``, errors.New(`PersonLimitExceeded`)
+	}
+	err := CheckPlans(`testdata/people.yaml`, false, WithProvider(p), data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `people: PersonLimitExceeded`)
+}
+
+func Test_MatchGolden(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `lyratest`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, `testdata`, `people.golden`)
+
+	err = MatchGolden(path, "a\n", false)
+	assert.EqualError(t, err, `golden file `+path+` does not exist, create it with lyra test --update`)
+	require.NoError(t, MatchGolden(path, "a\nb\nc\n", true))
+	require.NoError(t, MatchGolden(path, "a\nb\nc\n", false))
+	err = MatchGolden(path, "a\nx\nc\nd\n", false)
+	assert.EqualError(t, err, `plan differs from golden file `+path+":\n  a\n- b\n+ x\n  c\n+ d\n  \n")
+}
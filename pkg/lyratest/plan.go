@@ -0,0 +1,86 @@
+package lyratest
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+)
+
+// Change is a change made to a resource by a handler
+type Change struct {
+	// Action is create, update or delete
+	Action string
+
+	// Type is the name of the type of the resource
+	Type string
+
+	// State is the desired state of a created or updated resource, or the state of a deleted resource
+	State eval.PuppetObject
+}
+
+// Plan is the changes that applying a workflow makes to its resources
+type Plan []Change
+
+// String returns the plan in a stable text format that is suitable for golden files. Each change is
+// a line with its action and type followed by the attributes of the state, one per line, with values
+// in the Puppet language. Changes are sorted, so the order in which the workflow engine ran concurrent
+// steps doesn't matter.
+func (p Plan) String() string {
+	changes := make([]string, len(p))
+	for i, change := range p {
+		b := bytes.NewBufferString(change.Action)
+		b.WriteByte(' ')
+		b.WriteString(change.Type)
+		b.WriteByte('\n')
+		if change.State != nil {
+			change.State.InitHash().EachPair(func(k, v eval.Value) {
+				b.WriteString(`  `)
+				b.WriteString(k.String())
+				b.WriteString(` => `)
+				b.WriteString(eval.ToString2(v, types.PROGRAM))
+				b.WriteByte('\n')
+			})
+		}
+		changes[i] = b.String()
+	}
+	sort.Strings(changes)
+	return strings.Join(changes, "\n")
+}
+
+// Plan applies the named workflow with the given input, like Apply, and returns the changes that the
+// apply made to the resources of the providers. Reads are not changes and are left out.
+func (r *Runner) Plan(workflow string, input map[string]interface{}) (Plan, error) {
+	before := map[*Handler]int{}
+	handlers := r.handlers()
+	for _, h := range handlers {
+		before[h] = len(h.Calls())
+	}
+	if _, err := r.Apply(workflow, input); err != nil {
+		return nil, err
+	}
+	var plan Plan
+	for _, h := range handlers {
+		for _, call := range h.Calls()[before[h]:] {
+			if call.Method != `read` {
+				plan = append(plan, Change{Action: call.Method, Type: h.typeName, State: call.State})
+			}
+		}
+	}
+	return plan, nil
+}
+
+// handlers returns the handlers of all providers of the runner
+func (r *Runner) handlers() []*Handler {
+	var handlers []*Handler
+	for _, p := range r.providers {
+		p.lock.Lock()
+		for _, typeName := range p.names {
+			handlers = append(handlers, p.handlers[handlerName(typeName)])
+		}
+		p.lock.Unlock()
+	}
+	return handlers
+}
@@ -0,0 +1,320 @@
+// Package lyratest unit tests workflows. The resources of a workflow are managed by fake handlers that
+// keep them in memory, so a test needs no access to the cloud and no plugins. The behavior of each
+// handler can be programmed to simulate the responses of a real provider, e.g.
+//
+//	p := lyratest.NewProvider(`Aws`)
+//	vpcs := p.Handle(`Aws::Vpc`)
+//	vpcs.OnCreate = func(desired eval.PuppetObject) (eval.PuppetObject, string, error) {
+//		return nil, ``, errors.New(`VpcLimitExceeded`)
+//	}
+//	err := lyratest.Run(`testdata/vpc.yaml`, func(r *lyratest.Runner) {
+//		_, err := r.Apply(`vpc`, nil)
+//		...
+//	}, lyratest.WithProvider(p))
+package lyratest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// handlerMethods are the signatures of the methods of all fake handlers
+var handlerMethods = [][2]string{
+	{`create`, `Callable[Object, Tuple[Object, String]]`},
+	{`read`, `Callable[String, Object]`},
+	{`update`, `Callable[String, Object, Object]`},
+	{`delete`, `Callable[String]`},
+}
+
+// handlerInterface returns the interface of all fake handlers. The workflow engine only updates resources
+// whose handler interface has an update method.
+func handlerInterface(c eval.Context) eval.Type {
+	functions := make([]*types.HashEntry, len(handlerMethods))
+	for i, m := range handlerMethods {
+		functions[i] = types.WrapHashEntry2(m[0], c.ParseType2(m[1]))
+	}
+	return types.NewObjectType(`Lyratest::Handler`, nil, types.SingletonHash2(`functions`, types.WrapHash(functions))).Resolve(c)
+}
+
+// Call is a call made to a handler
+type Call struct {
+	// Method is one of create, read, update or delete
+	Method string
+
+	// ExternalID is the ID of the resource. It is empty for create.
+	ExternalID string
+
+	// State is the desired state given to create and update, the stored state of the resource given to
+	// delete, or nil
+	State eval.PuppetObject
+}
+
+// Handler is a fake handler of one type of resource. It keeps the resources that it creates in memory.
+// Each On function replaces the default behavior of the method that it is named after when set. The
+// functions are called concurrently when the workflow engine runs steps concurrently.
+type Handler struct {
+	// OnCreate returns the state of the new resource and its external ID. The default stores the desired
+	// state under a new ID.
+	OnCreate func(desired eval.PuppetObject) (eval.PuppetObject, string, error)
+
+	// OnRead returns the state of a resource. The default returns the stored state.
+	OnRead func(externalID string) (eval.PuppetObject, error)
+
+	// OnUpdate returns the new state of a resource. The default stores the desired state.
+	OnUpdate func(externalID string, desired eval.PuppetObject) (eval.PuppetObject, error)
+
+	// OnDelete deletes a resource. The default removes the stored state.
+	OnDelete func(externalID string) error
+
+	typeName  string
+	lock      sync.Mutex
+	resources map[string]eval.PuppetObject
+	next      int
+	calls     []Call
+}
+
+// Put stores a resource as if it had been created before the test, e.g. to test a workflow that refers
+// to an existing resource using its external ID
+func (h *Handler) Put(externalID string, state eval.PuppetObject) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.resources[externalID] = state
+}
+
+// Get returns the stored state of a resource
+func (h *Handler) Get(externalID string) (eval.PuppetObject, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	state, ok := h.resources[externalID]
+	return state, ok
+}
+
+// ExternalIDs returns the sorted IDs of the stored resources
+func (h *Handler) ExternalIDs() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ids := make([]string, 0, len(h.resources))
+	for id := range h.resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Calls returns the calls made to the handler in the order they were made
+func (h *Handler) Calls() []Call {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]Call{}, h.calls...)
+}
+
+// Reset forgets the calls made to the handler. The stored resources are kept.
+func (h *Handler) Reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.calls = nil
+}
+
+func (h *Handler) record(call Call) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.calls = append(h.calls, call)
+}
+
+func (h *Handler) create(desired eval.PuppetObject) (eval.PuppetObject, string, error) {
+	h.record(Call{Method: `create`, State: desired})
+	if h.OnCreate != nil {
+		return h.OnCreate(desired)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.next++
+	externalID := strconv.Itoa(h.next)
+	h.resources[externalID] = desired
+	return desired, externalID, nil
+}
+
+func (h *Handler) read(externalID string) (eval.PuppetObject, error) {
+	h.record(Call{Method: `read`, ExternalID: externalID})
+	if h.OnRead != nil {
+		return h.OnRead(externalID)
+	}
+	if state, ok := h.Get(externalID); ok {
+		return state, nil
+	}
+	return nil, fmt.Errorf("no %s with id %s", h.typeName, externalID)
+}
+
+func (h *Handler) update(externalID string, desired eval.PuppetObject) (eval.PuppetObject, error) {
+	h.record(Call{Method: `update`, ExternalID: externalID, State: desired})
+	if h.OnUpdate != nil {
+		return h.OnUpdate(externalID, desired)
+	}
+	h.Put(externalID, desired)
+	return desired, nil
+}
+
+func (h *Handler) delete(externalID string) error {
+	state, _ := h.Get(externalID)
+	h.record(Call{Method: `delete`, ExternalID: externalID, State: state})
+	if h.OnDelete != nil {
+		return h.OnDelete(externalID)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.resources, externalID)
+	return nil
+}
+
+// Provider is a fake provider. It is a service that provides a handler for each type of resource that
+// it has been asked to handle.
+type Provider struct {
+	name     string
+	lock     sync.Mutex
+	handlers map[string]*Handler
+	names    []string
+	failure  *failure
+}
+
+// NewProvider creates a provider with a service of the given name, e.g. Aws. The name must not be the
+// name of a service of the workflow. A provider must not be used by concurrent runs.
+func NewProvider(name string) *Provider {
+	return &Provider{name: name, handlers: map[string]*Handler{}}
+}
+
+// Handle returns the handler of the resources of the type with the given name, e.g. Aws::Vpc. The handler
+// is created on first use. The type itself is found by the workflow.
+func (p *Provider) Handle(typeName string) *Handler {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if h, ok := p.handlers[handlerName(typeName)]; ok {
+		return h
+	}
+	h := &Handler{typeName: typeName, resources: map[string]eval.PuppetObject{}}
+	p.handlers[handlerName(typeName)] = h
+	p.names = append(p.names, typeName)
+	return h
+}
+
+// handlerName returns the name of the definition of the handler of the given type
+func handlerName(typeName string) string {
+	return typeName + `Handler`
+}
+
+// Identifier returns the identifier of the service of the provider
+func (p *Provider) Identifier(c eval.Context) eval.TypedName {
+	return eval.NewTypedName(eval.NsService, p.name)
+}
+
+// Metadata returns the definitions of the handlers of the provider
+func (p *Provider) Metadata(c eval.Context) (eval.TypeSet, []serviceapi.Definition) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	crud := handlerInterface(c)
+	defs := make([]serviceapi.Definition, len(p.names))
+	for i, typeName := range p.names {
+		props := types.WrapHash([]*types.HashEntry{
+			types.WrapHashEntry2(`interface`, crud),
+			types.WrapHashEntry2(`style`, types.WrapString(`callable`)),
+			// Only the name of the type is used to find the handler of a resource
+			types.WrapHashEntry2(`handlerFor`, types.NewObjectType(typeName, nil, `{}`)),
+		})
+		defs[i] = serviceapi.NewDefinition(eval.NewTypedName(eval.NsDefinition, handlerName(typeName)), p.Identifier(c), props)
+	}
+	return nil, defs
+}
+
+// Invoke calls a method of a handler of the provider. The first error of a handler fails the run. The
+// workflow engine doesn't recover from errors of handlers, so the failing call, and all calls that follow
+// it in the same run, return results that let the engine finish without passing the calls to the handlers.
+func (p *Provider) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	p.lock.Lock()
+	h, ok := p.handlers[identifier]
+	failure := p.failure
+	p.lock.Unlock()
+	if !ok {
+		panic(fmt.Errorf("provider %s has no handler %s", p.name, identifier))
+	}
+
+	if failure.get() == nil {
+		result, err := h.invoke(name, arguments)
+		if err == nil {
+			return result
+		}
+		failure.set(err)
+	}
+	return h.skip(name, arguments)
+}
+
+// invoke calls the method with the given name
+func (h *Handler) invoke(name string, arguments []eval.Value) (eval.Value, error) {
+	switch name {
+	case `create`:
+		state, externalID, err := h.create(arguments[0].(eval.PuppetObject))
+		if err != nil {
+			return nil, err
+		}
+		return types.WrapValues([]eval.Value{state, types.WrapString(externalID)}), nil
+	case `read`:
+		return h.read(arguments[0].String())
+	case `update`:
+		return h.update(arguments[0].String(), arguments[1].(eval.PuppetObject))
+	case `delete`:
+		return eval.UNDEF, h.delete(arguments[0].String())
+	}
+	return nil, fmt.Errorf("handler %sHandler has no method %s", h.typeName, name)
+}
+
+// skip returns the result of a call that is not passed to the handler because the run has failed. It
+// is the desired state for create and update and the stored state for read.
+func (h *Handler) skip(name string, arguments []eval.Value) eval.Value {
+	switch name {
+	case `create`:
+		return types.WrapValues([]eval.Value{arguments[0], types.WrapString(``)})
+	case `read`:
+		if state, ok := h.Get(arguments[0].String()); ok {
+			return state
+		}
+		panic(fmt.Errorf("no %s with id %s", h.typeName, arguments[0]))
+	case `update`:
+		return arguments[1]
+	}
+	return eval.UNDEF
+}
+
+// failure is the first error of a handler in a run
+type failure struct {
+	lock sync.Mutex
+	err  error
+}
+
+func (f *failure) get() error {
+	if f == nil {
+		return nil
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.err
+}
+
+func (f *failure) set(err error) {
+	if f == nil {
+		panic(err)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// State is not provided by a provider
+func (p *Provider) State(c eval.Context, name string, input eval.OrderedMap) eval.PuppetObject {
+	panic(fmt.Errorf("provider %s has no state %s", p.name, name))
+}
@@ -0,0 +1,250 @@
+package lyratest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/hiera/lookup"
+	"github.com/lyraproj/lyra/cmd/goplugin-identity/identity"
+	"github.com/lyraproj/lyra/pkg/catalog"
+	"github.com/lyraproj/lyra/pkg/loader"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/service"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/servicesdk/wfapi"
+	"github.com/lyraproj/wfe/api"
+	wfservice "github.com/lyraproj/wfe/service"
+	"github.com/lyraproj/wfe/wfe"
+
+	// Ensure that lookup function properly loaded
+	_ "github.com/lyraproj/hiera/functions"
+)
+
+// Option configures the environment in which Run runs the workflows of a manifest
+type Option func(*config)
+
+type config struct {
+	providers []*Provider
+	data      map[string]interface{}
+	fakes     bool
+}
+
+// WithProvider makes the handlers of the given provider available to the workflows
+func WithProvider(p *Provider) Option {
+	return func(cfg *config) {
+		cfg.providers = append(cfg.providers, p)
+	}
+}
+
+// WithFakes makes the resources of the workflows whose types are not handled by the providers given
+// with WithProvider managed by fake handlers with the default behavior. The handlers belong to a
+// provider named FakeProvider.
+func WithFakes() Option {
+	return func(cfg *config) {
+		cfg.fakes = true
+	}
+}
+
+// FakeProvider is the name of the provider of the handlers added by WithFakes
+const FakeProvider = `Lyratest`
+
+// WithData makes the given values available to the lookups of the workflows. A dotted name digs into
+// nested maps, e.g. aws.region is the region found in the map under aws.
+func WithData(data map[string]interface{}) Option {
+	return func(cfg *config) {
+		for k, v := range data {
+			cfg.data[k] = v
+		}
+	}
+}
+
+// Runner applies and deletes the workflows of a manifest. It must only be used by the function given
+// to Run.
+type Runner struct {
+	c         eval.Context
+	failure   *failure
+	providers []*Provider
+	workflows []string
+}
+
+// Run loads the workflow manifest at the given path and calls the given function with a runner for its
+// workflows. The resource types of the manifest are found in the types directory next to it. Manifests
+// are loaded in this process, so only front-ends that don't need a plugin, such as the YAML front-end,
+// can be used. The external IDs of the resources are kept in a temporary identity store that is removed
+// when Run returns.
+func Run(manifest string, f func(r *Runner), options ...Option) error {
+	cfg := &config{data: map[string]interface{}{}}
+	for _, option := range options {
+		option(cfg)
+	}
+
+	dir, err := ioutil.TempDir(``, `lyratest`)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	id, err := identity.NewIdentity(filepath.Join(dir, `identity.db`))
+	if err != nil {
+		return err
+	}
+
+	// The data is wrapped once the evaluation context exists and before any lookup is made
+	var data eval.OrderedMap
+	tp := func(ic lookup.ProviderContext, key string, _ map[string]eval.Value) (eval.Value, bool) {
+		return data.Get4(key)
+	}
+	lookup.DoWithParent(context.Background(), tp, nil, func(c eval.Context) {
+		data = eval.Wrap(c, cfg.data).(eval.OrderedMap)
+		l := loader.New(hclog.NewNullLogger(), c.Loader(), loader.WithPluginPath(), loader.WithMetadataCache(``))
+		failure := &failure{}
+		c.DoWithLoader(l, func() {
+			sb := service.NewServerBuilder(c, `Default::Identity::Service`)
+			sb.RegisterAPI(serviceapi.IdentityName, id)
+			register(c, l, sb.Server())
+			for _, p := range cfg.providers {
+				p.lock.Lock()
+				p.failure = failure
+				p.lock.Unlock()
+				register(c, l, p)
+			}
+			var s serviceapi.Service
+			if s, err = loadManifest(c, l, manifest); err != nil {
+				return
+			}
+			r := &Runner{c: c, failure: failure, providers: cfg.providers, workflows: workflows(c, s)}
+			if cfg.fakes {
+				if p := fakeProvider(c, r.workflows, cfg.providers); p != nil {
+					p.failure = failure
+					register(c, l, p)
+					r.providers = append(r.providers, p)
+				}
+			}
+			f(r)
+		})
+	})
+	return err
+}
+
+// register makes a service and its definitions available to the given loader
+func register(c eval.Context, l *loader.Loader, s serviceapi.Service) {
+	l.RegisterService(c, s)
+	l.RegisterMetadata(c, s)
+}
+
+// loadManifest loads a manifest using the front-end that claims it and returns its service
+func loadManifest(c eval.Context, l *loader.Loader, manifest string) (serviceapi.Service, error) {
+	for _, f := range loader.Frontends() {
+		if f.Detect(manifest) {
+			s, err := f.Parse(c, manifest)
+			if err == nil {
+				err = f.RegisterDefinitions(c, l, s)
+			}
+			return s, err
+		}
+	}
+	return nil, fmt.Errorf("no front-end is able to load %s", manifest)
+}
+
+// workflows returns the names of the workflows defined by the service of a manifest
+func workflows(c eval.Context, s serviceapi.Service) []string {
+	var names []string
+	_, defs := s.Metadata(c)
+	for _, def := range defs {
+		if style, ok := def.Properties().Get4(`style`); ok && style.String() == `workflow` {
+			names = append(names, def.Identifier().Name())
+		}
+	}
+	return names
+}
+
+// fakeProvider returns a provider that handles the types of the resources of the given workflows that
+// none of the given providers handle, or nil if there are none
+func fakeProvider(c eval.Context, workflows []string, providers []*Provider) *Provider {
+	handled := map[string]bool{}
+	for _, p := range providers {
+		p.lock.Lock()
+		for _, typeName := range p.names {
+			handled[typeName] = true
+		}
+		p.lock.Unlock()
+	}
+	var fake *Provider
+	for _, workflow := range workflows {
+		def, ok := eval.Load(c, eval.NewTypedName(eval.NsDefinition, workflow))
+		if !ok {
+			continue
+		}
+		for _, r := range catalog.FromActivity(wfe.CreateActivity(def.(serviceapi.Definition))).Resources {
+			if handled[r.Type] {
+				continue
+			}
+			if fake == nil {
+				fake = NewProvider(FakeProvider)
+			}
+			fake.Handle(r.Type)
+			handled[r.Type] = true
+		}
+	}
+	return fake
+}
+
+// Apply applies the named workflow with the given input and returns its output. Resources that the
+// workflow declared in an earlier apply and no longer declares are deleted. The first error returned
+// by a handler is returned and fails the runner, so all later applies and deletes return it too.
+func (r *Runner) Apply(workflow string, input map[string]interface{}) (output eval.OrderedMap, err error) {
+	err = r.run(workflow, wfapi.Upsert, func(a api.Activity) {
+		in := eval.EMPTY_MAP
+		if input != nil {
+			in = eval.Wrap(r.c, input).(eval.OrderedMap)
+		}
+		output, _ = a.Run(r.c, in).(eval.OrderedMap)
+	})
+	return
+}
+
+// Delete deletes all resources of the named workflow
+func (r *Runner) Delete(workflow string) error {
+	return r.run(workflow, wfapi.Delete, nil)
+}
+
+// Workflows returns the names of the workflows defined by the manifest
+func (r *Runner) Workflows() []string {
+	return append([]string{}, r.workflows...)
+}
+
+func (r *Runner) run(workflow string, op wfapi.Operation, apply func(api.Activity)) (err error) {
+	if err = r.failure.get(); err != nil {
+		return err
+	}
+	defer func() {
+		if ferr := r.failure.get(); ferr != nil {
+			err = ferr
+		}
+	}()
+	defer func() {
+		if e := recover(); e != nil {
+			if ee, ok := e.(error); ok {
+				err = ee
+				return
+			}
+			panic(e)
+		}
+	}()
+	def, ok := eval.Load(r.c, eval.NewTypedName(eval.NsDefinition, workflow))
+	if !ok {
+		return fmt.Errorf("unable to find definition for workflow %s", workflow)
+	}
+	a := wfe.CreateActivity(def.(serviceapi.Definition))
+	r.c.Scope().Set(wfservice.ActivityContextKey, types.SingletonHash2(`operation`, types.WrapInteger(int64(op))))
+	wfservice.StartEra(r.c)
+	if apply != nil {
+		apply(a)
+	}
+	wfservice.SweepAndGC(r.c, a.Identifier()+`/`)
+	return nil
+}
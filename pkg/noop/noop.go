@@ -0,0 +1,259 @@
+// Package noop rehearses runs of workflows. The calls that a run makes to the handlers of providers are
+// intercepted so that reads are made as usual but creates, updates and deletes are simulated. A
+// simulated resource gets a fake external ID and plausible fake values for the attributes that its
+// desired state leaves unset, such as the IDs that providers assign, so that the activities that depend
+// on it, and the expressions that refer to its outputs, are run with the same execution paths as in a
+// real run.
+//
+// The calls that change the identity service are simulated too. The resources that a real run would
+// delete are found, and their deletion simulated, without changing the identity store.
+package noop
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// handlerMethods are the methods of handlers that change resources
+var handlerMethods = map[string]bool{`create`: true, `update`: true, `upsert`: true, `delete`: true}
+
+// identityChanges are the methods of the identity service that change the identity store
+var identityChanges = map[string]bool{
+	`associate`:      true,
+	`bumpEra`:        true,
+	`purgeExternal`:  true,
+	`purgeInternal`:  true,
+	`removeExternal`: true,
+	`removeInternal`: true,
+	`sweep`:          true,
+}
+
+// Simulator simulates the changes of one run
+type Simulator struct {
+	lock    sync.Mutex
+	next    int
+	states  map[string]eval.PuppetObject
+	read    map[string]eval.PuppetObject
+	touched map[string]bool
+	swept   []string
+	creates int
+	updates int
+	deletes int
+}
+
+// New returns a simulator for a run
+func New() *Simulator {
+	return &Simulator{states: map[string]eval.PuppetObject{}, read: map[string]eval.PuppetObject{}, touched: map[string]bool{}}
+}
+
+// Simulated returns the number of creates, updates and deletes that have been simulated
+func (s *Simulator) Simulated() (creates, updates, deletes int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.creates, s.updates, s.deletes
+}
+
+// WrapService returns a function that wraps a service so that the calls that would change resources, or
+// the identity store, are simulated
+func (s *Simulator) WrapService() func(serviceapi.Service) serviceapi.Service {
+	return func(svc serviceapi.Service) serviceapi.Service {
+		return &service{Service: svc, simulator: s}
+	}
+}
+
+type service struct {
+	serviceapi.Service
+	simulator *Simulator
+}
+
+func (s *service) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	if identifier == serviceapi.IdentityName {
+		return s.invokeIdentity(c, identifier, name, arguments)
+	}
+	switch {
+	case name == `read` && len(arguments) == 1:
+		return s.readResource(c, identifier, arguments[0])
+	case handlerMethods[name]:
+		return s.simulator.change(c, name, arguments)
+	}
+	return s.Service.Invoke(c, identifier, name, arguments...)
+}
+
+// readResource returns the simulated state of a resource that was created in this run, or reads the
+// resource
+func (s *service) readResource(c eval.Context, identifier string, externalID eval.Value) eval.Value {
+	id := externalID.String()
+	sm := s.simulator
+	sm.lock.Lock()
+	state, ok := sm.states[id]
+	sm.lock.Unlock()
+	if ok {
+		return state
+	}
+	result := s.Service.Invoke(c, identifier, `read`, externalID)
+	if state, ok := result.(eval.PuppetObject); ok {
+		// Kept so that a simulated update returns the attributes of the resource that it doesn't change
+		sm.lock.Lock()
+		sm.read[id] = state
+		sm.lock.Unlock()
+	}
+	return result
+}
+
+// change simulates a call of a handler method that changes a resource
+func (s *Simulator) change(c eval.Context, name string, arguments []eval.Value) eval.Value {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch name {
+	case `create`:
+		s.creates++
+		s.next++
+		id := fmt.Sprintf("noop-%d", s.next)
+		desired, ok := arguments[0].(eval.PuppetObject)
+		if !ok {
+			break
+		}
+		state := fill(c, desired, nil, id)
+		s.states[id] = state
+		return types.WrapValues([]eval.Value{state, types.WrapString(id)})
+	case `update`:
+		s.updates++
+		id := arguments[0].String()
+		desired, ok := arguments[1].(eval.PuppetObject)
+		if !ok {
+			break
+		}
+		current, ok := s.states[id]
+		if !ok {
+			current = s.read[id]
+		}
+		state := fill(c, desired, current, id)
+		s.states[id] = state
+		return state
+	case `upsert`:
+		// The handler of a state handler step returns its output. The input is the best guess.
+		s.updates++
+		if input, ok := arguments[0].(eval.OrderedMap); ok {
+			return input
+		}
+		return eval.EMPTY_MAP
+	case `delete`:
+		s.deletes++
+		if len(arguments) > 0 {
+			delete(s.states, arguments[0].String())
+		}
+		return eval.UNDEF
+	}
+	panic(fmt.Errorf("unable to simulate %s with arguments %s", name, types.WrapValues(arguments)))
+}
+
+// fill returns the desired state with each attribute that it leaves unset given the value of the current
+// state, if any, or a fake value. The fake value of a string is the external ID followed by the name of
+// the attribute, e.g. noop-1-vpcId. Numbers are zero and booleans false. Attributes of other types are
+// left unset.
+func fill(c eval.Context, desired, current eval.PuppetObject, id string) eval.PuppetObject {
+	typ, ok := desired.PType().(eval.ObjectType)
+	if !ok {
+		return desired
+	}
+	values := desired.InitHash()
+	var currentValues eval.OrderedMap = eval.EMPTY_MAP
+	if current != nil {
+		currentValues = current.InitHash()
+	}
+	entries := make([]*types.HashEntry, 0, len(typ.AttributesInfo().Attributes()))
+	for _, a := range typ.AttributesInfo().Attributes() {
+		if a.Kind() == types.CONSTANT || a.Kind() == types.DERIVED {
+			continue
+		}
+		v, ok := values.Get4(a.Name())
+		if !ok || eval.Equals(v, eval.UNDEF) {
+			if v, ok = currentValues.Get4(a.Name()); !ok || eval.Equals(v, eval.UNDEF) {
+				v = fake(a.Type(), id+`-`+a.Name())
+			}
+		}
+		if v != nil {
+			entries = append(entries, types.WrapHashEntry2(a.Name(), v))
+		}
+	}
+	return types.NewObjectValue2(c, typ, types.WrapHash(entries)).(eval.PuppetObject)
+}
+
+// fake returns a fake value of the given type or nil
+func fake(t eval.Type, s string) eval.Value {
+	for _, v := range []eval.Value{types.WrapString(s), types.WrapInteger(0), types.WrapFloat(0), types.WrapBoolean(false)} {
+		if eval.IsInstance(t, v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// invokeIdentity simulates the calls that change the identity store. The calls that read it are made. The
+// garbage found by a simulated sweep is added to the garbage of the store.
+func (s *service) invokeIdentity(c eval.Context, identifier, name string, arguments []eval.Value) eval.Value {
+	sm := s.simulator
+	switch {
+	case name == `garbage`:
+		return s.garbage(c, identifier)
+	case name == `getExternal` && len(arguments) > 0:
+		sm.touch(arguments[0].String())
+	case identityChanges[name]:
+		sm.lock.Lock()
+		switch name {
+		case `associate`:
+			sm.touched[arguments[0].String()] = true
+		case `bumpEra`:
+			sm.touched = map[string]bool{}
+			sm.swept = nil
+		case `sweep`:
+			sm.swept = append(sm.swept, arguments[0].String())
+		}
+		sm.lock.Unlock()
+		return eval.UNDEF
+	}
+	return s.Service.Invoke(c, identifier, name, arguments...)
+}
+
+func (s *Simulator) touch(internalID string) {
+	s.lock.Lock()
+	s.touched[internalID] = true
+	s.lock.Unlock()
+}
+
+// garbage returns the garbage of the identity store followed by the entries that the simulated sweeps
+// found, i.e. the entries with a swept prefix that have not been used since the era was bumped
+func (s *service) garbage(c eval.Context, identifier string) eval.Value {
+	found := make([]eval.Value, 0)
+	seen := map[string]bool{}
+	if g, ok := s.Service.Invoke(c, identifier, `garbage`).(eval.List); ok {
+		g.Each(func(t eval.Value) {
+			found = append(found, t)
+			seen[t.(eval.List).At(1).String()] = true
+		})
+	}
+
+	sm := s.simulator
+	sm.lock.Lock()
+	prefixes := append([]string{}, sm.swept...)
+	sm.lock.Unlock()
+	for _, prefix := range prefixes {
+		if l, ok := s.Service.Invoke(c, identifier, `search`, types.WrapString(prefix)).(eval.List); ok {
+			l.Each(func(v eval.Value) {
+				t := v.(eval.List)
+				sm.lock.Lock()
+				touched := sm.touched[t.At(0).String()]
+				sm.lock.Unlock()
+				if !touched && !seen[t.At(1).String()] {
+					found = append(found, t)
+					seen[t.At(1).String()] = true
+				}
+			})
+		}
+	}
+	return types.WrapValues(found)
+}
@@ -0,0 +1,99 @@
+package noop
+
+import (
+	"testing"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/stretchr/testify/assert"
+
+	// Ensure that pcore is initialized
+	_ "github.com/lyraproj/puppet-evaluator/pcore"
+)
+
+type testService struct {
+	serviceapi.Service
+	calls []string
+	vpc   eval.PuppetObject
+}
+
+func (s *testService) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	s.calls = append(s.calls, name)
+	switch name {
+	case `read`:
+		return s.vpc
+	case `garbage`:
+		return eval.EMPTY_ARRAY
+	case `search`:
+		return types.WrapValues([]eval.Value{
+			types.WrapValues([]eval.Value{types.WrapString(`vpc/a`), types.WrapString(`vpc-1`)}),
+			types.WrapValues([]eval.Value{types.WrapString(`vpc/b`), types.WrapString(`vpc-2`)}),
+		})
+	}
+	return eval.UNDEF
+}
+
+func vpcType(c eval.Context) eval.ObjectType {
+	return c.ParseType2(`Object[{
+    name => 'Test::Vpc',
+    attributes => {
+      cidrBlock => String,
+      vpcId => { type => Optional[String], value => undef },
+      size => { type => Optional[Integer], value => undef },
+      isDefault => { type => Optional[Boolean], value => undef },
+      tags => { type => Optional[Hash[String,String]], value => undef }
+    }
+  }]`).(eval.ResolvableType).Resolve(c).(eval.ObjectType)
+}
+
+func vpc(c eval.Context, typ eval.ObjectType, attrs ...*types.HashEntry) eval.PuppetObject {
+	return types.NewObjectValue2(c, typ, types.WrapHash(attrs)).(eval.PuppetObject)
+}
+
+func Test_SimulateChanges(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		typ := vpcType(c)
+		s := &testService{vpc: vpc(c, typ, types.WrapHashEntry2(`cidrBlock`, types.WrapString(`10.0.0.0/16`)), types.WrapHashEntry2(`vpcId`, types.WrapString(`vpc-1`)))}
+		sim := New()
+		svc := sim.WrapService()(s)
+
+		desired := vpc(c, typ, types.WrapHashEntry2(`cidrBlock`, types.WrapString(`10.1.0.0/16`)))
+		result := svc.Invoke(c, `Test::VpcHandler`, `create`, desired).(eval.List)
+		assert.Equal(t, `noop-1`, result.At(1).String())
+		assert.Equal(t, `Test::Vpc('cidrBlock' => '10.1.0.0/16', 'vpcId' => 'noop-1-vpcId', 'size' => 0, 'isDefault' => false)`, result.At(0).String())
+
+		// A resource created in the run is read without calling the handler
+		assert.Equal(t, result.At(0), svc.Invoke(c, `Test::VpcHandler`, `read`, types.WrapString(`noop-1`)))
+
+		// An update keeps the attributes of the resource that were read
+		svc.Invoke(c, `Test::VpcHandler`, `read`, types.WrapString(`vpc-1`))
+		updated := svc.Invoke(c, `Test::VpcHandler`, `update`, types.WrapString(`vpc-1`), desired)
+		assert.Equal(t, `Test::Vpc('cidrBlock' => '10.1.0.0/16', 'vpcId' => 'vpc-1', 'size' => 0, 'isDefault' => false)`, updated.String())
+
+		svc.Invoke(c, `Test::VpcHandler`, `delete`, types.WrapString(`vpc-1`))
+		creates, updates, deletes := sim.Simulated()
+		assert.Equal(t, 1, creates)
+		assert.Equal(t, 1, updates)
+		assert.Equal(t, 1, deletes)
+		assert.Equal(t, []string{`read`}, s.calls)
+	})
+}
+
+func Test_SimulateIdentity(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		s := &testService{}
+		svc := New().WrapService()(s)
+
+		svc.Invoke(c, serviceapi.IdentityName, `bumpEra`)
+		svc.Invoke(c, serviceapi.IdentityName, `getExternal`, types.WrapString(`vpc/a`))
+		svc.Invoke(c, serviceapi.IdentityName, `associate`, types.WrapString(`vpc/c`), types.WrapString(`noop-1`))
+		svc.Invoke(c, serviceapi.IdentityName, `sweep`, types.WrapString(`vpc/`))
+
+		// Only the entry that was not used in the era is garbage
+		garbage := svc.Invoke(c, serviceapi.IdentityName, `garbage`)
+		assert.Equal(t, `[['vpc/b', 'vpc-2']]`, garbage.String())
+		svc.Invoke(c, serviceapi.IdentityName, `purgeExternal`, types.WrapString(`vpc-2`))
+		assert.Equal(t, []string{`getExternal`, `garbage`, `search`}, s.calls)
+	})
+}
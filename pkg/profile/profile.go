@@ -0,0 +1,107 @@
+// Package profile writes the standard Go profiles of a lyra run, and serves them over HTTP in long
+// running processes, so that they can be attached to reports of performance problems. The profiles
+// are read using go tool pprof and go tool trace.
+package profile
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"runtime/trace"
+)
+
+const (
+	// CPU is a profile of the CPU usage of the run
+	CPU = "cpu"
+
+	// Mem is a profile of the memory that is allocated during the run
+	Mem = "mem"
+
+	// Trace is an execution trace of the run
+	Trace = "trace"
+)
+
+// DefaultFile returns the file that a profile of the given kind is written to when no file is given
+func DefaultFile(kind string) string {
+	if kind == Trace {
+		return "lyra.trace"
+	}
+	return "lyra-" + kind + ".pprof"
+}
+
+// Start starts a profile of the given kind that is written to the given file, or to the DefaultFile
+// when file is empty. The returned function stops the profile and writes what remains of it.
+func Start(kind, file string) (func() error, error) {
+	switch kind {
+	case CPU, Mem, Trace:
+	default:
+		return nil, fmt.Errorf("invalid profile '%s', expected one of %s, %s, or %s", kind, CPU, Mem, Trace)
+	}
+	if file == `` {
+		file = DefaultFile(kind)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var stop func() error
+	switch kind {
+	case CPU:
+		err = rpprof.StartCPUProfile(f)
+		stop = func() error {
+			rpprof.StopCPUProfile()
+			return nil
+		}
+	case Mem:
+		stop = func() error {
+			// Bring the statistics of memory in use up to date
+			runtime.GC()
+			return rpprof.Lookup("allocs").WriteTo(f, 0)
+		}
+	case Trace:
+		err = trace.Start(f)
+		stop = func() error {
+			trace.Stop()
+			return nil
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		err := stop()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
+
+// Handler returns a handler that serves the profiles of the running process below /debug/pprof/, in
+// the same way as the net/http/pprof package does
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Serve serves the Handler on the given address in the background. It returns the address that is
+// listened on, which holds the chosen port when the given address has port 0.
+func Serve(addr string) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return ``, err
+	}
+	go http.Serve(l, Handler())
+	return l.Addr().String(), nil
+}
@@ -0,0 +1,50 @@
+package profile
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Start(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, kind := range []string{CPU, Mem, Trace} {
+		file := filepath.Join(dir, DefaultFile(kind))
+		stop, err := Start(kind, file)
+		require.Nil(t, err, kind)
+		require.Nil(t, stop(), kind)
+
+		fi, err := os.Stat(file)
+		require.Nil(t, err, kind)
+		assert.True(t, fi.Size() > 0, kind)
+	}
+
+	_, err = Start("block", "")
+	assert.Error(t, err)
+	_, err = Start(CPU, filepath.Join(dir, "missing", "cpu.pprof"))
+	assert.Error(t, err)
+}
+
+func Test_DefaultFile(t *testing.T) {
+	assert.Equal(t, "lyra-cpu.pprof", DefaultFile(CPU))
+	assert.Equal(t, "lyra-mem.pprof", DefaultFile(Mem))
+	assert.Equal(t, "lyra.trace", DefaultFile(Trace))
+}
+
+func Test_Serve(t *testing.T) {
+	addr, err := Serve("127.0.0.1:0")
+	require.Nil(t, err)
+
+	resp, err := http.Get("http://" + addr + "/debug/pprof/heap?debug=1")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
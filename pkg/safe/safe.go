@@ -0,0 +1,75 @@
+// Package safe implements a hardened evaluation mode that makes functions with side effects unavailable
+// to workflow expressions. It is intended for servers that plan untrusted manifests.
+package safe
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/puppet-evaluator/eval"
+)
+
+// EnvVar enables safe evaluation in the lyra process and in all plugins that it starts when set to a
+// true value
+const EnvVar = "LYRA_SAFE_EVAL"
+
+// LYRA_FUNCTION_NOT_AVAILABLE is the issue reported when a disabled function is called
+const LYRA_FUNCTION_NOT_AVAILABLE = `LYRA_FUNCTION_NOT_AVAILABLE`
+
+func init() {
+	issue.Hard(LYRA_FUNCTION_NOT_AVAILABLE, `function '%{name}' is not available in safe evaluation mode`)
+}
+
+// disabledFunctions are the functions that shell out or read arbitrary files
+var disabledFunctions = map[string]bool{
+	`binary_file`: true,
+	`exec`:        true,
+}
+
+// Enabled returns true if safe evaluation has been requested using the LYRA_SAFE_EVAL environment variable.
+// A value that isn't a valid boolean enables safe evaluation.
+func Enabled() bool {
+	v := os.Getenv(EnvVar)
+	if v == `` {
+		return false
+	}
+	enabled, err := strconv.ParseBool(v)
+	return enabled || err != nil
+}
+
+// Disabled returns true if the function with the given name is unavailable in safe evaluation mode
+func Disabled(name string) bool {
+	return disabledFunctions[name]
+}
+
+// loader shadows the disabled functions of its parent
+type loader struct {
+	eval.DefiningLoader
+}
+
+// NewLoader returns a loader that delegates to the given loader except for the disabled functions.
+// Those are replaced with functions that fail with an error explaining why they cannot be called.
+// Loaders that are created with the returned loader as their parent will consult it before they
+// consult their own entries so manifests loaded by them cannot redefine the disabled functions.
+func NewLoader(parent eval.DefiningLoader) eval.DefiningLoader {
+	return &loader{parent}
+}
+
+func (l *loader) LoadEntry(c eval.Context, name eval.TypedName) eval.LoaderEntry {
+	if name.Namespace() == eval.NsFunction && Disabled(name.Name()) {
+		return eval.NewLoaderEntry(disabledFunction(c, name.Name()), nil)
+	}
+	return l.DefiningLoader.LoadEntry(c, name)
+}
+
+func disabledFunction(c eval.Context, name string) eval.Function {
+	return eval.BuildFunction(name, nil, []eval.DispatchCreator{
+		func(d eval.Dispatch) {
+			d.RepeatedParam(`Any`)
+			d.Function(func(c eval.Context, args []eval.Value) eval.Value {
+				panic(eval.Error(LYRA_FUNCTION_NOT_AVAILABLE, issue.H{`name`: name}))
+			})
+		},
+	}).Resolve(c)
+}
@@ -0,0 +1,65 @@
+package safe
+
+import (
+	"os"
+	"testing"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	// Provides the exec function
+	_ "github.com/lyraproj/puppet-workflow/puppet/functions"
+)
+
+func evaluate(safe bool, source string) (result eval.Value, err error) {
+	eval.Puppet.Do(func(c eval.Context) {
+		if safe {
+			c.SetLoader(NewLoader(c.Loader().(eval.DefiningLoader)))
+		}
+		// Manifests are loaded using a child of the context loader
+		c.SetLoader(eval.NewParentedLoader(c.Loader()))
+		defer func() {
+			if r := recover(); r != nil {
+				switch r := r.(type) {
+				case error:
+					err = r
+				case issue.Reported:
+					err = r
+				default:
+					panic(r)
+				}
+			}
+		}()
+		result = eval.Evaluate(c, c.ParseAndValidate(`test.pp`, source, false))
+	})
+	return
+}
+
+func Test_DisabledFunctions(t *testing.T) {
+	for _, source := range []string{`exec('echo', 'hello')`, `binary_file('/etc/passwd')`} {
+		_, err := evaluate(true, source)
+		require.Error(t, err, source)
+		assert.Contains(t, err.Error(), `not available in safe evaluation mode`)
+	}
+}
+
+func Test_OtherFunctionsAreAvailable(t *testing.T) {
+	v, err := evaluate(true, `[1, 2, 3].map |$x| { $x * 2 }`)
+	require.NoError(t, err)
+	assert.Equal(t, `[2, 4, 6]`, v.String())
+
+	v, err = evaluate(false, `exec('echo', 'hello')`)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", v.String())
+}
+
+func Test_Enabled(t *testing.T) {
+	defer os.Unsetenv(EnvVar)
+	tests := map[string]bool{``: false, `false`: false, `0`: false, `true`: true, `1`: true, `yes please`: true}
+	for value, expected := range tests {
+		os.Setenv(EnvVar, value)
+		assert.Equal(t, expected, Enabled(), value)
+	}
+}
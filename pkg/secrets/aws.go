@@ -0,0 +1,57 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+type awsProvider struct {
+	lock   sync.Mutex
+	client *secretsmanager.SecretsManager
+}
+
+// NewAWSProvider returns a provider that resolves awssm:<secret id>[#<key>] references using AWS
+// Secrets Manager. The AWS session is created from the shared configuration on first use.
+func NewAWSProvider() Provider {
+	return &awsProvider{}
+}
+
+func (p *awsProvider) Scheme() string {
+	return `awssm`
+}
+
+func (p *awsProvider) Resolve(ctx context.Context, ref *Ref) (string, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return ``, err
+	}
+	out, err := client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(ref.Path)})
+	if err != nil {
+		return ``, err
+	}
+	if out.SecretString != nil {
+		return *out.SecretString, nil
+	}
+	if out.SecretBinary != nil {
+		return string(out.SecretBinary), nil
+	}
+	return ``, fmt.Errorf("secret has no value")
+}
+
+func (p *awsProvider) getClient() (*secretsmanager.SecretsManager, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client == nil {
+		sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+		if err != nil {
+			return nil, err
+		}
+		p.client = secretsmanager.New(sess)
+	}
+	return p.client, nil
+}
@@ -0,0 +1,27 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+type envProvider struct{}
+
+// NewEnvProvider returns a provider that resolves env:<NAME> references to the value of the
+// environment variable NAME
+func NewEnvProvider() Provider {
+	return envProvider{}
+}
+
+func (envProvider) Scheme() string {
+	return `env`
+}
+
+func (envProvider) Resolve(ctx context.Context, ref *Ref) (string, error) {
+	v, ok := os.LookupEnv(ref.Path)
+	if !ok {
+		return ``, fmt.Errorf("environment variable %s is not set", ref.Path)
+	}
+	return v, nil
+}
@@ -0,0 +1,27 @@
+package secrets
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+)
+
+type fileProvider struct{}
+
+// NewFileProvider returns a provider that resolves file:<path> references to the contents of the
+// file at path. A trailing newline is removed.
+func NewFileProvider() Provider {
+	return fileProvider{}
+}
+
+func (fileProvider) Scheme() string {
+	return `file`
+}
+
+func (fileProvider) Resolve(ctx context.Context, ref *Ref) (string, error) {
+	bts, err := ioutil.ReadFile(ref.Path)
+	if err != nil {
+		return ``, err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(bts), "\n"), "\r"), nil
+}
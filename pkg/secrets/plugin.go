@@ -0,0 +1,56 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// PluginProviderID is the identifier of the object that a plugin registers in order to provide secrets.
+// The object must have a resolve method that takes the path of a reference and returns the secret as a String.
+const PluginProviderID = `Lyra::SecretsProvider`
+
+type pluginProvider struct {
+	scheme      string
+	serviceName string
+}
+
+// NewPluginProvider returns a provider that resolves references with the given scheme by invoking the
+// plugin service with the given name. The service is loaded from the loader of the eval.Context that is
+// passed to Resolve.
+func NewPluginProvider(scheme, serviceName string) Provider {
+	return &pluginProvider{scheme: scheme, serviceName: serviceName}
+}
+
+func (p *pluginProvider) Scheme() string {
+	return p.scheme
+}
+
+func (p *pluginProvider) Resolve(ctx context.Context, ref *Ref) (s string, err error) {
+	c, ok := ctx.(eval.Context)
+	if !ok {
+		return ``, fmt.Errorf("plugin secrets provider %s requires an evaluation context", p.serviceName)
+	}
+	x, ok := eval.Load(c, eval.NewTypedName(eval.NsService, p.serviceName))
+	if !ok {
+		return ``, fmt.Errorf("secrets provider plugin %s could not be loaded", p.serviceName)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+	v := x.(serviceapi.Service).Invoke(c, PluginProviderID, `resolve`, types.WrapString(ref.Path))
+	sv, ok := v.(eval.StringValue)
+	if !ok {
+		return ``, fmt.Errorf("secrets provider plugin %s returned a %s, expected a String", p.serviceName, v.PType().Name())
+	}
+	return sv.String(), nil
+}
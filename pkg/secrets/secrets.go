@@ -0,0 +1,150 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/lyraproj/puppet-evaluator/types"
+)
+
+// PluginsEnvVar can be used to register secrets providers implemented by plugins. The value is a
+// comma separated list of scheme=ServiceName entries, e.g. "keepass=KeePass,op=OnePassword".
+const PluginsEnvVar = "LYRA_SECRETS_PLUGINS"
+
+// Provider resolves references to secrets kept in a particular kind of store
+type Provider interface {
+	// Scheme is the scheme of the references that this provider resolves, e.g. "env" or "vault"
+	Scheme() string
+
+	// Resolve returns the secret that the reference points to
+	Resolve(ctx context.Context, ref *Ref) (string, error)
+}
+
+// Ref is a reference to a secret on the form <scheme>:<path>[#<key>]. The path is interpreted by the
+// provider for the scheme. When a key is given, the resolved secret must be a JSON object and the
+// value of the key is used.
+type Ref struct {
+	Scheme string
+	Path   string
+	Key    string
+}
+
+// ParseRef parses a reference on the form <scheme>:<path>[#<key>]
+func ParseRef(ref string) (*Ref, error) {
+	i := strings.IndexByte(ref, ':')
+	if i <= 0 || i == len(ref)-1 {
+		return nil, fmt.Errorf("invalid secret reference '%s', expected <scheme>:<path>[#<key>]", ref)
+	}
+	r := &Ref{Scheme: ref[:i], Path: ref[i+1:]}
+	if k := strings.LastIndexByte(r.Path, '#'); k >= 0 {
+		r.Key = r.Path[k+1:]
+		r.Path = r.Path[:k]
+		if r.Path == `` || r.Key == `` {
+			return nil, fmt.Errorf("invalid secret reference '%s', expected <scheme>:<path>[#<key>]", ref)
+		}
+	}
+	return r, nil
+}
+
+// String returns the reference in its textual form
+func (r *Ref) String() string {
+	if r.Key == `` {
+		return r.Scheme + `:` + r.Path
+	}
+	return r.Scheme + `:` + r.Path + `#` + r.Key
+}
+
+// Resolver dispatches references to the provider registered for their scheme
+type Resolver struct {
+	lock      sync.RWMutex
+	providers map[string]Provider
+}
+
+// NewResolver creates a resolver with the given providers
+func NewResolver(providers ...Provider) *Resolver {
+	r := &Resolver{providers: map[string]Provider{}}
+	for _, p := range providers {
+		r.Register(p)
+	}
+	return r
+}
+
+// NewDefaultResolver creates a resolver with the providers for env, file, vault and awssm
+// references and with the plugin providers named by the LYRA_SECRETS_PLUGINS environment variable
+func NewDefaultResolver() (*Resolver, error) {
+	r := NewResolver(NewEnvProvider(), NewFileProvider(), NewVaultProvider(), NewAWSProvider())
+	if spec := os.Getenv(PluginsEnvVar); spec != `` {
+		for _, entry := range strings.Split(spec, `,`) {
+			parts := strings.SplitN(strings.TrimSpace(entry), `=`, 2)
+			if len(parts) != 2 || parts[0] == `` || parts[1] == `` {
+				return nil, fmt.Errorf("invalid %s entry '%s', expected scheme=ServiceName", PluginsEnvVar, entry)
+			}
+			r.Register(NewPluginProvider(parts[0], parts[1]))
+		}
+	}
+	return r, nil
+}
+
+// Register adds a provider, replacing any provider previously registered for the same scheme
+func (r *Resolver) Register(p Provider) {
+	r.lock.Lock()
+	r.providers[p.Scheme()] = p
+	r.lock.Unlock()
+}
+
+// Resolve returns the secret that the given reference points to. The value is wrapped in a
+// Sensitive so that it is never revealed in logs or output.
+func (r *Resolver) Resolve(ctx context.Context, ref string) (*types.SensitiveValue, error) {
+	s, err := r.ResolveString(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return types.WrapSensitive(types.WrapString(s)), nil
+}
+
+// ResolveString returns the secret that the given reference points to as a plain string. Callers are
+// responsible for not revealing it.
+func (r *Resolver) ResolveString(ctx context.Context, ref string) (string, error) {
+	pr, err := ParseRef(ref)
+	if err != nil {
+		return ``, err
+	}
+	r.lock.RLock()
+	p, ok := r.providers[pr.Scheme]
+	r.lock.RUnlock()
+	if !ok {
+		return ``, fmt.Errorf("no secrets provider registered for scheme '%s'", pr.Scheme)
+	}
+	s, err := p.Resolve(ctx, pr)
+	if err != nil {
+		// The error must not contain the secret so only the reference is mentioned
+		return ``, fmt.Errorf("unable to resolve secret '%s': %s", pr, err)
+	}
+	if pr.Key == `` {
+		return s, nil
+	}
+	return extractKey(pr, s)
+}
+
+func extractKey(ref *Ref, s string) (string, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return ``, fmt.Errorf("secret '%s' is not a JSON object", ref)
+	}
+	v, ok := m[ref.Key]
+	if !ok {
+		return ``, fmt.Errorf("secret '%s' has no key '%s'", ref, ref.Key)
+	}
+	if str, ok := v.(string); ok {
+		return str, nil
+	}
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return ``, err
+	}
+	return string(bts), nil
+}
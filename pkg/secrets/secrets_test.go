@@ -0,0 +1,134 @@
+package secrets
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var parseRefTests = []struct {
+	ref      string
+	error    bool
+	expected *Ref
+}{
+	{ref: "env:DB_PASSWORD", expected: &Ref{Scheme: "env", Path: "DB_PASSWORD"}},
+	{ref: "vault:secret/data/db#password", expected: &Ref{Scheme: "vault", Path: "secret/data/db", Key: "password"}},
+	{ref: "file:/run/secrets/db", expected: &Ref{Scheme: "file", Path: "/run/secrets/db"}},
+	{ref: "DB_PASSWORD", error: true},
+	{ref: ":DB_PASSWORD", error: true},
+	{ref: "env:", error: true},
+	{ref: "vault:secret/db#", error: true},
+}
+
+func Test_ParseRef(t *testing.T) {
+	for _, test := range parseRefTests {
+		t.Run(test.ref, func(t *testing.T) {
+			r, err := ParseRef(test.ref)
+			if test.error {
+				assert.Error(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, test.expected, r)
+			assert.Equal(t, test.ref, r.String())
+		})
+	}
+}
+
+func Test_ResolveEnvAndFile(t *testing.T) {
+	os.Setenv("LYRA_TEST_SECRET", `{"user":"admin","password":"s3cret"}`)
+	defer os.Unsetenv("LYRA_TEST_SECRET")
+
+	dir, err := ioutil.TempDir("", "secrets")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "secret")
+	require.Nil(t, ioutil.WriteFile(file, []byte("from-file\n"), 0600))
+
+	r, err := NewDefaultResolver()
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	s, err := r.ResolveString(ctx, "env:LYRA_TEST_SECRET#password")
+	require.Nil(t, err)
+	assert.Equal(t, "s3cret", s)
+
+	s, err = r.ResolveString(ctx, "file:"+file)
+	require.Nil(t, err)
+	assert.Equal(t, "from-file", s)
+
+	_, err = r.ResolveString(ctx, "env:LYRA_TEST_SECRET#missing")
+	assert.Error(t, err)
+
+	_, err = r.ResolveString(ctx, "env:LYRA_NO_SUCH_SECRET")
+	assert.Error(t, err)
+
+	_, err = r.ResolveString(ctx, "keepass:db")
+	assert.Error(t, err)
+}
+
+func Test_ResolveIsSensitive(t *testing.T) {
+	os.Setenv("LYRA_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("LYRA_TEST_SECRET")
+
+	v, err := NewResolver(NewEnvProvider()).Resolve(context.Background(), "env:LYRA_TEST_SECRET")
+	require.Nil(t, err)
+	assert.NotContains(t, v.String(), "s3cret")
+	assert.Equal(t, "s3cret", v.Unwrap().String())
+}
+
+func Test_ResolveVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/db":
+			w.Write([]byte(`{"data":{"data":{"password":"s3cret","user":"admin"},"metadata":{"version":1}}}`))
+		case "/v1/kv/token":
+			w.Write([]byte(`{"data":{"value":"abc"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	os.Setenv(VaultAddrEnvVar, server.URL)
+	os.Setenv(VaultTokenEnvVar, "test-token")
+	defer os.Unsetenv(VaultAddrEnvVar)
+	defer os.Unsetenv(VaultTokenEnvVar)
+
+	r := NewResolver(NewVaultProvider())
+	ctx := context.Background()
+
+	s, err := r.ResolveString(ctx, "vault:secret/data/db#password")
+	require.Nil(t, err)
+	assert.Equal(t, "s3cret", s)
+
+	s, err = r.ResolveString(ctx, "vault:kv/token")
+	require.Nil(t, err)
+	assert.Equal(t, "abc", s)
+
+	_, err = r.ResolveString(ctx, "vault:secret/data/missing")
+	assert.Error(t, err)
+}
+
+func Test_PluginsEnvVar(t *testing.T) {
+	os.Setenv(PluginsEnvVar, "keepass=KeePass")
+	r, err := NewDefaultResolver()
+	require.Nil(t, err)
+	_, err = r.ResolveString(context.Background(), "keepass:db")
+	assert.Contains(t, err.Error(), "requires an evaluation context")
+
+	os.Setenv(PluginsEnvVar, "keepass")
+	_, err = NewDefaultResolver()
+	assert.Error(t, err)
+	os.Unsetenv(PluginsEnvVar)
+}
@@ -0,0 +1,85 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// VaultAddrEnvVar is the address of the Vault server
+	VaultAddrEnvVar = "VAULT_ADDR"
+	// VaultTokenEnvVar is the token used to authenticate with the Vault server
+	VaultTokenEnvVar = "VAULT_TOKEN"
+
+	vaultTimeout = 30 * time.Second
+)
+
+type vaultProvider struct {
+	client *http.Client
+}
+
+// NewVaultProvider returns a provider that resolves vault:<path>[#<key>] references by reading the
+// path from the Vault server at VAULT_ADDR using the token in VAULT_TOKEN. Both KV version 1 and
+// version 2 secrets engines are supported. The secret data is returned as a JSON object unless it
+// contains a single key.
+func NewVaultProvider() Provider {
+	return &vaultProvider{client: &http.Client{Timeout: vaultTimeout}}
+}
+
+func (p *vaultProvider) Scheme() string {
+	return `vault`
+}
+
+func (p *vaultProvider) Resolve(ctx context.Context, ref *Ref) (string, error) {
+	addr := os.Getenv(VaultAddrEnvVar)
+	if addr == `` {
+		return ``, fmt.Errorf("%s is not set", VaultAddrEnvVar)
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, `/`)+`/v1/`+strings.TrimPrefix(ref.Path, `/`), nil)
+	if err != nil {
+		return ``, err
+	}
+	req = req.WithContext(ctx)
+	if token := os.Getenv(VaultTokenEnvVar); token != `` {
+		req.Header.Set(`X-Vault-Token`, token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return ``, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ``, fmt.Errorf("vault responded with status %s", resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return ``, fmt.Errorf("unable to decode vault response: %s", err)
+	}
+	data := body.Data
+	if inner, ok := data[`data`].(map[string]interface{}); ok {
+		if _, ok := data[`metadata`]; ok {
+			// KV version 2
+			data = inner
+		}
+	}
+	if ref.Key == `` && len(data) == 1 {
+		for _, v := range data {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+		}
+	}
+	bts, err := json.Marshal(data)
+	if err != nil {
+		return ``, err
+	}
+	return string(bts), nil
+}
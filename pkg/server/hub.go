@@ -0,0 +1,213 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyraproj/lyra/pkg/event"
+)
+
+const (
+	// maxEvents is the number of events that the hub keeps and replays to new subscribers
+	maxEvents = 1000
+
+	// subscriberQueueSize is the number of events that are queued for a subscriber before it is dropped
+	subscriberQueueSize = 64
+)
+
+// Run is the progress of one run of a workflow, as reconstructed from its events
+type Run struct {
+	ID        string     `json:"id"`
+	Workflow  string     `json:"workflow"`
+	Source    string     `json:"source"`
+	Operation string     `json:"operation,omitempty"`
+	Noop      bool       `json:"noop,omitempty"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Started   time.Time  `json:"started"`
+	Ended     *time.Time `json:"ended,omitempty"`
+	Steps     []*Step    `json:"steps"`
+	Changes   []*Change  `json:"changes"`
+}
+
+// Step is an activity that a run has started
+type Step struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Change is a change of a resource made by a run
+type Change struct {
+	Type       string    `json:"type"`
+	Handler    string    `json:"handler"`
+	ExternalID string    `json:"externalId,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Run statuses
+const (
+	Running  = `running`
+	Finished = `finished`
+	Failed   = `failed`
+)
+
+// hub is an event.Sink that keeps the latest events and runs and passes each event on to the
+// subscribers of the live event stream
+type hub struct {
+	lock        sync.Mutex
+	events      []*event.Event
+	runs        []*Run
+	active      map[string]*Run
+	subscribers map[chan *event.Event]bool
+}
+
+func newHub() *hub {
+	return &hub{active: map[string]*Run{}, subscribers: map[chan *event.Event]bool{}}
+}
+
+// Send records the event and passes it on to all subscribers. A subscriber that doesn't keep up is
+// dropped.
+func (h *hub) Send(e *event.Event) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.events = append(h.events, e)
+	if len(h.events) > maxEvents {
+		h.events = h.events[len(h.events)-maxEvents:]
+	}
+	h.track(e)
+	for s := range h.subscribers {
+		select {
+		case s <- e:
+		default:
+			delete(h.subscribers, s)
+			close(s)
+		}
+	}
+	return nil
+}
+
+// Close ends the streams of all subscribers
+func (h *hub) Close() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for s := range h.subscribers {
+		close(s)
+	}
+	h.subscribers = map[chan *event.Event]bool{}
+	return nil
+}
+
+// subscribe returns the events kept by the hub and a channel that receives the events that follow. The
+// channel is closed when the subscriber is dropped.
+func (h *hub) subscribe() ([]*event.Event, chan *event.Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s := make(chan *event.Event, subscriberQueueSize)
+	h.subscribers[s] = true
+	return append([]*event.Event{}, h.events...), s
+}
+
+// unsubscribe stops sending events to the given channel
+func (h *hub) unsubscribe(s chan *event.Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.subscribers[s] {
+		delete(h.subscribers, s)
+		close(s)
+	}
+}
+
+// Runs returns copies of the runs that the hub knows about, the latest first
+func (h *hub) Runs() []*Run {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	runs := make([]*Run, len(h.runs))
+	for i, r := range h.runs {
+		c := *r
+		c.Steps = make([]*Step, len(r.Steps))
+		for si, s := range r.Steps {
+			sc := *s
+			c.Steps[si] = &sc
+		}
+		c.Changes = append([]*Change{}, r.Changes...)
+		runs[len(runs)-1-i] = &c
+	}
+	return runs
+}
+
+// track updates the run that the event belongs to. Runs are told apart by the source of their events,
+// since a source runs one workflow at a time.
+func (h *hub) track(e *event.Event) {
+	if e.Type == event.WorkflowStarted {
+		r := &Run{
+			ID:        e.ID,
+			Workflow:  e.Subject,
+			Source:    e.Source,
+			Operation: stringData(e, `operation`),
+			Noop:      e.Data[`noop`] == true,
+			Status:    Running,
+			Started:   e.Time,
+			Steps:     []*Step{},
+			Changes:   []*Change{},
+		}
+		h.active[e.Source] = r
+		h.runs = append(h.runs, r)
+		if len(h.runs) > maxEvents {
+			h.runs = h.runs[len(h.runs)-maxEvents:]
+		}
+		return
+	}
+
+	r, ok := h.active[e.Source]
+	if !ok {
+		return
+	}
+	switch e.Type {
+	case event.WorkflowFinished, event.WorkflowFailed:
+		r.Status = Finished
+		if e.Type == event.WorkflowFailed {
+			r.Status = Failed
+			r.Error = stringData(e, `error`)
+		}
+		t := e.Time
+		r.Ended = &t
+		for _, s := range r.Steps {
+			// Resource steps have no event of their own that ends them
+			if s.Status == Running {
+				s.Status = r.Status
+			}
+		}
+		delete(h.active, e.Source)
+	case event.StepStarted:
+		r.Steps = append(r.Steps, &Step{Name: e.Subject, Status: Running})
+	case event.StepFinished, event.StepFailed:
+		for i := len(r.Steps) - 1; i >= 0; i-- {
+			if s := r.Steps[i]; s.Name == e.Subject && s.Status == Running {
+				s.Status = Finished
+				if e.Type == event.StepFailed {
+					s.Status = Failed
+					s.Error = stringData(e, `error`)
+				}
+				break
+			}
+		}
+	case event.ResourceCreated, event.ResourceUpdated, event.ResourceDeleted, event.ResourceFailed:
+		r.Changes = append(r.Changes, &Change{
+			Type:       e.Type[strings.LastIndexByte(e.Type, '.')+1:],
+			Handler:    e.Subject,
+			ExternalID: stringData(e, `externalId`),
+			Error:      stringData(e, `error`),
+			Time:       e.Time,
+		})
+	}
+}
+
+func stringData(e *event.Event, key string) string {
+	if s, ok := e.Data[key].(string); ok {
+		return s
+	}
+	return ``
+}
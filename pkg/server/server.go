@@ -0,0 +1,289 @@
+// Package server serves the workflows within reach of lyra, the progress of their runs and the state of
+// the resources that they manage over HTTP, for teams that run lyra as a service. Runs report their
+// progress by sending their events to the server, e.g. with --event-sink http://localhost:8080/api/events.
+//
+// The API is:
+//
+//	GET  /api/workflows                the workflows with their resources and the dependencies between them
+//	GET  /api/workflows/<name>/state   the resources of a workflow with their external IDs and recorded states
+//	GET  /api/runs                     the runs that have reported events, the latest first
+//	GET  /api/events                   a stream of server-sent events, starting with the latest events
+//	POST /api/events                   receives an event in the structured JSON form of CloudEvents
+//
+// A minimal web dashboard is served at / when the server is created with WithUI.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/lyraproj/lyra/cmd/goplugin-identity/identity"
+	"github.com/lyraproj/lyra/pkg/catalog"
+	"github.com/lyraproj/lyra/pkg/event"
+	"github.com/lyraproj/lyra/pkg/incremental"
+	"github.com/lyraproj/puppet-evaluator/eval"
+)
+
+// maxEventSize is the largest event that is accepted
+const maxEventSize = 1 << 20
+
+// Server is the HTTP server of lyra serve
+type Server struct {
+	workflows     []*catalog.Workflow
+	ui            bool
+	identityStore string
+	stateFile     string
+	hub           *hub
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithUI makes the server serve the web dashboard
+func WithUI() Option {
+	return func(s *Server) {
+		s.ui = true
+	}
+}
+
+// WithIdentityStore sets the path of the identity store that external IDs are read from. The default is
+// identity.db.
+func WithIdentityStore(path string) Option {
+	return func(s *Server) {
+		s.identityStore = path
+	}
+}
+
+// WithStateFile sets the path of the file that the states recorded by incremental runs are read from. The
+// default is incremental.DefaultFile.
+func WithStateFile(path string) Option {
+	return func(s *Server) {
+		s.stateFile = path
+	}
+}
+
+// New creates a server for the given workflows
+func New(workflows []*catalog.Workflow, options ...Option) *Server {
+	s := &Server{workflows: workflows, identityStore: `identity.db`, stateFile: incremental.DefaultFile, hub: newHub()}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+// Sink returns the sink that the events of the server are sent to. Events that are sent to it are served
+// as if they had been posted.
+func (s *Server) Sink() event.Sink {
+	return s.hub
+}
+
+// Handler returns the handler of all requests to the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(`/api/workflows`, s.serveWorkflows)
+	mux.HandleFunc(`/api/workflows/`, s.serveState)
+	mux.HandleFunc(`/api/runs`, s.serveRuns)
+	mux.HandleFunc(`/api/events`, s.serveEvents)
+	if s.ui {
+		mux.HandleFunc(`/`, serveUI)
+	}
+	return mux
+}
+
+// Workflow is a workflow as served by the API
+type Workflow struct {
+	Name      string      `json:"name"`
+	Resources []*Resource `json:"resources"`
+}
+
+// Resource is a resource of a workflow as served by the API
+type Resource struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	InternalID string      `json:"internalId"`
+	ExternalID string      `json:"externalId,omitempty"`
+	DependsOn  []string    `json:"dependsOn"`
+	State      interface{} `json:"state,omitempty"`
+}
+
+func newWorkflow(wf *catalog.Workflow) *Workflow {
+	w := &Workflow{Name: wf.Name, Resources: make([]*Resource, len(wf.Resources))}
+	for i, r := range wf.Resources {
+		deps := wf.DependsOn(r)
+		names := make([]string, len(deps))
+		for di, d := range deps {
+			names[di] = d.Name
+		}
+		w.Resources[i] = &Resource{Name: r.Name, Type: r.Type, InternalID: r.InternalID, DependsOn: names}
+	}
+	return w
+}
+
+func (s *Server) serveWorkflows(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	wfs := make([]*Workflow, len(s.workflows))
+	for i, wf := range s.workflows {
+		wfs[i] = newWorkflow(wf)
+	}
+	writeJSON(w, wfs)
+}
+
+// serveState serves the resources of a workflow with their external IDs and the states that incremental
+// runs have recorded for them
+func (s *Server) serveState(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, `/api/workflows/`)
+	if !strings.HasSuffix(name, `/state`) {
+		http.NotFound(w, r)
+		return
+	}
+	name = strings.TrimSuffix(name, `/state`)
+	var wf *catalog.Workflow
+	for _, c := range s.workflows {
+		if c.Name == name {
+			wf = c
+		}
+	}
+	if wf == nil {
+		http.Error(w, fmt.Sprintf("no workflow named %s", name), http.StatusNotFound)
+		return
+	}
+
+	ids, err := s.externalIDs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	states, err := incremental.Recorded(s.stateFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := newWorkflow(wf)
+	for _, r := range result.Resources {
+		r.ExternalID = ids[r.InternalID]
+		if r.ExternalID != `` {
+			r.State = states[r.ExternalID]
+		}
+	}
+	writeJSON(w, result)
+}
+
+// externalIDs returns the external IDs of the identity store keyed by internal ID. A store that doesn't
+// exist has none.
+func (s *Server) externalIDs() (map[string]string, error) {
+	ids := map[string]string{}
+	if _, err := os.Stat(s.identityStore); os.IsNotExist(err) {
+		return ids, nil
+	}
+	store, err := identity.NewIdentity(s.identityStore)
+	if err != nil {
+		return nil, err
+	}
+	found, err := store.Search(``)
+	if err != nil {
+		return nil, err
+	}
+	found.Each(func(t eval.Value) {
+		tuple := t.(eval.List)
+		ids[tuple.At(0).String()] = tuple.At(1).String()
+	})
+	return ids, nil
+}
+
+func (s *Server) serveRuns(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, s.hub.Runs())
+}
+
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.receiveEvent(w, r)
+	case http.MethodGet:
+		s.streamEvents(w, r)
+	default:
+		allow(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *Server) receiveEvent(w http.ResponseWriter, r *http.Request) {
+	e := &event.Event{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEventSize)).Decode(e); err != nil {
+		http.Error(w, fmt.Sprintf("invalid event: %s", err), http.StatusBadRequest)
+		return
+	}
+	if e.ID == `` || e.Source == `` || e.Type == `` {
+		http.Error(w, `invalid event: id, source and type are required`, http.StatusBadRequest)
+		return
+	}
+	s.hub.Send(e)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// streamEvents sends the latest events followed by each event that is received, as server-sent events,
+// until the client disconnects or the server is closed
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, `streaming is not supported`, http.StatusInternalServerError)
+		return
+	}
+	latest, events := s.hub.subscribe()
+	defer s.hub.unsubscribe(events)
+
+	w.Header().Set(`Content-Type`, `text/event-stream`)
+	w.Header().Set(`Cache-Control`, `no-cache`)
+	for _, e := range latest {
+		writeEvent(w, e)
+	}
+	flusher.Flush()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, e)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e *event.Event) {
+	bts, err := json.Marshal(e)
+	if err == nil {
+		fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, bts)
+	}
+}
+
+// allow returns true if the method of the request is one of the given methods. Otherwise the request is
+// rejected with 405 Method Not Allowed and false is returned.
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set(`Allow`, strings.Join(methods, `, `))
+	http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent(``, `  `)
+	enc.Encode(v)
+}
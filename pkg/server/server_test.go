@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lyraproj/lyra/cmd/goplugin-identity/identity"
+	"github.com/lyraproj/lyra/pkg/catalog"
+	"github.com/lyraproj/lyra/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	// Ensure that pcore is initialized
+	_ "github.com/lyraproj/puppet-evaluator/pcore"
+)
+
+func sampleWorkflows() []*catalog.Workflow {
+	return []*catalog.Workflow{{
+		Name: "aws_vpc_yaml",
+		Resources: []*catalog.Resource{
+			{Name: "vpc", Type: "Aws::Vpc", InternalID: "lyra://puppet.com/aws_vpc_yaml/vpc", Output: []string{"vpcId"}},
+			{Name: "subnet", Type: "Aws::Subnet", InternalID: "lyra://puppet.com/aws_vpc_yaml/subnet", Input: []string{"vpcId"}},
+		},
+	}}
+}
+
+func get(t *testing.T, h http.Handler, path string, v interface{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if v != nil {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+	}
+	return w
+}
+
+func post(h http.Handler, e *event.Event) *httptest.ResponseRecorder {
+	bts, _ := json.Marshal(e)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/events", strings.NewReader(string(bts))))
+	return w
+}
+
+func Test_Workflows(t *testing.T) {
+	var wfs []*Workflow
+	get(t, New(sampleWorkflows()).Handler(), "/api/workflows", &wfs)
+	require.Len(t, wfs, 1)
+	assert.Equal(t, "aws_vpc_yaml", wfs[0].Name)
+	assert.Equal(t, []string{}, wfs[0].Resources[0].DependsOn)
+	assert.Equal(t, []string{"vpc"}, wfs[0].Resources[1].DependsOn)
+}
+
+func Test_State(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lyra-server")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := filepath.Join(dir, "identity.db")
+	h := New(sampleWorkflows(), WithIdentityStore(store), WithStateFile(filepath.Join(dir, "incremental.json"))).Handler()
+
+	// A store that doesn't exist is not created
+	var wf *Workflow
+	get(t, h, "/api/workflows/aws_vpc_yaml/state", &wf)
+	assert.Equal(t, "", wf.Resources[0].ExternalID)
+	_, err = os.Stat(store)
+	assert.True(t, os.IsNotExist(err))
+
+	i, err := identity.NewIdentity(store)
+	require.NoError(t, err)
+	require.NoError(t, i.Associate("lyra://puppet.com/aws_vpc_yaml/vpc", "vpc-123"))
+	get(t, h, "/api/workflows/aws_vpc_yaml/state", &wf)
+	assert.Equal(t, "vpc-123", wf.Resources[0].ExternalID)
+	assert.Equal(t, "", wf.Resources[1].ExternalID)
+
+	assert.Equal(t, http.StatusNotFound, get(t, h, "/api/workflows/unknown/state", nil).Code)
+}
+
+func Test_Runs(t *testing.T) {
+	s := New(sampleWorkflows())
+	h := s.Handler()
+	assert.Equal(t, http.StatusAccepted, post(h, event.New("/lyra/a", event.WorkflowStarted, "aws_vpc_yaml", map[string]interface{}{"operation": "apply"})).Code)
+	post(h, event.New("/lyra/a", event.StepStarted, "aws_vpc_yaml/vpc", nil))
+	post(h, event.New("/lyra/a", event.ResourceCreated, "Aws::VpcHandler", map[string]interface{}{"externalId": "vpc-123"}))
+
+	var runs []*Run
+	get(t, h, "/api/runs", &runs)
+	require.Len(t, runs, 1)
+	assert.Equal(t, Running, runs[0].Status)
+	assert.Equal(t, "apply", runs[0].Operation)
+	assert.Equal(t, &Step{Name: "aws_vpc_yaml/vpc", Status: Running}, runs[0].Steps[0])
+	assert.Equal(t, "created", runs[0].Changes[0].Type)
+	assert.Equal(t, "vpc-123", runs[0].Changes[0].ExternalID)
+
+	// Events of another source belong to another run
+	s.Sink().Send(event.New("/lyra/b", event.WorkflowStarted, "other", nil))
+	post(h, event.New("/lyra/a", event.WorkflowFailed, "aws_vpc_yaml", map[string]interface{}{"error": "boom"}))
+	get(t, h, "/api/runs", &runs)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "other", runs[0].Workflow)
+	assert.Equal(t, Running, runs[0].Status)
+	assert.Equal(t, Failed, runs[1].Status)
+	assert.Equal(t, "boom", runs[1].Error)
+	assert.Equal(t, Failed, runs[1].Steps[0].Status)
+}
+
+func Test_InvalidEvent(t *testing.T) {
+	h := New(nil).Handler()
+	assert.Equal(t, http.StatusBadRequest, post(h, &event.Event{Type: event.WorkflowStarted}).Code)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/events", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, POST", w.Header().Get("Allow"))
+}
+
+func Test_StreamEvents(t *testing.T) {
+	s := New(nil)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	s.Sink().Send(event.New("/lyra/a", event.WorkflowStarted, "aws_vpc_yaml", nil))
+
+	resp, err := http.Get(ts.URL + "/api/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// The stream starts with the latest events and ends when the server is closed
+	s.Sink().Send(event.New("/lyra/a", event.WorkflowFinished, "aws_vpc_yaml", nil))
+	s.Sink().Close()
+	bts, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(bts), "event: "+event.WorkflowStarted+"\n")
+	assert.Contains(t, string(bts), "event: "+event.WorkflowFinished+"\n")
+}
+
+func Test_UI(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, get(t, New(nil).Handler(), "/", nil).Code)
+	w := get(t, New(nil, WithUI()).Handler(), "/", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "api/workflows")
+}
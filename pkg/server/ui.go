@@ -0,0 +1,208 @@
+package server
+
+import (
+	"net/http"
+)
+
+// serveUI serves the web dashboard. It is a single page that uses the API of the server.
+func serveUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != `/` {
+		http.NotFound(w, r)
+		return
+	}
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set(`Content-Type`, `text/html; charset=utf-8`)
+	w.Header().Set(`Content-Security-Policy`, `default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'`)
+	w.Write([]byte(indexHTML))
+}
+
+const indexHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Lyra</title>
+<style>
+  body { font-family: sans-serif; margin: 0; color: #222; }
+  header { background: #2c3e50; color: #fff; padding: 0.6em 1em; font-size: 1.2em; }
+  main { display: grid; grid-template-columns: 16em 1fr 1fr; gap: 1em; padding: 1em; }
+  section { border: 1px solid #ddd; border-radius: 4px; padding: 0.5em 1em; overflow: auto; }
+  h2 { font-size: 1em; margin: 0.3em 0 0.6em; }
+  ul { list-style: none; padding: 0; margin: 0; }
+  li.workflow { cursor: pointer; padding: 0.2em 0.4em; }
+  li.workflow.selected { background: #e8eef4; }
+  table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+  td, th { text-align: left; padding: 0.2em 0.4em; border-bottom: 1px solid #eee; vertical-align: top; }
+  tr.resource { cursor: pointer; }
+  pre { font-size: 0.85em; background: #f7f7f7; padding: 0.5em; }
+  .running { color: #2471a3; } .finished { color: #1e8449; } .failed { color: #c0392b; }
+  #events { grid-column: 1 / 4; max-height: 14em; font-family: monospace; font-size: 0.85em; }
+  svg text { font-size: 12px; }
+</style>
+</head>
+<body>
+<header>Lyra</header>
+<main>
+  <section><h2>Workflows</h2><ul id="workflows"></ul></section>
+  <section><h2>Dependency graph</h2><svg id="graph" width="100%" height="0"></svg>
+    <h2>State</h2><table id="state"></table><pre id="detail" hidden></pre></section>
+  <section><h2>Runs</h2><div id="runs"></div></section>
+  <section id="events"><h2>Events</h2><ul id="log"></ul></section>
+</main>
+<script>
+"use strict";
+var selected = null;
+
+function el(tag, text, cls) {
+  var e = document.createElement(tag);
+  if (text !== undefined) { e.textContent = text; }
+  if (cls) { e.className = cls; }
+  return e;
+}
+
+function get(path) {
+  return fetch(path).then(function (r) {
+    if (!r.ok) { return r.text().then(function (t) { throw new Error(t); }); }
+    return r.json();
+  });
+}
+
+function loadWorkflows() {
+  get("api/workflows").then(function (wfs) {
+    var ul = document.getElementById("workflows");
+    ul.textContent = "";
+    wfs.forEach(function (wf) {
+      var li = el("li", wf.name, "workflow");
+      li.onclick = function () {
+        Array.prototype.forEach.call(ul.children, function (c) { c.classList.remove("selected"); });
+        li.classList.add("selected");
+        selected = wf.name;
+        loadState();
+      };
+      ul.appendChild(li);
+    });
+  });
+}
+
+function loadState() {
+  if (selected === null) { return; }
+  get("api/workflows/" + encodeURIComponent(selected) + "/state").then(function (wf) {
+    drawGraph(wf.resources);
+    var table = document.getElementById("state");
+    var detail = document.getElementById("detail");
+    detail.hidden = true;
+    table.textContent = "";
+    var head = el("tr");
+    ["Resource", "Type", "External ID"].forEach(function (h) { head.appendChild(el("th", h)); });
+    table.appendChild(head);
+    wf.resources.forEach(function (r) {
+      var tr = el("tr", undefined, "resource");
+      [r.name, r.type, r.externalId || "-"].forEach(function (v) { tr.appendChild(el("td", v)); });
+      tr.onclick = function () {
+        detail.hidden = false;
+        detail.textContent = r.state ? JSON.stringify(r.state, null, 2) : "No recorded state";
+      };
+      table.appendChild(tr);
+    });
+  }).catch(function (e) { document.getElementById("state").textContent = e.message; });
+}
+
+// drawGraph lays out the resources in columns by the length of their longest chain of dependencies
+function drawGraph(resources) {
+  var byName = {}, level = {};
+  resources.forEach(function (r) { byName[r.name] = r; });
+  function depth(r, seen) {
+    if (level[r.name] !== undefined) { return level[r.name]; }
+    var d = 0;
+    seen[r.name] = true;
+    r.dependsOn.forEach(function (n) {
+      if (byName[n] && !seen[n]) { d = Math.max(d, depth(byName[n], seen) + 1); }
+    });
+    level[r.name] = d;
+    return d;
+  }
+  var columns = [], pos = {}, w = 150, h = 40;
+  resources.forEach(function (r) {
+    var d = depth(r, {});
+    columns[d] = columns[d] || [];
+    pos[r.name] = { x: d * (w + 40) + 10, y: columns[d].length * (h + 15) + 10 };
+    columns[d].push(r);
+  });
+  var svg = document.getElementById("graph"), ns = "http://www.w3.org/2000/svg";
+  svg.textContent = "";
+  var rows = Math.max.apply(null, columns.map(function (c) { return c.length; }).concat([0]));
+  svg.setAttribute("height", rows * (h + 15) + 10);
+  svg.setAttribute("viewBox", "0 0 " + (columns.length * (w + 40) + 10) + " " + (rows * (h + 15) + 10));
+  resources.forEach(function (r) {
+    r.dependsOn.forEach(function (n) {
+      if (!pos[n]) { return; }
+      var line = document.createElementNS(ns, "line");
+      line.setAttribute("x1", pos[n].x + w); line.setAttribute("y1", pos[n].y + h / 2);
+      line.setAttribute("x2", pos[r.name].x); line.setAttribute("y2", pos[r.name].y + h / 2);
+      line.setAttribute("stroke", "#999");
+      svg.appendChild(line);
+    });
+  });
+  resources.forEach(function (r) {
+    var p = pos[r.name];
+    var rect = document.createElementNS(ns, "rect");
+    rect.setAttribute("x", p.x); rect.setAttribute("y", p.y);
+    rect.setAttribute("width", w); rect.setAttribute("height", h);
+    rect.setAttribute("rx", 4);
+    rect.setAttribute("fill", r.externalId ? "#d5f5e3" : "#f2f3f4");
+    rect.setAttribute("stroke", "#666");
+    svg.appendChild(rect);
+    [[r.name, 16], [r.type, 32]].forEach(function (t) {
+      var text = document.createElementNS(ns, "text");
+      text.setAttribute("x", p.x + 6); text.setAttribute("y", p.y + t[1]);
+      text.textContent = t[0];
+      svg.appendChild(text);
+    });
+  });
+}
+
+function loadRuns() {
+  get("api/runs").then(function (runs) {
+    var div = document.getElementById("runs");
+    div.textContent = "";
+    runs.forEach(function (run) {
+      var title = run.workflow + " " + (run.operation || "") + (run.noop ? " (noop)" : "") + " " + run.status;
+      div.appendChild(el("h2", title, run.status));
+      div.appendChild(el("div", run.source + " " + new Date(run.started).toLocaleString() + (run.error ? " " + run.error : "")));
+      var ul = el("ul");
+      run.steps.forEach(function (s) { ul.appendChild(el("li", s.status + " " + s.name + (s.error ? " " + s.error : ""), s.status)); });
+      run.changes.forEach(function (c) { ul.appendChild(el("li", c.type + " " + c.handler + " " + (c.externalId || ""), c.type === "failed" ? "failed" : "")); });
+      div.appendChild(ul);
+    });
+  });
+}
+
+var pending = null;
+function refresh() {
+  // Events arrive in bursts, so the views are refreshed at most once a second
+  if (pending !== null) { return; }
+  pending = setTimeout(function () { pending = null; loadRuns(); loadState(); }, 1000);
+}
+
+function listen() {
+  var source = new EventSource("api/events");
+  var log = document.getElementById("log");
+  ["workflow.started", "workflow.finished", "workflow.failed", "step.started", "step.finished", "step.failed",
+   "resource.created", "resource.updated", "resource.deleted", "resource.failed"].forEach(function (t) {
+    source.addEventListener("io.lyraproj." + t, function (m) {
+      var e = JSON.parse(m.data);
+      log.insertBefore(el("li", e.time + " " + t + " " + (e.subject || "")), log.firstChild);
+      while (log.children.length > 200) { log.removeChild(log.lastChild); }
+      refresh();
+    });
+  });
+}
+
+loadWorkflows();
+loadRuns();
+listen();
+</script>
+</body>
+</html>
+`
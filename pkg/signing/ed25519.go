@@ -0,0 +1,29 @@
+//go:build go1.13
+// +build go1.13
+
+package signing
+
+// The Ed25519 implementation of the standard library is part of the Go Cryptographic Module, which
+// makes signing usable in FIPS mode
+
+import "crypto/ed25519"
+
+type (
+	// PublicKey is an Ed25519 public key
+	PublicKey = ed25519.PublicKey
+
+	// PrivateKey is an Ed25519 private key
+	PrivateKey = ed25519.PrivateKey
+)
+
+const (
+	publicKeySize  = ed25519.PublicKeySize
+	privateKeySize = ed25519.PrivateKeySize
+	signatureSize  = ed25519.SignatureSize
+)
+
+var (
+	generateKey = ed25519.GenerateKey
+	sign        = ed25519.Sign
+	verify      = ed25519.Verify
+)
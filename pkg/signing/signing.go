@@ -0,0 +1,174 @@
+package signing
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// TrustedKeysEnvVar names a directory containing the public keys (*.pub) of the signers that
+	// are trusted. When set, manifests that aren't signed by one of those keys are refused.
+	TrustedKeysEnvVar = "LYRA_TRUSTED_KEYS"
+
+	// SignatureExt is appended to the name of a file to get the name of its detached signature
+	SignatureExt = ".sig"
+
+	// PublicKeyExt is the extension of public key files
+	PublicKeyExt = ".pub"
+
+	// PrivateKeyExt is the extension of private key files
+	PrivateKeyExt = ".key"
+
+	publicKeyType  = "LYRA ED25519 PUBLIC KEY"
+	privateKeyType = "LYRA ED25519 PRIVATE KEY"
+)
+
+// GenerateKeyPair creates a new key pair and writes it to <basePath>.key and <basePath>.pub. The
+// private key file is only readable by the current user.
+func GenerateKeyPair(basePath string) error {
+	pub, priv, err := generateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err = writeKey(basePath+PrivateKeyExt, privateKeyType, priv, 0600); err != nil {
+		return err
+	}
+	return writeKey(basePath+PublicKeyExt, publicKeyType, pub, 0644)
+}
+
+func writeKey(path, keyType string, key []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pem.Encode(f, &pem.Block{Type: keyType, Bytes: key})
+}
+
+func readKey(path, keyType string, size int) ([]byte, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bts)
+	if block == nil || block.Type != keyType || len(block.Bytes) != size {
+		return nil, fmt.Errorf("%s does not contain a %s", path, strings.ToLower(keyType))
+	}
+	return block.Bytes, nil
+}
+
+// ReadPrivateKey reads a private key written by GenerateKeyPair
+func ReadPrivateKey(path string) (PrivateKey, error) {
+	key, err := readKey(path, privateKeyType, privateKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return PrivateKey(key), nil
+}
+
+// ReadPublicKey reads a public key written by GenerateKeyPair
+func ReadPublicKey(path string) (PublicKey, error) {
+	key, err := readKey(path, publicKeyType, publicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return PublicKey(key), nil
+}
+
+// SignFile signs the file at the given path and writes the signature to <path>.sig
+func SignFile(path string, key PrivateKey) error {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sig := base64.StdEncoding.EncodeToString(Sign(key, bts))
+	return ioutil.WriteFile(path+SignatureExt, []byte(sig+"\n"), 0644)
+}
+
+// Sign returns the Ed25519 signature of the given message
+func Sign(key PrivateKey, message []byte) []byte {
+	return sign(key, message)
+}
+
+// Verifier verifies signatures against a set of trusted public keys
+type Verifier struct {
+	keys map[string]PublicKey
+}
+
+// NewVerifier creates a verifier that trusts the given keys. The map key is the name of the signer.
+func NewVerifier(keys map[string]PublicKey) *Verifier {
+	return &Verifier{keys: keys}
+}
+
+// LoadVerifier creates a verifier that trusts all public keys found in the given directory. The
+// name of a signer is the name of the key file without extension.
+func LoadVerifier(dir string) (*Verifier, error) {
+	files, err := filepath.Glob(filepath.Join(dir, `*`+PublicKeyExt))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no trusted keys found in %s", dir)
+	}
+	keys := make(map[string]PublicKey, len(files))
+	for _, f := range files {
+		key, err := ReadPublicKey(f)
+		if err != nil {
+			return nil, err
+		}
+		keys[strings.TrimSuffix(filepath.Base(f), PublicKeyExt)] = key
+	}
+	return NewVerifier(keys), nil
+}
+
+// VerifierFromEnv returns a verifier for the keys in the directory named by the LYRA_TRUSTED_KEYS
+// environment variable or nil if the variable is not set
+func VerifierFromEnv() (*Verifier, error) {
+	dir, ok := os.LookupEnv(TrustedKeysEnvVar)
+	if !ok || dir == `` {
+		return nil, nil
+	}
+	return LoadVerifier(dir)
+}
+
+// VerifyFile verifies the file at the given path against the signature in <path>.sig and returns
+// the name of the trusted signer. An error is returned if the signature is missing or if the file
+// wasn't signed by a trusted key.
+func (v *Verifier) VerifyFile(path string) (string, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ``, err
+	}
+	sigText, err := ioutil.ReadFile(path + SignatureExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ``, fmt.Errorf("%s is not signed", path)
+		}
+		return ``, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigText)))
+	if err != nil || len(sig) != signatureSize {
+		return ``, fmt.Errorf("%s%s does not contain a valid signature", path, SignatureExt)
+	}
+	if signer, ok := v.Verify(bts, sig); ok {
+		return signer, nil
+	}
+	return ``, fmt.Errorf("%s is not signed by a trusted key", path)
+}
+
+// Verify returns the name of the trusted signer of the given data and true, or false if the
+// signature wasn't made by any of the trusted keys
+func (v *Verifier) Verify(data, sig []byte) (string, bool) {
+	for signer, key := range v.keys {
+		if verify(key, data, sig) {
+			return signer, true
+		}
+	}
+	return ``, false
+}
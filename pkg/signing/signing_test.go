@@ -0,0 +1,69 @@
+package signing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SignAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	keyDir := filepath.Join(dir, "keys")
+	require.Nil(t, os.Mkdir(keyDir, 0755))
+	require.Nil(t, GenerateKeyPair(filepath.Join(keyDir, "ops")))
+	require.Nil(t, GenerateKeyPair(filepath.Join(dir, "untrusted")))
+
+	// Existing keys are never overwritten
+	assert.Error(t, GenerateKeyPair(filepath.Join(keyDir, "ops")))
+
+	stat, err := os.Stat(filepath.Join(keyDir, "ops"+PrivateKeyExt))
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	manifest := filepath.Join(dir, "workflow.pp")
+	require.Nil(t, ioutil.WriteFile(manifest, []byte("workflow example {}\n"), 0644))
+
+	v, err := LoadVerifier(keyDir)
+	require.Nil(t, err)
+
+	_, err = v.VerifyFile(manifest)
+	assert.EqualError(t, err, manifest+" is not signed")
+
+	key, err := ReadPrivateKey(filepath.Join(keyDir, "ops"+PrivateKeyExt))
+	require.Nil(t, err)
+	require.Nil(t, SignFile(manifest, key))
+	signer, err := v.VerifyFile(manifest)
+	require.Nil(t, err)
+	assert.Equal(t, "ops", signer)
+
+	// Tampering invalidates the signature
+	require.Nil(t, ioutil.WriteFile(manifest, []byte("workflow tampered {}\n"), 0644))
+	_, err = v.VerifyFile(manifest)
+	assert.EqualError(t, err, manifest+" is not signed by a trusted key")
+
+	key, err = ReadPrivateKey(filepath.Join(dir, "untrusted"+PrivateKeyExt))
+	require.Nil(t, err)
+	require.Nil(t, SignFile(manifest, key))
+	_, err = v.VerifyFile(manifest)
+	assert.EqualError(t, err, manifest+" is not signed by a trusted key")
+
+	// Public keys can't be used as private keys
+	_, err = ReadPrivateKey(filepath.Join(keyDir, "ops"+PublicKeyExt))
+	assert.Error(t, err)
+}
+
+func Test_LoadVerifierWithoutKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = LoadVerifier(dir)
+	assert.Error(t, err)
+}
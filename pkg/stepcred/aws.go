@@ -0,0 +1,133 @@
+package stepcred
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/lyraproj/lyra/pkg/credhelper"
+)
+
+// sessionDuration is the lifetime, in seconds, of assumed role sessions. It is the minimum that STS allows.
+const sessionDuration = 900
+
+// awsActions are the actions that the handlers of each AWS resource type perform, keyed by the name of the
+// type without namespace. Resources that are tagged need ec2:CreateTags or the equivalent of their service.
+var awsActions = map[string][]string{
+	`Instance`: {`ec2:RunInstances`, `ec2:DescribeInstances`, `ec2:TerminateInstances`, `ec2:CreateTags`},
+	`Vpc`:      {`ec2:CreateVpc`, `ec2:DescribeVpcs`, `ec2:ModifyVpcAttribute`, `ec2:DeleteVpc`, `ec2:CreateTags`},
+	`Subnet`:   {`ec2:CreateSubnet`, `ec2:DescribeSubnets`, `ec2:ModifySubnetAttribute`, `ec2:DeleteSubnet`, `ec2:CreateTags`},
+	`SecurityGroup`: {`ec2:CreateSecurityGroup`, `ec2:DescribeSecurityGroups`, `ec2:AuthorizeSecurityGroupIngress`,
+		`ec2:AuthorizeSecurityGroupEgress`, `ec2:RevokeSecurityGroupIngress`, `ec2:RevokeSecurityGroupEgress`,
+		`ec2:DeleteSecurityGroup`, `ec2:CreateTags`},
+	`InternetGateway`: {`ec2:CreateInternetGateway`, `ec2:DescribeInternetGateways`, `ec2:AttachInternetGateway`,
+		`ec2:DetachInternetGateway`, `ec2:DeleteInternetGateway`, `ec2:CreateTags`},
+	`RouteTable`: {`ec2:CreateRouteTable`, `ec2:DescribeRouteTables`, `ec2:CreateRoute`, `ec2:DeleteRoute`,
+		`ec2:AssociateRouteTable`, `ec2:DisassociateRouteTable`, `ec2:DeleteRouteTable`, `ec2:CreateTags`},
+	`KeyPair`: {`ec2:ImportKeyPair`, `ec2:DescribeKeyPairs`, `ec2:DeleteKeyPair`},
+	`IamRole`: {`iam:CreateRole`, `iam:GetRole`, `iam:TagRole`, `iam:DeleteRole`},
+}
+
+var invalidSessionName = regexp.MustCompile(`[^\w+=,.@-]+`)
+
+type policyDocument struct {
+	Version   string
+	Statement []policyStatement
+}
+
+type policyStatement struct {
+	Effect   string
+	Action   []string
+	Resource string
+}
+
+// SessionPolicy returns the session policy that grants the actions needed to manage resources of the
+// given AWS type. The second return value is false when the type is not known.
+func SessionPolicy(resourceType string) (string, bool) {
+	names := strings.Split(resourceType, `::`)
+	if len(names) < 2 || names[0] != `Aws` {
+		return ``, false
+	}
+	actions, ok := awsActions[names[len(names)-1]]
+	if !ok {
+		return ``, false
+	}
+	bs, err := json.Marshal(&policyDocument{
+		Version:   `2012-10-17`,
+		Statement: []policyStatement{{Effect: `Allow`, Action: actions, Resource: `*`}}})
+	if err != nil {
+		panic(err)
+	}
+	return string(bs), true
+}
+
+type assumeRoleSource struct {
+	lock   sync.Mutex
+	role   string
+	client stsiface.STSAPI
+}
+
+// NewAssumeRoleSource returns a source that assumes the given role for each step that manages an AWS
+// resource. The session is restricted by the policy returned by SessionPolicy. The role is assumed using
+// the credentials that the AWS SDK finds in the environment or in the shared configuration.
+func NewAssumeRoleSource(role string) Source {
+	return &assumeRoleSource{role: role}
+}
+
+func (s *assumeRoleSource) Credentials(ctx context.Context, step *Step) (*credhelper.Credentials, error) {
+	policy, ok := SessionPolicy(step.ResourceType)
+	if !ok {
+		return nil, nil
+	}
+	client, err := s.getClient()
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.AssumeRoleWithContext(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String(s.role),
+		RoleSessionName: aws.String(sessionName(step.Handler)),
+		Policy:          aws.String(policy),
+		DurationSeconds: aws.Int64(sessionDuration),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to assume role %s for %s: %s", s.role, step.Handler, err)
+	}
+	c := out.Credentials
+	return &credhelper.Credentials{
+		Values: map[string]string{
+			`AWS_ACCESS_KEY_ID`:     aws.StringValue(c.AccessKeyId),
+			`AWS_SECRET_ACCESS_KEY`: aws.StringValue(c.SecretAccessKey),
+			`AWS_SESSION_TOKEN`:     aws.StringValue(c.SessionToken),
+		},
+		Expires: c.Expiration,
+	}, nil
+}
+
+func (s *assumeRoleSource) getClient() (stsiface.STSAPI, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.client == nil {
+		sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+		if err != nil {
+			return nil, err
+		}
+		s.client = sts.New(sess)
+	}
+	return s.client, nil
+}
+
+// sessionName returns a role session name that identifies the handler in CloudTrail
+func sessionName(handler string) string {
+	n := `lyra-` + strings.Trim(invalidSessionName.ReplaceAllString(strings.Replace(handler, `::`, `.`, -1), `-`), `-`)
+	if len(n) > 64 {
+		n = n[:64]
+	}
+	return n
+}
@@ -0,0 +1,118 @@
+// Package stepcred acquires short-lived credentials for each step that manages a resource and passes
+// them to the provider for the invocations of that step only. The credentials are restricted to what
+// the type of the resource requires, so a provider can never act on other kinds of resources with them.
+package stepcred
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lyraproj/lyra/pkg/credhelper"
+	"github.com/lyraproj/lyra/pkg/grpc"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// RoleEnvVar names the role that is assumed when no role is given explicitly
+const RoleEnvVar = "LYRA_STEP_ROLE"
+
+// expiryMargin makes cached credentials expire a little before they actually do
+const expiryMargin = time.Minute
+
+// handlerMethods are the handler methods that act on a resource
+var handlerMethods = map[string]bool{
+	`create`: true,
+	`read`:   true,
+	`update`: true,
+	`upsert`: true,
+	`delete`: true,
+}
+
+// Step describes the step that credentials are requested for
+type Step struct {
+	// Handler is the identifier of the handler of the resource, e.g. Aws::InstanceHandler
+	Handler string
+
+	// ResourceType is the name of the type of the resource, e.g. Aws::Instance
+	ResourceType string
+}
+
+// Source acquires credentials for a step. It returns nil when it has no credentials for the type of
+// resource that the step manages, in which case the provider uses its own credentials.
+type Source interface {
+	Credentials(ctx context.Context, step *Step) (*credhelper.Credentials, error)
+}
+
+type service struct {
+	serviceapi.Service
+	source        Source
+	cache         *cache
+	typesOnce     sync.Once
+	resourceTypes map[string]string
+}
+
+type cache struct {
+	lock  sync.Mutex
+	creds map[Step]*credhelper.Credentials
+}
+
+// WrapService returns a function that wraps a service so that invocations of its resource handlers
+// pass the credentials that the given source acquires for the step
+func WrapService(source Source) func(serviceapi.Service) serviceapi.Service {
+	c := &cache{creds: map[Step]*credhelper.Credentials{}}
+	return func(s serviceapi.Service) serviceapi.Service {
+		return &service{Service: s, source: source, cache: c}
+	}
+}
+
+func (s *service) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	if handlerMethods[name] {
+		if rt, ok := s.resourceType(c, identifier); ok {
+			creds, err := s.credentials(c, &Step{Handler: identifier, ResourceType: rt})
+			if err != nil {
+				panic(err)
+			}
+			if creds != nil {
+				c = grpc.WithCredentials(c, creds)
+			}
+		}
+	}
+	return s.Service.Invoke(c, identifier, name, arguments...)
+}
+
+// resourceType returns the name of the type of the resource managed by the handler with the given identifier
+func (s *service) resourceType(c eval.Context, identifier string) (string, bool) {
+	s.typesOnce.Do(func() {
+		s.resourceTypes = map[string]string{}
+		_, defs := s.Service.Metadata(c)
+		for _, def := range defs {
+			if def.Identifier().Namespace() != eval.NsHandler {
+				continue
+			}
+			if t, ok := def.Properties().Get4(`resourceType`); ok {
+				if t, ok := t.(eval.Type); ok {
+					s.resourceTypes[def.Identifier().Name()] = t.Name()
+				}
+			}
+		}
+	})
+	rt, ok := s.resourceTypes[identifier]
+	return rt, ok
+}
+
+func (s *service) credentials(ctx context.Context, step *Step) (*credhelper.Credentials, error) {
+	s.cache.lock.Lock()
+	defer s.cache.lock.Unlock()
+	if c, ok := s.cache.creds[*step]; ok && (c.Expires == nil || time.Now().Add(expiryMargin).Before(*c.Expires)) {
+		return c, nil
+	}
+	c, err := s.source.Credentials(ctx, step)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		s.cache.creds[*step] = c
+	}
+	return c, nil
+}
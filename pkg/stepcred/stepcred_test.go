@@ -0,0 +1,149 @@
+package stepcred
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/lyraproj/lyra/pkg/credhelper"
+	"github.com/lyraproj/lyra/pkg/grpc"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/threadlocal"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	// Provides serviceapi.NewDefinition
+	_ "github.com/lyraproj/servicesdk/service"
+)
+
+func Test_SessionPolicy(t *testing.T) {
+	policy, ok := SessionPolicy(`Aws::Instance`)
+	require.True(t, ok)
+	doc := &policyDocument{}
+	require.NoError(t, json.Unmarshal([]byte(policy), doc))
+	assert.Equal(t, `2012-10-17`, doc.Version)
+	assert.Contains(t, doc.Statement[0].Action, `ec2:RunInstances`)
+	assert.NotContains(t, doc.Statement[0].Action, `ec2:CreateVpc`)
+
+	policy, ok = SessionPolicy(`Aws::Native::Vpc`)
+	require.True(t, ok)
+	assert.Contains(t, policy, `ec2:CreateVpc`)
+
+	_, ok = SessionPolicy(`Aws::Unknown`)
+	assert.False(t, ok)
+	_, ok = SessionPolicy(`Vpc`)
+	assert.False(t, ok)
+	_, ok = SessionPolicy(`Google::Instance`)
+	assert.False(t, ok)
+}
+
+func Test_SessionName(t *testing.T) {
+	assert.Equal(t, `lyra-Aws.InstanceHandler`, sessionName(`Aws::InstanceHandler`))
+	assert.Equal(t, `lyra-My-Handler`, sessionName(`My Handler!`))
+	assert.Len(t, sessionName(strings.Repeat(`a`, 100)), 64)
+}
+
+type testSTS struct {
+	stsiface.STSAPI
+	inputs []*sts.AssumeRoleInput
+	err    error
+}
+
+func (s *testSTS) AssumeRoleWithContext(ctx aws.Context, in *sts.AssumeRoleInput, opts ...request.Option) (*sts.AssumeRoleOutput, error) {
+	s.inputs = append(s.inputs, in)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &sts.AssumeRoleOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String(`AKID`),
+		SecretAccessKey: aws.String(`SECRET`),
+		SessionToken:    aws.String(`TOKEN`),
+		Expiration:      aws.Time(time.Now().Add(15 * time.Minute)),
+	}}, nil
+}
+
+func Test_AssumeRoleSource(t *testing.T) {
+	client := &testSTS{}
+	source := &assumeRoleSource{role: `arn:aws:iam::123456789012:role/lyra`, client: client}
+
+	creds, err := source.Credentials(context.Background(), &Step{Handler: `Aws::VPCHandler`, ResourceType: `Aws::Vpc`})
+	require.NoError(t, err)
+	assert.Equal(t, `AKID`, creds.Get(`AWS_ACCESS_KEY_ID`))
+	assert.Equal(t, `SECRET`, creds.Get(`AWS_SECRET_ACCESS_KEY`))
+	assert.Equal(t, `TOKEN`, creds.Get(`AWS_SESSION_TOKEN`))
+	require.Len(t, client.inputs, 1)
+	in := client.inputs[0]
+	assert.Equal(t, `arn:aws:iam::123456789012:role/lyra`, *in.RoleArn)
+	assert.Equal(t, `lyra-Aws.VPCHandler`, *in.RoleSessionName)
+	assert.Contains(t, *in.Policy, `ec2:CreateVpc`)
+
+	creds, err = source.Credentials(context.Background(), &Step{Handler: `My::Handler`, ResourceType: `My::Resource`})
+	assert.NoError(t, err)
+	assert.Nil(t, creds)
+	assert.Len(t, client.inputs, 1)
+
+	client.err = errors.New(`access denied`)
+	_, err = source.Credentials(context.Background(), &Step{Handler: `Aws::VPCHandler`, ResourceType: `Aws::Vpc`})
+	assert.Error(t, err)
+}
+
+type testSource struct {
+	steps []Step
+}
+
+func (s *testSource) Credentials(ctx context.Context, step *Step) (*credhelper.Credentials, error) {
+	s.steps = append(s.steps, *step)
+	return &credhelper.Credentials{Values: map[string]string{`KEY`: step.ResourceType}}, nil
+}
+
+type testService struct {
+	serviceapi.Service
+	received map[string]*credhelper.Credentials
+}
+
+func (s *testService) Metadata(c eval.Context) (eval.TypeSet, []serviceapi.Definition) {
+	sid := eval.NewTypedName(eval.NsService, `Test`)
+	return nil, []serviceapi.Definition{
+		serviceapi.NewDefinition(eval.NewTypedName(eval.NsHandler, `Aws::VPCHandler`), sid,
+			types.SingletonHash2(`resourceType`, types.NewObjectType(`Aws::Vpc`, nil, `{}`))),
+		serviceapi.NewDefinition(eval.NewTypedName(eval.NsActivity, `test::step`), sid, eval.EMPTY_MAP),
+	}
+}
+
+func (s *testService) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	// Credentials are read by the plugin from the context of the invocation
+	threadlocal.Set(eval.PuppetContextKey, c)
+	creds, _ := grpc.InvocationCredentials()
+	s.received[name+` `+identifier] = creds
+	return eval.UNDEF
+}
+
+func Test_WrapService(t *testing.T) {
+	eval.Puppet.Do(func(c eval.Context) {
+		threadlocal.Init()
+		source := &testSource{}
+		s := &testService{received: map[string]*credhelper.Credentials{}}
+		ws := WrapService(source)(s)
+
+		ws.Invoke(c, `Aws::VPCHandler`, `create`, eval.UNDEF)
+		ws.Invoke(c, `Aws::VPCHandler`, `read`, eval.UNDEF)
+		ws.Invoke(c, `test::step`, `do`, eval.UNDEF)
+
+		require.NotNil(t, s.received[`create Aws::VPCHandler`])
+		assert.Equal(t, `Aws::Vpc`, s.received[`create Aws::VPCHandler`].Get(`KEY`))
+		assert.Equal(t, s.received[`create Aws::VPCHandler`], s.received[`read Aws::VPCHandler`])
+		assert.Nil(t, s.received[`do test::step`])
+
+		// Credentials without expiry are reused
+		assert.Equal(t, []Step{{Handler: `Aws::VPCHandler`, ResourceType: `Aws::Vpc`}}, source.steps)
+	})
+}
@@ -0,0 +1,267 @@
+// Package throttle caps the calls that are made to each provider. Calls that exceed the number of calls
+// that a provider may have in progress, or the number of calls that it may be given per second, wait
+// until the provider can take them. Calls that the provider rejects because its API throttles them are
+// retried after a delay that grows with each attempt and is jittered, so that the calls that were
+// rejected together are not all retried together.
+package throttle
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LimitsEnvVar holds the limits, separated by semicolons, that are used when none are given explicitly
+const LimitsEnvVar = "LYRA_PROVIDER_LIMITS"
+
+// AnyProvider names the limit of the providers that have no limit of their own. Each provider is
+// limited separately.
+const AnyProvider = `*`
+
+// DefaultRetries is the number of retries of a throttled call when a limit doesn't specify it
+const DefaultRetries = 3
+
+// Delays of the retries of throttled calls. The delay doubles with each retry up to maxDelay.
+const (
+	baseDelay = 200 * time.Millisecond
+	maxDelay  = 10 * time.Second
+)
+
+// throttlingMessages are found in the errors of the APIs that throttle calls, e.g. ThrottlingException
+// and RequestLimitExceeded from AWS or 429 Too Many Requests from HTTP APIs
+var throttlingMessages = []string{
+	`throttl`,
+	`rate exceeded`,
+	`requestlimitexceeded`,
+	`too many requests`,
+	`slow down`,
+}
+
+// sleep is replaced by tests
+var sleep = time.Sleep
+
+// Limit caps the calls that are made to one provider
+type Limit struct {
+	// Concurrency is the number of calls that may be in progress at the same time. Zero means no cap.
+	Concurrency int
+
+	// Rate is the number of calls that may be started per second. Zero means no cap.
+	Rate float64
+
+	// Burst is the number of calls that may be started at once before Rate applies. It is at least one.
+	Burst int
+
+	// Retries is the number of times that a throttled call is retried before its error is returned
+	Retries int
+}
+
+// ParseLimits parses limits of the form <provider>:<key>=<value>,... where the keys are concurrency,
+// rate, burst and retries, e.g. Aws:concurrency=4,rate=10. The provider is the name of the service of
+// a plugin, or AnyProvider.
+func ParseLimits(specs []string) (map[string]Limit, error) {
+	limits := make(map[string]Limit, len(specs))
+	for _, spec := range specs {
+		sep := strings.IndexByte(spec, ':')
+		if sep <= 0 {
+			return nil, fmt.Errorf("invalid provider limit '%s', expected <provider>:<key>=<value>,...", spec)
+		}
+		provider := strings.TrimSpace(spec[:sep])
+		limit := Limit{Burst: 1, Retries: DefaultRetries}
+		for _, setting := range strings.Split(spec[sep+1:], `,`) {
+			kv := strings.SplitN(setting, `=`, 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid setting '%s' in provider limit '%s'", setting, spec)
+			}
+			key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+			var err error
+			switch key {
+			case `concurrency`:
+				limit.Concurrency, err = parseCount(value)
+			case `rate`:
+				limit.Rate, err = strconv.ParseFloat(value, 64)
+				if err == nil && limit.Rate < 0 {
+					err = fmt.Errorf("must not be negative")
+				}
+			case `burst`:
+				limit.Burst, err = parseCount(value)
+				if err == nil && limit.Burst == 0 {
+					err = fmt.Errorf("must be at least 1")
+				}
+			case `retries`:
+				limit.Retries, err = parseCount(value)
+			default:
+				return nil, fmt.Errorf("unknown setting '%s' in provider limit '%s'", key, spec)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s in provider limit '%s': %s", key, spec, err)
+			}
+		}
+		limits[strings.ToLower(provider)] = limit
+	}
+	return limits, nil
+}
+
+func parseCount(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("must not be negative")
+	}
+	return n, err
+}
+
+// IsThrottled returns true if the given error tells that a call was rejected because too many calls
+// were made
+func IsThrottled(err error) bool {
+	if s, ok := status.FromError(err); ok && s.Code() == codes.ResourceExhausted {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range throttlingMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// limiter applies the limit of one provider
+type limiter struct {
+	limit Limit
+	slots chan struct{}
+	lock  sync.Mutex
+	next  time.Time
+}
+
+func newLimiter(limit Limit) *limiter {
+	l := &limiter{limit: limit}
+	if limit.Concurrency > 0 {
+		l.slots = make(chan struct{}, limit.Concurrency)
+	}
+	return l
+}
+
+// acquire waits until a call may be started
+func (l *limiter) acquire() {
+	if l.slots != nil {
+		l.slots <- struct{}{}
+	}
+	if l.limit.Rate > 0 {
+		interval := time.Duration(float64(time.Second) / l.limit.Rate)
+		l.lock.Lock()
+		now := time.Now()
+		// Calls that were not made in the past may be made now, up to the burst
+		if earliest := now.Add(-time.Duration(l.limit.Burst-1) * interval); l.next.Before(earliest) {
+			l.next = earliest
+		}
+		wait := l.next.Sub(now)
+		l.next = l.next.Add(interval)
+		l.lock.Unlock()
+		if wait > 0 {
+			sleep(wait)
+		}
+	}
+}
+
+// release ends a call that was started by acquire
+func (l *limiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// retryDelay returns the jittered delay before the given retry, which is counted from zero
+func retryDelay(retry int) time.Duration {
+	delay := maxDelay
+	if retry < 16 {
+		if d := baseDelay << uint(retry); d < maxDelay {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+type limiters struct {
+	limits map[string]Limit
+	lock   sync.Mutex
+	byName map[string]*limiter
+}
+
+// get returns the limiter of the given provider or nil if the provider is not limited
+func (ls *limiters) get(provider string) *limiter {
+	provider = strings.ToLower(provider)
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	if l, ok := ls.byName[provider]; ok {
+		return l
+	}
+	var l *limiter
+	if limit, ok := ls.limits[provider]; ok {
+		l = newLimiter(limit)
+	} else if limit, ok := ls.limits[AnyProvider]; ok {
+		l = newLimiter(limit)
+	}
+	ls.byName[provider] = l
+	return l
+}
+
+type service struct {
+	serviceapi.Service
+	limiters *limiters
+	logger   hclog.Logger
+	once     sync.Once
+	limiter  *limiter
+}
+
+// WrapService returns a function that wraps a service so that the calls to it are capped by the limit
+// of the provider that the service belongs to. The limits are shared by all services of a provider.
+func WrapService(limits map[string]Limit, logger hclog.Logger) func(serviceapi.Service) serviceapi.Service {
+	ls := &limiters{limits: limits, byName: map[string]*limiter{}}
+	return func(s serviceapi.Service) serviceapi.Service {
+		return &service{Service: s, limiters: ls, logger: logger}
+	}
+}
+
+func (s *service) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	s.once.Do(func() { s.limiter = s.limiters.get(s.Identifier(c).Name()) })
+	l := s.limiter
+	if l == nil {
+		return s.Service.Invoke(c, identifier, name, arguments...)
+	}
+	for retry := 0; ; retry++ {
+		result, err := s.invoke(c, l, retry < l.limit.Retries, identifier, name, arguments)
+		if err == nil {
+			return result
+		}
+		delay := retryDelay(retry)
+		s.logger.Debug("call throttled, retrying", "identifier", identifier, "name", name, "retry", retry+1, "delay", delay, "err", err)
+		sleep(delay)
+	}
+}
+
+// invoke makes one call when the limiter permits it. It returns the error of the call rather than
+// panicking when the call is throttled and may be retried.
+func (s *service) invoke(c eval.Context, l *limiter, retry bool, identifier, name string, arguments []eval.Value) (result eval.Value, throttled error) {
+	l.acquire()
+	defer l.release()
+	if retry {
+		defer func() {
+			if e := recover(); e != nil {
+				if err, ok := e.(error); ok && IsThrottled(err) {
+					throttled = err
+					return
+				}
+				panic(e)
+			}
+		}()
+	}
+	return s.Service.Invoke(c, identifier, name, arguments...), nil
+}
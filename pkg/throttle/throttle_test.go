@@ -0,0 +1,181 @@
+package throttle
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/puppet-evaluator/eval"
+	"github.com/lyraproj/puppet-evaluator/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// Ensure that pcore is initialized
+	_ "github.com/lyraproj/puppet-evaluator/pcore"
+)
+
+func Test_ParseLimits(t *testing.T) {
+	limits, err := ParseLimits([]string{`Aws:concurrency=4,rate=2.5`, `*: burst=3, retries=0`})
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Concurrency: 4, Rate: 2.5, Burst: 1, Retries: DefaultRetries}, limits[`aws`])
+	assert.Equal(t, Limit{Burst: 3}, limits[AnyProvider])
+
+	for _, spec := range []string{`Aws`, `:rate=1`, `Aws:rate`, `Aws:speed=1`, `Aws:rate=-1`, `Aws:concurrency=x`, `Aws:burst=0`} {
+		_, err = ParseLimits([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func Test_IsThrottled(t *testing.T) {
+	assert.True(t, IsThrottled(errors.New(`ThrottlingException: Rate exceeded`)))
+	assert.True(t, IsThrottled(errors.New(`RequestLimitExceeded: Request limit exceeded.`)))
+	assert.True(t, IsThrottled(errors.New(`429 Too Many Requests`)))
+	assert.True(t, IsThrottled(status.Error(codes.ResourceExhausted, `busy`)))
+	assert.False(t, IsThrottled(errors.New(`InvalidParameterValue`)))
+}
+
+func Test_RetryDelay(t *testing.T) {
+	for retry, max := range []time.Duration{baseDelay, 2 * baseDelay, 4 * baseDelay} {
+		d := retryDelay(retry)
+		assert.True(t, d >= max/2 && d <= max, d)
+	}
+	d := retryDelay(100)
+	assert.True(t, d >= maxDelay/2 && d <= maxDelay, d)
+}
+
+type testService struct {
+	serviceapi.Service
+	name       string
+	delay      time.Duration
+	failures   int32
+	err        error
+	calls      int32
+	running    int32
+	maxRunning int32
+}
+
+func (s *testService) Identifier(c eval.Context) eval.TypedName {
+	return eval.NewTypedName(eval.NsService, s.name)
+}
+
+func (s *testService) Invoke(c eval.Context, identifier, name string, arguments ...eval.Value) eval.Value {
+	atomic.AddInt32(&s.calls, 1)
+	running := atomic.AddInt32(&s.running, 1)
+	defer atomic.AddInt32(&s.running, -1)
+	for {
+		max := atomic.LoadInt32(&s.maxRunning)
+		if running <= max || atomic.CompareAndSwapInt32(&s.maxRunning, max, running) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		panic(s.err)
+	}
+	return types.WrapString(identifier)
+}
+
+func invokeConcurrently(s serviceapi.Service, count int) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			eval.Puppet.Do(func(c eval.Context) {
+				s.Invoke(c, `Aws::InstanceHandler`, `read`, types.WrapString(`id`))
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+func Test_Concurrency(t *testing.T) {
+	wrap := WrapService(map[string]Limit{`aws`: {Concurrency: 2, Burst: 1}}, hclog.NewNullLogger())
+	limited := &testService{name: `Aws`, delay: 10 * time.Millisecond}
+	other := &testService{name: `Example`, delay: 10 * time.Millisecond}
+	invokeConcurrently(wrap(limited), 10)
+	invokeConcurrently(wrap(other), 10)
+	assert.Equal(t, int32(10), limited.calls)
+	assert.Equal(t, int32(2), limited.maxRunning)
+	assert.True(t, other.maxRunning > 2)
+}
+
+func Test_ConcurrencySharedByServices(t *testing.T) {
+	wrap := WrapService(map[string]Limit{AnyProvider: {Concurrency: 1, Burst: 1}}, hclog.NewNullLogger())
+	s := &testService{name: `Aws`, delay: 5 * time.Millisecond}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			invokeConcurrently(wrap(s), 3)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), s.maxRunning)
+}
+
+func Test_Rate(t *testing.T) {
+	var waited time.Duration
+	lock := sync.Mutex{}
+	sleep = func(d time.Duration) {
+		lock.Lock()
+		waited += d
+		lock.Unlock()
+	}
+	defer func() { sleep = time.Sleep }()
+
+	l := newLimiter(Limit{Rate: 10, Burst: 2})
+	for i := 0; i < 2; i++ {
+		l.acquire()
+		l.release()
+	}
+	assert.Equal(t, time.Duration(0), waited, `calls within the burst wait`)
+	l.acquire()
+	l.release()
+	assert.True(t, waited > 50*time.Millisecond && waited <= 100*time.Millisecond, waited)
+}
+
+func Test_RetryThrottled(t *testing.T) {
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { sleep = time.Sleep }()
+
+	wrap := WrapService(map[string]Limit{`aws`: {Burst: 1, Retries: 3}}, hclog.NewNullLogger())
+	s := &testService{name: `Aws`, failures: 2, err: errors.New(`ThrottlingException: Rate exceeded`)}
+	eval.Puppet.Do(func(c eval.Context) {
+		assert.Equal(t, `Aws::InstanceHandler`, wrap(s).Invoke(c, `Aws::InstanceHandler`, `create`).String())
+	})
+	assert.Equal(t, int32(3), s.calls)
+	assert.Len(t, delays, 2)
+}
+
+func Test_RetriesExhausted(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	wrap := WrapService(map[string]Limit{`aws`: {Burst: 1, Retries: 2}}, hclog.NewNullLogger())
+	s := &testService{name: `Aws`, failures: 10, err: errors.New(`ThrottlingException: Rate exceeded`)}
+	eval.Puppet.Do(func(c eval.Context) {
+		assert.PanicsWithValue(t, s.err, func() { wrap(s).Invoke(c, `Aws::InstanceHandler`, `create`) })
+	})
+	assert.Equal(t, int32(3), s.calls)
+}
+
+func Test_OtherErrorsNotRetried(t *testing.T) {
+	wrap := WrapService(map[string]Limit{`aws`: {Concurrency: 1, Burst: 1, Retries: 2}}, hclog.NewNullLogger())
+	s := &testService{name: `Aws`, failures: 1, err: errors.New(`InvalidParameterValue`)}
+	svc := wrap(s)
+	eval.Puppet.Do(func(c eval.Context) {
+		assert.PanicsWithValue(t, s.err, func() { svc.Invoke(c, `Aws::InstanceHandler`, `create`) })
+		// The slot of the failed call was released
+		assert.Equal(t, `Aws::InstanceHandler`, svc.Invoke(c, `Aws::InstanceHandler`, `create`).String())
+	})
+	assert.Equal(t, int32(2), s.calls)
+}